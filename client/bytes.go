@@ -0,0 +1,34 @@
+package pgis
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+)
+
+// IndexBytes is IndexFeature for callers that have a raw WOF GeoJSON record
+// rather than a geojson.Feature, the same way IntersectsFeature takes raw
+// bytes. Unlike IndexFeature the record is written using ctx so cancelling it
+// rolls back a record that is still being written.
+
+func (client *PgisClient) IndexBytes(ctx context.Context, body []byte, collection string) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	body, err = feature.UnmarshalFeature(body)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := feature.NewWOFFeature(body)
+
+	if err != nil {
+		return err
+	}
+
+	return client.IndexFeatureContext(ctx, f, collection)
+}
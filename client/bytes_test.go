@@ -0,0 +1,63 @@
+package pgis
+
+import (
+	"bytes"
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"strings"
+	"testing"
+)
+
+func TestIndexBytes(t *testing.T) {
+
+	body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		what    string
+		ctx     context.Context
+		body    string
+		written bool
+	}{
+		{"feature", context.Background(), body, true},
+		{"invalid JSON", context.Background(), "{", false},
+		{"not a feature", context.Background(), `{"type":"Point","coordinates":[-73.5,45.5]}`, false},
+		{"not a WOF feature", context.Background(), `{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, false},
+		{"cancelled", cancelled, body, false},
+	}
+
+	for _, test := range tests {
+
+		wr := &bytes.Buffer{}
+
+		client := &PgisClient{
+			Logger:    log.SimpleWOFLogger("test"),
+			SQLWriter: wr,
+		}
+
+		err := client.IndexBytes(test.ctx, []byte(test.body), "test")
+
+		if test.written {
+
+			if err != nil {
+				t.Errorf("%s: failed to index because %s", test.what, err)
+			}
+
+			if !strings.Contains(wr.String(), "INSERT INTO whosonfirst") || !strings.Contains(wr.String(), "101736545") {
+				t.Errorf("%s: expected the feature to be written, got %q", test.what, wr.String())
+			}
+
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: expected an error", test.what)
+		}
+
+		if wr.Len() > 0 {
+			t.Errorf("%s: expected nothing to be written, got %q", test.what, wr.String())
+		}
+	}
+}
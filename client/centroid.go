@@ -0,0 +1,39 @@
+package pgis
+
+import (
+	"errors"
+	"fmt"
+)
+
+// http://postgis.net/docs/ST_PointOnSurface.html
+// http://postgis.net/docs/ST_Centroid.html
+
+// these are the valid values for PgisClient.ServerCentroid. By default the
+// centroid column is the centroid that wof.Centroid finds in the feature's
+// properties (lbl:latitude and so on) but if ServerCentroid is set PostGIS
+// derives it from the stored geometry instead, in the same statement. A point
+// on surface is always inside the geometry, which is what you want for things
+// like labels; a centroid is the geometric center which may not be. Features
+// whose geometry isn't stored (Points and everything when SkipGeometry is set)
+// still get the centroid from their properties.
+
+const PGIS_SERVER_CENTROID_POINT_ON_SURFACE = "point-on-surface"
+const PGIS_SERVER_CENTROID_CENTROID = "centroid"
+
+// the PostGIS function for ServerCentroid or "" if the centroid comes from
+// the feature
+
+func (client *PgisClient) serverCentroid() (string, error) {
+
+	switch client.ServerCentroid {
+	case "":
+		return "", nil
+	case PGIS_SERVER_CENTROID_POINT_ON_SURFACE:
+		return "ST_PointOnSurface", nil
+	case PGIS_SERVER_CENTROID_CENTROID:
+		return "ST_Centroid", nil
+	default:
+		msg := fmt.Sprintf("invalid server centroid '%s'", client.ServerCentroid)
+		return "", errors.New(msg)
+	}
+}
@@ -0,0 +1,103 @@
+package pgis
+
+import (
+	"bytes"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"strings"
+	"testing"
+)
+
+func TestServerCentroid(t *testing.T) {
+
+	tests := []struct {
+		centroid string
+		function string
+		err      bool
+	}{
+		{"", "", false},
+		{PGIS_SERVER_CENTROID_POINT_ON_SURFACE, "ST_PointOnSurface", false},
+		{PGIS_SERVER_CENTROID_CENTROID, "ST_Centroid", false},
+		{"middle", "", true},
+	}
+
+	for _, test := range tests {
+
+		client := &PgisClient{
+			ServerCentroid: test.centroid,
+		}
+
+		function, err := client.serverCentroid()
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("expected server centroid %q to be refused", test.centroid)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("server centroid %q failed because %s", test.centroid, err)
+			continue
+		}
+
+		if function != test.function {
+			t.Errorf("expected server centroid %q to use %q, got %q", test.centroid, test.function, function)
+		}
+	}
+}
+
+// the centroid is derived from the stored geometry for polygons but still
+// comes from the properties for points, which don't have one
+
+func TestServerCentroidSQL(t *testing.T) {
+
+	tests := []struct {
+		geom     string
+		centroid string
+		expected string
+		server   bool // whether the centroid is derived by PostGIS
+	}{
+		{`{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}`, "", `ST_GeomFromGeoJSON('{"type":"Point","coordinates":[-73.5,45.5]}')`, false},
+		{`{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}`, PGIS_SERVER_CENTROID_POINT_ON_SURFACE, "ST_PointOnSurface(ST_GeomFromGeoJSON(", true},
+		{`{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}`, PGIS_SERVER_CENTROID_CENTROID, "ST_Centroid(ST_GeomFromGeoJSON(", true},
+		{`{"type":"Point","coordinates":[-73.5,45.5]}`, PGIS_SERVER_CENTROID_CENTROID, `ST_GeomFromGeoJSON('{"type":"Point","coordinates":[-73.5,45.5]}')`, false},
+	}
+
+	for _, test := range tests {
+
+		body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45,-73,46"},"geometry":` + test.geom + `}`
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		wr := &bytes.Buffer{}
+
+		client := &PgisClient{
+			ServerCentroid: test.centroid,
+			Logger:         log.SimpleWOFLogger("test"),
+			SQLWriter:      wr,
+		}
+
+		err = client.IndexFeature(f, "test")
+
+		if err != nil {
+			t.Fatalf("IndexFeature failed because %s", err)
+		}
+
+		if !strings.Contains(wr.String(), test.expected) {
+			t.Errorf("expected server centroid %q for %s to write %s, got %s", test.centroid, test.geom, test.expected, wr.String())
+		}
+
+		server := strings.Contains(wr.String(), "ST_PointOnSurface(") || strings.Contains(wr.String(), "ST_Centroid(")
+
+		if server != test.server {
+			t.Errorf("expected server centroid %q for %s to be derived by PostGIS: %t, got %s", test.centroid, test.geom, test.server, wr.String())
+		}
+	}
+}
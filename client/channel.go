@@ -0,0 +1,101 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	wof "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
+	"sync"
+)
+
+type PgisIndexResult struct {
+	Id  int64
+	Err error // nil if the feature was indexed successfully; use IsSkipped to tell skipped features from failures
+}
+
+// IndexChannel indexes features as they arrive on in, in to collection (see
+// IndexFeature), using as many workers as the client has connections, and
+// sends the result for each one to the channel it returns. That channel is closed once in has been closed and all
+// the features read from it have been indexed, or when ctx is cancelled; any
+// records that are still being written when ctx is cancelled are rolled back.
+// Results must be read or the workers will block.
+
+func (client *PgisClient) IndexChannel(ctx context.Context, in <-chan geojson.Feature, collection string) (<-chan PgisIndexResult, error) {
+	return indexChannel(ctx, client, client.maxconns, in, collection)
+}
+
+// IndexChannel for any Indexer, with workers goroutines. A PgisClient is given
+// ctx so that records still being written when it is cancelled are rolled back;
+// other Indexers don't know about contexts so they only stop being sent
+// features.
+
+func indexChannel(ctx context.Context, idx Indexer, workers int, in <-chan geojson.Feature, collection string) (<-chan PgisIndexResult, error) {
+
+	if in == nil {
+		return nil, errors.New("input channel is nil")
+	}
+
+	index := func(f geojson.Feature) error {
+		return idx.IndexFeature(f, collection)
+	}
+
+	client, ok := idx.(*PgisClient)
+
+	if ok {
+
+		index = func(f geojson.Feature) error {
+			return client.IndexFeatureContext(ctx, f, collection)
+		}
+	}
+
+	out := make(chan PgisIndexResult)
+
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for {
+
+				var f geojson.Feature
+				var ok bool
+
+				select {
+				case <-ctx.Done():
+					return
+				case f, ok = <-in:
+
+					if !ok {
+						return
+					}
+				}
+
+				err := index(f)
+
+				rsp := PgisIndexResult{
+					Id:  wof.Id(f),
+					Err: err,
+				}
+
+				select {
+				case out <- rsp:
+					// pass
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,192 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	wof "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// an Indexer that is safe to call from IndexChannel's workers and returns
+// whatever errors says for each ID
+
+type channelIndexer struct {
+	mockIndexer
+	mu          sync.Mutex
+	errors      map[int64]error
+	indexed     []int64
+	collections map[string]bool
+}
+
+func (m *channelIndexer) IndexFeature(f geojson.Feature, collection string) error {
+
+	id := wof.Id(f)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.collections == nil {
+		m.collections = make(map[string]bool)
+	}
+
+	m.indexed = append(m.indexed, id)
+	m.collections[collection] = true
+
+	return m.errors[id]
+}
+
+func testChannelFeature(t *testing.T, id int64) geojson.Feature {
+
+	body := fmt.Sprintf(`{"type":"Feature","id":%d,"properties":{"wof:id":%d,"wof:name":"test","wof:placetype":"locality","wof:repo":"whosonfirst-data","geom:latitude":0,"geom:longitude":0,"geom:bbox":"0,0,0,0"},"geometry":{"type":"Point","coordinates":[0,0]}}`, id, id)
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	return f
+}
+
+func TestIndexChannel(t *testing.T) {
+
+	failed := errors.New("failed")
+
+	idx := &channelIndexer{
+		errors: map[int64]error{
+			2: ErrSkippedDeprecated,
+			3: failed,
+		},
+	}
+
+	_, err := indexChannel(context.Background(), idx, 2, nil, "whosonfirst")
+
+	if err == nil {
+		t.Errorf("expected a nil input channel to be refused")
+	}
+
+	in := make(chan geojson.Feature)
+
+	out, err := indexChannel(context.Background(), idx, 2, in, "whosonfirst_v2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	features := make([]geojson.Feature, 0)
+
+	for id := int64(1); id <= 5; id++ {
+		features = append(features, testChannelFeature(t, id))
+	}
+
+	go func() {
+
+		for _, f := range features {
+			in <- f
+		}
+
+		close(in)
+	}()
+
+	results := make(map[int64]error)
+
+	// out is closed once in has been closed and everything read from it has
+	// been indexed
+
+	for rsp := range out {
+		results[rsp.Id] = rsp.Err
+	}
+
+	if len(results) != 5 {
+		t.Fatalf("expected 5 results, got %v", results)
+	}
+
+	for id, err := range results {
+
+		switch id {
+		case 2:
+
+			// skipped records are reported as such so that they can
+			// be told apart from records that were indexed
+
+			if err != ErrSkippedDeprecated || !IsSkipped(err) {
+				t.Errorf("expected %d to be skipped, got %v", id, err)
+			}
+
+		case 3:
+
+			if err != failed || IsSkipped(err) {
+				t.Errorf("expected %d to fail, got %v", id, err)
+			}
+
+		default:
+
+			if err != nil {
+				t.Errorf("expected %d to succeed, got %v", id, err)
+			}
+		}
+	}
+
+	sort.Slice(idx.indexed, func(i, j int) bool { return idx.indexed[i] < idx.indexed[j] })
+
+	if fmt.Sprint(idx.indexed) != "[1 2 3 4 5]" {
+		t.Errorf("expected every feature to be indexed, got %v", idx.indexed)
+	}
+
+	if len(idx.collections) != 1 || !idx.collections["whosonfirst_v2"] {
+		t.Errorf("expected every feature to be indexed in to whosonfirst_v2, got %v", idx.collections)
+	}
+}
+
+func TestIndexChannelCancelled(t *testing.T) {
+
+	idx := &channelIndexer{}
+
+	// in is never closed
+
+	in := make(chan geojson.Feature)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	out, err := indexChannel(ctx, idx, 2, in, "whosonfirst")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in <- testChannelFeature(t, 1)
+
+	rsp := <-out
+
+	if rsp.Id != 1 || rsp.Err != nil {
+		t.Errorf("expected 1 to be indexed, got %+v", rsp)
+	}
+
+	// a result that nobody reads doesn't keep the workers from stopping
+
+	in <- testChannelFeature(t, 2)
+
+	cancel()
+
+	timeout := time.After(2 * time.Second)
+
+	for {
+
+		select {
+		case _, ok := <-out:
+
+			if !ok {
+				return
+			}
+
+		case <-timeout:
+			t.Fatalf("expected the results channel to be closed once ctx was cancelled")
+		}
+	}
+}
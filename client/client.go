@@ -1,11 +1,12 @@
 package pgis
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
 	geom "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/geometry"
 	wof "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
@@ -14,14 +15,53 @@ import (
 	"github.com/whosonfirst/go-whosonfirst-placetypes"
 	"github.com/whosonfirst/go-whosonfirst-timer"
 	"github.com/whosonfirst/go-whosonfirst-uri"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// IndexFeature returns this for the Earth (wof:id 0) record which we don't index
+// because it confuses PostGIS; it is not really an error so callers will want to
+// check for it explicitly
+
+var ErrSkippedEarth = errors.New("skipped Earth because it confuses PostGIS")
+
+// returned by methods that operate on an existing record when there is no record
+// with that ID in the database
+
+var ErrNotFound = errors.New("record not found")
+
+// IndexFeature returns this, when PgisClient.SkipEmptyGeometry is set, for
+// features that have neither a geometry nor a centroid; otherwise they are
+// an error
+
+var ErrSkippedEmptyGeometry = errors.New("skipped feature with no usable geometry")
+
+// IndexFeature returns these, when PgisClient.SkipDeprecated or SkipSuperseded
+// are set, for features that are deprecated or superseded
+
+var ErrSkippedDeprecated = errors.New("skipped deprecated feature")
+var ErrSkippedSuperseded = errors.New("skipped superseded feature")
+
+// IsSkipped returns true if err is one of the errors IndexFeature returns for a
+// feature it deliberately didn't index, rather than one that failed
+
+func IsSkipped(err error) bool {
+
+	switch err {
+	case ErrSkippedEarth, ErrSkippedEmptyGeometry, ErrSkippedDeprecated, ErrSkippedSuperseded:
+		return true
+	default:
+		return false
+	}
+}
+
 type Meta struct {
 	Name      string             `json:"wof:name"`
 	Country   string             `json:"wof:country"`
@@ -29,6 +69,19 @@ type Meta struct {
 	Hierarchy []map[string]int64 `json:"wof:hierarchy"`
 }
 
+// this is the same as Meta but used when empty values should be left out
+// of the JSON stored in the database (see PgisClient.OmitEmptyMeta); it only
+// applies to meta because none of the columns promoted out of it are ever
+// empty: records without a repo are refused (ErrMissingRepo) and the
+// hierarchy columns use -1 for missing ancestors, like parent_id
+
+type metaOmitEmpty struct {
+	Name      string             `json:"wof:name,omitempty"`
+	Country   string             `json:"wof:country,omitempty"`
+	Repo      string             `json:"wof:repo,omitempty"`
+	Hierarchy []map[string]int64 `json:"wof:hierarchy,omitempty"`
+}
+
 type PgisRow struct {
 	Id           int64
 	ParentId     int64
@@ -57,8 +110,8 @@ func QueryRowToPgisRow(row PgisResultSet) (*PgisRow, error) {
 	var superseded int
 	var deprecated int
 	var meta string
-	var geom string
-	var centroid string
+	var geom sql.NullString // see notes in GetById below
+	var centroid sql.NullString
 
 	err := row.Scan(&wofid, &parentid, &placetypeid, &superseded, &deprecated, &meta, &geom, &centroid)
 
@@ -66,7 +119,7 @@ func QueryRowToPgisRow(row PgisResultSet) (*PgisRow, error) {
 		return nil, err
 	}
 
-	pgrow, err := NewPgisRow(wofid, parentid, placetypeid, superseded, deprecated, meta, geom, centroid)
+	pgrow, err := NewPgisRow(wofid, parentid, placetypeid, superseded, deprecated, meta, geom.String, centroid.String)
 
 	if err != nil {
 		return nil, err
@@ -138,41 +191,127 @@ func NewPgisAsyncWorker(client *PgisClient, expected int, per_page int, num_proc
 	return &w, nil
 }
 
+const PGIS_DEFAULT_DRIVER = "postgres"
+
+const PGIS_DEFAULT_CONN_MAX_LIFETIME = 30 * time.Minute
+const PGIS_DEFAULT_CONN_MAX_IDLE_TIME = 5 * time.Minute
+
+// when PgisClient.AsyncCommit is true this is run at the start of the
+// transaction each record is written in, which means PostgreSQL reports the
+// write as done before it has been flushed to disk. This is a lot faster for
+// bulk loads but if the server crashes the most recent writes (although never
+// more than a fraction of a second's worth) are lost, even though IndexFeature
+// said they succeeded, so it is only meant for loads that can be started over.
+// It does not affect the statements written to SQLWriter.
+
+const SQL_ASYNC_COMMIT = "SET LOCAL synchronous_commit = off"
+
+// records are subdivided afresh every time they are indexed
+
+const SQL_DELETE_SUBDIVIDED = "DELETE FROM whosonfirst_subdivided WHERE id=$1"
+
+// the wof:hierarchy keys that are copied in to columns of the same name when
+// StoreHierarchy is true
+
+var HIERARCHY_COLUMNS = []string{"continent_id", "country_id", "region_id", "locality_id"}
+
 type PgisClient struct {
-	Geometry string
-	Debug    bool
-	Verbose  bool
-	Logger   *log.WOFLogger
-	dsn      string
-	db       *sql.DB
-	conns    chan bool
+	Geometry             string
+	SimplifiedTolerance  float64
+	SubdivideMaxVertices int
+	CoordinatePrecision  int
+	ProjectedSRID        int
+	GeohashPrecision     int
+	RunId                string
+	ConflictKey          string
+	GeomColumn           string
+	CentroidColumn       string
+	StoreArea            bool
+	StoreLength          bool
+	StoreHierarchy       bool
+	StoreBBox            bool
+	StoreRaw             bool
+	StoreFeatureHash     bool
+	StoreValidity        bool
+	StoreLatLon          bool
+	StrictCRS            bool
+	RingOrientation      string
+	ServerCentroid       string
+	AsyncCommit          bool
+	PoolerMode           string
+	CreatePartitions     bool
+	PointsTable          string
+	SkipGeometry         bool
+	SkipEmptyGeometry    bool
+	SkipDeprecated       bool
+	SkipSuperseded       bool
+	PlacetypeResolver    PgisPlacetypeResolver
+	ExtraColumns         PgisExtraColumnsFunc
+	OmitEmptyMeta        bool
+	MaxMetaBytes         int
+	Progress             chan<- PgisIndexProgress
+	ProgressTotal        int64
+	SQLWriter            io.Writer
+	Metrics              PgisMetrics
+	Explain              bool
+	Debug                bool
+	Verbose              bool
+	Logger               *log.WOFLogger
+	dsn                  string
+	sql_mu               sync.Mutex
+	id_mu                [ID_LOCK_STRIPES]sync.Mutex
+	postgis_mu           sync.Mutex
+	postgis_version      []int
+	partitions           sync.Map
+	db                   *sql.DB
+	conns                chan bool
+	maxconns             int
+	progress_indexed     int64
+	progress_errors      int64
 }
 
 func NewPgisClient(host string, port int, user string, password string, dbname string, maxconns int) (*PgisClient, error) {
 
-	var dsn string
+	dsn := NewPgisDSN(host, port, user, password, dbname)
+	return NewPgisClientWithDSN(dsn, maxconns)
+}
 
-	if password == "" {
-		dsn = fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable", host, port, user, dbname)
-	} else {
-		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	}
+func NewPgisClientWithDSN(dsn string, maxconns int) (*PgisClient, error) {
+
+	return NewPgisClientWithDriver(PGIS_DEFAULT_DRIVER, dsn, maxconns)
+}
+
+// driver is the name of any database/sql driver that speaks PostgreSQL, for
+// example "postgres" (lib/pq, which is what we use by default) or "pgx" if you
+// have imported github.com/jackc/pgx/stdlib in your own code
 
-	db, err := sql.Open("postgres", dsn)
+func NewPgisClientWithDriver(driver string, dsn string, maxconns int) (*PgisClient, error) {
+
+	db, err := sql.Open(driver, dsn)
 
 	if err != nil {
-		return nil, err
+		return nil, redactError(dsn, err)
 	}
 
 	db.SetMaxIdleConns(512)
 	db.SetMaxOpenConns(1024)
 
+	// PostgreSQL (or more often something like pgbouncer or a load balancer
+	// in front of it) will quietly drop connections that have been open, or
+	// idle, for too long which database/sql only finds out about when it
+	// hands one out - see also SetConnMaxLifetime and SetConnMaxIdleTime
+
+	db.SetConnMaxLifetime(PGIS_DEFAULT_CONN_MAX_LIFETIME)
+	db.SetConnMaxIdleTime(PGIS_DEFAULT_CONN_MAX_IDLE_TIME)
+
 	// defer db.Close()
 
 	err = db.Ping()
 
 	if err != nil {
-		return nil, err
+		client_err := redactError(dsn, err)
+		msg := fmt.Sprintf("failed to connect to %s because %s", redactDSN(dsn), client_err)
+		return nil, errors.New(msg)
 	}
 
 	conns := make(chan bool, maxconns)
@@ -184,17 +323,47 @@ func NewPgisClient(host string, port int, user string, password string, dbname s
 	logger := log.SimpleWOFLogger("pgis-client")
 
 	client := PgisClient{
-		Geometry: "", // use the default geojson geometry
-		Debug:    false,
-		Logger:   logger,
-		dsn:      dsn,
-		db:       db,
-		conns:    conns,
+		Geometry:             "",  // use the default geojson geometry
+		SimplifiedTolerance:  0.0, // don't store a simplified geometry
+		SubdivideMaxVertices: 0,   // don't store subdivided geometries
+		OmitEmptyMeta:        false,
+		Debug:                false,
+		Logger:               logger,
+		dsn:                  dsn,
+		db:                   db,
+		conns:                conns,
+		maxconns:             maxconns,
 	}
 
 	return &client, nil
 }
 
+// Close waits for any in-flight queries to complete and then closes the
+// underlying database handle; the client can't be used after this
+
+func (client *PgisClient) Close() error {
+
+	for i := 0; i < client.maxconns; i++ {
+		<-client.conns
+	}
+
+	return client.db.Close()
+}
+
+// https://golang.org/pkg/database/sql/#DB.SetConnMaxLifetime
+// https://golang.org/pkg/database/sql/#DB.SetConnMaxIdleTime
+
+// a value of zero means connections are never closed for being too old (or
+// idle for too long)
+
+func (client *PgisClient) SetConnMaxLifetime(d time.Duration) {
+	client.db.SetConnMaxLifetime(d)
+}
+
+func (client *PgisClient) SetConnMaxIdleTime(d time.Duration) {
+	client.db.SetConnMaxIdleTime(d)
+}
+
 func (client *PgisClient) dbconn() (*sql.DB, error) {
 
 	<-client.conns
@@ -210,6 +379,29 @@ func (client *PgisClient) Connection() (*sql.DB, error) {
 }
 
 func (client *PgisClient) GetById(id int64) (*PgisRow, error) {
+	return client.GetByIdContext(context.Background(), id)
+}
+
+// GetByIdContext is GetById for callers, like HTTP handlers, that want the
+// query abandoned if ctx is cancelled
+
+func (client *PgisClient) GetByIdContext(ctx context.Context, id int64) (*PgisRow, error) {
+
+	t1 := time.Now()
+
+	row, err := client.getById(ctx, id)
+
+	client.reportQueried(t1, err)
+	return row, err
+}
+
+func (client *PgisClient) getById(ctx context.Context, id int64) (*PgisRow, error) {
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
 
 	db, err := client.dbconn()
 
@@ -217,6 +409,10 @@ func (client *PgisClient) GetById(id int64) (*PgisRow, error) {
 		return nil, err
 	}
 
+	defer func() {
+		client.conns <- true
+	}()
+
 	var wofid int64
 	var parentid int64
 	var placetypeid int64
@@ -226,13 +422,13 @@ func (client *PgisClient) GetById(id int64) (*PgisRow, error) {
 	var centroid sql.NullString // this column should never be NULL but
 	var geom sql.NullString     // this column might be so... https://golang.org/pkg/database/sql/#NullString
 
-	sql := fmt.Sprintf("SELECT id, parent_id, placetype_id, is_superseded, is_deprecated, meta, ST_AsGeoJSON(geom), ST_AsGeoJSON(centroid) FROM whosonfirst WHERE id=$1")
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE id=$1", client.columns().rowColumns(), source)
 
-	row := db.QueryRow(sql, id)
+	row := db.QueryRowContext(ctx, sql, id)
 	err = row.Scan(&wofid, &parentid, &placetypeid, &superseded, &deprecated, &meta, &geom, &centroid)
 
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 
 	pgrow, err := NewPgisRow(wofid, parentid, placetypeid, superseded, deprecated, meta, geom.String, centroid.String)
@@ -244,283 +440,1442 @@ func (client *PgisClient) GetById(id int64) (*PgisRow, error) {
 	return pgrow, nil
 }
 
-func (client *PgisClient) IndexFeature(feature geojson.Feature, collection string) error {
+// Exists is a cheap check for whether there is a record for id, without
+// fetching (or parsing) any of it
 
-	wofid := wof.Id(feature)
+func (client *PgisClient) Exists(ctx context.Context, id int64) (bool, error) {
 
-	if wofid == 0 {
-		client.Logger.Debug("skipping Earth because it confuses PostGIS")
-		return nil
-	}
+	t1 := time.Now()
 
-	str_wofid := strconv.FormatInt(wofid, 10)
+	exists, err := client.exists(ctx, id)
 
-	geom_type := geom.Type(feature)
+	client.reportQueried(t1, err)
+	return exists, err
+}
 
-	str_geom, err := geom.ToString(feature)
+func (client *PgisClient) exists(ctx context.Context, id int64) (bool, error) {
+
+	source, err := client.recordSource()
 
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// we do this now because we might redefine str_geom below (to
-	// be "") if we are dealing with a Point geometry which will
-	// cause the JSON wrangling in HashGeometry to fail
-	// (20170823/thisisaaronland)
-
-	geom_hash, err := utils.HashGeometry([]byte(str_geom))
+	db, err := client.dbconn()
 
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	centroid, err := wof.Centroid(feature)
+	defer func() {
+		client.conns <- true
+	}()
 
-	if err != nil {
-		return err
-	}
+	var exists bool
 
-	// client.Logger.Status("Centroid for %d derived from %s", wofid, centroid.Source())
+	sql := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE id=$1)", source)
 
-	str_centroid, err := centroid.ToString()
+	row := db.QueryRowContext(ctx, sql, id)
+	err = row.Scan(&exists)
 
 	if err != nil {
-		return err
-	}
-
-	if geom_type == "Point" {
-		str_centroid = str_geom
-		str_geom = ""
+		return false, err
 	}
 
-	placetype := wof.Placetype(feature)
+	return exists, nil
+}
 
-	pt, err := placetypes.GetPlacetypeByName(placetype)
+// GetByIds returns the records for all of ids that exist, keyed by ID, in as few
+// queries as possible; IDs without a record are simply left out. Like DeleteIds
+// very long lists are fetched in chunks.
 
-	if err != nil {
-		return err
-	}
+func (client *PgisClient) GetByIds(ctx context.Context, ids []int64) (map[int64]*PgisRow, error) {
 
-	repo := wof.Repo(feature)
+	t1 := time.Now()
 
-	if repo == "" {
+	results, err := client.getByIds(ctx, ids)
 
-		msg := fmt.Sprintf("missing wof:repo for %s", str_wofid)
-		return errors.New(msg)
-	}
+	client.reportQueried(t1, err)
+	return results, err
+}
 
-	parent := wof.ParentId(feature)
+func (client *PgisClient) getByIds(ctx context.Context, ids []int64) (map[int64]*PgisRow, error) {
 
-	is_deprecated, err := wof.IsDeprecated(feature)
+	source, err := client.recordSource()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	is_superseded, err := wof.IsSuperseded(feature)
+	sql := getByIdsSQL(client.columns().rowColumns(), source)
 
-	if err != nil {
-		return err
-	}
+	results := make(map[int64]*PgisRow)
 
-	str_deprecated := is_deprecated.StringFlag()
-	str_superseded := is_superseded.StringFlag()
+	for _, chunk := range chunkIds(ids, IDS_CHUNK_SIZE) {
 
-	meta_key := str_wofid + "#meta"
+		if client.Verbose {
+			client.Logger.Status("%s (%d IDs)", sql, len(chunk))
+		}
 
-	name := wof.Name(feature)
-	country := wof.Country(feature)
+		rows, err := client.selectRows(ctx, sql, pq.Array(chunk))
 
-	hier := wof.Hierarchy(feature)
+		if err != nil {
+			return nil, err
+		}
 
-	meta := Meta{
-		Name:      name,
-		Country:   country,
-		Hierarchy: hier,
-		Repo:      repo,
+		for _, row := range rows {
+			results[row.Id] = row
+		}
 	}
 
-	meta_json, err := json.Marshal(meta)
-
-	if err != nil {
-		client.Logger.Warning("FAILED to marshal JSON on %s because, %v", meta_key, err)
-		return err
-	}
+	return results, nil
+}
 
-	str_meta := string(meta_json)
+func getByIdsSQL(columns string, source string) string {
+	return fmt.Sprintf("SELECT %s FROM %s WHERE id = ANY($1)", columns, source)
+}
 
-	now := time.Now()
-	lastmod := now.Format(time.RFC3339)
+// the number of mutexes IndexFeature spreads records across; see below
 
-	// http://www.postgis.org/docs/ST_Multi.html
-	// http://postgis.net/docs/ST_GeomFromGeoJSON.html
+const ID_LOCK_STRIPES = 256
 
-	st_geojson := fmt.Sprintf("ST_Multi(ST_GeomFromGeoJSON('%s'))", str_geom)
-	st_centroid := fmt.Sprintf("ST_GeomFromGeoJSON('%s')", str_centroid)
+// waits for any other IndexFeature for id (or another ID in the same stripe) to
+// finish and returns the function that lets the next one go
 
-	if client.Verbose {
+func (client *PgisClient) lockId(id int64) func() {
 
-		// because we might be in verbose mode but not debug mode
-		// so the actual GeoJSON blob needs to be preserved
+	mu := &client.id_mu[uint64(id)%ID_LOCK_STRIPES]
+	mu.Lock()
 
-		actual_st_geojson := st_geojson
+	return mu.Unlock
+}
 
-		if client.Geometry == "" {
-			st_geojson = "ST_Multi(ST_GeomFromGeoJSON('...'))"
-		}
+func (client *PgisClient) IndexFeature(feature geojson.Feature, collection string) error {
+	return client.IndexFeatureContext(context.Background(), feature, collection)
+}
 
-		client.Logger.Status("INSERT INTO whosonfirst (id, parent_id, placetype_id, is_superseded, is_deprecated, meta, geom_hash, lastmod, geom, centroid) VALUES (%d, %d, %d, %s, %s, %s, %s, %s, %s, %s)", wofid, parent, pt.Id, str_superseded, str_deprecated, str_meta, geom_hash, lastmod, st_geojson, st_centroid)
+// IndexFeatureContext is IndexFeature for callers, like IndexChannel,
+// IndexBytes or a loader that wants to stop on SIGINT, that have a ctx whose
+// cancellation should roll back a record that is still being written
 
-		st_geojson = actual_st_geojson
-	}
+func (client *PgisClient) IndexFeatureContext(ctx context.Context, feature geojson.Feature, collection string) error {
 
-	if !client.Debug {
+	// two goroutines upserting the same record at the same time (for
+	// example because it appears twice in a filelist) will just end up
+	// waiting on each other's row locks in PostgreSQL, or deadlocking if
+	// they are also writing to whosonfirst_subdivided, so they are made to
+	// take turns here instead; records with different IDs that happen to
+	// share a stripe also take turns but that only costs a little throughput
 
-		db, err := client.dbconn()
+	wofid := wof.Id(feature)
 
-		if err != nil {
-			return err
-		}
+	t1 := time.Now()
 
-		defer func() {
-			client.conns <- true
-		}()
+	unlock := client.lockId(wofid)
 
-		// https://www.postgresql.org/docs/9.6/static/sql-insert.html#SQL-ON-CONFLICT
-		// https://wiki.postgresql.org/wiki/What's_new_in_PostgreSQL_9.5#INSERT_..._ON_CONFLICT_DO_NOTHING.2FUPDATE_.28.22UPSERT.22.29
+	err := client.indexFeature(ctx, feature, collection)
 
-		var sql string
+	unlock()
 
-		if str_geom != "" && str_centroid != "" {
+	client.reportProgress(wofid, err)
+	client.reportIndexed(t1, err)
+	return err
+}
 
-			sql = fmt.Sprintf("INSERT INTO whosonfirst (id, parent_id, placetype_id, is_superseded, is_deprecated, meta, geom_hash, lastmod, geom, centroid) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, %s, %s) ON CONFLICT(id) DO UPDATE SET parent_id=$9, placetype_id=$10, is_superseded=$11, is_deprecated=$12, meta=$13, geom_hash=$14, lastmod=$15, geom=%s, centroid=%s", st_geojson, st_centroid, st_geojson, st_centroid)
+func (client *PgisClient) indexFeature(ctx context.Context, feature geojson.Feature, collection string) error {
 
-		} else if str_geom != "" {
+	wofid := wof.Id(feature)
 
-			sql = fmt.Sprintf("INSERT INTO whosonfirst (id, parent_id, placetype_id, is_superseded, is_deprecated, meta, geom_hash, lastmod, xgeom, centroid) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, %s) ON CONFLICT(id) DO UPDATE SET parent_id=$9, placetype_id=$10, is_superseded=$11, is_deprecated=$12, meta=$13, geom_hash=$14, lastmod=$15, geom=%s", st_geojson, st_geojson)
+	if wofid == 0 {
+		client.Logger.Debug("skipping Earth because it confuses PostGIS")
+		return ErrSkippedEarth
+	}
 
-		} else if str_centroid != "" {
+	if client.SkipDeprecated {
 
-			sql = fmt.Sprintf("INSERT INTO whosonfirst (id, parent_id, placetype_id, is_superseded, is_deprecated, meta, geom_hash, lastmod, centroid) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, %s) ON CONFLICT(id) DO UPDATE SET parent_id=$9, placetype_id=$10, is_superseded=$11, is_deprecated=$12, meta=$13, geom_hash=$14, lastmod=$15, centroid=%s", st_centroid, st_centroid)
+		is_deprecated, err := wof.IsDeprecated(feature)
 
-		} else {
-			// this should never happend
+		if err != nil {
+			return err
 		}
 
-		_, err = db.Exec(sql, wofid, parent, pt.Id, str_superseded, str_deprecated, str_meta, geom_hash, lastmod, parent, pt.Id, str_superseded, str_deprecated, str_meta, geom_hash, lastmod)
+		if is_deprecated.IsTrue() {
+			client.Logger.Debug("skipping %d because it is deprecated", wofid)
+			return ErrSkippedDeprecated
+		}
+	}
 
-		if err != nil {
+	if client.SkipSuperseded {
 
-			client.Logger.Error("failed to execute query because %s", err)
-			client.Logger.Debug("%s", sql)
+		is_superseded, err := wof.IsSuperseded(feature)
 
-			os.Exit(1)
+		if err != nil {
 			return err
 		}
+
+		if is_superseded.IsTrue() {
+			client.Logger.Debug("skipping %d because it is superseded", wofid)
+			return ErrSkippedSuperseded
+		}
 	}
 
-	return nil
+	geom_type := geom.Type(feature)
 
-}
+	str_geom, err := geom.ToString(feature)
 
-func (client *PgisClient) Prune(data_root string, delete bool) error {
+	if err != nil {
+		return newPgisError(ErrInvalidGeometry, err)
+	}
 
-	db, err := client.dbconn()
+	// an empty (or missing) geometry is treated the same as no geometry
+	// at all; whether that's a problem depends on the centroid, see below
 
-	if err != nil {
-		return err
+	if !hasCoordinates(str_geom) {
+		str_geom = ""
 	}
 
-	sql_count := "SELECT COUNT(id) FROM whosonfirst"
+	// we do this now because we might redefine str_geom below (to
+	// be "") if we are dealing with a Point geometry which will
+	// cause the JSON wrangling in HashGeometry to fail
+	// (20170823/thisisaaronland)
 
-	row := db.QueryRow(sql_count)
+	geom_hash := ""
 
-	var count_rows int
-	err = row.Scan(&count_rows)
+	if str_geom != "" {
 
-	if err != nil {
-		return err
-	}
+		geom_hash, err = utils.HashGeometry([]byte(str_geom))
 
-	client.Logger.Status("PRUNE %d records", count_rows)
+		if err != nil {
+			return err
+		}
+	}
 
-	limit := 10000
-	procs := runtime.NumCPU() * 2
+	// older versions of PostGIS don't know what to do with a bbox member
+	// (which is optional and, for a geometry, unusual) and we don't need it
+	// since StoreBBox derives the bbox column from the geometry itself
 
-	w, err := NewPgisAsyncWorker(client, count_rows, limit, procs)
+	str_geom, err = stripBBox(str_geom)
 
 	if err != nil {
-		return err
+		return newPgisError(ErrInvalidGeometry, err)
 	}
 
-	w.QueryFunc = QueryRowToPgisRowForPruning
-
-	go w.Query("SELECT id, meta FROM whosonfirst")
+	// if the geometry isn't being stored (see below) there's nothing for
+	// PostGIS to derive a centroid from
 
-	tm, err := timer.NewDefaultTimer()
+	st_server_centroid, err := client.serverCentroid()
 
 	if err != nil {
-		w.ErrorChannel <- err
 		return err
 	}
 
-	defer tm.Stop()
-
-	fetching := 1
-	count := 0
+	if geom_type == "Point" || str_geom == "" || client.SkipGeometry {
+		st_server_centroid = ""
+	}
 
-	count_throttle := 100
+	str_centroid := ""
 
-	throttle_ch := make(chan bool, count_throttle)
+	if st_server_centroid == "" {
 
-	for t := 0; t < count_throttle; t++ {
-		throttle_ch <- true
-	}
+		centroid, err := wof.Centroid(feature)
 
-	for f := fetching; f > 0; {
-		select {
-		case row := <-w.ResultChannel:
+		if err != nil {
+			return err
+		}
 
-			<-throttle_ch
+		// client.Logger.Status("Centroid for %d derived from %s", wofid, centroid.Source())
 
-			go func() {
+		str_centroid, err = centroid.ToString()
 
-				defer func() {
-					throttle_ch <- true
-				}()
+		if err != nil {
+			return err
+		}
+	}
 
-				err := client.PruneRow(row, data_root, delete)
+	if geom_type == "Point" {
+		str_centroid = str_geom
+		str_geom = ""
+	}
 
-				if err != nil {
-					w.ErrorChannel <- err
-				}
+	// in which case geom will be NULL and the only thing spatial queries can
+	// match against is the centroid
 
-				count += 1
-			}()
+	if client.SkipGeometry {
+		str_geom = ""
+	}
 
-		case err := <-w.ErrorChannel:
-			return err
-		case <-w.DoneChannel:
-			f--
-		}
+	if !hasCoordinates(str_centroid) {
+		str_centroid = ""
 	}
 
-	client.Logger.Status("Pruned %d", count)
-	return nil
-}
+	// without either there is nothing to insert that any spatial query
+	// could ever match so it's better to say so than to quietly store a
+	// record with neither
 
-func (client *PgisClient) PruneRow(row *PgisRow, data_root string, delete bool) error {
+	if str_geom == "" && str_centroid == "" {
 
-	return nil
-	var meta Meta
+		if client.SkipEmptyGeometry {
+			client.Logger.Warning("skipping %d because it has no usable geometry or centroid", wofid)
+			return ErrSkippedEmptyGeometry
+		}
 
-	err := json.Unmarshal([]byte(row.Meta), &meta)
+		msg := fmt.Sprintf("feature %d has no usable geometry or centroid", wofid)
+		return newPgisError(ErrInvalidGeometry, errors.New(msg))
+	}
+
+	prop_cols, prop_args, err := client.propertyColumns(feature)
 
 	if err != nil {
 		return err
 	}
 
-	repo := filepath.Join(data_root, meta.Repo)
+	lastmod := lastModified(feature)
+
+	// http://postgis.net/docs/ST_Transform.html
+
+	// geometries in some other CRS are transformed to EPSG:4326 unless we're
+	// being strict about it; note that only Point centroids (which are the
+	// geometry) are transformed since the others come from WOF properties
+
+	srid, err := featureSRID(feature)
+
+	if err != nil {
+		return err
+	}
+
+	if srid != PGIS_STORAGE_SRID && client.StrictCRS {
+		msg := fmt.Sprintf("geometry for %d is EPSG:%d rather than EPSG:%d", wofid, srid, PGIS_STORAGE_SRID)
+		return newPgisError(ErrInvalidGeometry, errors.New(msg))
+	}
+
+	centroid_srid := PGIS_STORAGE_SRID
+
+	if geom_type == "Point" {
+		centroid_srid = srid
+	}
+
+	cols := []string{"id"}
+	cols = append(cols, prop_cols...)
+	cols = append(cols, "geom_hash", "lastmod")
+
+	args := []interface{}{wofid}
+	args = append(args, prop_args...)
+	args = append(args, geom_hash, lastmod)
+
+	if client.RunId != "" {
+		cols = append(cols, "run_id")
+		args = append(args, client.RunId)
+	}
+
+	conflict, err := client.conflictColumns()
+
+	if err != nil {
+		return err
+	}
+
+	if client.conflictRepo() {
+		cols = append(cols, "repo")
+		args = append(args, wof.Repo(feature))
+	}
+
+	vals := make([]string, len(cols))
+
+	for i := range cols {
+		vals[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	// like IndexGeometry the GeoJSON is bound as a parameter, once, rather
+	// than being pasted in to the expression for every column derived from
+	// it; parameters are only added if they're used since PostgreSQL can't
+	// work out the type of one that isn't
+
+	geom_placeholder := ""
+	centroid_placeholder := ""
+
+	if str_geom != "" {
+		args = append(args, str_geom)
+		geom_placeholder = fmt.Sprintf("$%d", len(args))
+	}
+
+	if str_centroid != "" {
+		args = append(args, str_centroid)
+		centroid_placeholder = fmt.Sprintf("$%d", len(args))
+	}
+
+	st_geojson := geomFromGeoJSON(geom_placeholder, srid)
+	st_centroid := geomFromGeoJSON(centroid_placeholder, centroid_srid)
+
+	// http://postgis.net/docs/ST_SnapToGrid.html
+	// http://postgis.net/docs/ST_MakeValid.html
+
+	// snapping coordinates to a grid can collapse rings or make them touch so
+	// the result is run through ST_MakeValid and, for polygons, anything that
+	// isn't a polygon (stray lines and points) is thrown away
+
+	if client.CoordinatePrecision > 0 {
+
+		grid := math.Pow(10, -float64(client.CoordinatePrecision))
+
+		st_geojson = fmt.Sprintf("ST_MakeValid(ST_SnapToGrid(%s, %g))", st_geojson, grid)
+		st_centroid = fmt.Sprintf("ST_SnapToGrid(%s, %g)", st_centroid, grid)
+
+		switch geom_type {
+		case "Polygon", "MultiPolygon":
+			st_geojson = fmt.Sprintf("ST_CollectionExtract(%s, 3)", st_geojson)
+		}
+	}
+
+	// the centroid is derived from the geometry as it's actually stored,
+	// which is to say after snapping
+
+	if st_server_centroid != "" {
+		st_centroid = fmt.Sprintf("%s(%s)", st_server_centroid, st_geojson)
+	}
+
+	// this happens after snapping because ST_MakeValid doesn't promise to
+	// preserve the orientation of anything
+
+	st_geojson, err = client.orientRings(st_geojson)
+
+	if err != nil {
+		return err
+	}
+
+	// http://postgis.net/docs/ST_SimplifyPreserveTopology.html
+
+	st_simplified := fmt.Sprintf("ST_SimplifyPreserveTopology(%s, %g)", st_geojson, client.SimplifiedTolerance)
+
+	// http://www.postgis.org/docs/ST_Multi.html
+
+	// geometries that are already multi-geometries are left as-is rather than
+	// being wrapped in ST_Multi which is redundant at best and can produce an
+	// extra level of collection-ness for some inputs at worst
+
+	switch geom_type {
+	case "MultiPolygon", "MultiPoint", "MultiLineString":
+		// pass
+	default:
+		st_geojson = fmt.Sprintf("ST_Multi(%s)", st_geojson)
+		st_simplified = fmt.Sprintf("ST_Multi(%s)", st_simplified)
+	}
+
+	// the geometry and centroid expressions are only used if there's
+	// something for them to be made from
+
+	if str_geom == "" {
+		st_geojson = ""
+	}
+
+	if str_centroid == "" && st_server_centroid == "" {
+		st_centroid = ""
+	}
+
+	geom_cols, geom_vals := client.geometryColumns(st_geojson, st_simplified, st_centroid)
+
+	cols = append(cols, geom_cols...)
+	vals = append(vals, geom_vals...)
+
+	table, err := client.routeTable(geom_type)
+
+	if err != nil {
+		return err
+	}
+
+	if client.Verbose {
+
+		// because we might be in verbose mode but not debug mode
+		// so the actual GeoJSON blob needs to be preserved
+
+		display_args := displayArgs(cols, args)
+
+		if client.Geometry == "" && str_geom != "" {
+			display_args[len(cols)] = "..."
+		}
+
+		display_sql := upsertSQL(cols, vals, conflict)
+
+		if table != "" {
+			display_sql = upsertTableSQL(table, cols, vals, conflict)
+		}
+
+		client.Logger.Status("%s %v", display_sql, display_args)
+	}
+
+	// this has already been validated by propertyColumns
+
+	pt, err := client.resolvePlacetype(wof.Placetype(feature))
+
+	if err != nil {
+		return err
+	}
+
+	u := &pgisUpsert{
+		id:        wofid,
+		placetype: pt,
+		table:     table,
+		repo:      wof.Repo(feature),
+		cols:      cols,
+		vals:      vals,
+		args:      args,
+	}
+
+	// http://postgis.net/docs/ST_Subdivide.html
+
+	// this is the geometry as it was given, before any snapping
+
+	if str_geom != "" && client.SubdivideMaxVertices > 0 {
+
+		u.subdivide = fmt.Sprintf("INSERT INTO whosonfirst_subdivided (id, geom) SELECT $1, ST_Subdivide(%s, %d)", geomFromGeoJSON("$2", srid), client.SubdivideMaxVertices)
+		u.subdivide_args = []interface{}{wofid, str_geom}
+
+		if client.Verbose {
+			client.Logger.Status("%s %d", SQL_DELETE_SUBDIVIDED, wofid)
+			client.Logger.Status("%s %d ...", u.subdivide, wofid)
+		}
+	}
+
+	return client.upsert(ctx, u)
+}
+
+// http://postgis.net/docs/ST_GeomFromGeoJSON.html
+
+// the SQL for the GeoJSON geometry bound to placeholder, transformed to
+// EPSG:4326 if srid is something else
+
+func geomFromGeoJSON(placeholder string, srid int) string {
+
+	st_geom := fmt.Sprintf("ST_GeomFromGeoJSON(%s)", placeholder)
+
+	if srid != PGIS_STORAGE_SRID {
+		st_geom = fmt.Sprintf("ST_Transform(ST_SetSRID(%s, %d), %d)", st_geom, srid, PGIS_STORAGE_SRID)
+	}
+
+	return st_geom
+}
+
+// the columns derived from a record's geometry and centroid (both SQL
+// expressions, either of which may be "" if the record doesn't have one) that
+// the client has been configured to store; st_simplified is the simplified
+// version of st_geom
+
+func (client *PgisClient) geometryColumns(st_geom string, st_simplified string, st_centroid string) ([]string, []string) {
+
+	names := client.columns()
+
+	geom_cols := []string{names.geom}
+	geom_vals := []string{st_geom}
+
+	if client.SimplifiedTolerance > 0.0 {
+		geom_cols = append(geom_cols, "geom_simplified")
+		geom_vals = append(geom_vals, st_simplified)
+	}
+
+	// http://postgis.net/docs/ST_Transform.html
+
+	// the geom column is a geography which is always EPSG:4326 so
+	// projected geometries go in a geometry column of their own
+
+	if client.ProjectedSRID > 0 {
+		geom_cols = append(geom_cols, "geom_projected")
+		geom_vals = append(geom_vals, fmt.Sprintf("ST_Transform(ST_SetSRID(%s, 4326), %d)", st_geom, client.ProjectedSRID))
+	}
+
+	// http://postgis.net/docs/ST_MakeEnvelope.html
+
+	// not ST_Envelope since that returns a POINT or a LINESTRING for
+	// a degenerate geometry (a horizontal or vertical line, or a
+	// polygon that collapsed when it was snapped) which can't be
+	// stored in a POLYGON column; the box of an empty geometry is
+	// NULL so its bbox is too
+
+	if client.StoreBBox {
+		geom_cols = append(geom_cols, "bbox")
+		geom_vals = append(geom_vals, fmt.Sprintf("(SELECT ST_MakeEnvelope(ST_XMin(b), ST_YMin(b), ST_XMax(b), ST_YMax(b), 4326) FROM Box2D(%s) AS b)", st_geom))
+	}
+
+	// http://postgis.net/docs/ST_Area.html
+
+	if client.StoreArea {
+		geom_cols = append(geom_cols, "area_meters")
+		geom_vals = append(geom_vals, fmt.Sprintf("ST_Area(%s::geography)", st_geom))
+	}
+
+	// http://postgis.net/docs/ST_Length.html - this is 0 for
+	// anything that isn't a line just as the area of a line is 0
+
+	if client.StoreLength {
+		geom_cols = append(geom_cols, "length_meters")
+		geom_vals = append(geom_vals, fmt.Sprintf("ST_Length(%s::geography)", st_geom))
+	}
+
+	centroid_cols := []string{names.centroid}
+	centroid_vals := []string{st_centroid}
+
+	// http://postgis.net/docs/ST_GeoHash.html
+
+	if client.GeohashPrecision > 0 {
+		centroid_cols = append(centroid_cols, "geohash")
+		centroid_vals = append(centroid_vals, fmt.Sprintf("ST_GeoHash(%s, %d)", st_centroid, client.GeohashPrecision))
+	}
+
+	// http://postgis.net/docs/ST_X.html
+	// http://postgis.net/docs/ST_Y.html
+
+	if client.StoreLatLon {
+		centroid_cols = append(centroid_cols, "latitude", "longitude")
+		centroid_vals = append(centroid_vals, fmt.Sprintf("ST_Y(%s)", st_centroid), fmt.Sprintf("ST_X(%s)", st_centroid))
+	}
+
+	// a record without a geometry (or a centroid) still sets the columns
+	// derived from it, to NULL, so that re-indexing it doesn't leave behind
+	// whatever was there before
+
+	if st_geom == "" {
+
+		for i := range geom_vals {
+			geom_vals[i] = "NULL"
+		}
+	}
+
+	if st_centroid == "" {
+
+		for i := range centroid_vals {
+			centroid_vals[i] = "NULL"
+		}
+	}
+
+	cols := append(geom_cols, centroid_cols...)
+	vals := append(geom_vals, centroid_vals...)
+
+	return cols, vals
+}
+
+// pgisUpsert is a single record to be written to the whosonfirst (or points)
+// table and, if subdivide isn't "", the statement (with subdivide_args) that
+// repopulates its rows in the whosonfirst_subdivided table
+
+type pgisUpsert struct {
+	id             int64
+	placetype      *placetypes.WOFPlacetype
+	table          string // "" means the whosonfirst table
+	repo           string // only used with the id+repo conflict key
+	cols           []string
+	vals           []string
+	args           []interface{}
+	subdivide      string
+	subdivide_args []interface{}
+}
+
+// the statements (and their arguments) that delete any other copy of u's
+// record, in the same transaction it is written in: one in the table it isn't
+// being written to, which is where it will be if its geometry has changed from
+// a point to something else, or back, since it was last indexed (see
+// PointsTable) and, with the id+placetype conflict key, one with a different
+// placetype since ON CONFLICT will never see that (and with CreatePartitions it
+// will be in a different partition)
+
+func (client *PgisClient) staleSQL(u *pgisUpsert) ([]string, [][]interface{}, error) {
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	target := u.table
+
+	if target == "" {
+		target = "whosonfirst"
+	}
+
+	stmts := make([]string, 0)
+	stmt_args := make([][]interface{}, 0)
+
+	for _, table := range tables {
+
+		sql := fmt.Sprintf("DELETE FROM %s WHERE id=$1", table)
+		args := []interface{}{u.id}
+
+		if client.conflictRepo() {
+			sql = fmt.Sprintf("%s AND repo=$2", sql)
+			args = append(args, u.repo)
+		}
+
+		if table == target {
+
+			if client.ConflictKey != PGIS_CONFLICT_ID_PLACETYPE {
+				continue
+			}
+
+			sql = fmt.Sprintf("%s AND placetype_id != $2", sql)
+			args = append(args, u.placetype.Id)
+		}
+
+		stmts = append(stmts, sql)
+		stmt_args = append(stmt_args, args)
+	}
+
+	return stmts, stmt_args, nil
+}
+
+// write u to the database, or to client.SQLWriter, creating its partition first
+// if necessary; this is the last step for everything that indexes records
+
+func (client *PgisClient) upsert(ctx context.Context, u *pgisUpsert) error {
+
+	conflict, err := client.conflictColumns()
+
+	if err != nil {
+		return err
+	}
+
+	subdivide := u.subdivide != ""
+
+	if subdivide && client.conflictRepo() {
+		return errors.New("subdivided geometries are keyed by id alone so they can not be used with the id+repo conflict key")
+	}
+
+	sql_upsert := upsertSQL(u.cols, u.vals, conflict)
+
+	if u.table != "" {
+		sql_upsert = upsertTableSQL(u.table, u.cols, u.vals, conflict)
+	}
+
+	stale, stale_args, err := client.staleSQL(u)
+
+	if err != nil {
+		return err
+	}
+
+	if client.Verbose {
+
+		for i, s := range stale {
+			client.Logger.Status("%s %v", s, stale_args[i])
+		}
+	}
+
+	// this writes the statement out too, so it happens first; partitions
+	// only ever belong to the whosonfirst table
+
+	if u.table == "" {
+
+		err = client.ensurePartition(ctx, u.placetype.Id, u.placetype.Name)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// write the statements out rather than executing them, even in debug
+	// mode since writing a file isn't indexing anything
+
+	if client.SQLWriter != nil {
+
+		stmts := append(stale, sql_upsert)
+		stmt_args := append(stale_args, u.args)
+
+		if subdivide {
+			stmts = append(stmts, SQL_DELETE_SUBDIVIDED, u.subdivide)
+			stmt_args = append(stmt_args, []interface{}{u.id}, u.subdivide_args)
+		}
+
+		if len(stmts) > 1 {
+			stmts = append([]string{"BEGIN"}, append(stmts, "COMMIT")...)
+			stmt_args = append([][]interface{}{nil}, append(stmt_args, nil)...)
+		}
+
+		return client.writeSQL(stmts, stmt_args)
+	}
+
+	if client.Debug {
+		return nil
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	err = client.requirePostGIS(ctx, db, client.postGISRequirements())
+
+	if err != nil {
+		return err
+	}
+
+	// the statement that failed, if one does
+
+	failed := sql_upsert
+
+	if subdivide || client.AsyncCommit || len(stale) > 0 {
+
+		// the record, any old copy of it and its subdivided geometries
+		// need to be updated together or not at all
+
+		err = withTx(ctx, db, func(tx *sql.Tx) error {
+
+			// https://www.postgresql.org/docs/9.6/static/wal-async-commit.html
+
+			// SET LOCAL only lasts until the end of the transaction so
+			// the setting never leaks in to anything else that ends up
+			// using the same (pooled) connection
+
+			if client.AsyncCommit {
+
+				failed = SQL_ASYNC_COMMIT
+				_, err := tx.Exec(SQL_ASYNC_COMMIT)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			for i, s := range stale {
+
+				failed = s
+				_, err := tx.Exec(s, stale_args[i]...)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			failed = sql_upsert
+			_, err := tx.Exec(sql_upsert, u.args...)
+
+			if err != nil || !subdivide {
+				return err
+			}
+
+			failed = SQL_DELETE_SUBDIVIDED
+			_, err = tx.Exec(SQL_DELETE_SUBDIVIDED, u.id)
+
+			if err != nil {
+				return err
+			}
+
+			failed = u.subdivide
+			_, err = tx.Exec(u.subdivide, u.subdivide_args...)
+
+			return err
+		})
+
+	} else {
+		_, err = db.ExecContext(ctx, sql_upsert, u.args...)
+	}
+
+	if err != nil {
+
+		client.Logger.Error("failed to execute query because %s", err)
+		client.Logger.Debug("%s", failed)
+
+		return classifyError(err)
+	}
+
+	return nil
+}
+
+// the UPDATE statements for UpdateMeta, one per record table, along with the
+// columns their args are for; the args start with the id and end with the repo
+// when that's part of the conflict key, neither of which is updated
+
+func (client *PgisClient) updateMetaSQL(feature geojson.Feature) ([]string, []string, []interface{}, error) {
+
+	wofid := wof.Id(feature)
+
+	cols, args, err := client.propertyColumns(feature)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cols = append(cols, "lastmod")
+	args = append(args, lastModified(feature))
+
+	updates := make([]string, len(cols))
+
+	for i, col := range cols {
+		updates[i] = fmt.Sprintf("%s=$%d", col, i+2)
+	}
+
+	where := "id=$1"
+
+	cols = append([]string{"id"}, cols...)
+	args = append([]interface{}{wofid}, args...)
+
+	if client.conflictRepo() {
+		cols = append(cols, "repo")
+		args = append(args, wof.Repo(feature))
+		where = fmt.Sprintf("%s AND repo=$%d", where, len(args))
+	}
+
+	// the record is only ever in one of them (see staleSQL)
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	stmts := make([]string, len(tables))
+
+	for i, table := range tables {
+		stmts[i] = fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(updates, ", "), where)
+	}
+
+	return stmts, cols, args, nil
+}
+
+// UpdateMeta updates the property columns (meta, parent_id, placetype_id and the
+// deprecated/superseded flags) for an existing record leaving its geometries, and
+// geom_hash, untouched. This is a lot cheaper than IndexFeature when only things
+// like names or hierarchies have changed.
+
+func (client *PgisClient) UpdateMeta(ctx context.Context, feature geojson.Feature) error {
+
+	t1 := time.Now()
+
+	err := client.updateMeta(ctx, feature)
+
+	client.reportExecuted(t1, err)
+	return err
+}
+
+func (client *PgisClient) updateMeta(ctx context.Context, feature geojson.Feature) error {
+
+	stmts, cols, args, err := client.updateMetaSQL(feature)
+
+	if err != nil {
+		return err
+	}
+
+	if client.Verbose {
+
+		for _, stmt := range stmts {
+			client.Logger.Status("%s %v", stmt, displayArgs(cols, args))
+		}
+	}
+
+	if client.Debug {
+		return nil
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	updated := int64(0)
+
+	for _, sql := range stmts {
+
+		rsp, err := db.ExecContext(ctx, sql, args...)
+
+		if err != nil {
+			client.Logger.Error("failed to execute query because %s", err)
+			return err
+		}
+
+		count, err := rsp.RowsAffected()
+
+		if err != nil {
+			return err
+		}
+
+		updated += count
+	}
+
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// the (non-geometry) columns, and their values, derived from the properties of
+// a feature; these are shared by IndexFeature and UpdateMeta
+
+func (client *PgisClient) propertyColumns(feature geojson.Feature) ([]string, []interface{}, error) {
+
+	wofid := wof.Id(feature)
+	str_wofid := strconv.FormatInt(wofid, 10)
+
+	placetype := wof.Placetype(feature)
+
+	pt, err := client.resolvePlacetype(placetype)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo := wof.Repo(feature)
+
+	if repo == "" {
+
+		msg := fmt.Sprintf("missing wof:repo for %s", str_wofid)
+		return nil, nil, newPgisError(ErrMissingRepo, errors.New(msg))
+	}
+
+	parent := wof.ParentId(feature)
+
+	is_deprecated, err := wof.IsDeprecated(feature)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	is_superseded, err := wof.IsSuperseded(feature)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	str_deprecated := is_deprecated.StringFlag()
+	str_superseded := is_superseded.StringFlag()
+
+	meta_key := str_wofid + "#meta"
+
+	name := wof.Name(feature)
+	country := wof.Country(feature)
+
+	hier := wof.Hierarchy(feature)
+
+	meta := Meta{
+		Name:      name,
+		Country:   country,
+		Hierarchy: hier,
+		Repo:      repo,
+	}
+
+	meta_json, err := client.marshalMeta(meta)
+
+	if err != nil {
+		client.Logger.Warning("FAILED to marshal JSON on %s because, %v", meta_key, err)
+		return nil, nil, err
+	}
+
+	// the only thing in meta that can get really big is the hierarchies, for
+	// records that have lots of them, so if there's too much meta we keep the
+	// first one and give up if that still isn't small enough
+
+	if client.MaxMetaBytes > 0 && len(meta_json) > client.MaxMetaBytes {
+
+		if len(meta.Hierarchy) > 1 {
+
+			client.Logger.Warning("meta for %s is %d bytes, keeping only the first of %d hierarchies", str_wofid, len(meta_json), len(meta.Hierarchy))
+
+			meta.Hierarchy = meta.Hierarchy[0:1]
+			meta_json, err = client.marshalMeta(meta)
+
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if len(meta_json) > client.MaxMetaBytes {
+			msg := fmt.Sprintf("meta for %s is %d bytes which is more than the maximum of %d", str_wofid, len(meta_json), client.MaxMetaBytes)
+			return nil, nil, newPgisError(ErrMetaTooLarge, errors.New(msg))
+		}
+	}
+
+	str_meta := string(meta_json)
+
+	cols := []string{"parent_id", "placetype_id", "is_superseded", "is_deprecated", "meta"}
+	args := []interface{}{parent, pt.Id, str_superseded, str_deprecated, str_meta}
+
+	// records with more than one hierarchy only get the ancestors from
+	// the first one; the full list is still in the meta column
+
+	if client.StoreHierarchy {
+
+		for _, col := range HIERARCHY_COLUMNS {
+
+			ancestor := int64(-1)
+
+			if len(hier) > 0 {
+
+				id, ok := hier[0][col]
+
+				if ok {
+					ancestor = id
+				}
+			}
+
+			cols = append(cols, col)
+			args = append(args, ancestor)
+		}
+	}
+
+	// the raw feature lives with the property columns so that UpdateMeta
+	// keeps it current too
+
+	if client.StoreRaw {
+		cols = append(cols, "raw")
+		args = append(args, string(feature.Bytes()))
+	}
+
+	if client.StoreValidity {
+		cols = append(cols, "valid_range")
+		args = append(args, validRange(feature))
+	}
+
+	if client.StoreFeatureHash {
+
+		feature_hash, err := HashFeature(feature)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cols = append(cols, "feature_hash")
+		args = append(args, feature_hash)
+	}
+
+	// "id" isn't in cols yet but it is always written
+
+	extra_cols, extra_args, err := client.extraColumns(feature, append([]string{"id"}, cols...))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cols = append(cols, extra_cols...)
+	args = append(args, extra_args...)
+
+	return cols, args, nil
+}
+
+func (client *PgisClient) marshalMeta(meta Meta) ([]byte, error) {
+
+	if client.OmitEmptyMeta {
+		return json.Marshal(metaOmitEmpty(meta))
+	}
+
+	return json.Marshal(meta)
+}
+
+// the values for a statement as they should be logged in verbose mode, which is
+// to say without the (raw) GeoJSON blob; cols and args must line up
+
+func displayArgs(cols []string, args []interface{}) []interface{} {
+
+	display := make([]interface{}, len(args))
+	copy(display, args)
+
+	for i, col := range cols {
+
+		if col == "raw" && i < len(display) {
+			display[i] = "..."
+		}
+	}
+
+	return display
+}
+
+// the value for the lastmod column: the feature's own wof:lastmodified (which
+// is a Unix timestamp) if it has one and now if it doesn't
+
+func lastModified(feature geojson.Feature) string {
+
+	ts := wof.LastModified(feature)
+
+	if ts > 0 {
+		return time.Unix(ts, 0).Format(time.RFC3339)
+	}
+
+	return time.Now().Format(time.RFC3339)
+}
+
+// https://www.postgresql.org/docs/9.6/static/sql-insert.html#SQL-ON-CONFLICT
+// https://wiki.postgresql.org/wiki/What's_new_in_PostgreSQL_9.5#INSERT_..._ON_CONFLICT_DO_NOTHING.2FUPDATE_.28.22UPSERT.22.29
+
+func upsertSQL(cols []string, vals []string, conflict []string) string {
+	return upsertTableSQL("whosonfirst", cols, vals, conflict)
+}
+
+func upsertTableSQL(table string, cols []string, vals []string, conflict []string) string {
+
+	is_conflict := make(map[string]bool)
+
+	for _, col := range conflict {
+		is_conflict[col] = true
+	}
+
+	updates := make([]string, 0)
+
+	for _, col := range cols {
+
+		if is_conflict[col] {
+			continue
+		}
+
+		updates = append(updates, fmt.Sprintf("%s=EXCLUDED.%s", col, col))
+	}
+
+	str_cols := strings.Join(cols, ", ")
+	str_vals := strings.Join(vals, ", ")
+	str_conflict := strings.Join(conflict, ", ")
+	str_updates := strings.Join(updates, ", ")
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT(%s) DO UPDATE SET %s", table, str_cols, str_vals, str_conflict, str_updates)
+}
+
+// https://www.postgresql.org/docs/9.6/static/sql-vacuum.html
+// https://www.postgresql.org/docs/9.6/static/sql-analyze.html
+
+// Optimize runs VACUUM ANALYZE, and Analyze just ANALYZE, on every table the
+// client writes to, which is worth doing after a big load since until then the
+// planner's statistics are stale.
+
+func (client *PgisClient) Optimize(ctx context.Context) error {
+
+	return client.maintenance(ctx, "VACUUM ANALYZE")
+}
+
+func (client *PgisClient) Analyze(ctx context.Context) error {
+
+	return client.maintenance(ctx, "ANALYZE")
+}
+
+func (client *PgisClient) maintenance(ctx context.Context, command string) error {
+
+	var db *sql.DB
+
+	// the client has no way of knowing whether IndexLabel is being used so
+	// the labels table is looked for rather than assumed (in debug mode
+	// that means it is left out)
+
+	labels := false
+
+	if !client.Debug {
+
+		conn, err := client.dbconn()
+
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			client.conns <- true
+		}()
+
+		db = conn
+
+		row := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_name=$1 AND table_schema=ANY(current_schemas(false)))", PGIS_LABELS_TABLE)
+		err = row.Scan(&labels)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	stmts, err := client.maintenanceSQL(command, labels)
+
+	if err != nil {
+		return err
+	}
+
+	for _, sql := range stmts {
+
+		if client.Verbose {
+			client.Logger.Status("%s", sql)
+		}
+
+		if client.Debug {
+			continue
+		}
+
+		_, err = db.ExecContext(ctx, sql)
+
+		if err != nil {
+			client.Logger.Warning("Failed to execute %s because %s", sql, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// command (VACUUM ANALYZE or ANALYZE) for each of the tables the client writes
+// to: the ones records are written to, whosonfirst_subdivided if it writes
+// subdivided geometries and the labels table if labels is true
+
+func (client *PgisClient) maintenanceSQL(command string, labels bool) ([]string, error) {
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if client.SubdivideMaxVertices > 0 {
+		tables = append(tables, "whosonfirst_subdivided")
+	}
+
+	if labels {
+		tables = append(tables, PGIS_LABELS_TABLE)
+	}
+
+	stmts := make([]string, len(tables))
+
+	for i, table := range tables {
+		stmts[i] = fmt.Sprintf("%s %s", command, table)
+	}
+
+	return stmts, nil
+}
+
+// Exec runs a statement (typically an UPDATE or DELETE) that isn't covered by
+// one of the other methods, using the client's connection pool and honouring
+// Verbose and Debug, and returns the number of rows it affected. In debug mode
+// nothing is run and the count is always 0.
+
+func (client *PgisClient) Exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+
+	t1 := time.Now()
+
+	affected, err := client.exec(ctx, sql, args...)
+
+	client.reportExecuted(t1, err)
+	return affected, err
+}
+
+func (client *PgisClient) exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, args)
+	}
+
+	if client.Debug {
+		return 0, nil
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	rsp, err := db.ExecContext(ctx, sql, args...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return rsp.RowsAffected()
+}
+
+func (client *PgisClient) Prune(data_root string, delete bool) error {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	sql_count := "SELECT COUNT(id) FROM whosonfirst"
+
+	row := db.QueryRow(sql_count)
+
+	var count_rows int
+	err = row.Scan(&count_rows)
+
+	if err != nil {
+		return err
+	}
+
+	client.Logger.Status("PRUNE %d records", count_rows)
+
+	limit := 10000
+	procs := runtime.NumCPU() * 2
+
+	w, err := NewPgisAsyncWorker(client, count_rows, limit, procs)
+
+	if err != nil {
+		return err
+	}
+
+	w.QueryFunc = QueryRowToPgisRowForPruning
+
+	go w.Query("SELECT id, meta FROM whosonfirst")
+
+	tm, err := timer.NewDefaultTimer()
+
+	if err != nil {
+		w.ErrorChannel <- err
+		return err
+	}
+
+	defer tm.Stop()
+
+	fetching := 1
+	count := 0
+
+	count_throttle := 100
+
+	throttle_ch := make(chan bool, count_throttle)
+
+	for t := 0; t < count_throttle; t++ {
+		throttle_ch <- true
+	}
+
+	for f := fetching; f > 0; {
+		select {
+		case row := <-w.ResultChannel:
+
+			<-throttle_ch
+
+			go func() {
+
+				defer func() {
+					throttle_ch <- true
+				}()
+
+				err := client.PruneRow(row, data_root, delete)
+
+				if err != nil {
+					w.ErrorChannel <- err
+				}
+
+				count += 1
+			}()
+
+		case err := <-w.ErrorChannel:
+			return err
+		case <-w.DoneChannel:
+			f--
+		}
+	}
+
+	client.Logger.Status("Pruned %d", count)
+	return nil
+}
+
+func (client *PgisClient) PruneRow(row *PgisRow, data_root string, delete bool) error {
+
+	return nil
+	var meta Meta
+
+	err := json.Unmarshal([]byte(row.Meta), &meta)
+
+	if err != nil {
+		return err
+	}
+
+	repo := filepath.Join(data_root, meta.Repo)
 	data := filepath.Join(repo, "data")
 
 	wofid := row.Id
@@ -563,6 +1918,347 @@ func (client *PgisClient) PruneRow(row *PgisRow, data_root string, delete bool)
 	return nil
 }
 
+// SweepStale deletes all the records for repo that were not (re)indexed with
+// PgisClient.RunId set to run_id, which is to say records that have disappeared
+// from the repo since the last time it was indexed
+
+func (client *PgisClient) SweepStale(ctx context.Context, repo string, run_id string) (int64, error) {
+
+	t1 := time.Now()
+
+	swept, err := client.sweepStale(ctx, repo, run_id)
+
+	client.reportExecuted(t1, err)
+	return swept, err
+}
+
+func (client *PgisClient) sweepStale(ctx context.Context, repo string, run_id string) (int64, error) {
+
+	if repo == "" || run_id == "" {
+		return 0, errors.New("sweeping requires both a repo and a run ID")
+	}
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		return 0, err
+	}
+
+	stmts := sweepStaleSQL(tables)
+
+	if client.Verbose {
+
+		for _, stmt := range stmts {
+			client.Logger.Status("%s %s %s", stmt, repo, run_id)
+		}
+	}
+
+	if client.Debug {
+		return 0, nil
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	// the records are swept from every table or none of them
+
+	swept := int64(0)
+
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+
+		for _, sql := range stmts {
+
+			rsp, err := tx.ExecContext(ctx, sql, repo, run_id)
+
+			if err != nil {
+				client.Logger.Warning("Failed to sweep %s because %s (%s)", repo, err, sql)
+				return err
+			}
+
+			count, err := rsp.RowsAffected()
+
+			if err != nil {
+				return err
+			}
+
+			swept += count
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return swept, nil
+}
+
+// the DELETE statements for SweepStale, one per table, which take the repo and
+// the run ID as $1 and $2; records that were never stamped with a run ID are
+// stale too
+
+func sweepStaleSQL(tables []string) []string {
+
+	stmts := make([]string, len(tables))
+
+	for i, table := range tables {
+		stmts[i] = fmt.Sprintf("DELETE FROM %s WHERE meta->>'wof:repo'=$1 AND (run_id IS NULL OR run_id != $2)", table)
+	}
+
+	return stmts
+}
+
+// ids are passed as a single array parameter so there's no bind-parameter
+// limit to worry about but we still delete, fetch, touch or fix them in chunks
+// to keep individual statements (and the locks they hold) a reasonable size
+
+const IDS_CHUNK_SIZE = 10000
+
+// Deprecated: use IDS_CHUNK_SIZE
+
+const DELETE_IDS_CHUNK_SIZE = IDS_CHUNK_SIZE
+
+// ids split in to consecutive chunks of no more than size IDs each
+
+func chunkIds(ids []int64, size int) [][]int64 {
+
+	chunks := make([][]int64, 0)
+
+	for start := 0; start < len(ids); start += size {
+
+		end := start + size
+
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		chunks = append(chunks, ids[start:end])
+	}
+
+	return chunks
+}
+
+func deleteIdsSQL(table string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE id = ANY($1)", table)
+}
+
+// DeleteIds removes the records for ids from the whosonfirst table, and the
+// points table if there is one, and returns the number of records removed
+
+func (client *PgisClient) DeleteIds(ctx context.Context, ids []int64) (int64, error) {
+
+	t1 := time.Now()
+
+	deleted, err := client.deleteIds(ctx, ids)
+
+	client.reportExecuted(t1, err)
+	return deleted, err
+}
+
+func (client *PgisClient) deleteIds(ctx context.Context, ids []int64) (int64, error) {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := int64(0)
+
+	// the chunks keep each statement a reasonable size but they are all
+	// deleted in the same transaction so that a failure (or ctx being
+	// cancelled) part way through doesn't leave only some of ids deleted
+
+	del := func(tx *sql.Tx) error {
+
+		for _, chunk := range chunkIds(ids, IDS_CHUNK_SIZE) {
+
+			for _, table := range tables {
+
+				sql := deleteIdsSQL(table)
+
+				if client.Verbose {
+					client.Logger.Status("%s (%d ids)", sql, len(chunk))
+				}
+
+				if client.Debug {
+					continue
+				}
+
+				rsp, err := tx.ExecContext(ctx, sql, pq.Array(chunk))
+
+				if err != nil {
+					client.Logger.Warning("Failed to delete ids because %s (%s)", err, sql)
+					return err
+				}
+
+				count, err := rsp.RowsAffected()
+
+				if err != nil {
+					return err
+				}
+
+				deleted += count
+			}
+		}
+
+		return nil
+	}
+
+	if client.Debug {
+		return 0, del(nil)
+	}
+
+	err = withTx(ctx, db, del)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// DeleteFeature removes the record for feature, if there is one; like DeleteIds
+// this is by ID alone so with the id+repo conflict key the records for every
+// repo are removed
+
+func (client *PgisClient) DeleteFeature(ctx context.Context, feature geojson.Feature) error {
+
+	_, err := client.DeleteIds(ctx, []int64{wof.Id(feature)})
+	return err
+}
+
+// Touch sets the lastmod column of each of ids to now, without changing anything
+// else, and returns the number of records updated. If the client has a RunId the
+// records are also stamped with it so that SweepStale will leave them be. Like
+// DeleteIds the updates are done in chunks.
+
+func (client *PgisClient) Touch(ctx context.Context, ids []int64) (int64, error) {
+
+	t1 := time.Now()
+
+	touched, err := client.touch(ctx, ids)
+
+	client.reportExecuted(t1, err)
+	return touched, err
+}
+
+func (client *PgisClient) touch(ctx context.Context, ids []int64) (int64, error) {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	// an RFC 3339 string rather than now() so that this works with older
+	// databases where lastmod is a CHAR(25) too (see README)
+
+	lastmod := time.Now().Format(time.RFC3339)
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		return 0, err
+	}
+
+	stmts, args := touchSQL(tables, lastmod, client.RunId)
+
+	touched := int64(0)
+
+	// like DeleteIds every chunk is updated in the same transaction
+
+	update := func(tx *sql.Tx) error {
+
+		for _, chunk := range chunkIds(ids, IDS_CHUNK_SIZE) {
+
+			for _, sql := range stmts {
+
+				if client.Verbose {
+					client.Logger.Status("%s (%d ids) %v", sql, len(chunk), args)
+				}
+
+				if client.Debug {
+					continue
+				}
+
+				rsp, err := tx.ExecContext(ctx, sql, append([]interface{}{pq.Array(chunk)}, args...)...)
+
+				if err != nil {
+					client.Logger.Warning("Failed to touch ids because %s (%s)", err, sql)
+					return err
+				}
+
+				count, err := rsp.RowsAffected()
+
+				if err != nil {
+					return err
+				}
+
+				touched += count
+			}
+		}
+
+		return nil
+	}
+
+	if client.Debug {
+		return 0, update(nil)
+	}
+
+	err = withTx(ctx, db, update)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return touched, nil
+}
+
+// the UPDATE statements for Touch, one per table, and the arguments that follow
+// the chunk of IDs ($1) in each of them
+
+func touchSQL(tables []string, lastmod string, run_id string) ([]string, []interface{}) {
+
+	set := "lastmod=$2"
+	args := []interface{}{lastmod}
+
+	if run_id != "" {
+		set = "lastmod=$2, run_id=$3"
+		args = append(args, run_id)
+	}
+
+	stmts := make([]string, len(tables))
+
+	for i, table := range tables {
+		stmts[i] = fmt.Sprintf("UPDATE %s SET %s WHERE id = ANY($1)", table, set)
+	}
+
+	return stmts, args
+}
+
 func (w *PgisAsyncWorker) Query(sql string, args ...interface{}) {
 
 	defer func() {
@@ -649,3 +2345,102 @@ func (w *PgisAsyncWorker) Query(sql string, args ...interface{}) {
 		}
 	}
 }
+
+// https://tools.ietf.org/html/rfc7946#section-5
+
+// str_geom without any bbox members, including those of the members of a
+// GeometryCollection
+
+func stripBBox(str_geom string) (string, error) {
+
+	// don't bother parsing (potentially very large) geometries that can't
+	// possibly have one
+
+	if !strings.Contains(str_geom, `"bbox"`) {
+		return str_geom, nil
+	}
+
+	var g map[string]json.RawMessage
+
+	err := json.Unmarshal([]byte(str_geom), &g)
+
+	if err != nil {
+		return "", err
+	}
+
+	delete(g, "bbox")
+
+	raw_members, ok := g["geometries"]
+
+	if ok {
+
+		var members []json.RawMessage
+
+		err := json.Unmarshal(raw_members, &members)
+
+		if err != nil {
+			return "", err
+		}
+
+		for i, m := range members {
+
+			str_member, err := stripBBox(string(m))
+
+			if err != nil {
+				return "", err
+			}
+
+			members[i] = json.RawMessage(str_member)
+		}
+
+		enc, err := json.Marshal(members)
+
+		if err != nil {
+			return "", err
+		}
+
+		g["geometries"] = enc
+	}
+
+	enc, err := json.Marshal(g)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(enc), nil
+}
+
+// true if str_geom is a GeoJSON geometry with at least one coordinate in it;
+// a GeometryCollection counts if any of its members do
+
+func hasCoordinates(str_geom string) bool {
+
+	if str_geom == "" {
+		return false
+	}
+
+	var g struct {
+		Coordinates json.RawMessage   `json:"coordinates"`
+		Geometries  []json.RawMessage `json:"geometries"`
+	}
+
+	err := json.Unmarshal([]byte(str_geom), &g)
+
+	if err != nil {
+		return false
+	}
+
+	if strings.ContainsAny(string(g.Coordinates), "0123456789") {
+		return true
+	}
+
+	for _, member := range g.Geometries {
+
+		if hasCoordinates(string(member)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,2052 @@
+package pgis
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStripBBox(t *testing.T) {
+
+	tests := []struct {
+		geom     string
+		stripped string
+		err      bool
+	}{
+		{`{"type":"Point","coordinates":[1,2]}`, `{"type":"Point","coordinates":[1,2]}`, false},
+		{`{"type":"Point","bbox":[1,2,1,2],"coordinates":[1,2]}`, `{"coordinates":[1,2],"type":"Point"}`, false},
+		{`{"type":"GeometryCollection","bbox":[0,0,1,2],"geometries":[{"type":"Point","bbox":[1,2,1,2],"coordinates":[1,2]},{"type":"Point","coordinates":[0,0]}]}`, `{"geometries":[{"coordinates":[1,2],"type":"Point"},{"type":"Point","coordinates":[0,0]}],"type":"GeometryCollection"}`, false},
+		{`{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[{"type":"Point","bbox":[1,2,1,2],"coordinates":[1,2]}]}]}`, `{"geometries":[{"geometries":[{"coordinates":[1,2],"type":"Point"}],"type":"GeometryCollection"}],"type":"GeometryCollection"}`, false},
+		{`{"type":"Point","bbox":`, "", true},
+	}
+
+	for _, test := range tests {
+
+		stripped, err := stripBBox(test.geom)
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("stripBBox(%q) returned %q, expected an error", test.geom, stripped)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("stripBBox(%q) failed because %s", test.geom, err)
+			continue
+		}
+
+		if stripped != test.stripped {
+			t.Errorf("stripBBox(%q) returned %q, expected %q", test.geom, stripped, test.stripped)
+		}
+	}
+}
+
+func TestSkippedEarth(t *testing.T) {
+
+	body := `{"type":"Feature","id":0,"properties":{"wof:id":0,"wof:name":"Earth","wof:placetype":"planet","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":0,"geom:longitude":0,"geom:bbox":"-180,-90,180,90"},"geometry":{"type":"Polygon","coordinates":[[[-180,-90],[180,-90],[180,90],[-180,90],[-180,-90]]]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	wr := &bytes.Buffer{}
+
+	client := &PgisClient{
+		Logger:    log.SimpleWOFLogger("test"),
+		SQLWriter: wr,
+	}
+
+	rec := &PgisGeometryRecord{
+		Id:        0,
+		ParentId:  -1,
+		Placetype: "planet",
+		Meta:      Meta{Name: "Earth", Repo: "whosonfirst-data"},
+		Geometry:  []byte("POLYGON((-180 -90,180 -90,180 90,-180 90,-180 -90))"),
+		Format:    GEOMETRY_FORMAT_WKT,
+	}
+
+	tests := []struct {
+		what  string
+		index func() error
+	}{
+		{"IndexFeature", func() error { return client.IndexFeature(f, "test") }},
+		{"IndexLabel", func() error { return client.IndexLabel(f) }},
+		{"IndexGeometry", func() error { return client.IndexGeometry(context.Background(), rec) }},
+	}
+
+	for _, test := range tests {
+
+		err := test.index()
+
+		if err != ErrSkippedEarth {
+			t.Errorf("%s for Earth returned %v, expected ErrSkippedEarth", test.what, err)
+		}
+
+		if !IsSkipped(err) {
+			t.Errorf("%s for Earth returned an error that IsSkipped doesn't recognize", test.what)
+		}
+	}
+
+	if wr.Len() > 0 {
+		t.Errorf("expected nothing to be written for Earth, got %q", wr.String())
+	}
+}
+
+func TestHasCoordinates(t *testing.T) {
+
+	tests := []struct {
+		geom     string
+		expected bool
+	}{
+		{`{"type":"Point","coordinates":[-73.5,45.5]}`, true},
+		{`{"type":"Point","coordinates":[]}`, false},
+		{`{"type":"Polygon","coordinates":[[]]}`, false},
+		{`{"type":"GeometryCollection","geometries":[]}`, false},
+		{`{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[]},{"type":"Point","coordinates":[0,0]}]}`, true},
+		{`{"type":"Point"}`, false},
+		{"", false},
+		{"{", false},
+	}
+
+	for _, test := range tests {
+
+		if hasCoordinates(test.geom) != test.expected {
+			t.Errorf("expected hasCoordinates(%q) to be %t", test.geom, test.expected)
+		}
+	}
+}
+
+// a feature with no usable geometry or centroid fails to index, or is skipped
+// if SkipEmptyGeometry is set, without anything being written
+
+func TestSkippedEmptyGeometry(t *testing.T) {
+
+	body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	for _, skip := range []bool{false, true} {
+
+		wr := &bytes.Buffer{}
+
+		client := &PgisClient{
+			Logger:            log.SimpleWOFLogger("test"),
+			SQLWriter:         wr,
+			SkipEmptyGeometry: skip,
+		}
+
+		err := client.IndexFeature(f, "test")
+
+		if skip && err != ErrSkippedEmptyGeometry {
+			t.Errorf("expected ErrSkippedEmptyGeometry, got %v", err)
+		}
+
+		if skip && !IsSkipped(err) {
+			t.Errorf("expected IsSkipped to recognize %v", err)
+		}
+
+		if !skip && !errors.Is(err, ErrInvalidGeometry) {
+			t.Errorf("expected ErrInvalidGeometry, got %v", err)
+		}
+
+		if wr.Len() > 0 {
+			t.Errorf("expected nothing to be written, got %q", wr.String())
+		}
+	}
+}
+
+// with SkipGeometry a record is written with its centroid and properties but
+// with the geometry, and everything derived from it, set to NULL so that the
+// only thing a spatial query can find it by is its centroid
+
+func TestSkipGeometry(t *testing.T) {
+
+	body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45,-73,46"},"geometry":{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	var mu sync.Mutex
+	var upsert string
+	var upsert_args []driver.Value
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if !strings.HasPrefix(query, "INSERT INTO whosonfirst ") {
+			return &testResult{columns: TEST_ROW_COLUMNS}, nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		upsert = query
+		upsert_args = args
+
+		return &testResult{affected: 1}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	client.SkipGeometry = true
+	client.SimplifiedTolerance = 0.001
+	client.ProjectedSRID = 3857
+	client.StoreBBox = true
+	client.StoreArea = true
+	client.StoreLength = true
+
+	err = client.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature failed because %s", err)
+	}
+
+	values := testInsertValues(t, upsert)
+
+	for _, col := range []string{"geom", "geom_simplified", "geom_projected", "bbox", "area_meters", "length_meters"} {
+
+		if values[col] != "NULL" {
+			t.Errorf("expected %s to be NULL, got %q", col, values[col])
+		}
+	}
+
+	if !strings.HasPrefix(values["centroid"], "ST_GeomFromGeoJSON($") {
+		t.Errorf("expected the centroid to be stored, got %q", values["centroid"])
+	}
+
+	// the meta is written as usual and there is no geometry to bind
+
+	i, err := strconv.Atoi(strings.TrimPrefix(values["meta"], "$"))
+
+	if err != nil || i < 1 || i > len(upsert_args) {
+		t.Fatalf("expected meta to be a parameter, got %q", values["meta"])
+	}
+
+	if !strings.Contains(fmt.Sprint(upsert_args[i-1]), `"wof:name":"Montreal"`) {
+		t.Errorf("expected the meta to be written, got %v", upsert_args[i-1])
+	}
+
+	if strings.Contains(fmt.Sprint(upsert_args), "Polygon") {
+		t.Errorf("expected the geometry not to be sent at all, got %v", upsert_args)
+	}
+
+	// an intersects query only tests the geometry, which is NULL, so it
+	// never finds the record and a bbox query falls back to the centroid
+
+	polygon := []byte(`{"type":"Polygon","coordinates":[[[-74,45],[-73.9,45],[-73.9,45.1],[-74,45.1],[-74,45]]]}`)
+
+	opts := NewDefaultPgisIntersectsOptions()
+
+	_, err = client.IntersectsGeometry(context.Background(), polygon, opts)
+
+	if err != nil {
+		t.Fatalf("IntersectsGeometry failed because %s", err)
+	}
+
+	opts = NewDefaultPgisIntersectsOptions()
+	opts.BBox = []float64{-74, 45, -73.9, 45.1}
+	opts.UseBBoxColumn = true
+
+	it, err := client.Query(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("Query failed because %s", err)
+	}
+
+	it.Close()
+
+	stmts := db.statements()
+
+	tests := []struct {
+		what     string
+		stmt     string
+		expected []string
+	}{
+		{"intersects", stmts[len(stmts)-2], []string{"ST_Intersects(geom, "}},
+		{"bbox", stmts[len(stmts)-1], []string{"(bbox IS NULL OR bbox && ", "ST_Intersects(COALESCE(geom, centroid), "}},
+	}
+
+	for _, test := range tests {
+
+		parts := strings.SplitN(test.stmt, " WHERE ", 2)
+
+		if len(parts) != 2 {
+			t.Errorf("expected the %s query to have a WHERE clause, got %s", test.what, test.stmt)
+			continue
+		}
+
+		where := strings.Replace(parts[1], "COALESCE(geom, centroid)", "", -1)
+
+		if strings.Contains(where, "centroid") {
+			t.Errorf("expected the %s query to only use the centroid in place of the geometry, got %s", test.what, parts[1])
+		}
+
+		for _, e := range test.expected {
+
+			if !strings.Contains(parts[1], e) {
+				t.Errorf("expected the %s query to test %s, got %s", test.what, e, parts[1])
+			}
+		}
+	}
+}
+
+func TestSkipDeprecatedSuperseded(t *testing.T) {
+
+	fixture := func(extra string) geojson.Feature {
+
+		body := fmt.Sprintf(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data",%s"geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, extra)
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		return f
+	}
+
+	live := fixture("")
+	deprecated := fixture(`"edtf:deprecated":"2017-08-23",`)
+	superseded := fixture(`"wof:superseded_by":[1108955789],`)
+	both := fixture(`"edtf:deprecated":"2017-08-23","wof:superseded_by":[1108955789],`)
+
+	tests := []struct {
+		what            string
+		feature         geojson.Feature
+		skip_deprecated bool
+		skip_superseded bool
+		expected        error
+	}{
+		{"live", live, true, true, nil},
+		{"deprecated", deprecated, false, false, nil},
+		{"deprecated", deprecated, true, false, ErrSkippedDeprecated},
+		{"deprecated", deprecated, false, true, nil},
+		{"superseded", superseded, false, false, nil},
+		{"superseded", superseded, true, false, nil},
+		{"superseded", superseded, false, true, ErrSkippedSuperseded},
+		{"both", both, false, true, ErrSkippedSuperseded},
+		{"both", both, true, true, ErrSkippedDeprecated},
+	}
+
+	for _, test := range tests {
+
+		wr := &bytes.Buffer{}
+
+		client := &PgisClient{
+			Logger:         log.SimpleWOFLogger("test"),
+			SQLWriter:      wr,
+			SkipDeprecated: test.skip_deprecated,
+			SkipSuperseded: test.skip_superseded,
+		}
+
+		err := client.IndexFeature(test.feature, "test")
+
+		if err != test.expected {
+			t.Errorf("%s with SkipDeprecated=%t and SkipSuperseded=%t returned %v, expected %v", test.what, test.skip_deprecated, test.skip_superseded, err, test.expected)
+			continue
+		}
+
+		// a skip is counted as such, rather than as a failure, and
+		// nothing is written
+
+		if test.expected != nil {
+
+			if !IsSkipped(err) {
+				t.Errorf("%s: expected IsSkipped to recognize %v", test.what, err)
+			}
+
+			if wr.Len() > 0 {
+				t.Errorf("%s: expected nothing to be written, got %q", test.what, wr.String())
+			}
+
+			continue
+		}
+
+		if wr.Len() == 0 {
+			t.Errorf("%s with SkipDeprecated=%t and SkipSuperseded=%t wasn't written", test.what, test.skip_deprecated, test.skip_superseded)
+		}
+	}
+}
+
+func TestNewPgisClientWithDriver(t *testing.T) {
+
+	testDBs.Store(t.Name(), &testDB{})
+	defer testDBs.Delete(t.Name())
+
+	tests := []struct {
+		driver string
+		dsn    string
+		err    string // "" means the client should be created
+	}{
+		{TEST_DRIVER, t.Name(), ""},
+		{TEST_DRIVER, "nothing registered here", "unknown test database"},
+		{PGIS_DEFAULT_DRIVER, "host=/nonexistent user=whosonfirst dbname=whosonfirst sslmode=disable", "failed to connect"},
+		{"pgx", "postgres://whosonfirst@localhost/whosonfirst", "unknown driver"},
+		{"", "postgres://whosonfirst@localhost/whosonfirst", "unknown driver"},
+	}
+
+	for _, test := range tests {
+
+		client, err := NewPgisClientWithDriver(test.driver, test.dsn, 1)
+
+		if test.err == "" {
+
+			if err != nil {
+				t.Errorf("NewPgisClientWithDriver(%q) failed because %s", test.driver, err)
+				continue
+			}
+
+			client.Close()
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("NewPgisClientWithDriver(%q) returned %v, expected an error containing %q", test.driver, err, test.err)
+		}
+	}
+}
+
+// database/sql doesn't say what the limits are so check what they do instead:
+// an expired connection is closed the next time it would be handed out but an
+// idle one is only closed by a cleaner that runs (at most) once a second
+
+func TestConnMaxLifetime(t *testing.T) {
+
+	tests := []struct {
+		what     string
+		lifetime time.Duration // 0 means use the default
+		idle     time.Duration // 0 means use the default
+		expired  bool
+		idled    bool
+	}{
+		{"defaults", 0, 0, false, false},
+		{"max lifetime", time.Millisecond, 0, true, false},
+		{"max idle time", 0, time.Millisecond, false, true},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.what, func(t *testing.T) {
+
+			client, _ := newTestClient(t, nil)
+
+			if test.lifetime > 0 {
+				client.SetConnMaxLifetime(test.lifetime)
+			}
+
+			if test.idle > 0 {
+				client.SetConnMaxIdleTime(test.idle)
+			}
+
+			ctx := context.Background()
+
+			for i := 0; i < 2; i++ {
+
+				_, err := client.db.ExecContext(ctx, "SELECT 1")
+
+				if err != nil {
+					t.Fatalf("failed to execute statement because %s", err)
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			deadline := time.Now().Add(3 * time.Second)
+
+			for test.idled && client.db.Stats().MaxIdleTimeClosed == 0 && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			stats := client.db.Stats()
+
+			if (stats.MaxLifetimeClosed > 0) != test.expired {
+				t.Errorf("expected expired connections to be closed: %t, closed %d", test.expired, stats.MaxLifetimeClosed)
+			}
+
+			if (stats.MaxIdleTimeClosed > 0) != test.idled {
+				t.Errorf("expected idle connections to be closed: %t, closed %d", test.idled, stats.MaxIdleTimeClosed)
+			}
+		})
+	}
+}
+
+func TestMarshalMeta(t *testing.T) {
+
+	hier := []map[string]int64{{"country_id": 85633041}}
+
+	tests := []struct {
+		meta     Meta
+		omit     bool
+		expected string
+	}{
+		{Meta{Repo: "whosonfirst-data"}, false, `{"wof:name":"","wof:country":"","wof:repo":"whosonfirst-data","wof:hierarchy":null}`},
+		{Meta{Repo: "whosonfirst-data"}, true, `{"wof:repo":"whosonfirst-data"}`},
+		{Meta{Name: "Montreal", Country: "CA", Repo: "whosonfirst-data", Hierarchy: hier}, true, `{"wof:name":"Montreal","wof:country":"CA","wof:repo":"whosonfirst-data","wof:hierarchy":[{"country_id":85633041}]}`},
+		{Meta{Name: "Montreal", Repo: "whosonfirst-data", Hierarchy: []map[string]int64{}}, true, `{"wof:name":"Montreal","wof:repo":"whosonfirst-data"}`},
+	}
+
+	for _, test := range tests {
+
+		client := PgisClient{
+			OmitEmptyMeta: test.omit,
+		}
+
+		meta_json, err := client.marshalMeta(test.meta)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(meta_json) != test.expected {
+			t.Errorf("marshalMeta(%v) with OmitEmptyMeta=%t returned %s, expected %s", test.meta, test.omit, meta_json, test.expected)
+		}
+	}
+}
+
+func TestGetByIdContext(t *testing.T) {
+
+	client, db := newTestClient(t, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.GetByIdContext(ctx, 101736545)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected GetByIdContext with a cancelled context to return context.Canceled, got %v", err)
+	}
+
+	if len(db.statements()) != 0 {
+		t.Errorf("expected nothing to be sent to the database, got %v", db.statements())
+	}
+
+	// and GetById, which has no context to cancel, still looks the record up
+
+	_, err = client.GetById(101736545)
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected GetById to return ErrNotFound, got %v", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		exists := fmt.Sprint(args) == "[101736545]"
+		return &testResult{columns: []string{"exists"}, rows: [][]driver.Value{{exists}}}, nil
+	}
+
+	tests := []struct {
+		points   string
+		id       int64
+		expected bool
+		sql      string
+	}{
+		{"", 101736545, true, "SELECT EXISTS(SELECT 1 FROM whosonfirst WHERE id=$1)"},
+		{"", 85633041, false, "SELECT EXISTS(SELECT 1 FROM whosonfirst WHERE id=$1)"},
+		{"whosonfirst_points", 101736545, true, `SELECT EXISTS(SELECT 1 FROM (SELECT * FROM whosonfirst UNION ALL SELECT * FROM "whosonfirst_points") AS whosonfirst WHERE id=$1)`},
+	}
+
+	for i, test := range tests {
+
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+			client.PointsTable = test.points
+
+			exists, err := client.Exists(context.Background(), test.id)
+
+			if err != nil {
+				t.Fatalf("Exists(%d) failed because %s", test.id, err)
+			}
+
+			if exists != test.expected {
+				t.Errorf("expected Exists(%d) to be %t, got %t", test.id, test.expected, exists)
+			}
+
+			stmts := db.statements()
+
+			if len(stmts) != 1 || stmts[0] != test.sql {
+				t.Errorf("expected %s, got %v", test.sql, stmts)
+			}
+		})
+	}
+}
+
+func TestIndexFeatureSerialised(t *testing.T) {
+
+	features := make(map[int64]geojson.Feature)
+
+	// 1 and 2 are in different stripes
+
+	for _, id := range []int64{1, 2} {
+
+		body := fmt.Sprintf(`{"type":"Feature","id":%d,"properties":{"wof:id":%d,"wof:name":"test","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, id, id)
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		features[id] = f
+	}
+
+	mu := new(sync.Mutex)
+	inflight := make(map[int64]int)
+	max_inflight := make(map[int64]int)
+
+	// while wait is set each insert waits (for a while) for the insert for
+	// the other ID to start, which it can only do if they aren't serialised
+
+	var wait bool
+	started := map[int64]chan bool{1: make(chan bool), 2: make(chan bool)}
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if !strings.HasPrefix(query, "INSERT") {
+			return &testResult{affected: 1}, nil
+		}
+
+		id := args[0].(int64)
+
+		mu.Lock()
+		inflight[id] += 1
+
+		if inflight[id] > max_inflight[id] {
+			max_inflight[id] = inflight[id]
+		}
+
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			inflight[id] -= 1
+			mu.Unlock()
+		}()
+
+		if !wait {
+			time.Sleep(5 * time.Millisecond)
+			return &testResult{affected: 1}, nil
+		}
+
+		close(started[id])
+
+		select {
+		case <-started[3-id]:
+			return &testResult{affected: 1}, nil
+		case <-time.After(2 * time.Second):
+			return nil, errors.New("timed out waiting for the other ID")
+		}
+	}
+
+	client, _ := newTestClient(t, handler)
+
+	index := func(ids ...int64) {
+
+		wg := new(sync.WaitGroup)
+
+		for _, id := range ids {
+
+			wg.Add(1)
+
+			go func(f geojson.Feature) {
+
+				defer wg.Done()
+
+				err := client.IndexFeature(f, "test")
+
+				if err != nil {
+					t.Errorf("IndexFeature failed because %s", err)
+				}
+			}(features[id])
+		}
+
+		wg.Wait()
+	}
+
+	index(1, 1, 1, 1)
+
+	if max_inflight[1] != 1 {
+		t.Errorf("expected the inserts for the same ID to take turns, got %d at once", max_inflight[1])
+	}
+
+	wait = true
+	index(1, 2)
+}
+
+func TestMultiGeometry(t *testing.T) {
+
+	tests := []struct {
+		geom  string
+		multi bool
+	}{
+		{`{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}`, true},
+		{`{"type":"MultiPolygon","coordinates":[[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]]}`, false},
+		{`{"type":"LineString","coordinates":[[-74,45],[-73,46]]}`, true},
+		{`{"type":"MultiLineString","coordinates":[[[-74,45],[-73,46]]]}`, false},
+		{`{"type":"MultiPoint","coordinates":[[-74,45],[-73,46]]}`, false},
+	}
+
+	for _, test := range tests {
+
+		body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45,-73,46"},"geometry":` + test.geom + `}`
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		wr := &bytes.Buffer{}
+
+		client := &PgisClient{
+			SimplifiedTolerance: 0.001,
+			Logger:              log.SimpleWOFLogger("test"),
+			SQLWriter:           wr,
+		}
+
+		err = client.IndexFeature(f, "test")
+
+		if err != nil {
+			t.Fatalf("IndexFeature failed because %s", err)
+		}
+
+		// once for geom and once for geom_simplified
+
+		expected := 0
+
+		if test.multi {
+			expected = 2
+		}
+
+		count := strings.Count(wr.String(), "ST_Multi(")
+
+		if count != expected {
+			t.Errorf("expected %s to be wrapped in ST_Multi %d times, got %d: %s", test.geom, expected, count, wr.String())
+		}
+	}
+}
+
+func TestGeometryColumns(t *testing.T) {
+
+	tests := []struct {
+		client   *PgisClient
+		geom     string
+		centroid string
+		cols     string
+		nulls    string // the columns that are set to NULL
+	}{
+		{&PgisClient{}, "g", "c", "geom,centroid", ""},
+		{&PgisClient{}, "", "c", "geom,centroid", "geom"},
+		{&PgisClient{}, "g", "", "geom,centroid", "centroid"},
+		{&PgisClient{SimplifiedTolerance: 0.001}, "g", "c", "geom,geom_simplified,centroid", ""},
+		{&PgisClient{SimplifiedTolerance: 0.001}, "", "c", "geom,geom_simplified,centroid", "geom,geom_simplified"},
+		{&PgisClient{StoreArea: true, StoreBBox: true}, "g", "c", "geom,bbox,area_meters,centroid", ""},
+		{&PgisClient{StoreArea: true, StoreBBox: true, StoreLatLon: true}, "", "c", "geom,bbox,area_meters,centroid,latitude,longitude", "geom,bbox,area_meters"},
+		{&PgisClient{GeomColumn: "geom_v2", GeohashPrecision: 6}, "g", "c", `"geom_v2",centroid,geohash`, ""},
+		{&PgisClient{GeomColumn: "geom_v2", GeohashPrecision: 6}, "g", "", `"geom_v2",centroid,geohash`, "centroid,geohash"},
+	}
+
+	for i, test := range tests {
+
+		cols, vals := test.client.geometryColumns(test.geom, "s", test.centroid)
+
+		if strings.Join(cols, ",") != test.cols {
+			t.Errorf("test %d: expected columns %s, got %s", i, test.cols, strings.Join(cols, ","))
+		}
+
+		if len(vals) != len(cols) {
+			t.Errorf("test %d: got %d values for %d columns", i, len(vals), len(cols))
+			continue
+		}
+
+		nulls := make([]string, 0)
+
+		for j, val := range vals {
+
+			if val == "NULL" {
+				nulls = append(nulls, cols[j])
+			}
+		}
+
+		if strings.Join(nulls, ",") != test.nulls {
+			t.Errorf("test %d: expected %s to be NULL, got %s", i, test.nulls, strings.Join(nulls, ","))
+		}
+	}
+}
+
+// the GeoJSON is bound once, as a parameter, however many columns are derived
+// from it rather than being pasted in to the SQL for each of them
+
+func TestGeometryParameter(t *testing.T) {
+
+	str_geom := `{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}`
+	str_centroid := `{"type":"Point","coordinates":[-73.5,45.5]}`
+
+	body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45,-73,46"},"geometry":` + str_geom + `}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	var mu sync.Mutex
+	bound := make(map[string][]driver.Value)
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if query == "SELECT postgis_lib_version()" {
+			return &testResult{columns: []string{"postgis_lib_version"}, rows: [][]driver.Value{{"3.4.2"}}}, nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		bound[query] = args
+		return &testResult{}, nil
+	}
+
+	client, _ := newTestClient(t, handler)
+
+	client.SimplifiedTolerance = 0.001
+	client.StoreBBox = true
+	client.StoreArea = true
+	client.SubdivideMaxVertices = 256
+
+	err = client.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature failed because %s", err)
+	}
+
+	upserts := 0
+
+	for query, args := range bound {
+
+		if strings.Contains(query, `"coordinates"`) {
+			t.Errorf("expected the GeoJSON to be bound rather than in the SQL, got %s", query)
+		}
+
+		geom := 0
+		centroid := 0
+
+		for i, arg := range args {
+
+			placeholder := fmt.Sprintf("ST_GeomFromGeoJSON($%d)", i+1)
+
+			switch arg {
+			case str_geom:
+				geom += 1
+			case str_centroid:
+				centroid += 1
+			default:
+				continue
+			}
+
+			if !strings.Contains(query, placeholder) {
+				t.Errorf("expected the GeoJSON bound to $%d to be used in %s", i+1, query)
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(query, "INSERT INTO whosonfirst_subdivided"):
+
+			if geom != 1 || centroid != 0 {
+				t.Errorf("expected the geometry to be bound once to %s, got %v", query, args)
+			}
+
+		case strings.HasPrefix(query, "INSERT INTO whosonfirst "):
+
+			upserts += 1
+
+			if geom != 1 || centroid != 1 {
+				t.Errorf("expected the geometry and the centroid to be bound once each to %s, got %v", query, args)
+			}
+
+			if strings.Count(query, "ST_GeomFromGeoJSON(") != 5 {
+				t.Errorf("expected the geometry to be used for geom, geom_simplified, bbox and area_meters and the centroid for centroid, got %s", query)
+			}
+		}
+	}
+
+	if upserts != 1 {
+		t.Errorf("expected 1 upsert, got %d in %v", upserts, bound)
+	}
+}
+
+// the bbox column is a POLYGON so the bbox of a horizontal line (or of anything
+// else whose box has no width or height) has to be one too, which ST_Envelope's
+// isn't
+
+func TestStoreBBox(t *testing.T) {
+
+	body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45.5,-73,45.5"},"geometry":{"type":"LineString","coordinates":[[-74,45.5],[-73,45.5]]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	rec := &PgisGeometryRecord{
+		Id:        101736545,
+		ParentId:  -1,
+		Placetype: "locality",
+		Meta:      Meta{Name: "Montreal", Repo: "whosonfirst-data"},
+		Geometry:  []byte("LINESTRING(-74 45.5,-73 45.5)"),
+		Format:    GEOMETRY_FORMAT_WKT,
+	}
+
+	wr := &bytes.Buffer{}
+
+	client := &PgisClient{
+		StoreBBox: true,
+		Logger:    log.SimpleWOFLogger("test"),
+		SQLWriter: wr,
+	}
+
+	st_bbox := "(SELECT ST_MakeEnvelope(ST_XMin(b), ST_YMin(b), ST_XMax(b), ST_YMax(b), 4326) FROM Box2D("
+
+	tests := []struct {
+		what  string
+		index func() error
+	}{
+		{"IndexFeature", func() error { return client.IndexFeature(f, "test") }},
+		{"IndexGeometry", func() error { return client.IndexGeometry(context.Background(), rec) }},
+	}
+
+	for _, test := range tests {
+
+		wr.Reset()
+
+		err := test.index()
+
+		if err != nil {
+			t.Fatalf("%s failed because %s", test.what, err)
+		}
+
+		if !strings.Contains(wr.String(), "bbox") || !strings.Contains(wr.String(), st_bbox) {
+			t.Errorf("%s: expected the bbox to be built with ST_MakeEnvelope, got %s", test.what, wr.String())
+		}
+
+		if strings.Contains(wr.String(), "ST_Envelope(") {
+			t.Errorf("%s: expected the bbox not to be built with ST_Envelope, got %s", test.what, wr.String())
+		}
+	}
+
+	stmts, err := client.derivedGeometrySQL("whosonfirst", "id = $1")
+
+	if err != nil {
+		t.Fatalf("derivedGeometrySQL failed because %s", err)
+	}
+
+	if !strings.Contains(stmts[0], "bbox="+st_bbox+"geom::geometry) AS b)") {
+		t.Errorf("expected derivedGeometrySQL to build the bbox with ST_MakeEnvelope, got %s", stmts[0])
+	}
+}
+
+// with CoordinatePrecision the geometry is snapped to a grid, and made valid
+// again, before anything is derived from it so that nothing is stored with
+// more precision than was asked for; polygons stay polygons, even if snapping
+// leaves stray lines or points behind, and the subdivided geometries are made
+// from the geometry as it was given
+
+func TestCoordinatePrecision(t *testing.T) {
+
+	polygon := `{"type":"Polygon","coordinates":[[[-74.123456789,45.123456789],[-73.123456789,45.123456789],[-73.123456789,46.123456789],[-74.123456789,45.123456789]]]}`
+	line := `{"type":"LineString","coordinates":[[-74.123456789,45.123456789],[-73.123456789,45.123456789]]}`
+
+	tests := []struct {
+		geom      string
+		precision int
+		grid      string
+		extract   bool
+	}{
+		{polygon, 0, "", false},
+		{polygon, 1, "0.1", true},
+		{polygon, 6, "1e-06", true},
+		{polygon, 7, "1e-07", true},
+		{line, 7, "1e-07", false},
+	}
+
+	for _, test := range tests {
+
+		body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45,-73,46"},"geometry":` + test.geom + `}`
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		var mu sync.Mutex
+		bound := make(map[string][]driver.Value)
+
+		handler := func(query string, args []driver.Value) (*testResult, error) {
+
+			if query == "SELECT postgis_lib_version()" {
+				return &testResult{columns: []string{"postgis_lib_version"}, rows: [][]driver.Value{{"3.4.2"}}}, nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			bound[query] = args
+			return &testResult{affected: 1}, nil
+		}
+
+		client, _ := newTestClient(t, handler)
+
+		client.CoordinatePrecision = test.precision
+		client.SimplifiedTolerance = 0.001
+		client.StoreBBox = true
+		client.StoreArea = true
+		client.SubdivideMaxVertices = 256
+
+		err = client.IndexFeature(f, "test")
+
+		if err != nil {
+			t.Fatalf("IndexFeature with precision %d failed because %s", test.precision, err)
+		}
+
+		var values map[string]string
+
+		for query, args := range bound {
+
+			switch {
+			case strings.HasPrefix(query, "INSERT INTO whosonfirst "):
+
+				values = testInsertValues(t, query)
+
+			case strings.HasPrefix(query, "INSERT INTO whosonfirst_subdivided"):
+
+				if strings.Contains(query, "ST_SnapToGrid(") {
+					t.Errorf("expected the subdivided geometries to be made from the original geometry, got %s", query)
+				}
+
+				if fmt.Sprint(args[1]) != test.geom {
+					t.Errorf("expected the original geometry to be subdivided, got %v", args[1])
+				}
+			}
+		}
+
+		if values == nil {
+			t.Fatalf("precision %d: no upsert in %v", test.precision, bound)
+		}
+
+		for _, col := range []string{"geom", "geom_simplified", "bbox", "area_meters", "centroid"} {
+
+			snapped := strings.Contains(values[col], "ST_SnapToGrid(")
+
+			if snapped != (test.precision > 0) {
+				t.Errorf("precision %d: expected %s to be snapped: %t, got %s", test.precision, col, test.precision > 0, values[col])
+			}
+
+			if test.precision > 0 && !strings.Contains(values[col], ", "+test.grid+")") {
+				t.Errorf("precision %d: expected %s to be snapped to a grid of %s, got %s", test.precision, col, test.grid, values[col])
+			}
+		}
+
+		if test.precision == 0 {
+			continue
+		}
+
+		if !strings.Contains(values["geom"], "ST_MakeValid(ST_SnapToGrid(ST_GeomFromGeoJSON($") {
+			t.Errorf("precision %d: expected the snapped geometry to be made valid, got %s", test.precision, values["geom"])
+		}
+
+		extract := strings.Contains(values["geom"], "ST_CollectionExtract(ST_MakeValid(")
+
+		if extract != test.extract {
+			t.Errorf("precision %d: expected only polygons to be extracted from the snapped geometry: %t, got %s", test.precision, test.extract, values["geom"])
+		}
+	}
+}
+
+// tolerances are in degrees so small ones are normal and must not be rounded
+// (let alone rounded to 0, which is no simplification at all)
+
+func TestSimplifiedTolerance(t *testing.T) {
+
+	body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45,-73,46"},"geometry":{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	rec := &PgisGeometryRecord{
+		Id:        101736545,
+		ParentId:  -1,
+		Placetype: "locality",
+		Meta:      Meta{Name: "Montreal", Repo: "whosonfirst-data"},
+		Geometry:  []byte("POLYGON((-74 45,-73 45,-73 46,-74 46,-74 45))"),
+		Format:    GEOMETRY_FORMAT_WKT,
+	}
+
+	tests := []struct {
+		tolerance float64
+		expected  string
+	}{
+		{0.5, "0.5"},
+		{0.001, "0.001"},
+		{0.0000005, "5e-07"},
+		{0.0000001, "1e-07"},
+		{0.00000012345, "1.2345e-07"},
+	}
+
+	for _, test := range tests {
+
+		expected := fmt.Sprintf(", %s)", test.expected)
+
+		wr := &bytes.Buffer{}
+
+		client := &PgisClient{
+			SimplifiedTolerance: test.tolerance,
+			Logger:              log.SimpleWOFLogger("test"),
+			SQLWriter:           wr,
+		}
+
+		indexers := []struct {
+			what  string
+			index func() error
+		}{
+			{"IndexFeature", func() error { return client.IndexFeature(f, "test") }},
+			{"IndexGeometry", func() error { return client.IndexGeometry(context.Background(), rec) }},
+		}
+
+		for _, idx := range indexers {
+
+			wr.Reset()
+
+			err := idx.index()
+
+			if err != nil {
+				t.Fatalf("%s failed because %s", idx.what, err)
+			}
+
+			i := strings.Index(wr.String(), "ST_SimplifyPreserveTopology(")
+
+			if i == -1 || !strings.Contains(wr.String()[i:], expected) {
+				t.Errorf("%s: expected a tolerance of %s, got %s", idx.what, test.expected, wr.String())
+			}
+		}
+
+		stmts, err := client.derivedGeometrySQL("whosonfirst", "id = $1")
+
+		if err != nil {
+			t.Fatalf("derivedGeometrySQL failed because %s", err)
+		}
+
+		if !strings.Contains(stmts[0], "ST_SimplifyPreserveTopology(geom::geometry"+expected) {
+			t.Errorf("derivedGeometrySQL: expected a tolerance of %s, got %s", test.expected, stmts[0])
+		}
+	}
+}
+
+func TestAsyncCommit(t *testing.T) {
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	tests := []struct {
+		async    bool
+		expected []string // the statements, up to the INSERT
+	}{
+		{false, []string{"INSERT"}},
+		{true, []string{"BEGIN", SQL_ASYNC_COMMIT, "INSERT", "COMMIT"}},
+	}
+
+	for _, test := range tests {
+
+		t.Run(fmt.Sprintf("async=%t", test.async), func(t *testing.T) {
+
+			client, db := newTestClient(t, nil)
+			client.AsyncCommit = test.async
+
+			err := client.IndexFeature(f, "test")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stmts := db.statements()
+
+			if len(stmts) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, stmts)
+			}
+
+			for i, stmt := range stmts {
+
+				if !strings.HasPrefix(stmt, test.expected[i]) {
+					t.Errorf("expected statement %d to be %s, got %s", i, test.expected[i], stmt)
+				}
+			}
+
+			// the setting is only for the database, not the SQL that is
+			// written out
+
+			wr := &bytes.Buffer{}
+
+			client.SQLWriter = wr
+
+			err = client.IndexFeature(f, "test")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(wr.String(), "synchronous_commit") {
+				t.Errorf("expected the SQL written out not to change synchronous_commit, got %s", wr.String())
+			}
+		})
+	}
+}
+
+func TestChunkIds(t *testing.T) {
+
+	tests := []struct {
+		ids      []int64
+		size     int
+		expected string
+	}{
+		{[]int64{}, 3, "[]"},
+		{[]int64{1}, 3, "[[1]]"},
+		{[]int64{1, 2, 3}, 3, "[[1 2 3]]"},
+		{[]int64{1, 2, 3, 4}, 3, "[[1 2 3] [4]]"},
+		{[]int64{1, 2, 3, 4, 5, 6, 7, 8, 9}, 3, "[[1 2 3] [4 5 6] [7 8 9]]"},
+		{[]int64{1, 2, 3}, 1, "[[1] [2] [3]]"},
+	}
+
+	for _, test := range tests {
+
+		chunks := chunkIds(test.ids, test.size)
+
+		if fmt.Sprint(chunks) != test.expected {
+			t.Errorf("expected %v in chunks of %d to be %s, got %v", test.ids, test.size, test.expected, chunks)
+		}
+	}
+}
+
+func TestDeleteIdsSQL(t *testing.T) {
+
+	tests := []struct {
+		table    string
+		expected string
+	}{
+		{"whosonfirst", "DELETE FROM whosonfirst WHERE id = ANY($1)"},
+		{`"whosonfirst_points"`, `DELETE FROM "whosonfirst_points" WHERE id = ANY($1)`},
+	}
+
+	for _, test := range tests {
+
+		str_sql := deleteIdsSQL(test.table)
+
+		if str_sql != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, str_sql)
+		}
+	}
+}
+
+func TestLastModified(t *testing.T) {
+
+	fixture := func(extra string) geojson.Feature {
+
+		body := fmt.Sprintf(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data",%s"geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, extra)
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		return f
+	}
+
+	// an explicit wof:lastmodified is an epoch, which is what gets stored
+	// (as a timestamp) and written out
+
+	f := fixture(`"wof:lastmodified":1500000000,`)
+
+	expected := time.Unix(1500000000, 0).Format(time.RFC3339)
+
+	if lastModified(f) != expected {
+		t.Errorf("expected lastmod to be %s, got %s", expected, lastModified(f))
+	}
+
+	wr := &bytes.Buffer{}
+
+	client := &PgisClient{
+		Logger:    log.SimpleWOFLogger("test"),
+		SQLWriter: wr,
+	}
+
+	err := client.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("failed to index feature because %s", err)
+	}
+
+	if !strings.Contains(wr.String(), fmt.Sprintf("'%s'", expected)) {
+		t.Errorf("expected lastmod %s to be written, got %q", expected, wr.String())
+	}
+
+	// and without one (or with one that isn't an epoch) it's now
+
+	for _, extra := range []string{"", `"wof:lastmodified":-1,`} {
+
+		t1 := time.Now().Truncate(time.Second)
+
+		lastmod, err := time.Parse(time.RFC3339, lastModified(fixture(extra)))
+
+		if err != nil {
+			t.Fatalf("failed to parse lastmod because %s", err)
+		}
+
+		if lastmod.Before(t1) || lastmod.After(time.Now()) {
+			t.Errorf("expected lastmod for %q to be now, got %s", extra, lastmod)
+		}
+	}
+}
+
+// lastmod defaults to now, in the database as well as for features without a
+// wof:lastmodified, and records can be selected by a range of lastmod times
+// using the by_lastmod index
+
+func TestLastModifiedRange(t *testing.T) {
+
+	stmts, err := (&PgisClient{}).schemaSQL(NewDefaultPgisSchemaOptions())
+
+	if err != nil {
+		t.Fatalf("schemaSQL failed because %s", err)
+	}
+
+	schema := strings.Join(stmts, ";\n")
+
+	for _, expected := range []string{"lastmod TIMESTAMPTZ DEFAULT now()", "CREATE INDEX by_lastmod ON whosonfirst (lastmod)"} {
+
+		if !strings.Contains(schema, expected) {
+			t.Errorf("expected the schema to contain %s, got %s", expected, schema)
+		}
+	}
+
+	// the rows a database would have for three records, one of which was
+	// indexed without a wof:lastmodified and so got the default
+
+	now := time.Now().Truncate(time.Second)
+
+	body := `{"type":"Feature","id":3,"properties":{"wof:id":3,"wof:name":"test","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	lastmods := map[int64]string{
+		1: time.Unix(1500000000, 0).Format(time.RFC3339),
+		2: time.Unix(1600000000, 0).Format(time.RFC3339),
+		3: lastModified(f),
+	}
+
+	// the handler stands in for PostgreSQL, comparing each record's lastmod
+	// with the ones in the query the way it would
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		rsp := &testResult{columns: TEST_ROW_COLUMNS}
+
+		for id := int64(1); id <= 3; id++ {
+
+			lastmod, _ := time.Parse(time.RFC3339, lastmods[id])
+			match := true
+
+			for i, arg := range args {
+
+				ts, err := time.Parse(time.RFC3339, fmt.Sprint(arg))
+
+				if err != nil {
+					return nil, err
+				}
+
+				switch {
+				case strings.Contains(query, fmt.Sprintf("lastmod >= $%d", i+1)):
+					match = match && !lastmod.Before(ts)
+				case strings.Contains(query, fmt.Sprintf("lastmod < $%d", i+1)):
+					match = match && lastmod.Before(ts)
+				}
+			}
+
+			if match {
+				rsp.rows = append(rsp.rows, testRow(id, 102312317, `{"wof:name":"test"}`, `{"type":"Point","coordinates":[-73.5,45.5]}`))
+			}
+		}
+
+		return rsp, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	tests := []struct {
+		since    time.Time
+		before   time.Time
+		where    string
+		expected string
+	}{
+		{time.Time{}, time.Time{}, "", "[1 2 3]"},
+		{time.Unix(1500000000, 0), time.Time{}, " WHERE lastmod >= $1", "[1 2 3]"},
+		{time.Unix(1500000001, 0), time.Time{}, " WHERE lastmod >= $1", "[2 3]"},
+		{time.Time{}, time.Unix(1600000000, 0), " WHERE lastmod < $1", "[1]"},
+		{time.Unix(1500000001, 0), time.Unix(1600000001, 0), " WHERE lastmod >= $1 AND lastmod < $2", "[2]"},
+		{now, time.Time{}, " WHERE lastmod >= $1", "[3]"},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultPgisIntersectsOptions()
+		opts.ModifiedSince = test.since
+		opts.ModifiedBefore = test.before
+
+		it, err := client.Query(context.Background(), opts)
+
+		if err != nil {
+			t.Fatalf("Query failed because %s", err)
+		}
+
+		ids := make([]int64, 0)
+
+		for it.Next() {
+
+			row, err := it.Scan()
+
+			if err != nil {
+				t.Fatalf("failed to scan row because %s", err)
+			}
+
+			ids = append(ids, row.Id)
+		}
+
+		it.Close()
+
+		if fmt.Sprint(ids) != test.expected {
+			t.Errorf("expected records modified between %s and %s to be %s, got %v", test.since, test.before, test.expected, ids)
+		}
+
+		stmts := db.statements()
+		expected := "SELECT " + PGIS_ROW_COLUMNS + " FROM whosonfirst" + test.where
+
+		if stmts[len(stmts)-1] != expected {
+			t.Errorf("expected %s, got %s", expected, stmts[len(stmts)-1])
+		}
+	}
+}
+
+func TestUpdateMetaSQL(t *testing.T) {
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","wof:lastmodified":1500000000,"geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	tests := []struct {
+		client   *PgisClient
+		expected []string
+	}{
+		{&PgisClient{}, []string{"UPDATE whosonfirst SET parent_id=$2, placetype_id=$3, is_superseded=$4, is_deprecated=$5, meta=$6, lastmod=$7 WHERE id=$1"}},
+		{&PgisClient{StoreHierarchy: true}, []string{"UPDATE whosonfirst SET parent_id=$2, placetype_id=$3, is_superseded=$4, is_deprecated=$5, meta=$6, continent_id=$7, country_id=$8, region_id=$9, locality_id=$10, lastmod=$11 WHERE id=$1"}},
+		{&PgisClient{StoreRaw: true, StoreFeatureHash: true}, []string{"UPDATE whosonfirst SET parent_id=$2, placetype_id=$3, is_superseded=$4, is_deprecated=$5, meta=$6, raw=$7, feature_hash=$8, lastmod=$9 WHERE id=$1"}},
+		{&PgisClient{ConflictKey: PGIS_CONFLICT_ID_REPO}, []string{"UPDATE whosonfirst SET parent_id=$2, placetype_id=$3, is_superseded=$4, is_deprecated=$5, meta=$6, lastmod=$7 WHERE id=$1 AND repo=$8"}},
+		{&PgisClient{PointsTable: "whosonfirst_points"}, []string{
+			"UPDATE whosonfirst SET parent_id=$2, placetype_id=$3, is_superseded=$4, is_deprecated=$5, meta=$6, lastmod=$7 WHERE id=$1",
+			`UPDATE "whosonfirst_points" SET parent_id=$2, placetype_id=$3, is_superseded=$4, is_deprecated=$5, meta=$6, lastmod=$7 WHERE id=$1`,
+		}},
+	}
+
+	for i, test := range tests {
+
+		stmts, cols, args, err := test.client.updateMetaSQL(f)
+
+		if err != nil {
+			t.Fatalf("test %d: updateMetaSQL failed because %s", i, err)
+		}
+
+		if strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, stmts)
+		}
+
+		// every placeholder has a value, and a column, and the geometries
+		// are never touched
+
+		if len(cols) != len(args) || strings.Count(stmts[0], "$") != len(args) {
+			t.Errorf("test %d: %d columns and %d args for %s", i, len(cols), len(args), stmts[0])
+		}
+
+		if args[0] != int64(101736545) || args[len(args)-1] == nil {
+			t.Errorf("test %d: unexpected args %v", i, args)
+		}
+
+		if strings.Contains(stmts[0], "geom") {
+			t.Errorf("test %d: expected the geometry to be left alone, got %s", i, stmts[0])
+		}
+
+		if test.client.ConflictKey == PGIS_CONFLICT_ID_REPO && args[len(args)-1] != "whosonfirst-data" {
+			t.Errorf("test %d: expected the last arg to be the repo, got %v", i, args[len(args)-1])
+		}
+	}
+}
+
+func TestMaxMetaBytes(t *testing.T) {
+
+	hierarchy := `{"continent_id":102191575,"country_id":85633041,"region_id":136251273,"locality_id":101736545}`
+	hierarchies := strings.TrimSuffix(strings.Repeat(hierarchy+",", 10), ",")
+
+	body := func(name string) []byte {
+		return []byte(fmt.Sprintf(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"%s","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","wof:hierarchy":[%s],"geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, name, hierarchies))
+	}
+
+	// ten hierarchies don't fit but one does
+
+	f, err := feature.LoadFeature(body("Montreal"))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	client := &PgisClient{MaxMetaBytes: 300, Logger: log.SimpleWOFLogger("test")}
+
+	cols, args, err := client.propertyColumns(f)
+
+	if err != nil {
+		t.Fatalf("propertyColumns failed because %s", err)
+	}
+
+	found := false
+
+	for i, col := range cols {
+
+		if col != "meta" {
+			continue
+		}
+
+		found = true
+
+		var meta Meta
+
+		err := json.Unmarshal([]byte(args[i].(string)), &meta)
+
+		if err != nil {
+			t.Fatalf("failed to parse meta because %s", err)
+		}
+
+		if len(meta.Hierarchy) != 1 || meta.Hierarchy[0]["locality_id"] != 101736545 {
+			t.Errorf("expected meta to be trimmed to the first hierarchy, got %v", meta.Hierarchy)
+		}
+
+		if len(args[i].(string)) > client.MaxMetaBytes {
+			t.Errorf("expected meta to be at most %d bytes, got %d", client.MaxMetaBytes, len(args[i].(string)))
+		}
+	}
+
+	if !found {
+		t.Errorf("expected a meta column, got %v", cols)
+	}
+
+	// and no amount of trimming hierarchies makes a big enough name fit
+
+	f, err = feature.LoadFeature(body(strings.Repeat("Montreal", 100)))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	_, _, err = client.propertyColumns(f)
+
+	if !errors.Is(err, ErrMetaTooLarge) {
+		t.Errorf("expected ErrMetaTooLarge, got %v", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+
+	var mu sync.Mutex
+	var bound []driver.Value
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.HasPrefix(query, "DELETE FROM broken") {
+			return nil, errors.New(`relation "broken" does not exist`)
+		}
+
+		mu.Lock()
+		bound = args
+		mu.Unlock()
+
+		return &testResult{affected: 3}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	free := len(client.conns)
+
+	count, err := client.Exec(context.Background(), "DELETE FROM whosonfirst WHERE placetype_id=$1 AND meta->>'wof:repo'=$2", int64(102312317), "whosonfirst-data")
+
+	if err != nil {
+		t.Fatalf("Exec failed because %s", err)
+	}
+
+	if count != 3 {
+		t.Errorf("expected Exec to return the 3 rows affected, got %d", count)
+	}
+
+	if fmt.Sprint(bound) != "[102312317 whosonfirst-data]" {
+		t.Errorf("expected the args to be passed along, got %v", bound)
+	}
+
+	// a failed statement affects nothing
+
+	count, err = client.Exec(context.Background(), "DELETE FROM broken WHERE id=$1", int64(101736545))
+
+	if err == nil || count != 0 {
+		t.Errorf("expected a failed statement to return 0 and an error, got %d and %v", count, err)
+	}
+
+	if len(client.conns) != free {
+		t.Errorf("expected every connection slot to be returned, %d of %d are free", len(client.conns), free)
+	}
+
+	// and in debug mode nothing is run at all
+
+	client.Debug = true
+
+	count, err = client.Exec(context.Background(), "DELETE FROM whosonfirst WHERE id=$1", int64(101736545))
+
+	if err != nil || count != 0 {
+		t.Errorf("expected Exec in debug mode to return 0, got %d and %v", count, err)
+	}
+
+	stmts := db.statements()
+
+	if len(stmts) != 2 {
+		t.Errorf("expected 2 statements to have been run, got %v", stmts)
+	}
+}
+
+func TestUnionGeometrySQL(t *testing.T) {
+
+	st_union := "ST_Multi(ST_CollectionExtract(COALESCE(ST_Union(geom::geometry, $2), $2), 3))::geography"
+
+	tests := []struct {
+		client   *PgisClient
+		table    string
+		expected []string
+	}{
+		{&PgisClient{}, "whosonfirst", []string{
+			"UPDATE whosonfirst SET geom=" + st_union + ", lastmod=$3 WHERE id=$1",
+		}},
+		{&PgisClient{StoreBBox: true, StoreArea: true}, "whosonfirst", []string{
+			"UPDATE whosonfirst SET geom=" + st_union + ", lastmod=$3 WHERE id=$1",
+			"UPDATE whosonfirst SET bbox=(SELECT ST_MakeEnvelope(ST_XMin(b), ST_YMin(b), ST_XMax(b), ST_YMax(b), 4326) FROM Box2D(geom::geometry) AS b), area_meters=ST_Area(geom::geometry::geography) WHERE id=$1",
+		}},
+		{&PgisClient{SimplifiedTolerance: 0.5, SubdivideMaxVertices: 256}, "whosonfirst", []string{
+			"UPDATE whosonfirst SET geom=" + st_union + ", lastmod=$3 WHERE id=$1",
+			"UPDATE whosonfirst SET geom_simplified=ST_Multi(ST_SimplifyPreserveTopology(geom::geometry, 0.5)) WHERE id=$1",
+			SQL_DELETE_SUBDIVIDED,
+			"INSERT INTO whosonfirst_subdivided (id, geom) SELECT id, ST_Subdivide(geom::geometry, 256) FROM whosonfirst WHERE id=$1",
+		}},
+		{&PgisClient{PointsTable: "whosonfirst_points", StoreArea: true}, `"whosonfirst_points"`, []string{
+			`UPDATE "whosonfirst_points" SET geom=` + st_union + ", lastmod=$3 WHERE id=$1",
+			`UPDATE "whosonfirst_points" SET area_meters=ST_Area(geom::geometry::geography) WHERE id=$1`,
+		}},
+	}
+
+	for i, test := range tests {
+
+		stmts, err := test.client.unionGeometrySQL(test.table, "$2")
+
+		if err != nil {
+			t.Fatalf("test %d: unionGeometrySQL failed because %s", i, err)
+		}
+
+		if strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, stmts)
+		}
+	}
+
+	// records are matched by ID alone, which would update the record from
+	// every repo
+
+	client := &PgisClient{ConflictKey: PGIS_CONFLICT_ID_REPO}
+
+	_, err := client.unionGeometrySQL("whosonfirst", "$2")
+
+	if err == nil {
+		t.Errorf("expected unionGeometrySQL to fail with the id+repo conflict key")
+	}
+}
+
+func TestUnionGeometry(t *testing.T) {
+
+	body := []byte(`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`)
+
+	// nothing to union with is not the same as an empty geometry
+
+	client, db := newTestClient(t, nil)
+
+	err := client.UnionGeometry(context.Background(), 101736545, body)
+
+	if err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	stmts := db.statements()
+
+	if len(stmts) == 0 || stmts[len(stmts)-1] != "ROLLBACK" {
+		t.Errorf("expected the union to be rolled back, got %v", stmts)
+	}
+
+	// a record in the points table is found there, rather than not being
+	// found in the whosonfirst table, and everything derived from its
+	// geometry is updated there too
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.HasPrefix(query, `UPDATE "whosonfirst_points" SET geom=`) {
+			return &testResult{affected: 1}, nil
+		}
+
+		return &testResult{}, nil
+	}
+
+	client, db = newTestClient(t, handler)
+
+	client.PointsTable = "whosonfirst_points"
+	client.StoreArea = true
+
+	err = client.UnionGeometry(context.Background(), 101736545, body)
+
+	if err != nil {
+		t.Fatalf("failed to union geometry in to the points table because %s", err)
+	}
+
+	stmts = db.statements()
+
+	expected := []string{
+		"UPDATE whosonfirst SET geom=",
+		`UPDATE "whosonfirst_points" SET geom=`,
+		`UPDATE "whosonfirst_points" SET area_meters=`,
+		"COMMIT",
+	}
+
+	if len(stmts) != len(expected)+1 {
+		t.Fatalf("expected %d statements, got %v", len(expected)+1, stmts)
+	}
+
+	for i, prefix := range expected {
+
+		if !strings.HasPrefix(stmts[i+1], prefix) {
+			t.Errorf("expected statement %d to start with %s, got %s", i+1, prefix, stmts[i+1])
+		}
+	}
+
+	// and nothing is touched with the id+repo conflict key
+
+	client, db = newTestClient(t, nil)
+
+	client.ConflictKey = PGIS_CONFLICT_ID_REPO
+
+	err = client.UnionGeometry(context.Background(), 101736545, body)
+
+	if err == nil {
+		t.Errorf("expected UnionGeometry to fail with the id+repo conflict key")
+	}
+
+	if len(db.statements()) != 0 {
+		t.Errorf("expected no statements with the id+repo conflict key, got %v", db.statements())
+	}
+}
+
+func TestOptimize(t *testing.T) {
+
+	labels := false
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.HasPrefix(query, "SELECT EXISTS") {
+			return &testResult{columns: []string{"exists"}, rows: [][]driver.Value{{labels}}}, nil
+		}
+
+		return &testResult{}, nil
+	}
+
+	tests := []struct {
+		what     string
+		call     func(client *PgisClient) error
+		points   string
+		vertices int
+		labels   bool
+		expected []string
+	}{
+		{"optimize", func(client *PgisClient) error { return client.Optimize(context.Background()) }, "", 0, false, []string{"VACUUM ANALYZE whosonfirst"}},
+		{"analyze", func(client *PgisClient) error { return client.Analyze(context.Background()) }, "", 0, false, []string{"ANALYZE whosonfirst"}},
+		{"everything", func(client *PgisClient) error { return client.Optimize(context.Background()) }, "whosonfirst_points", 256, true, []string{
+			"VACUUM ANALYZE whosonfirst",
+			`VACUUM ANALYZE "whosonfirst_points"`,
+			"VACUUM ANALYZE whosonfirst_subdivided",
+			"VACUUM ANALYZE whosonfirst_labels",
+		}},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.what, func(t *testing.T) {
+
+			labels = test.labels
+
+			client, db := newTestClient(t, handler)
+			client.PointsTable = test.points
+			client.SubdivideMaxVertices = test.vertices
+
+			err := test.call(client)
+
+			if err != nil {
+				t.Fatalf("failed because %s", err)
+			}
+
+			stmts := make([]string, 0)
+
+			for _, stmt := range db.statements() {
+
+				if !strings.HasPrefix(stmt, "SELECT EXISTS") {
+					stmts = append(stmts, stmt)
+				}
+			}
+
+			if strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected %v, got %v", test.expected, stmts)
+			}
+		})
+	}
+}
+
+func TestSweepStaleSQL(t *testing.T) {
+
+	tests := []struct {
+		tables   []string
+		expected []string
+	}{
+		{[]string{"whosonfirst"}, []string{"DELETE FROM whosonfirst WHERE meta->>'wof:repo'=$1 AND (run_id IS NULL OR run_id != $2)"}},
+		{[]string{"whosonfirst", `"whosonfirst_points"`}, []string{
+			"DELETE FROM whosonfirst WHERE meta->>'wof:repo'=$1 AND (run_id IS NULL OR run_id != $2)",
+			`DELETE FROM "whosonfirst_points" WHERE meta->>'wof:repo'=$1 AND (run_id IS NULL OR run_id != $2)`,
+		}},
+	}
+
+	for _, test := range tests {
+
+		stmts := sweepStaleSQL(test.tables)
+
+		if strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("expected %v, got %v", test.expected, stmts)
+		}
+	}
+
+	// and sweeping needs to know what it's sweeping
+
+	client := &PgisClient{}
+
+	for _, args := range [][]string{{"", "run"}, {"whosonfirst-data", ""}} {
+
+		_, err := client.SweepStale(context.Background(), args[0], args[1])
+
+		if err == nil {
+			t.Errorf("expected SweepStale(%q, %q) to fail", args[0], args[1])
+		}
+	}
+}
+
+func TestGetByIds(t *testing.T) {
+
+	// every ID except 0 has a record
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		rsp := testResult{columns: TEST_ROW_COLUMNS}
+
+		str_ids := strings.Trim(args[0].(string), "{}")
+
+		for _, str_id := range strings.Split(str_ids, ",") {
+
+			id, err := strconv.ParseInt(str_id, 10, 64)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if id == 0 {
+				continue
+			}
+
+			rsp.rows = append(rsp.rows, testRow(id, 102312317, `{"wof:name":"test"}`, `{"type":"Point","coordinates":[-73.5,45.5]}`))
+		}
+
+		return &rsp, nil
+	}
+
+	tests := []struct {
+		count   int
+		queries int
+	}{
+		{1, 1},
+		{IDS_CHUNK_SIZE, 1},
+		{IDS_CHUNK_SIZE + 1, 2},
+		{IDS_CHUNK_SIZE*2 + 1, 3},
+	}
+
+	for _, test := range tests {
+
+		t.Run(strconv.Itoa(test.count), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+
+			ids := make([]int64, test.count)
+
+			for i := range ids {
+				ids[i] = int64(i)
+			}
+
+			results, err := client.GetByIds(context.Background(), ids)
+
+			if err != nil {
+				t.Fatalf("failed to get %d ids because %s", test.count, err)
+			}
+
+			if len(results) != test.count-1 {
+				t.Errorf("expected %d records, got %d", test.count-1, len(results))
+			}
+
+			if _, ok := results[0]; ok {
+				t.Errorf("expected the ID without a record to be left out")
+			}
+
+			if len(db.statements()) != test.queries {
+				t.Errorf("expected %d queries, got %d", test.queries, len(db.statements()))
+			}
+		})
+	}
+
+	str_sql := getByIdsSQL("id, meta", "whosonfirst")
+
+	if str_sql != "SELECT id, meta FROM whosonfirst WHERE id = ANY($1)" {
+		t.Errorf("unexpected GetByIds SQL: %s", str_sql)
+	}
+}
+
+func TestTouchSQL(t *testing.T) {
+
+	tests := []struct {
+		tables   []string
+		run_id   string
+		expected []string
+		args     string
+	}{
+		{[]string{"whosonfirst"}, "", []string{"UPDATE whosonfirst SET lastmod=$2 WHERE id = ANY($1)"}, "[2026-10-14T00:00:00Z]"},
+		{[]string{"whosonfirst", `"whosonfirst_points"`}, "run", []string{
+			"UPDATE whosonfirst SET lastmod=$2, run_id=$3 WHERE id = ANY($1)",
+			`UPDATE "whosonfirst_points" SET lastmod=$2, run_id=$3 WHERE id = ANY($1)`,
+		}, "[2026-10-14T00:00:00Z run]"},
+	}
+
+	for _, test := range tests {
+
+		stmts, args := touchSQL(test.tables, "2026-10-14T00:00:00Z", test.run_id)
+
+		if strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("expected %v, got %v", test.expected, stmts)
+		}
+
+		if fmt.Sprint(args) != test.args {
+			t.Errorf("expected args %s, got %v", test.args, args)
+		}
+	}
+}
+
+func TestTouch(t *testing.T) {
+
+	mu := new(sync.Mutex)
+	chunks := make([]int, 0)
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(args) != 3 || args[2] != "run" {
+			msg := fmt.Sprintf("unexpected args %v", args)
+			return nil, errors.New(msg)
+		}
+
+		count := strings.Count(args[0].(string), ",") + 1
+		chunks = append(chunks, count)
+
+		return &testResult{affected: int64(count)}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+	client.RunId = "run"
+
+	ids := make([]int64, IDS_CHUNK_SIZE+1)
+
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+
+	touched, err := client.Touch(context.Background(), ids)
+
+	if err != nil {
+		t.Fatalf("failed to touch ids because %s", err)
+	}
+
+	if touched != int64(len(ids)) {
+		t.Errorf("expected %d records to be touched, got %d", len(ids), touched)
+	}
+
+	if fmt.Sprint(chunks) != fmt.Sprintf("[%d 1]", IDS_CHUNK_SIZE) {
+		t.Errorf("expected chunks of %d and 1, got %v", IDS_CHUNK_SIZE, chunks)
+	}
+
+	statements := db.statements()
+
+	if len(statements) != 4 || statements[0] != "BEGIN" || statements[3] != "COMMIT" {
+		t.Errorf("expected both chunks to be updated in one transaction, got %v", statements)
+	}
+}
@@ -0,0 +1,69 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// https://postgis.net/docs/ST_ClosestPoint.html
+
+// ClosestPointOn returns the point on the boundary of feature id that is
+// closest to lon, lat - useful for snapping a marker to the nearest border.
+// Point features (which only have a centroid, see IndexFeature) return the
+// centroid itself.
+
+func (client *PgisClient) ClosestPointOn(ctx context.Context, id int64, lon float64, lat float64) (float64, float64, error) {
+
+	t1 := time.Now()
+
+	lon, lat, err := client.closestPointOn(ctx, id, lon, lat)
+
+	client.reportQueried(t1, err)
+	return lon, lat, err
+}
+
+func (client *PgisClient) closestPointOn(ctx context.Context, id int64, lon float64, lat float64) (float64, float64, error) {
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	var closest_lon, closest_lat sql.NullFloat64
+
+	cols := client.columns()
+
+	query := fmt.Sprintf(`SELECT ST_X(pt), ST_Y(pt) FROM (
+	       SELECT ST_ClosestPoint(COALESCE(ST_Boundary(%s::geometry), %s::geometry), ST_SetSRID(ST_MakePoint($2, $3), 4326)) AS pt
+	       FROM %s WHERE id=$1) AS closest`, cols.geom, cols.centroid, source)
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", query, []interface{}{id, lon, lat})
+	}
+
+	row := db.QueryRowContext(ctx, query, id, lon, lat)
+	err = row.Scan(&closest_lon, &closest_lat)
+
+	if err != nil {
+		return 0, 0, classifyError(err)
+	}
+
+	if !closest_lon.Valid || !closest_lat.Valid {
+		return 0, 0, ErrNotFound
+	}
+
+	return closest_lon.Float64, closest_lat.Float64, nil
+}
@@ -0,0 +1,67 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestClosestPointOn(t *testing.T) {
+
+	tests := []struct {
+		what string
+		rows [][]driver.Value
+		lon  float64
+		lat  float64
+		err  error
+	}{
+		{"found", [][]driver.Value{{-73.25, 45.75}}, -73.25, 45.75, nil},
+		{"no record", [][]driver.Value{}, 0, 0, ErrNotFound},
+		{"no geometry", [][]driver.Value{{nil, nil}}, 0, 0, ErrNotFound},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.what, func(t *testing.T) {
+
+			var query_args []driver.Value
+
+			handler := func(query string, args []driver.Value) (*testResult, error) {
+				query_args = args
+				return &testResult{columns: []string{"st_x", "st_y"}, rows: test.rows}, nil
+			}
+
+			client, db := newTestClient(t, handler)
+
+			lon, lat, err := client.ClosestPointOn(context.Background(), 101736545, -73.5, 45.5)
+
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+
+			if lon != test.lon || lat != test.lat {
+				t.Errorf("expected %f, %f got %f, %f", test.lon, test.lat, lon, lat)
+			}
+
+			if fmt.Sprint(query_args) != "[101736545 -73.5 45.5]" {
+				t.Errorf("unexpected args %v", query_args)
+			}
+
+			stmts := db.statements()
+
+			if len(stmts) != 1 {
+				t.Fatalf("expected one query, got %v", stmts)
+			}
+
+			for _, expected := range []string{"ST_ClosestPoint(COALESCE(ST_Boundary(geom::geometry), centroid::geometry), ST_SetSRID(ST_MakePoint($2, $3), 4326))", "FROM whosonfirst WHERE id=$1"} {
+
+				if !strings.Contains(stmts[0], expected) {
+					t.Errorf("expected %s in %s", expected, stmts[0])
+				}
+			}
+		})
+	}
+}
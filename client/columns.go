@@ -0,0 +1,57 @@
+package pgis
+
+import (
+	"fmt"
+)
+
+// the (quoted, if they aren't the defaults) names of the columns that hold a
+// record's geometry and centroid; see PgisClient.GeomColumn and CentroidColumn
+
+type pgisColumns struct {
+	geom     string
+	centroid string
+}
+
+func (client *PgisClient) columns() pgisColumns {
+
+	cols := pgisColumns{
+		geom:     "geom",
+		centroid: "centroid",
+	}
+
+	if client.geomColumn() != "geom" {
+		cols.geom = quoteIdent(client.geomColumn())
+	}
+
+	if client.centroidColumn() != "centroid" {
+		cols.centroid = quoteIdent(client.centroidColumn())
+	}
+
+	return cols
+}
+
+// the unquoted names, as they appear in information_schema
+
+func (client *PgisClient) geomColumn() string {
+
+	if client.GeomColumn == "" {
+		return "geom"
+	}
+
+	return client.GeomColumn
+}
+
+func (client *PgisClient) centroidColumn() string {
+
+	if client.CentroidColumn == "" {
+		return "centroid"
+	}
+
+	return client.CentroidColumn
+}
+
+// the same as PGIS_ROW_COLUMNS but for cols
+
+func (cols pgisColumns) rowColumns() string {
+	return fmt.Sprintf("id, parent_id, placetype_id, is_superseded, is_deprecated, meta, ST_AsGeoJSON(%s), ST_AsGeoJSON(%s)", cols.geom, cols.centroid)
+}
@@ -0,0 +1,120 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"sort"
+	"strings"
+)
+
+// these are the valid values for PgisClient.ConflictKey, which decides what
+// makes a record unique (and so what gets updated rather than inserted); the
+// default is the wof:id alone but if you are indexing more than one repo that
+// share IDs (for example a repo and a fork of it) then "id+repo" will keep
+// them from clobbering one another. "id+repo" requires a repo column and a
+// unique index on (id, repo) in place of the primary key on id. "id+placetype"
+// is for tables that are partitioned by placetype_id (see CreatePartitions)
+// since PostgreSQL requires that unique indices include the partition key.
+
+const PGIS_CONFLICT_ID = "id"
+const PGIS_CONFLICT_ID_REPO = "id+repo"
+const PGIS_CONFLICT_ID_PLACETYPE = "id+placetype"
+
+func (client *PgisClient) conflictColumns() ([]string, error) {
+
+	switch client.ConflictKey {
+	case "", PGIS_CONFLICT_ID:
+		return []string{"id"}, nil
+	case PGIS_CONFLICT_ID_REPO:
+		return []string{"id", "repo"}, nil
+	case PGIS_CONFLICT_ID_PLACETYPE:
+		return []string{"id", "placetype_id"}, nil
+	default:
+		msg := fmt.Sprintf("invalid conflict key '%s'", client.ConflictKey)
+		return nil, errors.New(msg)
+	}
+}
+
+// returns true if records are also keyed by the repo column
+
+func (client *PgisClient) conflictRepo() bool {
+	return client.ConflictKey == PGIS_CONFLICT_ID_REPO
+}
+
+// the SQL for a record's repo: the repo column only exists with the id+repo
+// conflict key but wof:repo is always in meta (which is the same thing)
+
+func (client *PgisClient) repoColumn() string {
+
+	if client.conflictRepo() {
+		return "repo"
+	}
+
+	return "meta->>'wof:repo'"
+}
+
+// ON CONFLICT needs a unique index (or constraint) over exactly the conflict
+// columns or PostgreSQL refuses to run the query; this returns a description
+// of the problem if there isn't one
+
+func (client *PgisClient) checkConflictIndex(ctx context.Context, db *sql.DB) (string, error) {
+
+	conflict, err := client.conflictColumns()
+
+	if err != nil {
+		return "", err
+	}
+
+	expected := make([]string, len(conflict))
+	copy(expected, conflict)
+	sort.Strings(expected)
+
+	str_expected := strings.Join(expected, ",")
+
+	q := `SELECT array_agg(a.attname::text ORDER BY a.attname) FROM pg_index i
+	     JOIN pg_attribute a ON a.attrelid=i.indrelid AND a.attnum=ANY(i.indkey)
+	     WHERE i.indrelid='whosonfirst'::regclass AND i.indisunique GROUP BY i.indexrelid`
+
+	rows, err := db.QueryContext(ctx, q)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var cols []string
+
+		err := rows.Scan(pq.Array(&cols))
+
+		if err != nil {
+			return "", err
+		}
+
+		if strings.Join(cols, ",") == str_expected {
+			return "", nil
+		}
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return "", err
+	}
+
+	// the usual way this happens is a table that was created without a
+	// primary key so say so
+
+	str_conflict := strings.Join(conflict, ", ")
+
+	if len(conflict) == 1 {
+		return fmt.Sprintf("missing unique index on (%s), for example a PRIMARY KEY, which is needed to update existing records", str_conflict), nil
+	}
+
+	return fmt.Sprintf("missing unique index on (%s), which is needed to update existing records", str_conflict), nil
+}
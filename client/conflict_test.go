@@ -0,0 +1,188 @@
+package pgis
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"strings"
+	"testing"
+)
+
+func TestConflictColumns(t *testing.T) {
+
+	tests := []struct {
+		key     string
+		columns []string
+		repo    bool
+		err     bool
+	}{
+		{"", []string{"id"}, false, false},
+		{PGIS_CONFLICT_ID, []string{"id"}, false, false},
+		{PGIS_CONFLICT_ID_REPO, []string{"id", "repo"}, true, false},
+		{PGIS_CONFLICT_ID_PLACETYPE, []string{"id", "placetype_id"}, false, false},
+		{"id+alt_label", nil, false, true},
+	}
+
+	for _, test := range tests {
+
+		client := &PgisClient{ConflictKey: test.key}
+
+		columns, err := client.conflictColumns()
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("conflictColumns for %q returned %v, expected an error", test.key, columns)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("conflictColumns for %q failed because %s", test.key, err)
+			continue
+		}
+
+		if strings.Join(columns, ",") != strings.Join(test.columns, ",") {
+			t.Errorf("conflictColumns for %q returned %v, expected %v", test.key, columns, test.columns)
+		}
+
+		if client.conflictRepo() != test.repo {
+			t.Errorf("conflictRepo for %q returned %t, expected %t", test.key, client.conflictRepo(), test.repo)
+		}
+	}
+}
+
+func TestUpsertSQL(t *testing.T) {
+
+	cols := []string{"id", "placetype_id", "repo", "meta"}
+	vals := []string{"$1", "$2", "$3", "$4"}
+
+	tests := []struct {
+		conflict []string
+		sql      string
+	}{
+		{[]string{"id"}, "INSERT INTO whosonfirst (id, placetype_id, repo, meta) VALUES ($1, $2, $3, $4) ON CONFLICT(id) DO UPDATE SET placetype_id=EXCLUDED.placetype_id, repo=EXCLUDED.repo, meta=EXCLUDED.meta"},
+		{[]string{"id", "repo"}, "INSERT INTO whosonfirst (id, placetype_id, repo, meta) VALUES ($1, $2, $3, $4) ON CONFLICT(id, repo) DO UPDATE SET placetype_id=EXCLUDED.placetype_id, meta=EXCLUDED.meta"},
+		{[]string{"id", "placetype_id"}, "INSERT INTO whosonfirst (id, placetype_id, repo, meta) VALUES ($1, $2, $3, $4) ON CONFLICT(id, placetype_id) DO UPDATE SET repo=EXCLUDED.repo, meta=EXCLUDED.meta"},
+	}
+
+	for _, test := range tests {
+
+		sql := upsertSQL(cols, vals, test.conflict)
+
+		if sql != test.sql {
+			t.Errorf("upsertSQL with conflict %v returned %q, expected %q", test.conflict, sql, test.sql)
+		}
+	}
+
+	sql := upsertTableSQL(PGIS_LABELS_TABLE, cols, vals, []string{"id"})
+
+	if !strings.HasPrefix(sql, "INSERT INTO whosonfirst_labels (") {
+		t.Errorf("upsertTableSQL returned %q, expected an INSERT in to %s", sql, PGIS_LABELS_TABLE)
+	}
+}
+
+func TestStaleSQL(t *testing.T) {
+
+	locality, err := placetypes.GetPlacetypeByName("locality")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		client *PgisClient
+		table  string
+		stmts  []string
+		args   string
+	}{
+		{&PgisClient{}, "", []string{}, "[]"},
+		{&PgisClient{ConflictKey: PGIS_CONFLICT_ID_PLACETYPE, CreatePartitions: true}, "", []string{"DELETE FROM whosonfirst WHERE id=$1 AND placetype_id != $2"}, "[[101736545 102312317]]"},
+		{&PgisClient{PointsTable: "whosonfirst_points"}, "", []string{`DELETE FROM "whosonfirst_points" WHERE id=$1`}, "[[101736545]]"},
+		{&PgisClient{PointsTable: "whosonfirst_points"}, `"whosonfirst_points"`, []string{"DELETE FROM whosonfirst WHERE id=$1"}, "[[101736545]]"},
+		{&PgisClient{PointsTable: "whosonfirst_points", ConflictKey: PGIS_CONFLICT_ID_REPO}, "", []string{`DELETE FROM "whosonfirst_points" WHERE id=$1 AND repo=$2`}, "[[101736545 whosonfirst-data]]"},
+		{&PgisClient{PointsTable: "whosonfirst_points", ConflictKey: PGIS_CONFLICT_ID_PLACETYPE}, `"whosonfirst_points"`, []string{"DELETE FROM whosonfirst WHERE id=$1", `DELETE FROM "whosonfirst_points" WHERE id=$1 AND placetype_id != $2`}, "[[101736545] [101736545 102312317]]"},
+	}
+
+	for i, test := range tests {
+
+		u := &pgisUpsert{
+			id:        101736545,
+			placetype: locality,
+			table:     test.table,
+			repo:      "whosonfirst-data",
+		}
+
+		stmts, args, err := test.client.staleSQL(u)
+
+		if err != nil {
+			t.Errorf("%d: staleSQL failed because %s", i, err)
+			continue
+		}
+
+		if strings.Join(stmts, ";") != strings.Join(test.stmts, ";") {
+			t.Errorf("%d: staleSQL returned %q, expected %q", i, stmts, test.stmts)
+		}
+
+		if fmt.Sprintf("%v", args) != test.args {
+			t.Errorf("%d: staleSQL returned args %v, expected %s", i, args, test.args)
+		}
+	}
+}
+
+// re-indexing a record whose placetype has changed in to a partitioned table
+// removes the row from its old partition
+
+func TestUpsertPartitioned(t *testing.T) {
+
+	body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"localadmin","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45,-73,46"},"geometry":{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	wr := &bytes.Buffer{}
+
+	client := &PgisClient{
+		ConflictKey:      PGIS_CONFLICT_ID_PLACETYPE,
+		CreatePartitions: true,
+		Logger:           log.SimpleWOFLogger("test"),
+		SQLWriter:        wr,
+	}
+
+	err = client.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature failed because %s", err)
+	}
+
+	stmts := strings.Split(strings.TrimSpace(wr.String()), "\n")
+
+	expected := []string{
+		`CREATE TABLE IF NOT EXISTS "whosonfirst_localadmin" PARTITION OF whosonfirst FOR VALUES IN (404221409);`,
+		"BEGIN;",
+		"DELETE FROM whosonfirst WHERE id=101736545 AND placetype_id != 404221409;",
+		"INSERT INTO whosonfirst ",
+		"COMMIT;",
+	}
+
+	if len(stmts) != len(expected) {
+		t.Fatalf("expected %d statements, got %q", len(expected), stmts)
+	}
+
+	for i, prefix := range expected {
+
+		if !strings.HasPrefix(stmts[i], prefix) {
+			t.Errorf("expected statement %d to start with %q, got %q", i, prefix, stmts[i])
+		}
+	}
+
+	if !strings.Contains(stmts[3], "ON CONFLICT(id, placetype_id) DO UPDATE SET parent_id=EXCLUDED.parent_id, is_superseded=") {
+		t.Errorf("expected the upsert to leave placetype_id out of SET, got %q", stmts[3])
+	}
+}
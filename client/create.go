@@ -0,0 +1,355 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the values PgisSchemaOptions.GeometryType may be; lines are stored as
+// MULTILINESTRING (see StoreLength) so anything other than polygons will need
+// GEOMETRY
+
+var PGIS_GEOMETRY_TYPES = map[string]bool{
+	"GEOMETRY":           true,
+	"GEOMETRYCOLLECTION": true,
+	"MULTIPOLYGON":       true,
+	"MULTILINESTRING":    true,
+	"MULTIPOINT":         true,
+}
+
+type PgisSchemaOptions struct {
+	GeometryType string            // the type of the geom (and geom_simplified) column; "" means MULTIPOLYGON
+	Labels       bool              // also create the whosonfirst_labels table (see IndexLabel)
+	ExtraColumns map[string]string // column name to SQL type for anything the client's ExtraColumns function writes
+	IfNotExists  bool              // leave any tables or indices that already exist alone
+}
+
+func NewDefaultPgisSchemaOptions() *PgisSchemaOptions {
+
+	opts := PgisSchemaOptions{
+		GeometryType: "",
+		Labels:       false,
+		ExtraColumns: map[string]string{},
+		IfNotExists:  false,
+	}
+
+	return &opts
+}
+
+// a column and its (PostgreSQL) definition in the order they are created
+
+type pgisColumnDef struct {
+	name string
+	def  string
+}
+
+// the columns written by propertyColumns, which is to say the ones that the
+// whosonfirst and whosonfirst_labels tables share
+
+func (client *PgisClient) propertyColumnDefs(opts *PgisSchemaOptions) ([]pgisColumnDef, error) {
+
+	defs := []pgisColumnDef{
+		{"id", "BIGINT NOT NULL"},
+		{"parent_id", "BIGINT"},
+		{"placetype_id", "BIGINT NOT NULL"},
+		{"is_superseded", "SMALLINT"},
+		{"is_deprecated", "SMALLINT"},
+		{"meta", "JSON"},
+	}
+
+	if client.StoreHierarchy {
+
+		for _, col := range HIERARCHY_COLUMNS {
+			defs = append(defs, pgisColumnDef{col, "BIGINT"})
+		}
+	}
+
+	if client.StoreRaw {
+		defs = append(defs, pgisColumnDef{"raw", "JSON"})
+	}
+
+	if client.StoreValidity {
+		defs = append(defs, pgisColumnDef{"valid_range", "DATERANGE"})
+	}
+
+	if client.StoreFeatureHash {
+		defs = append(defs, pgisColumnDef{"feature_hash", "CHAR(32)"})
+	}
+
+	if client.conflictRepo() {
+		defs = append(defs, pgisColumnDef{"repo", "TEXT NOT NULL"})
+	}
+
+	names := make([]string, 0, len(opts.ExtraColumns))
+
+	for name := range opts.ExtraColumns {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+
+		err := validateIdent(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range defs {
+
+			if d.name == name {
+				msg := fmt.Sprintf("extra column '%s' is already written by the client", name)
+				return nil, errors.New(msg)
+			}
+		}
+
+		defs = append(defs, pgisColumnDef{quoteIdent(name), opts.ExtraColumns[name]})
+	}
+
+	defs = append(defs, pgisColumnDef{"lastmod", "TIMESTAMPTZ DEFAULT now()"})
+	return defs, nil
+}
+
+// the statements CreateSchema runs, in order
+
+func (client *PgisClient) schemaSQL(opts *PgisSchemaOptions) ([]string, error) {
+
+	conflict, err := client.conflictColumns()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// PostgreSQL insists that unique indices on a partitioned table include
+	// the partition key
+
+	if client.CreatePartitions && client.ConflictKey != PGIS_CONFLICT_ID_PLACETYPE {
+		msg := fmt.Sprintf("partitioned tables need the '%s' conflict key", PGIS_CONFLICT_ID_PLACETYPE)
+		return nil, errors.New(msg)
+	}
+
+	for _, name := range []string{client.geomColumn(), client.centroidColumn()} {
+
+		err = validateIdent(name)
+
+		if err != nil {
+			msg := fmt.Sprintf("invalid geometry column name because %s", err)
+			return nil, errors.New(msg)
+		}
+	}
+
+	geom_col := client.columns().geom
+	centroid_col := client.columns().centroid
+
+	geom_type := opts.GeometryType
+
+	if geom_type == "" {
+		geom_type = "MULTIPOLYGON"
+	}
+
+	geom_type = strings.ToUpper(geom_type)
+
+	if !PGIS_GEOMETRY_TYPES[geom_type] {
+		msg := fmt.Sprintf("invalid geometry type '%s'", opts.GeometryType)
+		return nil, errors.New(msg)
+	}
+
+	prop_defs, err := client.propertyColumnDefs(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	create_table := "CREATE TABLE"
+	create_index := "CREATE INDEX"
+
+	if opts.IfNotExists {
+		create_table = "CREATE TABLE IF NOT EXISTS"
+		create_index = "CREATE INDEX IF NOT EXISTS"
+	}
+
+	defs := append([]pgisColumnDef{}, prop_defs...)
+	defs = append(defs, pgisColumnDef{"geom_hash", "CHAR(32)"})
+
+	defs = append(defs, pgisColumnDef{geom_col, fmt.Sprintf("GEOGRAPHY(%s, 4326)", geom_type)})
+	defs = append(defs, pgisColumnDef{centroid_col, "GEOGRAPHY(POINT, 4326)"})
+
+	indices := [][]string{
+		{"by_geom", fmt.Sprintf("USING GIST(%s)", geom_col)},
+		{"by_centroid", fmt.Sprintf("USING GIST(%s)", centroid_col)},
+		{"by_placetype", "(placetype_id)"},
+		{"by_lastmod", "(lastmod)"},
+	}
+
+	if client.SimplifiedTolerance > 0.0 {
+		defs = append(defs, pgisColumnDef{"geom_simplified", fmt.Sprintf("GEOGRAPHY(%s, 4326)", geom_type)})
+		indices = append(indices, []string{"by_geom_simplified", "USING GIST(geom_simplified)"})
+	}
+
+	if client.ProjectedSRID > 0 {
+		defs = append(defs, pgisColumnDef{"geom_projected", fmt.Sprintf("GEOMETRY(%s, %d)", geom_type, client.ProjectedSRID)})
+		indices = append(indices, []string{"by_geom_projected", "USING GIST(geom_projected)"})
+	}
+
+	if client.StoreBBox {
+		defs = append(defs, pgisColumnDef{"bbox", "GEOMETRY(POLYGON, 4326)"})
+		indices = append(indices, []string{"by_bbox", "USING GIST(bbox)"})
+	}
+
+	if client.StoreArea {
+		defs = append(defs, pgisColumnDef{"area_meters", "DOUBLE PRECISION"})
+	}
+
+	if client.StoreLength {
+		defs = append(defs, pgisColumnDef{"length_meters", "DOUBLE PRECISION"})
+	}
+
+	if client.StoreLatLon {
+		defs = append(defs, pgisColumnDef{"latitude", "DOUBLE PRECISION"})
+		defs = append(defs, pgisColumnDef{"longitude", "DOUBLE PRECISION"})
+	}
+
+	if client.GeohashPrecision > 0 {
+		defs = append(defs, pgisColumnDef{"geohash", "TEXT"})
+		indices = append(indices, []string{"by_geohash", "(geohash text_pattern_ops)"})
+	}
+
+	if client.RunId != "" {
+		defs = append(defs, pgisColumnDef{"run_id", "TEXT"})
+	}
+
+	if client.StoreHierarchy {
+
+		for _, col := range HIERARCHY_COLUMNS {
+			name := fmt.Sprintf("by_%s", strings.TrimSuffix(col, "_id"))
+			indices = append(indices, []string{name, fmt.Sprintf("(%s)", col)})
+		}
+	}
+
+	if client.StoreValidity {
+		indices = append(indices, []string{"by_valid_range", "USING GIST(valid_range)"})
+	}
+
+	partition := ""
+
+	if client.CreatePartitions {
+		partition = " PARTITION BY LIST (placetype_id)"
+	}
+
+	stmts := []string{
+		fmt.Sprintf("%s whosonfirst (%s, PRIMARY KEY (%s))%s", create_table, columnDefs(defs), strings.Join(conflict, ", "), partition),
+	}
+
+	for _, idx := range indices {
+		stmts = append(stmts, fmt.Sprintf("%s %s ON whosonfirst %s", create_index, idx[0], idx[1]))
+	}
+
+	// the points table is never partitioned either but otherwise it is the
+	// same as the whosonfirst table, indices and all
+
+	points, err := client.routeTable("Point")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if points != "" {
+		stmts = append(stmts, fmt.Sprintf("%s %s (LIKE whosonfirst INCLUDING ALL)", create_table, points))
+	}
+
+	if client.SubdivideMaxVertices > 0 {
+		stmts = append(stmts, fmt.Sprintf("%s whosonfirst_subdivided (id BIGINT NOT NULL, geom GEOMETRY(GEOMETRY, 4326))", create_table))
+		stmts = append(stmts, fmt.Sprintf("%s by_subdivided_id ON whosonfirst_subdivided (id)", create_index))
+		stmts = append(stmts, fmt.Sprintf("%s by_subdivided_geom ON whosonfirst_subdivided USING GIST(geom)", create_index))
+	}
+
+	if opts.Labels {
+
+		// the labels table is never partitioned (see indexLabel)
+
+		label_defs := append([]pgisColumnDef{}, prop_defs...)
+		label_defs = append(label_defs, pgisColumnDef{"geom", "GEOGRAPHY(POINT, 4326)"})
+
+		stmts = append(stmts, fmt.Sprintf("%s %s (%s, PRIMARY KEY (%s))", create_table, PGIS_LABELS_TABLE, columnDefs(label_defs), strings.Join(conflict, ", ")))
+		stmts = append(stmts, fmt.Sprintf("%s by_labels_geom ON %s USING GIST(geom)", create_index, PGIS_LABELS_TABLE))
+	}
+
+	return stmts, nil
+}
+
+func columnDefs(defs []pgisColumnDef) string {
+
+	str_defs := make([]string, len(defs))
+
+	for i, d := range defs {
+		str_defs[i] = fmt.Sprintf("%s %s", d.name, d.def)
+	}
+
+	return strings.Join(str_defs, ", ")
+}
+
+// CreateSchema creates the whosonfirst table, and its indices, with the columns
+// IndexFeature is going to write to given the way the client is configured:
+// the primary key is the conflict key (see ConflictKey), the table is
+// partitioned by placetype if CreatePartitions is true and the optional
+// columns (like area_meters or repo) are only added if they will be used. It
+// also creates the points table if PointsTable is set, the whosonfirst_subdivided
+// table if SubdivideMaxVertices is set and the whosonfirst_labels table if
+// opts.Labels is true. Everything is
+// created in a single transaction so it either all works or none of it does.
+// Columns written by the client's ExtraColumns function need to be listed in
+// opts.ExtraColumns since there is no way to know their types otherwise.
+
+func (client *PgisClient) CreateSchema(ctx context.Context, opts *PgisSchemaOptions) error {
+
+	stmts, err := client.schemaSQL(opts)
+
+	if err != nil {
+		return err
+	}
+
+	if client.Verbose {
+
+		for _, s := range stmts {
+			client.Logger.Status("%s", s)
+		}
+	}
+
+	if client.SQLWriter != nil {
+		return client.writeSQL(stmts, make([][]interface{}, len(stmts)))
+	}
+
+	if client.Debug {
+		return nil
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+
+		for _, s := range stmts {
+
+			_, err := tx.ExecContext(ctx, s)
+
+			if err != nil {
+				client.Logger.Error("failed to execute query because %s", err)
+				return classifyError(err)
+			}
+		}
+
+		return nil
+	})
+}
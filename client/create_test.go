@@ -0,0 +1,142 @@
+package pgis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchemaSQL(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		client   *PgisClient
+		opts     *PgisSchemaOptions
+		contains []string
+		absent   []string
+		err      bool
+	}{
+		{
+			name:     "default",
+			client:   &PgisClient{},
+			opts:     NewDefaultPgisSchemaOptions(),
+			contains: []string{"CREATE TABLE whosonfirst (id BIGINT NOT NULL,", "geom GEOGRAPHY(MULTIPOLYGON, 4326)", "PRIMARY KEY (id))", "CREATE INDEX by_geom ON whosonfirst USING GIST(geom)"},
+			absent:   []string{"repo", "PARTITION", "whosonfirst_subdivided", "whosonfirst_labels", "area_meters"},
+		},
+		{
+			name:     "id+repo",
+			client:   &PgisClient{ConflictKey: PGIS_CONFLICT_ID_REPO},
+			opts:     &PgisSchemaOptions{Labels: true},
+			contains: []string{"repo TEXT NOT NULL", "PRIMARY KEY (id, repo))", "CREATE TABLE whosonfirst_labels (", "geom GEOGRAPHY(POINT, 4326), PRIMARY KEY (id, repo))"},
+		},
+		{
+			name:     "partitioned",
+			client:   &PgisClient{ConflictKey: PGIS_CONFLICT_ID_PLACETYPE, CreatePartitions: true},
+			opts:     NewDefaultPgisSchemaOptions(),
+			contains: []string{"PRIMARY KEY (id, placetype_id)) PARTITION BY LIST (placetype_id)"},
+		},
+		{
+			name:   "partitioned without placetype",
+			client: &PgisClient{CreatePartitions: true},
+			opts:   NewDefaultPgisSchemaOptions(),
+			err:    true,
+		},
+		{
+			name:   "invalid conflict key",
+			client: &PgisClient{ConflictKey: "id+alt"},
+			opts:   NewDefaultPgisSchemaOptions(),
+			err:    true,
+		},
+		{
+			name:     "optional columns",
+			client:   &PgisClient{StoreArea: true, StoreLength: true, StoreBBox: true, SimplifiedTolerance: 0.01, ProjectedSRID: 3857, SubdivideMaxVertices: 256, GeohashPrecision: 6, StoreHierarchy: true, RunId: "run"},
+			opts:     &PgisSchemaOptions{GeometryType: "geometry", IfNotExists: true},
+			contains: []string{"CREATE TABLE IF NOT EXISTS whosonfirst (", "geom GEOGRAPHY(GEOMETRY, 4326)", "geom_simplified GEOGRAPHY(GEOMETRY, 4326)", "geom_projected GEOMETRY(GEOMETRY, 3857)", "bbox GEOMETRY(POLYGON, 4326)", "area_meters DOUBLE PRECISION", "length_meters DOUBLE PRECISION", "geohash TEXT", "run_id TEXT", "country_id BIGINT", "CREATE INDEX IF NOT EXISTS by_continent ON whosonfirst (continent_id)", "CREATE INDEX IF NOT EXISTS by_country ON whosonfirst (country_id)", "CREATE INDEX IF NOT EXISTS by_region ON whosonfirst (region_id)", "CREATE INDEX IF NOT EXISTS by_locality ON whosonfirst (locality_id)", "CREATE TABLE IF NOT EXISTS whosonfirst_subdivided ("},
+		},
+		{
+			name:     "points table",
+			client:   &PgisClient{PointsTable: "whosonfirst_points"},
+			opts:     &PgisSchemaOptions{IfNotExists: true},
+			contains: []string{`CREATE TABLE IF NOT EXISTS "whosonfirst_points" (LIKE whosonfirst INCLUDING ALL)`},
+		},
+		{
+			name:     "geometry column names",
+			client:   &PgisClient{GeomColumn: "the_geom", CentroidColumn: "centroid"},
+			opts:     NewDefaultPgisSchemaOptions(),
+			contains: []string{`"the_geom" GEOGRAPHY(MULTIPOLYGON, 4326), centroid GEOGRAPHY(POINT, 4326)`, `USING GIST("the_geom")`},
+		},
+		{
+			name:     "extra columns",
+			client:   &PgisClient{},
+			opts:     &PgisSchemaOptions{ExtraColumns: map[string]string{"source": "TEXT", "batch": "INTEGER"}},
+			contains: []string{`"batch" INTEGER, "source" TEXT, lastmod`},
+		},
+		{
+			name:   "duplicate extra column",
+			client: &PgisClient{},
+			opts:   &PgisSchemaOptions{ExtraColumns: map[string]string{"meta": "JSON"}},
+			err:    true,
+		},
+		{
+			name:   "invalid geometry type",
+			client: &PgisClient{},
+			opts:   &PgisSchemaOptions{GeometryType: "POLYGON); DROP TABLE whosonfirst; --"},
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+
+		stmts, err := test.client.schemaSQL(test.opts)
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("%s: expected an error but got none", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+
+		str_stmts := strings.Join(stmts, ";\n")
+
+		for _, s := range test.contains {
+
+			if !strings.Contains(str_stmts, s) {
+				t.Errorf("%s: expected schema to contain %q but got %s", test.name, s, str_stmts)
+			}
+		}
+
+		for _, s := range test.absent {
+
+			if strings.Contains(str_stmts, s) {
+				t.Errorf("%s: expected schema not to contain %q but got %s", test.name, s, str_stmts)
+			}
+		}
+	}
+}
+
+// the columns CreateSchema creates should always be the ones ValidateSchema
+// expects
+
+func TestSchemaSQLExpectedColumns(t *testing.T) {
+
+	client := &PgisClient{ConflictKey: PGIS_CONFLICT_ID_REPO, StoreArea: true, StoreLength: true, StoreBBox: true, StoreRaw: true, StoreValidity: true, StoreLatLon: true, StoreFeatureHash: true, StoreHierarchy: true, SimplifiedTolerance: 0.01, ProjectedSRID: 3857, GeohashPrecision: 6, RunId: "run"}
+
+	stmts, err := client.schemaSQL(NewDefaultPgisSchemaOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range client.expectedColumns() {
+
+		if !strings.Contains(stmts[0], " "+name+" ") && !strings.Contains(stmts[0], "("+name+" ") {
+			t.Errorf("expected column %s is not created by CreateSchema: %s", name, stmts[0])
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package pgis
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"strconv"
+	"strings"
+)
+
+// https://tools.ietf.org/html/rfc7946#section-4
+// http://geojson.org/geojson-spec.html#named-crs
+
+// RFC 7946 GeoJSON is always EPSG:4326 but older (2008) GeoJSON could declare
+// some other coordinate reference system with a "crs" member, either for the
+// feature or for its geometry, and some non-WOF sources still do
+
+const PGIS_STORAGE_SRID = 4326
+
+// mixed datasets that have been through some other tool often record the SRID
+// of each feature's original geometry in this property instead, either as a
+// number or as a name like "EPSG:3857"
+
+const PGIS_GEOM_SRID_PROPERTY = "src:geom_srid"
+
+type namedCRS struct {
+	Type       string `json:"type"`
+	Properties struct {
+		Name string `json:"name"`
+	} `json:"properties"`
+}
+
+type crsFeature struct {
+	CRS      *namedCRS `json:"crs"`
+	Geometry struct {
+		CRS *namedCRS `json:"crs"`
+	} `json:"geometry"`
+	Properties struct {
+		GeomSRID json.RawMessage `json:"src:geom_srid"`
+	} `json:"properties"`
+}
+
+// returns the SRID declared by feature (the geometry's crs wins over the
+// feature's, which wins over the src:geom_srid property) or PGIS_STORAGE_SRID
+// if it doesn't declare one
+
+func featureSRID(feature geojson.Feature) (int, error) {
+
+	body := feature.Bytes()
+
+	// don't bother parsing (potentially very large) features that can't
+	// possibly declare a CRS
+
+	if !bytes.Contains(body, []byte(`"crs"`)) && !bytes.Contains(body, []byte(`"`+PGIS_GEOM_SRID_PROPERTY+`"`)) {
+		return PGIS_STORAGE_SRID, nil
+	}
+
+	var f crsFeature
+
+	err := json.Unmarshal(body, &f)
+
+	if err != nil {
+		return 0, err
+	}
+
+	crs := f.Geometry.CRS
+
+	if crs == nil {
+		crs = f.CRS
+	}
+
+	if crs == nil && len(f.Properties.GeomSRID) > 0 {
+		return parseGeomSRID(f.Properties.GeomSRID)
+	}
+
+	if crs == nil {
+		return PGIS_STORAGE_SRID, nil
+	}
+
+	if crs.Type != "name" {
+		msg := fmt.Sprintf("unsupported crs type '%s'", crs.Type)
+		return 0, errors.New(msg)
+	}
+
+	return parseCRSName(crs.Properties.Name)
+}
+
+// names look like "EPSG:3857" or "urn:ogc:def:crs:EPSG::3857" and CRS84 is
+// the same as EPSG:4326 (give or take axis order, which GeoJSON ignores)
+
+func parseCRSName(name string) (int, error) {
+
+	if strings.HasSuffix(name, "CRS84") {
+		return PGIS_STORAGE_SRID, nil
+	}
+
+	idx := strings.LastIndex(name, ":")
+
+	if idx == -1 || !strings.Contains(name, "EPSG") {
+		msg := fmt.Sprintf("unsupported crs '%s'", name)
+		return 0, errors.New(msg)
+	}
+
+	srid, err := strconv.Atoi(name[idx+1:])
+
+	if err != nil {
+		msg := fmt.Sprintf("unsupported crs '%s'", name)
+		return 0, errors.New(msg)
+	}
+
+	return srid, nil
+}
+
+// the value of the src:geom_srid property, which is a number or a name that
+// parseCRSName understands; null is the same as not having the property
+
+func parseGeomSRID(raw json.RawMessage) (int, error) {
+
+	var v interface{}
+
+	err := json.Unmarshal(raw, &v)
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch srid := v.(type) {
+	case nil:
+		return PGIS_STORAGE_SRID, nil
+	case float64:
+
+		if srid <= 0 || srid != float64(int(srid)) {
+			msg := fmt.Sprintf("invalid %s '%v'", PGIS_GEOM_SRID_PROPERTY, srid)
+			return 0, errors.New(msg)
+		}
+
+		return int(srid), nil
+	case string:
+
+		i, err := strconv.Atoi(srid)
+
+		if err == nil && i > 0 {
+			return i, nil
+		}
+
+		return parseCRSName(srid)
+	default:
+		msg := fmt.Sprintf("invalid %s '%s'", PGIS_GEOM_SRID_PROPERTY, string(raw))
+		return 0, errors.New(msg)
+	}
+}
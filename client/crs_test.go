@@ -0,0 +1,142 @@
+package pgis
+
+import (
+	"encoding/json"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"testing"
+)
+
+func TestParseCRSName(t *testing.T) {
+
+	tests := []struct {
+		name string
+		srid int
+		err  bool
+	}{
+		{"EPSG:4326", 4326, false},
+		{"EPSG:3857", 3857, false},
+		{"urn:ogc:def:crs:EPSG::2154", 2154, false},
+		{"urn:ogc:def:crs:OGC:1.3:CRS84", 4326, false},
+		{"EPSG:", 0, true},
+		{"EPSG:web", 0, true},
+		{"ESRI:102100", 0, true},
+		{"3857", 0, true},
+	}
+
+	for _, test := range tests {
+
+		srid, err := parseCRSName(test.name)
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("parseCRSName(%q) returned %d, expected an error", test.name, srid)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseCRSName(%q) failed because %s", test.name, err)
+			continue
+		}
+
+		if srid != test.srid {
+			t.Errorf("parseCRSName(%q) returned %d, expected %d", test.name, srid, test.srid)
+		}
+	}
+}
+
+func TestFeatureSRID(t *testing.T) {
+
+	tests := []struct {
+		body string
+		srid int
+		err  bool
+	}{
+		{`{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`, 4326, false},
+		{`{"type":"Feature","crs":{"type":"name","properties":{"name":"EPSG:3857"}},"properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`, 3857, false},
+		{`{"type":"Feature","properties":{},"geometry":{"type":"Point","crs":{"type":"name","properties":{"name":"urn:ogc:def:crs:EPSG::2154"}},"coordinates":[0,0]}}`, 2154, false},
+		{`{"type":"Feature","crs":{"type":"name","properties":{"name":"EPSG:3857"}},"properties":{},"geometry":{"type":"Point","crs":{"type":"name","properties":{"name":"EPSG:2154"}},"coordinates":[0,0]}}`, 2154, false},
+		{`{"type":"Feature","properties":{"src:geom_srid":3857},"geometry":{"type":"Point","coordinates":[0,0]}}`, 3857, false},
+		{`{"type":"Feature","properties":{"src:geom_srid":"EPSG:2154"},"geometry":{"type":"Point","coordinates":[0,0]}}`, 2154, false},
+		{`{"type":"Feature","crs":{"type":"name","properties":{"name":"EPSG:2154"}},"properties":{"src:geom_srid":3857},"geometry":{"type":"Point","coordinates":[0,0]}}`, 2154, false},
+		{`{"type":"Feature","properties":{"src:geom_srid":"web mercator"},"geometry":{"type":"Point","coordinates":[0,0]}}`, 0, true},
+		{`{"type":"Feature","crs":{"type":"link","properties":{"href":"http://example.com/crs"}},"properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`, 0, true},
+		{`{"type":"Feature","crs":{"type":"name","properties":{"name":"ESRI:102100"}},"properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`, 0, true},
+	}
+
+	for _, test := range tests {
+
+		f, err := feature.LoadFeature([]byte(test.body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		srid, err := featureSRID(f)
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("featureSRID(%s) returned %d, expected an error", test.body, srid)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("featureSRID(%s) failed because %s", test.body, err)
+			continue
+		}
+
+		if srid != test.srid {
+			t.Errorf("featureSRID(%s) returned %d, expected %d", test.body, srid, test.srid)
+		}
+	}
+}
+
+func TestParseGeomSRID(t *testing.T) {
+
+	tests := []struct {
+		raw  string
+		srid int
+		err  bool
+	}{
+		{`3857`, 3857, false},
+		{`"3857"`, 3857, false},
+		{`"EPSG:2154"`, 2154, false},
+		{`"urn:ogc:def:crs:OGC:1.3:CRS84"`, 4326, false},
+		{`null`, 4326, false},
+		{`0`, 0, true},
+		{`-4326`, 0, true},
+		{`3857.5`, 0, true},
+		{`"web mercator"`, 0, true},
+		{`true`, 0, true},
+		{`[3857]`, 0, true},
+		{`{`, 0, true},
+	}
+
+	for _, test := range tests {
+
+		srid, err := parseGeomSRID(json.RawMessage(test.raw))
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("parseGeomSRID(%s) returned %d, expected an error", test.raw, srid)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseGeomSRID(%s) failed because %s", test.raw, err)
+			continue
+		}
+
+		if srid != test.srid {
+			t.Errorf("parseGeomSRID(%s) returned %d, expected %d", test.raw, srid, test.srid)
+		}
+	}
+}
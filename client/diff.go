@@ -0,0 +1,287 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PgisDiffReport describes the differences between two databases, as returned
+// by Diff. All the IDs are in ascending order.
+
+type PgisDiffReport struct {
+	Compared     int64   `json:"compared"`
+	MissingLocal []int64 `json:"missing_local"` // in the other database but not this one
+	MissingOther []int64 `json:"missing_other"` // in this database but not the other one
+	Different    []int64 `json:"different"`
+}
+
+// InSync returns true if the two databases hold the same records with the
+// same content
+
+func (r *PgisDiffReport) InSync() bool {
+	return len(r.MissingLocal) == 0 && len(r.MissingOther) == 0 && len(r.Different) == 0
+}
+
+// Diff compares the records (matching opts) in the whosonfirst table of client
+// with those in other, for example a replica, and reports the IDs that are
+// missing from either side or whose content differs. Content is compared using
+// the feature_hash column if StoreFeatureHash is set, which it must be for both
+// clients or neither, and the geom_hash column (which only covers the geometry)
+// if it isn't. Both result sets are read in ID order and merged as they come in
+// so that nothing more than the report itself is held in memory.
+
+func (client *PgisClient) Diff(ctx context.Context, other *PgisClient, opts *PgisIntersectsOptions) (*PgisDiffReport, error) {
+
+	// a client can't be compared with itself, not least because with only
+	// one connection it would wait forever for the second one
+
+	if other == nil || other == client {
+		return nil, errors.New("Diff needs a different client to compare against")
+	}
+
+	if client.conflictRepo() || other.conflictRepo() {
+		return nil, errors.New("records are compared by id alone so Diff can not be used with the id+repo conflict key")
+	}
+
+	// the hashes are only comparable if they are the same kind of hash
+
+	if client.StoreFeatureHash != other.StoreFeatureHash {
+		return nil, errors.New("Diff needs both clients to have the same StoreFeatureHash setting")
+	}
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	for _, c := range []*PgisClient{client, other} {
+
+		missing := c.missingFilterColumns(opts)
+
+		if len(missing) > 0 {
+			msg := fmt.Sprintf("Diff can not filter on columns that one of the clients doesn't store: %s", strings.Join(missing, ", "))
+			return nil, errors.New(msg)
+		}
+
+		err := c.requireQueryPostGIS(ctx, opts)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// each side's filters use its own column names (see GeomColumn) which
+	// need not be the same
+
+	local_query, local_args, err := client.diffSQL(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	other_query, other_args, err := other.diffSQL(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", local_query, local_args)
+		client.Logger.Status("%s %v", other_query, other_args)
+	}
+
+	local_db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	other_db, err := other.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		other.conns <- true
+	}()
+
+	local_rows, err := local_db.QueryContext(ctx, local_query, local_args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer local_rows.Close()
+
+	other_rows, err := other_db.QueryContext(ctx, other_query, other_args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer other_rows.Close()
+
+	return mergeDiff(newDiffRows(local_rows), newDiffRows(other_rows))
+}
+
+// the query, and its arguments, for the ids and hashes of the records matching
+// opts in ID order, from the points table too if there is one
+
+func (client *PgisClient) diffSQL(opts *PgisIntersectsOptions) (string, []interface{}, error) {
+
+	hash_col := "geom_hash"
+
+	if client.StoreFeatureHash {
+		hash_col = "feature_hash"
+	}
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	where, args := opts.filters(client.columns(), []string{}, []interface{}{})
+
+	str_where := ""
+
+	if len(where) > 0 {
+		str_where = fmt.Sprintf(" WHERE %s", strings.Join(where, " AND "))
+	}
+
+	query := fmt.Sprintf("SELECT id, COALESCE(%s, '') FROM %s%s ORDER BY id ASC", hash_col, source, str_where)
+	return query, args, nil
+}
+
+// the optional columns that opts filters on but client doesn't store, going by
+// its Store* settings
+
+func (client *PgisClient) missingFilterColumns(opts *PgisIntersectsOptions) []string {
+
+	missing := make([]string, 0)
+
+	if (opts.MinArea > 0.0 || opts.MaxArea > 0.0) && !client.StoreArea {
+		missing = append(missing, "area_meters")
+	}
+
+	if len(opts.BBox) == 4 && opts.UseBBoxColumn && !client.StoreBBox {
+		missing = append(missing, "bbox")
+	}
+
+	if !client.StoreHierarchy {
+
+		ancestors := map[string]int64{
+			"continent_id": opts.ContinentId,
+			"country_id":   opts.CountryId,
+			"region_id":    opts.RegionId,
+			"locality_id":  opts.LocalityId,
+		}
+
+		for _, col := range HIERARCHY_COLUMNS {
+
+			if ancestors[col] != 0 {
+				missing = append(missing, col)
+			}
+		}
+	}
+
+	return missing
+}
+
+// returns the next id and hash, and false once there aren't any more
+
+type pgisDiffRows func() (int64, string, bool, error)
+
+func newDiffRows(rows *sql.Rows) pgisDiffRows {
+
+	return func() (int64, string, bool, error) {
+		return nextDiffRow(rows)
+	}
+}
+
+// merges the (ID ordered) records of both sides in to a report
+
+func mergeDiff(local pgisDiffRows, other pgisDiffRows) (*PgisDiffReport, error) {
+
+	report := &PgisDiffReport{
+		MissingLocal: make([]int64, 0),
+		MissingOther: make([]int64, 0),
+		Different:    make([]int64, 0),
+	}
+
+	local_id, local_hash, local_ok, err := local()
+
+	if err != nil {
+		return nil, err
+	}
+
+	other_id, other_hash, other_ok, err := other()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for local_ok || other_ok {
+
+		switch {
+		case !other_ok || (local_ok && local_id < other_id):
+
+			report.MissingOther = append(report.MissingOther, local_id)
+			local_id, local_hash, local_ok, err = local()
+
+		case !local_ok || other_id < local_id:
+
+			report.MissingLocal = append(report.MissingLocal, other_id)
+			other_id, other_hash, other_ok, err = other()
+
+		default:
+
+			report.Compared += 1
+
+			if local_hash != other_hash {
+				report.Different = append(report.Different, local_id)
+			}
+
+			local_id, local_hash, local_ok, err = local()
+
+			if err != nil {
+				return nil, err
+			}
+
+			other_id, other_hash, other_ok, err = other()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// the next id and hash from rows, and false once there aren't any more
+
+func nextDiffRow(rows *sql.Rows) (int64, string, bool, error) {
+
+	if !rows.Next() {
+		return 0, "", false, rows.Err()
+	}
+
+	var id int64
+	var hash string
+
+	err := rows.Scan(&id, &hash)
+
+	if err != nil {
+		return 0, "", false, err
+	}
+
+	return id, hash, true, nil
+}
@@ -0,0 +1,162 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testDiffRow struct {
+	id   int64
+	hash string
+}
+
+// a pgisDiffRows that returns rows and then err, if there is one
+
+func testDiffRows(rows []testDiffRow, err error) pgisDiffRows {
+
+	i := 0
+
+	return func() (int64, string, bool, error) {
+
+		if i >= len(rows) {
+			return 0, "", false, err
+		}
+
+		r := rows[i]
+		i += 1
+
+		return r.id, r.hash, true, nil
+	}
+}
+
+func TestMergeDiff(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		local    []testDiffRow
+		other    []testDiffRow
+		expected PgisDiffReport
+	}{
+		{"empty", nil, nil, PgisDiffReport{0, []int64{}, []int64{}, []int64{}}},
+		{"same", []testDiffRow{{1, "a"}, {2, "b"}}, []testDiffRow{{1, "a"}, {2, "b"}}, PgisDiffReport{2, []int64{}, []int64{}, []int64{}}},
+		{"different", []testDiffRow{{1, "a"}, {2, "b"}}, []testDiffRow{{1, "a"}, {2, "c"}}, PgisDiffReport{2, []int64{}, []int64{}, []int64{2}}},
+		{"missing local", nil, []testDiffRow{{1, "a"}, {2, "b"}}, PgisDiffReport{0, []int64{1, 2}, []int64{}, []int64{}}},
+		{"missing other", []testDiffRow{{1, "a"}, {2, "b"}}, nil, PgisDiffReport{0, []int64{}, []int64{1, 2}, []int64{}}},
+		{"interleaved", []testDiffRow{{1, "a"}, {3, "c"}, {5, "e"}, {6, "f"}}, []testDiffRow{{2, "b"}, {3, "x"}, {4, "d"}, {6, "f"}, {7, "g"}}, PgisDiffReport{2, []int64{2, 4, 7}, []int64{1, 5}, []int64{3}}},
+	}
+
+	for _, test := range tests {
+
+		report, err := mergeDiff(testDiffRows(test.local, nil), testDiffRows(test.other, nil))
+
+		if err != nil {
+			t.Errorf("%s: mergeDiff failed because %s", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(*report, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, *report)
+		}
+
+		if report.InSync() != (test.name == "empty" || test.name == "same") {
+			t.Errorf("%s: InSync returned %t", test.name, report.InSync())
+		}
+	}
+
+	// an error from either side, at any point, fails the whole thing
+
+	failed := errors.New("failed")
+
+	for _, local_fails := range []bool{true, false} {
+
+		for _, n := range []int{0, 1, 2} {
+
+			rows := []testDiffRow{{1, "a"}, {2, "b"}}
+
+			local := testDiffRows(rows, nil)
+			other := testDiffRows(rows[:n], failed)
+
+			if local_fails {
+				local = testDiffRows(rows[:n], failed)
+				other = testDiffRows(rows, nil)
+			}
+
+			_, err := mergeDiff(local, other)
+
+			if !errors.Is(err, failed) {
+				t.Errorf("expected an error after %d rows (local failing: %t), got %v", n, local_fails, err)
+			}
+		}
+	}
+}
+
+func TestDiffRefuses(t *testing.T) {
+
+	client, _ := newTestClient(t, nil)
+
+	area := NewDefaultPgisIntersectsOptions()
+	area.MinArea = 1000.0
+
+	tests := []struct {
+		name   string
+		other  func() *PgisClient
+		opts   *PgisIntersectsOptions
+		reason string
+	}{
+		{"nil", func() *PgisClient { return nil }, nil, "different client"},
+		{"itself", func() *PgisClient { return client }, nil, "different client"},
+		{"feature hash", func() *PgisClient { return &PgisClient{StoreFeatureHash: true} }, nil, "StoreFeatureHash"},
+		{"id+repo", func() *PgisClient { return &PgisClient{ConflictKey: PGIS_CONFLICT_ID_REPO} }, nil, "conflict key"},
+		{"area", func() *PgisClient { return &PgisClient{StoreArea: true} }, area, "area_meters"},
+	}
+
+	for _, test := range tests {
+
+		_, err := client.Diff(context.Background(), test.other(), test.opts)
+
+		if err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: expected an error about %s, got %v", test.name, test.reason, err)
+		}
+	}
+}
+
+// each side is queried with its own column names
+
+func TestDiffSQL(t *testing.T) {
+
+	opts := NewDefaultPgisIntersectsOptions()
+	opts.BBox = []float64{-74.0, 45.0, -73.0, 46.0}
+
+	local := &PgisClient{}
+	other := &PgisClient{GeomColumn: "geom_v2", StoreFeatureHash: true}
+	points := &PgisClient{PointsTable: "whosonfirst_points"}
+
+	tests := []struct {
+		client   *PgisClient
+		expected string
+	}{
+		{local, "SELECT id, COALESCE(geom_hash, '') FROM whosonfirst WHERE ST_Intersects(COALESCE(geom, centroid), ST_MakeEnvelope($1, $2, $3, $4, 4326)::geography) ORDER BY id ASC"},
+		{other, `SELECT id, COALESCE(feature_hash, '') FROM whosonfirst WHERE ST_Intersects(COALESCE("geom_v2", centroid), ST_MakeEnvelope($1, $2, $3, $4, 4326)::geography) ORDER BY id ASC`},
+		{points, `SELECT id, COALESCE(geom_hash, '') FROM (SELECT * FROM whosonfirst UNION ALL SELECT * FROM "whosonfirst_points") AS whosonfirst WHERE ST_Intersects(COALESCE(geom, centroid), ST_MakeEnvelope($1, $2, $3, $4, 4326)::geography) ORDER BY id ASC`},
+	}
+
+	for _, test := range tests {
+
+		query, args, err := test.client.diffSQL(opts)
+
+		if err != nil {
+			t.Fatalf("diffSQL failed because %s", err)
+		}
+
+		if query != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, query)
+		}
+
+		if len(args) != 4 {
+			t.Errorf("expected 4 args, got %v", args)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package pgis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var re_dsn_password = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s]+)`)
+
+// https://www.postgresql.org/docs/9.6/static/libpq-connect.html#LIBPQ-CONNSTRING
+
+// note that if host starts with a "/" it is treated as the directory containing
+// the PostgreSQL Unix domain socket (for example /var/run/postgresql) rather than
+// a hostname, which is the same thing libpq does
+
+func NewPgisDSN(host string, port int, user string, password string, dbname string) string {
+
+	params := []string{
+		fmt.Sprintf("host=%s", dsnValue(host)),
+		fmt.Sprintf("port=%d", port),
+		fmt.Sprintf("user=%s", dsnValue(user)),
+	}
+
+	if password != "" {
+		params = append(params, fmt.Sprintf("password=%s", dsnValue(password)))
+	}
+
+	params = append(params, fmt.Sprintf("dbname=%s", dsnValue(dbname)))
+	params = append(params, "sslmode=disable")
+
+	return strings.Join(params, " ")
+}
+
+// https://www.postgresql.org/docs/9.6/static/libpq-pgservice.html
+
+// lib/pq doesn't know anything about service files so we read the connection
+// parameters for service ourselves and expand them in to a plain DSN
+
+func NewPgisServiceDSN(service string) (string, error) {
+
+	for _, path := range serviceFiles() {
+
+		params, err := readServiceFile(path, service)
+
+		if err != nil {
+			return "", err
+		}
+
+		if params == nil {
+			continue
+		}
+
+		_, ok := params["sslmode"]
+
+		if !ok {
+			params["sslmode"] = "disable"
+		}
+
+		keys := make([]string, 0)
+
+		for k, _ := range params {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		dsn := make([]string, 0)
+
+		for _, k := range keys {
+			dsn = append(dsn, fmt.Sprintf("%s=%s", k, dsnValue(params[k])))
+		}
+
+		return strings.Join(dsn, " "), nil
+	}
+
+	msg := fmt.Sprintf("definition of service \"%s\" not found", service)
+	return "", errors.New(msg)
+}
+
+func serviceFiles() []string {
+
+	files := make([]string, 0)
+
+	path := os.Getenv("PGSERVICEFILE")
+
+	if path != "" {
+		files = append(files, path)
+	} else {
+
+		home := os.Getenv("HOME")
+
+		if home != "" {
+			files = append(files, filepath.Join(home, ".pg_service.conf"))
+		}
+	}
+
+	sysconf := os.Getenv("PGSYSCONFDIR")
+
+	if sysconf == "" {
+		sysconf = "/etc/postgresql-common"
+	}
+
+	files = append(files, filepath.Join(sysconf, "pg_service.conf"))
+
+	return files
+}
+
+// returns nil (not an error) if either path or service do not exist
+
+func readServiceFile(path string, service string) (map[string]string, error) {
+
+	fh, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	var params map[string]string
+
+	scanner := bufio.NewScanner(fh)
+
+	for scanner.Scan() {
+
+		ln := strings.TrimSpace(scanner.Text())
+
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(ln, "[") && strings.HasSuffix(ln, "]") {
+
+			if params != nil {
+				break
+			}
+
+			if ln[1:len(ln)-1] == service {
+				params = make(map[string]string)
+			}
+
+			continue
+		}
+
+		if params == nil {
+			continue
+		}
+
+		kv := strings.SplitN(ln, "=", 2)
+
+		if len(kv) != 2 {
+			msg := fmt.Sprintf("invalid line in %s: %s", path, ln)
+			return nil, errors.New(msg)
+		}
+
+		k := strings.TrimSpace(kv[0])
+		v := strings.TrimSpace(kv[1])
+
+		params[k] = v
+	}
+
+	err = scanner.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// values containing spaces (or nothing at all) need to be single-quoted and
+// any quotes or backslashes inside them escaped
+
+func dsnValue(v string) string {
+
+	if v != "" && !strings.ContainsAny(v, " \t'\\") {
+		return v
+	}
+
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `'`, `\'`, -1)
+
+	return fmt.Sprintf("'%s'", v)
+}
+
+// replace the password in dsn, which may be either a list of key=value pairs
+// or a postgres:// URL, so that it can be safely logged
+
+func redactDSN(dsn string) string {
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+
+		u, err := url.Parse(dsn)
+
+		if err != nil {
+			return "postgres://(unparseable)"
+		}
+
+		if u.User != nil {
+
+			_, ok := u.User.Password()
+
+			if ok {
+				u.User = url.UserPassword(u.User.Username(), "xxxxxx")
+			}
+		}
+
+		q := u.Query()
+
+		if q.Get("password") != "" {
+			q.Set("password", "xxxxxx")
+			u.RawQuery = q.Encode()
+		}
+
+		return u.String()
+	}
+
+	return re_dsn_password.ReplaceAllString(dsn, "${1}xxxxxx")
+}
+
+// errors from the database driver will sometimes quote part (or all) of the
+// DSN back at you so anything that looks like the password is removed
+
+func redactError(dsn string, err error) error {
+
+	str_err := err.Error()
+
+	for _, m := range re_dsn_password.FindAllStringSubmatch(dsn, -1) {
+
+		password := strings.Trim(m[2], "'")
+
+		if password != "" {
+			str_err = strings.Replace(str_err, password, "xxxxxx", -1)
+		}
+	}
+
+	if strings.Contains(dsn, "://") {
+
+		u, parse_err := url.Parse(dsn)
+
+		if parse_err == nil && u.User != nil {
+
+			password, ok := u.User.Password()
+
+			if ok && password != "" {
+				str_err = strings.Replace(str_err, password, "xxxxxx", -1)
+			}
+		}
+	}
+
+	if str_err == err.Error() {
+		return err
+	}
+
+	return errors.New(str_err)
+}
@@ -0,0 +1,173 @@
+package pgis
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var re_placeholder = regexp.MustCompile(`\$(\d+)`)
+
+// write one or more statements to client.SQLWriter, one per line, with all of
+// their placeholders replaced by the literal values in args; statements are
+// written together (and in order) so that concurrent calls don't interleave
+
+func (client *PgisClient) writeSQL(stmts []string, args [][]interface{}) error {
+
+	lines := make([]string, len(stmts))
+
+	for i, stmt := range stmts {
+
+		expanded, err := expandSQL(stmt, args[i])
+
+		if err != nil {
+			return err
+		}
+
+		lines[i] = expanded + ";\n"
+	}
+
+	client.sql_mu.Lock()
+	defer client.sql_mu.Unlock()
+
+	for _, ln := range lines {
+
+		_, err := client.SQLWriter.Write([]byte(ln))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// note that this does not know anything about quoting so a "$1" inside a
+// string literal in stmt will be replaced too - none of the statements we
+// generate do that (GeoJSON doesn't have dollar signs)
+
+func expandSQL(stmt string, args []interface{}) (string, error) {
+
+	var err error
+
+	expanded := re_placeholder.ReplaceAllStringFunc(stmt, func(m string) string {
+
+		i, _ := strconv.Atoi(m[1:])
+
+		if i < 1 || i > len(args) {
+			msg := fmt.Sprintf("statement has no value for %s", m)
+			err = errors.New(msg)
+			return m
+		}
+
+		literal, literal_err := sqlLiteral(args[i-1])
+
+		if literal_err != nil {
+			err = literal_err
+			return m
+		}
+
+		return literal
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return expanded, nil
+}
+
+// this assumes standard_conforming_strings is on, which has been the default
+// since PostgreSQL 9.1, so only single quotes need to be escaped (see
+// quoteLiteral for the exception); anything that isn't one of the types
+// database/sql knows how to send (or a Valuer, like pq.Array, that returns
+// one) is an error rather than a guess
+
+func sqlLiteral(v interface{}) (string, error) {
+
+	valuer, ok := v.(driver.Valuer)
+
+	if ok {
+
+		value, err := valuer.Value()
+
+		if err != nil {
+			return "", err
+		}
+
+		return sqlLiteral(value)
+	}
+
+	switch t := v.(type) {
+	case nil:
+		return "NULL", nil
+	case int:
+		return strconv.Itoa(t), nil
+	case int8:
+		return strconv.FormatInt(int64(t), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(t), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(t), 10), nil
+	case int64:
+		return strconv.FormatInt(t, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(t), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(t), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(t), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(t), 10), nil
+	case uint64:
+		return strconv.FormatUint(t, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case string:
+		return quoteLiteral(t), nil
+	case []byte:
+
+		// https://www.postgresql.org/docs/9.6/static/datatype-binary.html
+
+		// lib/pq sends []byte as a bytea so this is the hex format for
+		// one of those
+
+		return fmt.Sprintf("'\\x%s'::bytea", hex.EncodeToString(t)), nil
+	case time.Time:
+		return quoteLiteral(t.Format(time.RFC3339Nano)), nil
+	default:
+		msg := fmt.Sprintf("can not write a value of type %T as SQL", v)
+		return "", errors.New(msg)
+	}
+}
+
+// https://www.postgresql.org/docs/9.6/static/sql-syntax-lexical.html#SQL-SYNTAX-STRINGS-ESCAPE
+
+// strings with line breaks in them (like the pretty-printed WOF files that
+// StoreRaw writes) are written as escape strings, with the line breaks (and
+// therefore any backslashes) escaped, so that every statement stays on a line
+// of its own
+
+func quoteLiteral(str string) string {
+
+	quoted := strings.Replace(str, "'", "''", -1)
+
+	if !strings.ContainsAny(str, "\r\n") {
+		return fmt.Sprintf("'%s'", quoted)
+	}
+
+	quoted = strings.Replace(quoted, `\`, `\\`, -1)
+	quoted = strings.Replace(quoted, "\r", `\r`, -1)
+	quoted = strings.Replace(quoted, "\n", `\n`, -1)
+
+	return fmt.Sprintf("E'%s'", quoted)
+}
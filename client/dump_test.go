@@ -0,0 +1,299 @@
+package pgis
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"github.com/lib/pq"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSQLLiteral(t *testing.T) {
+
+	tests := []struct {
+		value   interface{}
+		literal string
+	}{
+		{nil, "NULL"},
+		{1, "1"},
+		{int32(-2), "-2"},
+		{int64(102087579), "102087579"},
+		{uint16(3), "3"},
+		{float32(0.5), "0.5"},
+		{1.25, "1.25"},
+		{true, "true"},
+		{"SF", "'SF'"},
+		{"O'Hare", "'O''Hare'"},
+		{`C:\Hare`, `'C:\Hare'`},
+		{"{\n  \"wof:name\": \"O'Hare\"\n}", `E'{\n  "wof:name": "O''Hare"\n}'`},
+		{"C:\\Hare\r\n", `E'C:\\Hare\r\n'`},
+		{`{"wof:name":"Montréal"}`, `'{"wof:name":"Montréal"}'`},
+		{[]byte{0xde, 0xad, 0xbe, 0xef}, `'\xdeadbeef'::bytea`},
+		{time.Date(2017, 8, 24, 12, 30, 0, 0, time.UTC), "'2017-08-24T12:30:00Z'"},
+		{pq.Array([]int64{1, 2, 3}), "'{1,2,3}'"},
+		{pq.Array([]string{"a", "b'c"}), `'{"a","b''c"}'`},
+	}
+
+	for _, test := range tests {
+
+		literal, err := sqlLiteral(test.value)
+
+		if err != nil {
+			t.Errorf("sqlLiteral(%#v) failed because %s", test.value, err)
+			continue
+		}
+
+		if literal != test.literal {
+			t.Errorf("sqlLiteral(%#v) returned %s, expected %s", test.value, literal, test.literal)
+		}
+	}
+
+	_, err := sqlLiteral(struct{}{})
+
+	if err == nil {
+		t.Errorf("expected sqlLiteral to fail for an unknown type")
+	}
+
+	_, err = sqlLiteral([]int64{1, 2})
+
+	if err == nil {
+		t.Errorf("expected sqlLiteral to fail for a slice that isn't wrapped in pq.Array")
+	}
+}
+
+func TestExpandSQL(t *testing.T) {
+
+	tests := []struct {
+		stmt     string
+		args     []interface{}
+		expanded string
+	}{
+		{"SELECT 1", nil, "SELECT 1"},
+		{"DELETE FROM whosonfirst WHERE id=$1", []interface{}{int64(85922583)}, "DELETE FROM whosonfirst WHERE id=85922583"},
+		{"UPDATE whosonfirst SET meta=$2 WHERE id=$1", []interface{}{int64(1), "it's"}, "UPDATE whosonfirst SET meta='it''s' WHERE id=1"},
+		{"SELECT $1, $10, $1", []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, "SELECT 1, 10, 1"},
+	}
+
+	for _, test := range tests {
+
+		expanded, err := expandSQL(test.stmt, test.args)
+
+		if err != nil {
+			t.Errorf("expandSQL(%q) failed because %s", test.stmt, err)
+			continue
+		}
+
+		if expanded != test.expanded {
+			t.Errorf("expandSQL(%q) returned %q, expected %q", test.stmt, expanded, test.expanded)
+		}
+	}
+
+	bad := [][]interface{}{
+		{},
+		{struct{}{}},
+	}
+
+	for _, args := range bad {
+
+		_, err := expandSQL("SELECT $1", args)
+
+		if err == nil {
+			t.Errorf("expected expandSQL to fail with %v", args)
+		}
+	}
+
+	_, err := expandSQL("SELECT $0", []interface{}{1})
+
+	if err == nil {
+		t.Errorf("expected expandSQL to fail for $0")
+	}
+}
+
+func TestWriteSQL(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	client := &PgisClient{
+		SQLWriter: &buf,
+	}
+
+	err := client.writeSQL([]string{"BEGIN", "DELETE FROM whosonfirst WHERE id=$1", "COMMIT"}, [][]interface{}{nil, {int64(1)}, nil})
+
+	if err != nil {
+		t.Fatalf("writeSQL failed because %s", err)
+	}
+
+	expected := "BEGIN;\nDELETE FROM whosonfirst WHERE id=1;\nCOMMIT;\n"
+
+	if buf.String() != expected {
+		t.Errorf("writeSQL wrote %q, expected %q", buf.String(), expected)
+	}
+
+	// nothing is written if any of the statements can't be expanded
+
+	buf.Reset()
+
+	err = client.writeSQL([]string{"SELECT 1", "SELECT $1"}, [][]interface{}{nil, nil})
+
+	if err == nil {
+		t.Errorf("expected writeSQL to fail")
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written, got %q", buf.String())
+	}
+}
+
+// what is written out is what would have been executed: IndexFeature's output,
+// including a pretty-printed raw feature, has one statement per line and each
+// of those lines, run through the test driver, is a statement IndexFeature runs
+// against a database with its arguments filled in
+
+func TestWriteSQLRoundTrip(t *testing.T) {
+
+	body := `{
+  "type": "Feature",
+  "id": 101736545,
+  "properties": {
+    "wof:id": 101736545,
+    "wof:name": "Montr\u00e9al's \"downtown\"",
+    "wof:placetype": "locality",
+    "wof:parent_id": -1,
+    "wof:repo": "whosonfirst-data",
+    "geom:latitude": 45.5,
+    "geom:longitude": -73.5,
+    "geom:bbox": "-74,45,-73,46"
+  },
+  "geometry": {
+    "type": "Polygon",
+    "coordinates": [[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]
+  }
+}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	var mu sync.Mutex
+	replaying := false
+	executed := make([]string, 0)
+	replayed := make([]string, 0)
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if query == "SELECT postgis_lib_version()" {
+			return &testResult{columns: []string{"postgis_lib_version"}, rows: [][]driver.Value{{"3.4.2"}}}, nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if replaying {
+			replayed = append(replayed, query)
+			return &testResult{affected: 1}, nil
+		}
+
+		values := make([]interface{}, len(args))
+
+		for i, v := range args {
+			values[i] = v
+		}
+
+		expanded, err := expandSQL(query, values)
+
+		if err != nil {
+			t.Errorf("failed to expand %s because %s", query, err)
+		}
+
+		executed = append(executed, expanded)
+		return &testResult{affected: 1}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	var buf bytes.Buffer
+
+	writer := &PgisClient{
+		Logger:    log.SimpleWOFLogger("test"),
+		SQLWriter: &buf,
+	}
+
+	for _, c := range []*PgisClient{client, writer} {
+		c.StoreRaw = true
+		c.StoreBBox = true
+		c.SimplifiedTolerance = 0.001
+		c.SubdivideMaxVertices = 256
+	}
+
+	err = client.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature failed because %s", err)
+	}
+
+	err = writer.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature (writing SQL) failed because %s", err)
+	}
+
+	// the statements as they were executed, in order and including the
+	// transaction around them
+
+	expected := make([]string, 0)
+
+	for _, stmt := range db.statements() {
+
+		switch stmt {
+		case "SELECT postgis_lib_version()":
+			// pass
+		case "BEGIN", "COMMIT":
+			expected = append(expected, stmt)
+		default:
+			expected = append(expected, executed[0])
+			executed = executed[1:]
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d statements, one per line, got %d lines: %s", len(expected), len(lines), buf.String())
+	}
+
+	replaying = true
+
+	for _, ln := range lines {
+
+		if !strings.HasSuffix(ln, ";") {
+			t.Fatalf("expected every line to be a complete statement, got %s", ln)
+		}
+
+		_, err := client.db.Exec(strings.TrimSuffix(ln, ";"))
+
+		if err != nil {
+			t.Fatalf("failed to replay %s because %s", ln, err)
+		}
+	}
+
+	for i, stmt := range expected {
+
+		if replayed[i] != stmt {
+			t.Errorf("expected statement %d to be\n%s\ngot\n%s", i, stmt, replayed[i])
+		}
+	}
+
+	// and the raw feature is written as it was given, line breaks and all
+
+	raw := strings.Replace(strings.Replace(strings.Replace(string(f.Bytes()), "'", "''", -1), `\`, `\\`, -1), "\n", `\n`, -1)
+
+	if !strings.Contains(buf.String(), "E'"+raw+"'") {
+		t.Errorf("expected the raw feature to be written as an escape string, got %s", buf.String())
+	}
+}
@@ -0,0 +1,225 @@
+package pgis
+
+import (
+	"context"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://www.loc.gov/standards/datetime/
+// https://www.postgresql.org/docs/9.6/static/rangetypes.html
+
+// when PgisClient.StoreValidity is true a record's edtf:inception and
+// edtf:cessation properties are stored as a DATERANGE in the valid_range
+// column. Only the parts of EDTF that Who's On First actually uses are
+// understood: dates with year, month or day precision (with or without the
+// ~, ? and % qualifiers), unspecified X digits and intervals. Anything else,
+// including "uuuu" (unknown) and ".." or "open", is treated as unbounded so
+// a record with no dates at all is valid at any time. So is a record whose
+// cessation is before its inception.
+
+func validRange(feature geojson.Feature) string {
+
+	inception := utils.StringProperty(feature.Bytes(), []string{"properties.edtf:inception"}, "uuuu")
+	cessation := utils.StringProperty(feature.Bytes(), []string{"properties.edtf:cessation"}, "uuuu")
+
+	lower := edtfDate(inception, false)
+	upper := edtfDate(cessation, true)
+
+	// PostgreSQL refuses a range whose lower bound is after its upper bound,
+	// which would fail the whole record, so dates that are the wrong way round
+	// are treated the same as no dates at all (the dates are YYYY-MM-DD so
+	// comparing them as strings is fine)
+
+	if lower != "" && upper != "" && lower > upper {
+		return "[,]"
+	}
+
+	// both bounds are inclusive and an empty bound is unbounded
+
+	return fmt.Sprintf("[%s,%s]", lower, upper)
+}
+
+// the first (or if end is true the last) day described by str as YYYY-MM-DD,
+// or "" if it can't be determined
+
+func edtfDate(str string, end bool) string {
+
+	str = strings.TrimSpace(str)
+
+	// for an interval the start of the first part or the end of the last
+
+	if strings.Contains(str, "/") {
+
+		parts := strings.Split(str, "/")
+
+		if end {
+			str = parts[len(parts)-1]
+		} else {
+			str = parts[0]
+		}
+	}
+
+	str = strings.TrimRight(str, "~?%")
+
+	parts := strings.Split(str, "-")
+
+	if len(parts) > 3 || len(parts[0]) != 4 {
+		return ""
+	}
+
+	year, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return ""
+	}
+
+	// anything unspecified (XX) means the precision stops there
+
+	precision := make([]int, 0)
+
+	for _, p := range parts[1:] {
+
+		p = strings.TrimRight(p, "~?%")
+
+		v, err := strconv.Atoi(p)
+
+		if err != nil {
+			break
+		}
+
+		precision = append(precision, v)
+	}
+
+	month := 1
+	day := 1
+
+	if len(precision) > 0 {
+		month = precision[0]
+	}
+
+	if len(precision) > 1 {
+		day = precision[1]
+	}
+
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return ""
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+
+	if end {
+
+		switch len(precision) {
+		case 0:
+			t = t.AddDate(1, 0, -1)
+		case 1:
+			t = t.AddDate(0, 1, -1)
+		}
+	}
+
+	return t.Format("2006-01-02")
+}
+
+// FeaturesValidAt returns the IDs of the records (matching opts) whose
+// edtf:inception and edtf:cessation dates include t, which requires that they
+// were indexed with StoreValidity set; records that weren't are never returned
+
+func (client *PgisClient) FeaturesValidAt(ctx context.Context, t time.Time, opts *PgisIntersectsOptions) ([]int64, error) {
+
+	t1 := time.Now()
+
+	ids, err := client.featuresValidAt(ctx, t, opts)
+
+	client.reportQueried(t1, err)
+	return ids, err
+}
+
+func (client *PgisClient) featuresValidAt(ctx context.Context, t time.Time, opts *PgisIntersectsOptions) ([]int64, error) {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	where := []string{
+		"valid_range @> $1::date",
+	}
+
+	args := []interface{}{
+		t.Format("2006-01-02"),
+	}
+
+	where, args = opts.filters(client.columns(), where, args)
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sql := fmt.Sprintf("SELECT id FROM %s WHERE %s", source, strings.Join(where, " AND "))
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, args)
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	if client.Explain {
+
+		err := client.explain(ctx, db, sql, args...)
+
+		if err != nil {
+			client.Logger.Warning("failed to explain query because %s", err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, sql, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+
+	for rows.Next() {
+
+		var id int64
+
+		err := rows.Scan(&id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,152 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEDTFDate(t *testing.T) {
+
+	tests := []struct {
+		str  string
+		end  bool
+		date string
+	}{
+		{"2017-08-24", false, "2017-08-24"},
+		{"2017-08-24", true, "2017-08-24"},
+		{"2017-08", false, "2017-08-01"},
+		{"2017-08", true, "2017-08-31"},
+		{"2016-02", true, "2016-02-29"},
+		{"2017", false, "2017-01-01"},
+		{"2017", true, "2017-12-31"},
+		{"2017-XX", true, "2017-12-31"},
+		{"2017-08-XX", false, "2017-08-01"},
+		{"2017~", false, "2017-01-01"},
+		{"2017-08?", true, "2017-08-31"},
+		{"2017-08-24%", false, "2017-08-24"},
+		{"2004/2017", false, "2004-01-01"},
+		{"2004/2017", true, "2017-12-31"},
+		{" 2017 ", false, "2017-01-01"},
+		{"uuuu", false, ""},
+		{"..", true, ""},
+		{"open", true, ""},
+		{"", false, ""},
+		{"17", false, ""},
+		{"2017-13", false, ""},
+		{"2017-08-24-01", false, ""},
+	}
+
+	for _, test := range tests {
+
+		date := edtfDate(test.str, test.end)
+
+		if date != test.date {
+			t.Errorf("edtfDate(%q, %t) returned %q, expected %q", test.str, test.end, date, test.date)
+		}
+	}
+}
+
+func TestValidRange(t *testing.T) {
+
+	tests := []struct {
+		inception string
+		cessation string
+		valid     string
+	}{
+		{"2001-02-03", "2010", "[2001-02-03,2010-12-31]"},
+		{"2001", "uuuu", "[2001-01-01,]"},
+		{"uuuu", "2010-05", "[,2010-05-31]"},
+		{"uuuu", "uuuu", "[,]"},
+		{"2001", "2001", "[2001-01-01,2001-12-31]"},
+		{"2010", "2001", "[,]"},
+		{"2010-06-02", "2010-06-01", "[,]"},
+	}
+
+	for _, test := range tests {
+
+		body := fmt.Sprintf(`{"type":"Feature","properties":{"edtf:inception":%q,"edtf:cessation":%q},"geometry":{"type":"Point","coordinates":[0,0]}}`, test.inception, test.cessation)
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		valid := validRange(f)
+
+		if valid != test.valid {
+			t.Errorf("validRange for %s/%s returned %q, expected %q", test.inception, test.cessation, valid, test.valid)
+		}
+	}
+
+	// no properties at all
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	valid := validRange(f)
+
+	if valid != "[,]" {
+		t.Errorf("validRange for a feature without dates returned %q, expected [,]", valid)
+	}
+}
+
+func TestFeaturesValidAt(t *testing.T) {
+
+	var query_args []driver.Value
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+		query_args = args
+		return &testResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(101736545)}}}, nil
+	}
+
+	// the date is the day t falls on, whatever the time of day
+
+	at := time.Date(1867, time.July, 1, 23, 59, 0, 0, time.UTC)
+
+	tests := []struct {
+		opts     *PgisIntersectsOptions
+		points   string
+		expected string
+		args     string
+	}{
+		{nil, "", "SELECT id FROM whosonfirst WHERE valid_range @> $1::date", "[1867-07-01]"},
+		{&PgisIntersectsOptions{PlacetypeId: 102312307}, "", "SELECT id FROM whosonfirst WHERE valid_range @> $1::date AND placetype_id=$2", "[1867-07-01 102312307]"},
+		{nil, "whosonfirst_points", `SELECT id FROM (SELECT * FROM whosonfirst UNION ALL SELECT * FROM "whosonfirst_points") AS whosonfirst WHERE valid_range @> $1::date`, "[1867-07-01]"},
+	}
+
+	for i, test := range tests {
+
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+			client.PointsTable = test.points
+
+			ids, err := client.FeaturesValidAt(context.Background(), at, test.opts)
+
+			if err != nil {
+				t.Fatalf("FeaturesValidAt failed because %s", err)
+			}
+
+			if len(ids) != 1 || ids[0] != 101736545 {
+				t.Errorf("unexpected ids %v", ids)
+			}
+
+			if stmts := db.statements(); len(stmts) != 1 || stmts[0] != test.expected {
+				t.Errorf("expected %s, got %v", test.expected, stmts)
+			}
+
+			if fmt.Sprint(query_args) != test.args {
+				t.Errorf("expected args %s, got %v", test.args, query_args)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package pgis
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	wof "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
+	"io"
+	"strings"
+	"sync"
+)
+
+// these describe the kinds of failure that callers are likely to want to
+// handle differently from everything else; the errors the client returns for
+// them are PgisErrors, which wrap the underlying cause, so check for them with
+// errors.Is rather than == (see also ErrNotFound)
+
+var ErrInvalidGeometry = errors.New("invalid geometry")
+var ErrUnknownPlacetype = errors.New("unknown placetype")
+var ErrMissingRepo = errors.New("missing wof:repo")
+var ErrConflict = errors.New("conflicting record")
+var ErrUnsupportedPostGIS = errors.New("unsupported PostGIS version")
+var ErrMetaTooLarge = errors.New("meta too large")
+
+// PgisError is one of the errors above (Kind) along with whatever actually
+// went wrong (Err), which may be a *pq.Error (or the equivalent for whichever
+// driver the client is using)
+
+type PgisError struct {
+	Kind error
+	Err  error
+}
+
+func (e *PgisError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PgisError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PgisError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func newPgisError(kind error, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	return &PgisError{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// https://www.postgresql.org/docs/9.6/static/errcodes-appendix.html
+
+// the errors from pgx (*pgconn.PgError) and other drivers that report the
+// SQLSTATE code for a PostgreSQL error do it with this method; lib/pq's
+// *pq.Error has a Code field instead
+
+type pgisSQLStateError interface {
+	SQLState() string
+}
+
+// the SQLSTATE code for err, or "" if it isn't an error from PostgreSQL
+
+func sqlState(err error) string {
+
+	var pq_err *pq.Error
+
+	if errors.As(err, &pq_err) {
+		return string(pq_err.Code)
+	}
+
+	var state_err pgisSQLStateError
+
+	if errors.As(err, &state_err) {
+		return state_err.SQLState()
+	}
+
+	return ""
+}
+
+// err as a PgisError if it's sql.ErrNoRows or a PostgreSQL error we know how
+// to classify and err unchanged if it isn't. PostGIS reports geometries it
+// can't parse (or make sense of) as internal errors, or invalid parameters,
+// so those are only classified as such if they mention a geometry.
+
+func classifyError(err error) error {
+
+	if err == sql.ErrNoRows {
+		return newPgisError(ErrNotFound, err)
+	}
+
+	switch sqlState(err) {
+	case "23505", "23P01": // unique_violation, exclusion_violation
+		return newPgisError(ErrConflict, err)
+	case "XX000", "22023": // internal_error, invalid_parameter_value
+
+		str_err := strings.ToLower(err.Error())
+
+		if strings.Contains(str_err, "geometry") || strings.Contains(str_err, "geojson") {
+			return newPgisError(ErrInvalidGeometry, err)
+		}
+
+		return err
+	default:
+		return err
+	}
+}
+
+type PgisIndexFailure struct {
+	Id   int64
+	Path string
+	Err  error
+}
+
+// PgisErrorBudget keeps track of features that failed to index so that a
+// batch can carry on past individual bad records and only give up once too
+// many of them have failed. It is safe for concurrent use.
+
+type PgisErrorBudget struct {
+	ContinueOnError bool      // if false the first error is fatal
+	MaxErrors       int       // the number of errors to tolerate; 0 means no limit
+	Rejects         io.Writer // if not nil each failure is written here as a line of JSON
+	mu              sync.Mutex
+	failures        []*PgisIndexFailure
+}
+
+// PgisReject is what gets written to PgisErrorBudget.Rejects for each failure,
+// including the feature itself if it got as far as being parsed, so that they
+// can be inspected (or re-indexed) once the batch is done
+
+type PgisReject struct {
+	Id      int64           `json:"id"`
+	Path    string          `json:"path"`
+	Error   string          `json:"error"`
+	Feature json.RawMessage `json:"feature,omitempty"`
+}
+
+func NewPgisErrorBudget(continue_on_error bool, max_errors int) *PgisErrorBudget {
+
+	b := PgisErrorBudget{
+		ContinueOnError: continue_on_error,
+		MaxErrors:       max_errors,
+		failures:        make([]*PgisIndexFailure, 0),
+	}
+
+	return &b
+}
+
+// Record adds a failure to the budget and returns a non-nil error if the
+// batch should be aborted
+
+func (b *PgisErrorBudget) Record(id int64, path string, err error) error {
+	return b.record(id, path, nil, err)
+}
+
+// RecordFeature is the same as Record for a feature that has been parsed, which
+// is included in Rejects
+
+func (b *PgisErrorBudget) RecordFeature(f geojson.Feature, path string, err error) error {
+	return b.record(wof.Id(f), path, f.Bytes(), err)
+}
+
+func (b *PgisErrorBudget) record(id int64, path string, body []byte, err error) error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// failures are written as they happen, rather than at the end, so that
+	// they aren't lost if the batch dies for some other reason
+
+	if b.Rejects != nil {
+
+		r := PgisReject{
+			Id:      id,
+			Path:    path,
+			Error:   err.Error(),
+			Feature: body,
+		}
+
+		enc, enc_err := json.Marshal(r)
+
+		if enc_err == nil {
+			_, enc_err = b.Rejects.Write(append(enc, '\n'))
+		}
+
+		if enc_err != nil {
+			msg := fmt.Sprintf("failed to write reject for %s because %s (original error was %s)", path, enc_err, err)
+			return errors.New(msg)
+		}
+	}
+
+	f := PgisIndexFailure{
+		Id:   id,
+		Path: path,
+		Err:  err,
+	}
+
+	b.failures = append(b.failures, &f)
+
+	if !b.ContinueOnError {
+		return err
+	}
+
+	if b.MaxErrors > 0 && len(b.failures) > b.MaxErrors {
+		msg := fmt.Sprintf("exceeded error budget of %d (last error was %s)", b.MaxErrors, err)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func (b *PgisErrorBudget) Failures() []*PgisIndexFailure {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failures := make([]*PgisIndexFailure, len(b.failures))
+	copy(failures, b.failures)
+
+	return failures
+}
+
+// Err returns a single error summarizing all the failures or nil if there
+// weren't any
+
+func (b *PgisErrorBudget) Err() error {
+
+	failures := b.Failures()
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(failures))
+
+	for i, f := range failures {
+		ids[i] = fmt.Sprintf("%d", f.Id)
+	}
+
+	msg := fmt.Sprintf("%d features failed to index: %s", len(failures), strings.Join(ids, ", "))
+	return errors.New(msg)
+}
@@ -0,0 +1,283 @@
+package pgis
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"strings"
+	"testing"
+)
+
+func TestPgisErrorBudget(t *testing.T) {
+
+	failure := errors.New("bad feature")
+
+	tests := []struct {
+		continue_on_error bool
+		max_errors        int
+		records           int
+		fatal             int // the record (counting from 1) that should abort the batch; 0 means none
+	}{
+		{false, 0, 3, 1},
+		{false, 10, 3, 1},
+		{true, 0, 100, 0},
+		{true, 2, 5, 3},
+		{true, 5, 5, 0},
+	}
+
+	for _, test := range tests {
+
+		b := NewPgisErrorBudget(test.continue_on_error, test.max_errors)
+
+		fatal := 0
+
+		for i := 1; i <= test.records; i++ {
+
+			err := b.Record(int64(i), "path.geojson", failure)
+
+			if err != nil {
+				fatal = i
+				break
+			}
+		}
+
+		if fatal != test.fatal {
+			t.Errorf("budget (continue %t, max %d) aborted at record %d, expected %d", test.continue_on_error, test.max_errors, fatal, test.fatal)
+		}
+	}
+}
+
+func TestPgisErrorBudgetErr(t *testing.T) {
+
+	b := NewPgisErrorBudget(true, 0)
+
+	if b.Err() != nil {
+		t.Errorf("expected an empty budget to have no error, got %s", b.Err())
+	}
+
+	b.Record(101736545, "a.geojson", errors.New("one"))
+	b.Record(85633041, "b.geojson", errors.New("two"))
+
+	expected := "2 features failed to index: 101736545, 85633041"
+
+	if b.Err() == nil || b.Err().Error() != expected {
+		t.Errorf("expected %q, got %v", expected, b.Err())
+	}
+
+	// Failures returns a copy
+
+	failures := b.Failures()
+	failures[0] = nil
+
+	if b.Failures()[0] == nil || b.Failures()[0].Path != "a.geojson" {
+		t.Errorf("expected Failures to return a copy")
+	}
+}
+
+// an io.Writer that always fails
+
+type failingWriter struct{}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestPgisErrorBudgetRejects(t *testing.T) {
+
+	body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	wr := &bytes.Buffer{}
+
+	b := NewPgisErrorBudget(true, 0)
+	b.Rejects = wr
+
+	b.Record(85633041, "unparseable.geojson", errors.New("invalid JSON"))
+	b.RecordFeature(f, "montreal.geojson", errors.New("unknown placetype"))
+
+	lines := strings.Split(strings.TrimSpace(wr.String()), "\n")
+
+	rejects := make([]PgisReject, 0)
+
+	scanner := bufio.NewScanner(wr)
+
+	for scanner.Scan() {
+
+		var r PgisReject
+
+		err := json.Unmarshal(scanner.Bytes(), &r)
+
+		if err != nil {
+			t.Fatalf("failed to parse reject %q because %s", scanner.Text(), err)
+		}
+
+		rejects = append(rejects, r)
+	}
+
+	if len(rejects) != 2 {
+		t.Fatalf("expected 2 rejects, got %d", len(rejects))
+	}
+
+	if rejects[0].Id != 85633041 || rejects[0].Path != "unparseable.geojson" || rejects[0].Error != "invalid JSON" || rejects[0].Feature != nil {
+		t.Errorf("unexpected reject %+v", rejects[0])
+	}
+
+	if len(lines) != 2 || strings.Contains(lines[0], `"feature"`) {
+		t.Errorf("expected a reject without a feature to leave it out")
+	}
+
+	if rejects[1].Id != 101736545 || rejects[1].Path != "montreal.geojson" || rejects[1].Error != "unknown placetype" {
+		t.Errorf("unexpected reject %+v", rejects[1])
+	}
+
+	reject_f, err := feature.LoadFeature(rejects[1].Feature)
+
+	if err != nil || reject_f.Id() != f.Id() {
+		t.Errorf("expected the reject to include the feature, got %s", string(rejects[1].Feature))
+	}
+
+	// failing to write a reject is always fatal, since otherwise the
+	// failure would be lost
+
+	b = NewPgisErrorBudget(true, 0)
+	b.Rejects = &failingWriter{}
+
+	err = b.Record(1, "a.geojson", errors.New("bad feature"))
+
+	if err == nil || !strings.Contains(err.Error(), "disk full") || !strings.Contains(err.Error(), "bad feature") {
+		t.Errorf("expected an error mentioning both failures, got %v", err)
+	}
+}
+
+// the way pgx (and other drivers that aren't lib/pq) report PostgreSQL errors
+
+type testStateError struct {
+	code string
+	msg  string
+}
+
+func (e *testStateError) Error() string {
+	return e.msg
+}
+
+func (e *testStateError) SQLState() string {
+	return e.code
+}
+
+func TestClassifyError(t *testing.T) {
+
+	other := errors.New("something else")
+
+	tests := []struct {
+		err  error
+		kind error // nil means err should come back unchanged
+	}{
+		{sql.ErrNoRows, ErrNotFound},
+		{&pq.Error{Code: "23505"}, ErrConflict},
+		{&pq.Error{Code: "23P01"}, ErrConflict},
+		{&pq.Error{Code: "42P01"}, nil},
+		{&pq.Error{Code: "XX000", Message: "parse error - invalid geometry"}, ErrInvalidGeometry},
+		{&pq.Error{Code: "XX000", Message: "could not access file"}, nil},
+		{&testStateError{"23505", "duplicate key value violates unique constraint"}, ErrConflict},
+		{&testStateError{"22023", "unknown GeoJSON type"}, ErrInvalidGeometry},
+		{&testStateError{"42P01", "relation does not exist"}, nil},
+		{other, nil},
+	}
+
+	for _, test := range tests {
+
+		err := classifyError(test.err)
+
+		if test.kind == nil {
+
+			if err != test.err {
+				t.Errorf("classifyError(%v) returned %v, expected it unchanged", test.err, err)
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, test.kind) {
+			t.Errorf("classifyError(%v) returned %v, expected it to be %v", test.err, err, test.kind)
+		}
+
+		// the original error is still there for anyone who wants it
+
+		if !errors.Is(err, test.err) {
+			t.Errorf("classifyError(%v) returned %v, expected it to wrap the original error", test.err, err)
+		}
+
+		if err.Error() != test.err.Error() {
+			t.Errorf("classifyError(%v) changed the message to %q", test.err, err.Error())
+		}
+	}
+
+	if classifyError(nil) != nil {
+		t.Errorf("expected classifyError(nil) to be nil")
+	}
+}
+
+func TestPgisErrorIs(t *testing.T) {
+
+	cause := errors.New("not a polygon")
+	err := newPgisError(ErrInvalidGeometry, cause)
+
+	if !errors.Is(err, ErrInvalidGeometry) {
+		t.Errorf("expected %v to be ErrInvalidGeometry", err)
+	}
+
+	if !errors.Is(err, cause) {
+		t.Errorf("expected %v to wrap its cause", err)
+	}
+
+	for _, kind := range []error{ErrNotFound, ErrUnknownPlacetype, ErrMissingRepo, ErrConflict, ErrMetaTooLarge} {
+
+		if errors.Is(err, kind) {
+			t.Errorf("expected %v not to be %v", err, kind)
+		}
+	}
+
+	var pgis_err *PgisError
+
+	if !errors.As(err, &pgis_err) || pgis_err.Kind != ErrInvalidGeometry {
+		t.Errorf("expected %v to be a PgisError", err)
+	}
+
+	if newPgisError(ErrInvalidGeometry, nil) != nil {
+		t.Errorf("expected newPgisError with a nil error to be nil")
+	}
+}
+
+// errors from a driver other than lib/pq are classified all the way back up
+// through IndexFeature
+
+func TestClassifyDriverError(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+		return nil, &testStateError{"23505", "duplicate key value violates unique constraint \"whosonfirst_pkey\""}
+	}
+
+	client, _ := newTestClient(t, handler)
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	err = client.IndexFeature(f, "test")
+
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("IndexFeature returned %v, expected ErrConflict", err)
+	}
+}
@@ -0,0 +1,54 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// https://www.postgresql.org/docs/9.6/static/sql-explain.html
+
+// when PgisClient.Explain is true the query methods (IntersectsFeature and
+// friends, PointInPolygon, Query and Count) log the query plan for the exact
+// statement they are about to run. Remember that EXPLAIN ANALYZE actually runs
+// the query so everything takes (at least) twice as long.
+
+func explainSQL(query string) string {
+	return fmt.Sprintf("EXPLAIN (ANALYZE, BUFFERS) %s", query)
+}
+
+func (client *PgisClient) explain(ctx context.Context, db *sql.DB, query string, args ...interface{}) error {
+
+	rows, err := db.QueryContext(ctx, explainSQL(query), args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	plan := make([]string, 0)
+
+	for rows.Next() {
+
+		var ln string
+
+		err := rows.Scan(&ln)
+
+		if err != nil {
+			return err
+		}
+
+		plan = append(plan, ln)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return err
+	}
+
+	client.Logger.Status("query plan for %s\n%s", query, strings.Join(plan, "\n"))
+	return nil
+}
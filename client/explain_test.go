@@ -0,0 +1,78 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExplainSQL(t *testing.T) {
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT 1", "EXPLAIN (ANALYZE, BUFFERS) SELECT 1"},
+		{"SELECT COUNT(id) FROM whosonfirst WHERE placetype_id=$1", "EXPLAIN (ANALYZE, BUFFERS) SELECT COUNT(id) FROM whosonfirst WHERE placetype_id=$1"},
+	}
+
+	for _, test := range tests {
+
+		str_sql := explainSQL(test.query)
+
+		if str_sql != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, str_sql)
+		}
+	}
+}
+
+// the plan is for the exact statement that is run next, with the same args
+
+func TestExplain(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.HasPrefix(query, "EXPLAIN") {
+			return &testResult{columns: []string{"QUERY PLAN"}, rows: [][]driver.Value{{"Seq Scan on whosonfirst"}}}, nil
+		}
+
+		return &testResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}, nil
+	}
+
+	for _, explain := range []bool{false, true} {
+
+		// each test client needs a name (see newTestClient) of its own
+
+		t.Run(fmt.Sprintf("explain=%t", explain), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+			client.Explain = explain
+
+			opts := NewDefaultPgisIntersectsOptions()
+			opts.PlacetypeId = 102312317
+
+			_, err := client.Count(context.Background(), opts)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stmts := db.statements()
+
+			if !explain {
+
+				if len(stmts) != 1 || strings.HasPrefix(stmts[0], "EXPLAIN") {
+					t.Errorf("expected only the query to be run without Explain, got %v", stmts)
+				}
+
+				return
+			}
+
+			if len(stmts) != 2 || stmts[0] != explainSQL(stmts[1]) {
+				t.Errorf("expected the query to be explained and then run, got %v", stmts)
+			}
+		})
+	}
+}
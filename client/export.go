@@ -0,0 +1,204 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// the header row written by ExportCSV
+
+var EXPORT_CSV_COLUMNS = []string{"id", "name", "placetype", "longitude", "latitude", "wkt"}
+
+// ExportCSV writes all the records matching opts to wr as CSV (with a header
+// row) one row at a time, for use by things like QGIS or pandas. The geometry
+// is the record's geom column as WKT or its centroid if it doesn't have one.
+
+func (client *PgisClient) ExportCSV(ctx context.Context, opts *PgisIntersectsOptions, wr io.Writer) error {
+
+	t1 := time.Now()
+
+	err := client.exportCSV(ctx, opts, wr)
+
+	client.reportQueried(t1, err)
+	return err
+}
+
+func (client *PgisClient) exportCSV(ctx context.Context, opts *PgisIntersectsOptions, wr io.Writer) error {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return err
+	}
+
+	cols := client.columns()
+
+	// Point geometries are only stored in the centroid column - see notes
+	// in IndexFeature
+
+	str_cols := fmt.Sprintf(`id, meta->>'wof:name', placetype_id, ST_X(%s::geometry), ST_Y(%s::geometry),
+	         ST_AsText(COALESCE(%s, %s))`, cols.centroid, cols.centroid, cols.geom, cols.centroid)
+
+	query, args, err := client.selectSQL(opts, str_cols)
+
+	if err != nil {
+		return err
+	}
+
+	it, err := client.queryIterator(ctx, query, args)
+
+	if err != nil {
+		return err
+	}
+
+	defer it.Close()
+
+	writer := csv.NewWriter(wr)
+
+	err = writer.Write(EXPORT_CSV_COLUMNS)
+
+	if err != nil {
+		return err
+	}
+
+	for it.Next() {
+
+		var id int64
+		var name sql.NullString
+		var placetype_id int64
+		var lon, lat sql.NullFloat64
+		var wkt sql.NullString
+
+		err := it.rows.Scan(&id, &name, &placetype_id, &lon, &lat, &wkt)
+
+		if err != nil {
+			return err
+		}
+
+		out := exportCSVRow(id, name, placetype_id, lon, lat, wkt)
+
+		err = writer.Write(out)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = it.Err()
+
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// a row for ExportCSV, in the same order as EXPORT_CSV_COLUMNS; an unknown
+// placetype, or a missing name, coordinate or geometry, is an empty field
+
+func exportCSVRow(id int64, name sql.NullString, placetype_id int64, lon sql.NullFloat64, lat sql.NullFloat64, wkt sql.NullString) []string {
+
+	placetype := ""
+
+	pt, err := placetypeById(placetype_id)
+
+	if err == nil {
+		placetype = pt.Name
+	}
+
+	str_lon := ""
+	str_lat := ""
+
+	if lon.Valid && lat.Valid {
+		str_lon = strconv.FormatFloat(lon.Float64, 'f', -1, 64)
+		str_lat = strconv.FormatFloat(lat.Float64, 'f', -1, 64)
+	}
+
+	return []string{
+		strconv.FormatInt(id, 10),
+		name.String,
+		placetype,
+		str_lon,
+		str_lat,
+		wkt.String,
+	}
+}
+
+// https://tools.ietf.org/html/rfc8142
+
+// ExportGeoJSONSeq writes all the records matching opts to wr as newline-
+// delimited GeoJSON: one (reconstructed, see PgisFeature) feature per line and
+// nothing else, so that consumers can start processing features before the
+// export is finished. Lines are not prefixed with the RFC 8142 record separator
+// since most things that read newline-delimited GeoJSON don't expect one.
+
+func (client *PgisClient) ExportGeoJSONSeq(ctx context.Context, opts *PgisIntersectsOptions, wr io.Writer) error {
+
+	t1 := time.Now()
+
+	err := client.exportGeoJSONSeq(ctx, opts, wr)
+
+	client.reportQueried(t1, err)
+	return err
+}
+
+func (client *PgisClient) exportGeoJSONSeq(ctx context.Context, opts *PgisIntersectsOptions, wr io.Writer) error {
+
+	it, err := client.query(ctx, opts)
+
+	if err != nil {
+		return err
+	}
+
+	defer it.Close()
+
+	for it.Next() {
+
+		row, err := it.Scan()
+
+		if err != nil {
+			return err
+		}
+
+		line, err := geoJSONSeqLine(row)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = wr.Write(line)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return it.Err()
+}
+
+// a line for ExportGeoJSONSeq: row as a single line of GeoJSON followed by a
+// newline, with no record separator
+
+func geoJSONSeqLine(row *PgisRow) ([]byte, error) {
+
+	f, err := row.ToFeature()
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(body, '\n'), nil
+}
@@ -0,0 +1,198 @@
+package pgis
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testNullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: true}
+}
+
+func testNullFloat(f float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: f, Valid: true}
+}
+
+func TestExportCSVRow(t *testing.T) {
+
+	tests := []struct {
+		id           int64
+		name         sql.NullString
+		placetype_id int64
+		lon          sql.NullFloat64
+		lat          sql.NullFloat64
+		wkt          sql.NullString
+		expected     string
+	}{
+		{101736545, testNullString("Montreal"), 102312317, testNullFloat(-73.5), testNullFloat(45.5), testNullString("POINT(-73.5 45.5)"), "101736545|Montreal|locality|-73.5|45.5|POINT(-73.5 45.5)"},
+		{85633041, testNullString("Canada"), 102312307, testNullFloat(-106.346771), testNullFloat(56.130366), sql.NullString{}, "85633041|Canada|country|-106.346771|56.130366|"},
+		// no name, an unknown placetype and only half a coordinate
+		{1, sql.NullString{}, -1, testNullFloat(-73.5), sql.NullFloat64{}, sql.NullString{}, "1|||||"},
+	}
+
+	for _, test := range tests {
+
+		row := exportCSVRow(test.id, test.name, test.placetype_id, test.lon, test.lat, test.wkt)
+
+		if len(row) != len(EXPORT_CSV_COLUMNS) {
+			t.Errorf("expected %d fields for %d, got %d", len(EXPORT_CSV_COLUMNS), test.id, len(row))
+			continue
+		}
+
+		if strings.Join(row, "|") != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, strings.Join(row, "|"))
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		rsp := testResult{
+			columns: strings.Split("id,name,placetype_id,lon,lat,wkt", ","),
+			rows: [][]driver.Value{
+				{int64(101736545), "Montreal, QC", int64(102312317), -73.5, 45.5, "POINT(-73.5 45.5)"},
+				{int64(85633041), nil, int64(102312307), nil, nil, nil},
+			},
+		}
+
+		return &rsp, nil
+	}
+
+	client, _ := newTestClient(t, handler)
+
+	var buf bytes.Buffer
+
+	err := client.ExportCSV(context.Background(), nil, &buf)
+
+	if err != nil {
+		t.Fatalf("failed to export CSV because %s", err)
+	}
+
+	expected := "id,name,placetype,longitude,latitude,wkt\n" +
+		"101736545,\"Montreal, QC\",locality,-73.5,45.5,POINT(-73.5 45.5)\n" +
+		"85633041,,country,,,\n"
+
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestGeoJSONSeqLine(t *testing.T) {
+
+	tests := []struct {
+		row  *PgisRow
+		geom string
+	}{
+		{&PgisRow{Id: 101736545, PlacetypeId: 102312317, Meta: `{"wof:name":"Montreal"}`, Centroid: `{"type":"Point","coordinates":[-73.5,45.5]}`}, `{"type":"Point","coordinates":[-73.5,45.5]}`},
+		// a geometry that was stored pretty-printed still ends up on one line
+		{&PgisRow{Id: 85633041, PlacetypeId: 102312307, Meta: `{"wof:name":"Canada"}`, Geom: "{\n  \"type\": \"Point\",\n  \"coordinates\": [-106.3, 56.1]\n}"}, `{"type":"Point","coordinates":[-106.3,56.1]}`},
+		{&PgisRow{Id: 1, PlacetypeId: 102312317, Meta: `{"wof:name":"nowhere"}`}, `null`},
+	}
+
+	for _, test := range tests {
+
+		line, err := geoJSONSeqLine(test.row)
+
+		if err != nil {
+			t.Errorf("failed to encode %d because %s", test.row.Id, err)
+			continue
+		}
+
+		str_line := string(line)
+
+		if !strings.HasSuffix(str_line, "\n") || strings.Count(str_line, "\n") != 1 {
+			t.Errorf("expected %d to be a single newline-terminated line, got %q", test.row.Id, str_line)
+		}
+
+		if strings.HasPrefix(str_line, "\x1e") {
+			t.Errorf("expected %d not to start with a record separator", test.row.Id)
+		}
+
+		var f PgisFeature
+
+		err = json.Unmarshal(line, &f)
+
+		if err != nil {
+			t.Errorf("failed to parse %q because %s", str_line, err)
+			continue
+		}
+
+		if f.Type != "Feature" || f.Id != test.row.Id {
+			t.Errorf("expected feature %d, got %s %d", test.row.Id, f.Type, f.Id)
+		}
+
+		if string(f.Geometry) != test.geom {
+			t.Errorf("expected geometry %s for %d, got %s", test.geom, test.row.Id, f.Geometry)
+		}
+	}
+
+	_, err := geoJSONSeqLine(&PgisRow{Id: 1, Meta: "not JSON"})
+
+	if err == nil {
+		t.Errorf("expected a record with invalid meta to fail")
+	}
+}
+
+func TestExportGeoJSONSeq(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		rsp := testResult{
+			columns: TEST_ROW_COLUMNS,
+			rows: [][]driver.Value{
+				testRow(101736545, 102312317, `{"wof:name":"Montreal"}`, `{"type":"Point","coordinates":[-73.5,45.5]}`),
+				testRow(85633041, 102312307, `{"wof:name":"Canada"}`, `{"type":"Point","coordinates":[-106.3,56.1]}`),
+			},
+		}
+
+		return &rsp, nil
+	}
+
+	client, _ := newTestClient(t, handler)
+
+	metrics := newTestMetrics()
+	client.Metrics = metrics
+
+	var buf bytes.Buffer
+
+	err := client.ExportGeoJSONSeq(context.Background(), nil, &buf)
+
+	if err != nil {
+		t.Fatalf("failed to export GeoJSONSeq because %s", err)
+	}
+
+	// the export is one query, not an export and a query
+
+	if metrics.calls["queried"] != 1 {
+		t.Errorf("expected the export to be reported once, got %d", metrics.calls["queried"])
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+
+	for i, id := range []int64{101736545, 85633041} {
+
+		var f PgisFeature
+
+		err := json.Unmarshal([]byte(lines[i]), &f)
+
+		if err != nil {
+			t.Errorf("failed to parse line %d because %s", i, err)
+			continue
+		}
+
+		if f.Id != id {
+			t.Errorf("expected line %d to be %d, got %d", i, id, f.Id)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package pgis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"sort"
+)
+
+// PgisExtraColumnsFunc returns any additional columns (and their values) to
+// store for a feature, for things like provenance or the name of the batch
+// it was loaded in, that the client doesn't know about itself. The columns
+// must already exist in the whosonfirst table.
+
+type PgisExtraColumnsFunc func(feature geojson.Feature) (map[string]interface{}, error)
+
+// the columns returned by the client's ExtraColumns function, sorted by name so
+// the statements are always the same, quoted and checked against the other
+// property columns (PostgreSQL will complain about any other duplicates)
+
+func (client *PgisClient) extraColumns(feature geojson.Feature, existing []string) ([]string, []interface{}, error) {
+
+	if client.ExtraColumns == nil {
+		return []string{}, []interface{}{}, nil
+	}
+
+	extras, err := client.ExtraColumns(feature)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(extras))
+
+	for name := range extras {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	is_existing := make(map[string]bool)
+
+	for _, col := range existing {
+		is_existing[col] = true
+	}
+
+	cols := make([]string, len(names))
+	args := make([]interface{}, len(names))
+
+	for i, name := range names {
+
+		err := validateIdent(name)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if is_existing[name] {
+			msg := fmt.Sprintf("extra column '%s' is already written by the client", name)
+			return nil, nil, errors.New(msg)
+		}
+
+		cols[i] = quoteIdent(name)
+		args[i] = extras[name]
+	}
+
+	return cols, args, nil
+}
@@ -0,0 +1,99 @@
+package pgis
+
+import (
+	"encoding/json"
+)
+
+// these are features reconstructed from the data stored in the database, which
+// is to say they are not the same as the original WOF records and only contain
+// a handful of properties
+
+type PgisFeature struct {
+	Type       string                 `json:"type"`
+	Id         int64                  `json:"id"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   json.RawMessage        `json:"geometry"`
+}
+
+type PgisFeatureCollection struct {
+	Type     string         `json:"type"`
+	Features []*PgisFeature `json:"features"`
+}
+
+func NewPgisFeatureCollection(rows []*PgisRow) (*PgisFeatureCollection, error) {
+
+	features := make([]*PgisFeature, 0)
+
+	for _, row := range rows {
+
+		f, err := row.ToFeature()
+
+		if err != nil {
+			return nil, err
+		}
+
+		features = append(features, f)
+	}
+
+	fc := PgisFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: features,
+	}
+
+	return &fc, nil
+}
+
+func (row *PgisRow) ToFeature() (*PgisFeature, error) {
+
+	var meta Meta
+
+	err := json.Unmarshal([]byte(row.Meta), &meta)
+
+	if err != nil {
+		return nil, err
+	}
+
+	props := map[string]interface{}{
+		"wof:id":           row.Id,
+		"wof:parent_id":    row.ParentId,
+		"wof:name":         meta.Name,
+		"wof:country":      meta.Country,
+		"wof:repo":         meta.Repo,
+		"wof:hierarchy":    meta.Hierarchy,
+		"mz:is_superseded": row.IsSuperseded,
+		"mz:is_deprecated": row.IsDeprecated,
+		"wof:placetype_id": row.PlacetypeId,
+	}
+
+	pt, err := placetypeById(row.PlacetypeId)
+
+	if err == nil {
+		props["wof:placetype"] = pt.Name
+	}
+
+	// Point geometries are only stored in the centroid column - see notes
+	// in IndexFeature
+
+	str_geom := row.Geom
+
+	if str_geom == "" {
+		str_geom = row.Centroid
+	}
+
+	var geom json.RawMessage
+
+	if str_geom != "" {
+		geom = json.RawMessage(str_geom)
+	} else {
+		geom = json.RawMessage("null")
+	}
+
+	f := PgisFeature{
+		Type:       "Feature",
+		Id:         row.Id,
+		Properties: props,
+		Geometry:   geom,
+	}
+
+	return &f, nil
+}
@@ -0,0 +1,94 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the base32 alphabet geohashes are made of - notably there is no a, i, l or o
+
+const GEOHASH_ALPHABET = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// FeaturesInGeohash returns the IDs of all the records whose centroid falls
+// inside the geohash cell prefix. This requires the geohash column, populated
+// by setting GeohashPrecision when indexing; prefixes longer than that aren't
+// going to match anything.
+
+func (client *PgisClient) FeaturesInGeohash(ctx context.Context, prefix string) ([]int64, error) {
+
+	t1 := time.Now()
+
+	ids, err := client.featuresInGeohash(ctx, prefix)
+
+	client.reportQueried(t1, err)
+	return ids, err
+}
+
+func (client *PgisClient) featuresInGeohash(ctx context.Context, prefix string) ([]int64, error) {
+
+	prefix = strings.ToLower(prefix)
+
+	if prefix == "" || strings.Trim(prefix, GEOHASH_ALPHABET) != "" {
+		msg := fmt.Sprintf("invalid geohash '%s'", prefix)
+		return nil, errors.New(msg)
+	}
+
+	// prefix is known to be plain alphanumeric so there's nothing to escape
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sql := fmt.Sprintf("SELECT id FROM %s WHERE geohash LIKE $1", source)
+	args := []interface{}{prefix + "%"}
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, args)
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	rows, err := db.QueryContext(ctx, sql, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+
+	for rows.Next() {
+
+		var id int64
+
+		err := rows.Scan(&id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
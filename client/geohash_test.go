@@ -0,0 +1,76 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestFeaturesInGeohash(t *testing.T) {
+
+	var query_args []driver.Value
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+		query_args = args
+		return &testResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(101736545)}}}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	tests := []struct {
+		prefix string
+		like   string // "" means the prefix is invalid
+	}{
+		{"f25d", "f25d%"},
+		{"F25D", "f25d%"},
+		{"f", "f%"},
+		{"", ""},
+		{"f25a", ""}, // no a, i, l or o
+		{"of25", ""},
+		{"f25%", ""},
+		{"f2_d", ""},
+		{"f25d'", ""},
+	}
+
+	for _, test := range tests {
+
+		query_args = nil
+		count := len(db.statements())
+
+		ids, err := client.FeaturesInGeohash(context.Background(), test.prefix)
+
+		if test.like == "" {
+
+			if err == nil {
+				t.Errorf("expected '%s' to be an invalid geohash", test.prefix)
+			}
+
+			if len(db.statements()) != count {
+				t.Errorf("expected nothing to be queried for '%s'", test.prefix)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("failed to find features in '%s' because %s", test.prefix, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(ids, []int64{101736545}) {
+			t.Errorf("unexpected ids %v for '%s'", ids, test.prefix)
+		}
+
+		if len(query_args) != 1 || query_args[0] != test.like {
+			t.Errorf("expected '%s' to be queried as '%s', got %v", test.prefix, test.like, query_args)
+		}
+
+		stmts := db.statements()
+		expected := "SELECT id FROM whosonfirst WHERE geohash LIKE $1"
+
+		if stmts[len(stmts)-1] != expected {
+			t.Errorf("expected %s, got %s", expected, stmts[len(stmts)-1])
+		}
+	}
+}
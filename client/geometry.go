@@ -0,0 +1,579 @@
+package pgis
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	GEOMETRY_FORMAT_GEOJSON = "geojson"
+	GEOMETRY_FORMAT_WKT     = "wkt"
+	GEOMETRY_FORMAT_WKB     = "wkb"
+)
+
+// PgisGeometryRecord is for indexing things that aren't WOF GeoJSON features, for
+// example the output of tools that emit WKT or WKB geometries. Geometries are
+// assumed to be EPSG:4326.
+
+type PgisGeometryRecord struct {
+	Id           int64
+	ParentId     int64
+	Placetype    string
+	IsSuperseded int
+	IsDeprecated int
+	Meta         Meta
+	Geometry     []byte
+	Format       string
+}
+
+// http://postgis.net/docs/ST_GeomFromText.html
+// http://postgis.net/docs/ST_GeomFromWKB.html
+
+func geomFromFormat(format string, placeholder string) (string, error) {
+
+	switch format {
+	case GEOMETRY_FORMAT_GEOJSON:
+		return fmt.Sprintf("ST_SetSRID(ST_GeomFromGeoJSON(%s), 4326)", placeholder), nil
+	case GEOMETRY_FORMAT_WKT:
+		return fmt.Sprintf("ST_GeomFromText(%s, 4326)", placeholder), nil
+	case GEOMETRY_FORMAT_WKB:
+		return fmt.Sprintf("ST_GeomFromWKB(%s, 4326)", placeholder), nil
+	default:
+		msg := fmt.Sprintf("invalid geometry format '%s'", format)
+		return "", errors.New(msg)
+	}
+}
+
+// returns "Point" if rec is a point, which is all routeTable needs to know, and
+// "" for anything else including geometries that can't be read here; PostGIS
+// will complain about those soon enough
+
+func (rec *PgisGeometryRecord) pointType() string {
+
+	is_point := false
+
+	switch rec.Format {
+	case GEOMETRY_FORMAT_GEOJSON:
+
+		var g struct {
+			Type string `json:"type"`
+		}
+
+		err := json.Unmarshal(rec.Geometry, &g)
+		is_point = err == nil && g.Type == "Point"
+
+	case GEOMETRY_FORMAT_WKT:
+
+		str_wkt := strings.TrimSpace(string(rec.Geometry))
+		end := strings.IndexFunc(str_wkt, func(r rune) bool { return !unicode.IsLetter(r) })
+
+		if end == -1 {
+			end = len(str_wkt)
+		}
+
+		is_point = strings.EqualFold(str_wkt[:end], "POINT")
+
+	case GEOMETRY_FORMAT_WKB:
+
+		// https://libgeos.org/specifications/wkb/ - a byte order flag and
+		// then the type, which may have the extended (EWKB) flags set in
+		// its high bits or be offset by 1000s (ISO) for Z and M
+
+		if len(rec.Geometry) >= 5 {
+
+			var order binary.ByteOrder = binary.BigEndian
+
+			if rec.Geometry[0] == 1 {
+				order = binary.LittleEndian
+			}
+
+			wkb_type := order.Uint32(rec.Geometry[1:5]) & 0x0fffffff
+			is_point = wkb_type%1000 == 1
+		}
+	}
+
+	if is_point {
+		return "Point"
+	}
+
+	return ""
+}
+
+// IndexGeometry indexes rec the same way IndexFeature indexes a feature, which
+// is to say that it takes the same locks and the client's options (RunId,
+// SQLWriter, the extra geometry and centroid columns and so on) apply, except
+// for the ones that need a GeoJSON feature: property-derived columns (like the
+// hierarchy or raw columns) aren't written and CoordinatePrecision is ignored.
+
+func (client *PgisClient) IndexGeometry(ctx context.Context, rec *PgisGeometryRecord) error {
+
+	t1 := time.Now()
+
+	unlock := client.lockId(rec.Id)
+
+	err := client.indexGeometry(ctx, rec)
+
+	unlock()
+
+	client.reportProgress(rec.Id, err)
+	client.reportIndexed(t1, err)
+	return err
+}
+
+func (client *PgisClient) indexGeometry(ctx context.Context, rec *PgisGeometryRecord) error {
+
+	if rec.Id == 0 {
+		client.Logger.Debug("skipping Earth because it confuses PostGIS")
+		return ErrSkippedEarth
+	}
+
+	if client.SkipDeprecated && rec.IsDeprecated == 1 {
+		client.Logger.Debug("skipping %d because it is deprecated", rec.Id)
+		return ErrSkippedDeprecated
+	}
+
+	if client.SkipSuperseded && rec.IsSuperseded == 1 {
+		client.Logger.Debug("skipping %d because it is superseded", rec.Id)
+		return ErrSkippedSuperseded
+	}
+
+	if len(rec.Geometry) == 0 {
+		msg := fmt.Sprintf("missing geometry for %d", rec.Id)
+		return newPgisError(ErrInvalidGeometry, errors.New(msg))
+	}
+
+	pt, err := client.resolvePlacetype(rec.Placetype)
+
+	if err != nil {
+		return err
+	}
+
+	meta_json, err := client.marshalMeta(rec.Meta)
+
+	if err != nil {
+		return err
+	}
+
+	str_meta := string(meta_json)
+
+	// this is not the same as utils.HashGeometry since that only works with
+	// GeoJSON but it serves the same purpose
+
+	hash := md5.Sum(rec.Geometry)
+	geom_hash := hex.EncodeToString(hash[:])
+
+	lastmod := time.Now().Format(time.RFC3339)
+
+	var geom_arg interface{}
+
+	switch rec.Format {
+	case GEOMETRY_FORMAT_WKB:
+		geom_arg = rec.Geometry
+	case GEOMETRY_FORMAT_GEOJSON:
+
+		// see notes in IndexFeature
+
+		str_geom, err := stripBBox(string(rec.Geometry))
+
+		if err != nil {
+			return newPgisError(ErrInvalidGeometry, err)
+		}
+
+		geom_arg = str_geom
+	default:
+		geom_arg = string(rec.Geometry)
+	}
+
+	cols := []string{"id", "parent_id", "placetype_id", "is_superseded", "is_deprecated", "meta", "geom_hash", "lastmod"}
+	args := []interface{}{rec.Id, rec.ParentId, pt.Id, rec.IsSuperseded, rec.IsDeprecated, str_meta, geom_hash, lastmod}
+
+	if client.RunId != "" {
+		cols = append(cols, "run_id")
+		args = append(args, client.RunId)
+	}
+
+	if client.conflictRepo() {
+		cols = append(cols, "repo")
+		args = append(args, rec.Meta.Repo)
+	}
+
+	vals := make([]string, len(cols))
+
+	for i := range cols {
+		vals[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	// the geometry is the last argument but it can be used by any number of
+	// the geometry columns
+
+	args = append(args, geom_arg)
+
+	st_geom, err := geomFromFormat(rec.Format, fmt.Sprintf("$%d", len(args)))
+
+	if err != nil {
+		return err
+	}
+
+	st_geom, err = client.orientRings(st_geom)
+
+	if err != nil {
+		return err
+	}
+
+	st_server_centroid, err := client.serverCentroid()
+
+	if err != nil {
+		return err
+	}
+
+	if st_server_centroid == "" {
+		st_server_centroid = "ST_PointOnSurface"
+	}
+
+	// Point geometries are only stored in the centroid column - see notes
+	// in IndexFeature - and since we don't know anything about the geometry
+	// until PostGIS has parsed it we let the database sort things out
+
+	st_geojson := fmt.Sprintf("CASE WHEN GeometryType(%s) = 'POINT' THEN NULL ELSE ST_Multi(%s) END", st_geom, st_geom)
+	st_simplified := fmt.Sprintf("CASE WHEN GeometryType(%s) = 'POINT' THEN NULL ELSE ST_Multi(ST_SimplifyPreserveTopology(%s, %g)) END", st_geom, st_geom, client.SimplifiedTolerance)
+	st_centroid := fmt.Sprintf("%s(%s)", st_server_centroid, st_geom)
+
+	if client.SkipGeometry {
+		st_geojson = ""
+	}
+
+	geom_cols, geom_vals := client.geometryColumns(st_geojson, st_simplified, st_centroid)
+
+	cols = append(cols, geom_cols...)
+	vals = append(vals, geom_vals...)
+
+	table, err := client.routeTable(rec.pointType())
+
+	if err != nil {
+		return err
+	}
+
+	if client.Verbose {
+
+		display_args := displayArgs(cols, args)
+		display_args[len(display_args)-1] = "..."
+
+		conflict, err := client.conflictColumns()
+
+		if err != nil {
+			return err
+		}
+
+		display_sql := upsertSQL(cols, vals, conflict)
+
+		if table != "" {
+			display_sql = upsertTableSQL(table, cols, vals, conflict)
+		}
+
+		client.Logger.Status("%s %v", display_sql, display_args)
+	}
+
+	u := &pgisUpsert{
+		id:        rec.Id,
+		placetype: pt,
+		table:     table,
+		repo:      rec.Meta.Repo,
+		cols:      cols,
+		vals:      vals,
+		args:      args,
+	}
+
+	// http://postgis.net/docs/ST_Subdivide.html
+
+	if st_geojson != "" && client.SubdivideMaxVertices > 0 {
+
+		st_subdivide, err := geomFromFormat(rec.Format, "$2")
+
+		if err != nil {
+			return err
+		}
+
+		u.subdivide = fmt.Sprintf("INSERT INTO whosonfirst_subdivided (id, geom) SELECT $1, ST_Subdivide(g, %d) FROM (SELECT %s AS g) AS r WHERE GeometryType(g) != 'POINT'", client.SubdivideMaxVertices, st_subdivide)
+		u.subdivide_args = []interface{}{rec.Id, geom_arg}
+
+		if client.Verbose {
+			client.Logger.Status("%s %d", SQL_DELETE_SUBDIVIDED, rec.Id)
+			client.Logger.Status("%s %d ...", u.subdivide, rec.Id)
+		}
+	}
+
+	return client.upsert(ctx, u)
+}
+
+// http://postgis.net/docs/ST_Union.html
+
+// UnionGeometry merges a (GeoJSON) polygon in to the existing geometry for id,
+// or sets it if the record doesn't have one yet, which is useful for assembling
+// a geometry that has been delivered in pieces. Everything derived from the
+// geometry that the client has been configured to store (the simplified and
+// projected geometries, bbox, area and subdivided geometries, and the centroid
+// if ServerCentroid is set) is updated to match, in the same transaction. Note
+// that geom_hash is left as is so it will no longer match the geometry in the
+// original feature, and that a record in the points table (see PointsTable)
+// stays there, polygon and all, until it is next indexed. Records are matched by
+// ID alone so this returns an error with the id+repo conflict key.
+
+func (client *PgisClient) UnionGeometry(ctx context.Context, id int64, body []byte) error {
+
+	t1 := time.Now()
+
+	err := client.unionGeometry(ctx, id, body)
+
+	client.reportExecuted(t1, err)
+	return err
+}
+
+func (client *PgisClient) unionGeometry(ctx context.Context, id int64, body []byte) error {
+
+	if len(body) == 0 {
+		msg := fmt.Sprintf("missing geometry for %d", id)
+		return errors.New(msg)
+	}
+
+	st_geom, err := geomFromFormat(GEOMETRY_FORMAT_GEOJSON, "$2")
+
+	if err != nil {
+		return err
+	}
+
+	// the record might be in any of the record tables (see PointsTable) and
+	// there's no telling which without looking, so there's a set of
+	// statements for each of them and the first one whose union finds the
+	// record is the one that's used
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		return err
+	}
+
+	table_stmts := make([][]string, len(tables))
+
+	for i, table := range tables {
+
+		stmts, err := client.unionGeometrySQL(table, st_geom)
+
+		if err != nil {
+			return err
+		}
+
+		table_stmts[i] = stmts
+	}
+
+	lastmod := time.Now().Format(time.RFC3339)
+
+	args := func(stmts []string) [][]interface{} {
+
+		stmt_args := [][]interface{}{{id, string(body), lastmod}}
+
+		for i := 1; i < len(stmts); i++ {
+			stmt_args = append(stmt_args, []interface{}{id})
+		}
+
+		return stmt_args
+	}
+
+	if client.Verbose {
+
+		for _, stmts := range table_stmts {
+
+			for _, stmt := range stmts {
+				client.Logger.Status("%s %d", stmt, id)
+			}
+		}
+	}
+
+	// the statements for a table the record isn't in don't do anything so
+	// when they're being written out, rather than executed, they all are
+
+	if client.SQLWriter != nil {
+
+		stmts := []string{"BEGIN"}
+		stmt_args := [][]interface{}{nil}
+
+		for _, s := range table_stmts {
+			stmts = append(stmts, s...)
+			stmt_args = append(stmt_args, args(s)...)
+		}
+
+		stmts = append(stmts, "COMMIT")
+		stmt_args = append(stmt_args, nil)
+
+		return client.writeSQL(stmts, stmt_args)
+	}
+
+	if client.Debug {
+		return nil
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	err = client.requirePostGIS(ctx, db, client.postGISRequirements())
+
+	if err != nil {
+		return err
+	}
+
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+
+		for _, stmts := range table_stmts {
+
+			stmt_args := args(stmts)
+
+			rsp, err := tx.ExecContext(ctx, stmts[0], stmt_args[0]...)
+
+			if err != nil {
+				return err
+			}
+
+			count, err := rsp.RowsAffected()
+
+			if err != nil {
+				return err
+			}
+
+			if count == 0 {
+				continue
+			}
+
+			for i := 1; i < len(stmts); i++ {
+
+				_, err := tx.ExecContext(ctx, stmts[i], stmt_args[i]...)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		return ErrNotFound
+	})
+
+	if err != nil {
+
+		if err != ErrNotFound {
+			client.Logger.Error("failed to execute query because %s", err)
+		}
+
+		return classifyError(err)
+	}
+
+	return nil
+}
+
+// the statements UnionGeometry executes for a record in table: the union
+// itself, which takes the ID, the geometry (as st_geom) and lastmod, followed
+// by the ones in derivedGeometrySQL, which only take the ID. Records are
+// matched by ID alone so, like Diff, this can't be used with the id+repo
+// conflict key where that would update the record from every repo.
+
+func (client *PgisClient) unionGeometrySQL(table string, st_geom string) ([]string, error) {
+
+	if client.conflictRepo() {
+		return nil, errors.New("geometries are unioned by id alone so UnionGeometry can not be used with the id+repo conflict key")
+	}
+
+	// ST_Union returns NULL if either geometry is NULL
+
+	geom_col := client.columns().geom
+
+	st_union := fmt.Sprintf("ST_Multi(ST_CollectionExtract(COALESCE(ST_Union(%s::geometry, %s), %s), 3))::geography", geom_col, st_geom, st_geom)
+
+	stmts := []string{
+		fmt.Sprintf("UPDATE %s SET %s=%s, lastmod=$3 WHERE id=$1", table, geom_col, st_union),
+	}
+
+	derived, err := client.derivedGeometrySQL(table, "id=$1")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(stmts, derived...), nil
+}
+
+// the statements that bring everything derived from the geometry stored in
+// table for the records matching where, whose only placeholder is $1, up to
+// date with it: the other geometry columns, bbox, area and so on, and the
+// subdivided geometries. They are derived from the geometry as it has been
+// stored, rather than repeating whatever produced it for each of them, which
+// means they need a statement of their own since SET only ever sees the old
+// values
+
+func (client *PgisClient) derivedGeometrySQL(table string, where string) ([]string, error) {
+
+	geom_col := client.columns().geom
+
+	st_stored := fmt.Sprintf("%s::geometry", geom_col)
+
+	st_server_centroid, err := client.serverCentroid()
+
+	if err != nil {
+		return nil, err
+	}
+
+	st_centroid := ""
+
+	if st_server_centroid != "" {
+		st_centroid = fmt.Sprintf("%s(%s)", st_server_centroid, st_stored)
+	}
+
+	st_simplified := fmt.Sprintf("ST_Multi(ST_SimplifyPreserveTopology(%s, %g))", st_stored, client.SimplifiedTolerance)
+
+	derived_cols, derived_vals := client.geometryColumns(st_stored, st_simplified, st_centroid)
+
+	updates := make([]string, 0)
+
+	// without a server centroid the centroid comes from the feature's
+	// properties, which are none of our business here, rather than being
+	// NULL
+
+	for i, col := range derived_cols {
+
+		if col != geom_col && derived_vals[i] != "NULL" {
+			updates = append(updates, fmt.Sprintf("%s=%s", col, derived_vals[i]))
+		}
+	}
+
+	stmts := make([]string, 0)
+
+	if len(updates) > 0 {
+		stmts = append(stmts, fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(updates, ", "), where))
+	}
+
+	// http://postgis.net/docs/ST_Subdivide.html
+
+	if client.SubdivideMaxVertices > 0 && !client.conflictRepo() {
+
+		sql_delete_subdivided := fmt.Sprintf("DELETE FROM whosonfirst_subdivided WHERE %s", where)
+		sql_insert_subdivided := fmt.Sprintf("INSERT INTO whosonfirst_subdivided (id, geom) SELECT id, ST_Subdivide(%s, %d) FROM %s WHERE %s", st_stored, client.SubdivideMaxVertices, table, where)
+
+		stmts = append(stmts, sql_delete_subdivided, sql_insert_subdivided)
+	}
+
+	return stmts, nil
+}
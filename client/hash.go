@@ -0,0 +1,85 @@
+package pgis
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	geom "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/geometry"
+	wof "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/utils"
+)
+
+// the properties that HashFeature considers, in a struct rather than a map so
+// that the JSON encoding (and therefore the hash) is always the same
+
+type featureHashProperties struct {
+	GeomHash     string             `json:"geom_hash"`
+	Name         string             `json:"name"`
+	Country      string             `json:"country"`
+	Repo         string             `json:"repo"`
+	Placetype    string             `json:"placetype"`
+	ParentId     int64              `json:"parent_id"`
+	IsDeprecated string             `json:"is_deprecated"`
+	IsSuperseded string             `json:"is_superseded"`
+	Hierarchy    []map[string]int64 `json:"hierarchy"`
+}
+
+// HashFeature returns an MD5 hash of feature's geometry and the properties that
+// end up in the database (name, country, repo, placetype, parent, hierarchy and
+// the deprecated and superseded flags) so, unlike geom_hash, it changes when
+// only the metadata for a record does. It is stored in the feature_hash column
+// when PgisClient.StoreFeatureHash is set.
+
+func HashFeature(feature geojson.Feature) (string, error) {
+
+	str_geom, err := geom.ToString(feature)
+
+	if err != nil {
+		return "", err
+	}
+
+	geom_hash := ""
+
+	if hasCoordinates(str_geom) {
+
+		geom_hash, err = utils.HashGeometry([]byte(str_geom))
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	is_deprecated, err := wof.IsDeprecated(feature)
+
+	if err != nil {
+		return "", err
+	}
+
+	is_superseded, err := wof.IsSuperseded(feature)
+
+	if err != nil {
+		return "", err
+	}
+
+	props := featureHashProperties{
+		GeomHash:     geom_hash,
+		Name:         wof.Name(feature),
+		Country:      wof.Country(feature),
+		Repo:         wof.Repo(feature),
+		Placetype:    wof.Placetype(feature),
+		ParentId:     wof.ParentId(feature),
+		IsDeprecated: is_deprecated.StringFlag(),
+		IsSuperseded: is_superseded.StringFlag(),
+		Hierarchy:    wof.Hierarchy(feature),
+	}
+
+	enc, err := json.Marshal(props)
+
+	if err != nil {
+		return "", err
+	}
+
+	hash := md5.Sum(enc)
+	return hex.EncodeToString(hash[:]), nil
+}
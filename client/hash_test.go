@@ -0,0 +1,61 @@
+package pgis
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"testing"
+)
+
+func TestHashFeature(t *testing.T) {
+
+	base := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:country":"CA","wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`
+
+	hash := func(body string) string {
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		h, err := HashFeature(f)
+
+		if err != nil {
+			t.Fatalf("HashFeature failed because %s", err)
+		}
+
+		return h
+	}
+
+	base_hash := hash(base)
+
+	if len(base_hash) != 32 {
+		t.Errorf("expected a 32 character hash, got %q", base_hash)
+	}
+
+	tests := []struct {
+		what string
+		body string
+		same bool
+	}{
+		{"the same feature", base, true},
+		{"a property that isn't stored", `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:country":"CA","wof:repo":"whosonfirst-data","wof:lastmodified":1500000000,"geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, true},
+		{"properties in a different order", `{"type":"Feature","id":101736545,"properties":{"wof:repo":"whosonfirst-data","wof:country":"CA","wof:parent_id":-1,"wof:placetype":"locality","wof:name":"Montreal","wof:id":101736545,"geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, true},
+		{"a different name", `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montréal","wof:placetype":"locality","wof:parent_id":-1,"wof:country":"CA","wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, false},
+		{"a different parent", `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":85682057,"wof:country":"CA","wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, false},
+		{"a different geometry", `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:country":"CA","wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.6,45.5]}}`, false},
+		{"deprecated", `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:country":"CA","wof:repo":"whosonfirst-data","edtf:deprecated":"2017-08-24","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, false},
+	}
+
+	for _, test := range tests {
+
+		h := hash(test.body)
+
+		if test.same && h != base_hash {
+			t.Errorf("expected %s to have the same hash, got %s and %s", test.what, h, base_hash)
+		}
+
+		if !test.same && h == base_hash {
+			t.Errorf("expected %s to have a different hash", test.what)
+		}
+	}
+}
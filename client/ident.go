@@ -0,0 +1,45 @@
+package pgis
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// https://www.postgresql.org/docs/9.6/static/sql-syntax-lexical.html#SQL-SYNTAX-IDENTIFIERS
+
+// PostgreSQL silently truncates identifiers longer than this (NAMEDATALEN - 1)
+// which is worse than failing since two long names can end up the same
+
+const MAX_IDENTIFIER_LENGTH = 63
+
+// returns an error if s can't be used as a table, column or index name even
+// once it's been quoted
+
+func validateIdent(s string) error {
+
+	if s == "" {
+		return errors.New("empty identifier")
+	}
+
+	if len(s) > MAX_IDENTIFIER_LENGTH {
+		msg := fmt.Sprintf("identifier '%s' is longer than %d bytes", s, MAX_IDENTIFIER_LENGTH)
+		return errors.New(msg)
+	}
+
+	if strings.ContainsRune(s, 0) {
+		msg := fmt.Sprintf("identifier '%s' contains a NUL character", s)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// double-quote s so that it can be interpolated in to a SQL statement as a
+// table, column or index name whatever it contains, reserved words included;
+// anything that comes from outside the client should be checked with
+// validateIdent first
+
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,60 @@
+package pgis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateIdent(t *testing.T) {
+
+	tests := []struct {
+		ident string
+		valid bool
+	}{
+		{"geom", true},
+		{"the geom", true},
+		{`weird"name`, true},
+		{"select", true},
+		{strings.Repeat("x", MAX_IDENTIFIER_LENGTH), true},
+		{strings.Repeat("x", MAX_IDENTIFIER_LENGTH+1), false},
+		{"", false},
+		{"nul\x00byte", false},
+	}
+
+	for _, test := range tests {
+
+		err := validateIdent(test.ident)
+
+		if test.valid && err != nil {
+			t.Errorf("validateIdent(%q) failed because %s", test.ident, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("validateIdent(%q) succeeded, expected an error", test.ident)
+		}
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+
+	tests := []struct {
+		ident  string
+		quoted string
+	}{
+		{"geom", `"geom"`},
+		{"the geom", `"the geom"`},
+		{"select", `"select"`},
+		{`weird"name`, `"weird""name"`},
+		{`""`, `""""""`},
+		{`x"; DROP TABLE whosonfirst; --`, `"x""; DROP TABLE whosonfirst; --"`},
+	}
+
+	for _, test := range tests {
+
+		quoted := quoteIdent(test.ident)
+
+		if quoted != test.quoted {
+			t.Errorf("quoteIdent(%q) returned %q, expected %q", test.ident, quoted, test.quoted)
+		}
+	}
+}
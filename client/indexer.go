@@ -0,0 +1,154 @@
+package pgis
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+)
+
+// Indexer is the part of PgisClient a loader needs, so that loaders can be
+// written without caring what the features are being indexed in to (or so that
+// they can be tested without a database)
+
+type Indexer interface {
+	IndexFeature(feature geojson.Feature, collection string) error
+	DeleteFeature(ctx context.Context, feature geojson.Feature) error
+	Exec(ctx context.Context, sql string, args ...interface{}) (int64, error)
+	Close() error
+}
+
+var _ Indexer = (*PgisClient)(nil)
+
+// MultiIndexer sends everything to each of a list of Indexers in turn, for
+// loaders that write to more than one database (or backend) at once. The first
+// error stops it so some Indexers may have been told about something that the
+// rest never were. Since nothing is queued the slowest Indexer sets the pace for
+// all of them. A PgisClient never has more than its maxconns statements in
+// flight however many goroutines are calling it but other Indexers may not be
+// so careful, which is what NewMultiIndexerWithLimit is for.
+
+type MultiIndexer struct {
+	indexers []Indexer
+	slots    []chan bool // one per Indexer, or nil if there is no limit
+}
+
+var _ Indexer = (*MultiIndexer)(nil)
+
+func NewMultiIndexer(indexers ...Indexer) *MultiIndexer {
+	return NewMultiIndexerWithLimit(0, indexers...)
+}
+
+// max_inflight is the most calls that any one of indexers will be asked to
+// handle at the same time, however many goroutines are calling the
+// MultiIndexer; 0 means no limit
+
+func NewMultiIndexerWithLimit(max_inflight int, indexers ...Indexer) *MultiIndexer {
+
+	m := MultiIndexer{
+		indexers: indexers,
+	}
+
+	if max_inflight > 0 {
+
+		m.slots = make([]chan bool, len(indexers))
+
+		for i := range indexers {
+
+			m.slots[i] = make(chan bool, max_inflight)
+
+			for j := 0; j < max_inflight; j++ {
+				m.slots[i] <- true
+			}
+		}
+	}
+
+	return &m
+}
+
+// waits for the i'th Indexer to have a free slot and returns the function that
+// gives it back
+
+func (m *MultiIndexer) acquire(i int) func() {
+
+	if m.slots == nil {
+		return func() {}
+	}
+
+	<-m.slots[i]
+
+	return func() {
+		m.slots[i] <- true
+	}
+}
+
+func (m *MultiIndexer) IndexFeature(feature geojson.Feature, collection string) error {
+
+	for i, idx := range m.indexers {
+
+		release := m.acquire(i)
+		err := idx.IndexFeature(feature, collection)
+		release()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MultiIndexer) DeleteFeature(ctx context.Context, feature geojson.Feature) error {
+
+	for i, idx := range m.indexers {
+
+		release := m.acquire(i)
+		err := idx.DeleteFeature(ctx, feature)
+		release()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Exec returns the total number of rows affected by all the Indexers
+
+func (m *MultiIndexer) Exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+
+	total := int64(0)
+
+	for i, idx := range m.indexers {
+
+		release := m.acquire(i)
+		count, err := idx.Exec(ctx, sql, args...)
+		release()
+
+		if err != nil {
+			return total, err
+		}
+
+		total += count
+	}
+
+	return total, nil
+}
+
+// Close closes all of the Indexers, even if some of them fail, and returns the
+// first error
+
+func (m *MultiIndexer) Close() error {
+
+	var first error
+
+	for _, idx := range m.indexers {
+
+		err := idx.Close()
+
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,274 @@
+package pgis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockIndexer struct {
+	indexed []string
+	deleted []string
+	execed  []string
+	closed  bool
+	err     error
+}
+
+func (m *mockIndexer) IndexFeature(f geojson.Feature, collection string) error {
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.indexed = append(m.indexed, f.Id())
+	return nil
+}
+
+func (m *mockIndexer) DeleteFeature(ctx context.Context, f geojson.Feature) error {
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.deleted = append(m.deleted, f.Id())
+	return nil
+}
+
+func (m *mockIndexer) Exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+
+	if m.err != nil {
+		return 0, m.err
+	}
+
+	m.execed = append(m.execed, sql)
+	return int64(len(args)), nil
+}
+
+func (m *mockIndexer) Close() error {
+	m.closed = true
+	return m.err
+}
+
+var _ Indexer = (*mockIndexer)(nil)
+
+func TestMultiIndexer(t *testing.T) {
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","id":101736545,"properties":{},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	a := &mockIndexer{}
+	b := &mockIndexer{}
+
+	m := NewMultiIndexer(a, b)
+
+	err = m.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature failed because %s", err)
+	}
+
+	err = m.DeleteFeature(context.Background(), f)
+
+	if err != nil {
+		t.Fatalf("DeleteFeature failed because %s", err)
+	}
+
+	count, err := m.Exec(context.Background(), "VACUUM", 1, 2)
+
+	if err != nil {
+		t.Fatalf("Exec failed because %s", err)
+	}
+
+	if count != 4 {
+		t.Errorf("Exec returned %d, expected 4", count)
+	}
+
+	err = m.Close()
+
+	if err != nil {
+		t.Fatalf("Close failed because %s", err)
+	}
+
+	for _, idx := range []*mockIndexer{a, b} {
+
+		if len(idx.indexed) != 1 || idx.indexed[0] != f.Id() {
+			t.Errorf("expected %s to be indexed, got %v", f.Id(), idx.indexed)
+		}
+
+		if len(idx.deleted) != 1 || idx.deleted[0] != f.Id() {
+			t.Errorf("expected %s to be deleted, got %v", f.Id(), idx.deleted)
+		}
+
+		if len(idx.execed) != 1 || idx.execed[0] != "VACUUM" {
+			t.Errorf("expected VACUUM to be executed, got %v", idx.execed)
+		}
+
+		if !idx.closed {
+			t.Errorf("expected indexer to be closed")
+		}
+	}
+}
+
+func TestMultiIndexerErrors(t *testing.T) {
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","id":1,"properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	failure := errors.New("nope")
+
+	a := &mockIndexer{err: failure}
+	b := &mockIndexer{}
+
+	m := NewMultiIndexer(a, b)
+
+	err = m.IndexFeature(f, "test")
+
+	if err != failure {
+		t.Errorf("IndexFeature returned %v, expected %v", err, failure)
+	}
+
+	if len(b.indexed) != 0 {
+		t.Errorf("expected the first error to stop IndexFeature")
+	}
+
+	// Close carries on regardless
+
+	err = m.Close()
+
+	if err != failure {
+		t.Errorf("Close returned %v, expected %v", err, failure)
+	}
+
+	if !b.closed {
+		t.Errorf("expected Close to close every indexer")
+	}
+}
+
+// a MultiIndexer made of nothing but PgisClients, writing SQL rather than
+// executing it so that there doesn't need to be a database
+
+func TestMultiIndexerPgisClients(t *testing.T) {
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	a := &bytes.Buffer{}
+	b := &bytes.Buffer{}
+
+	indexers := make([]Indexer, 0)
+
+	for _, wr := range []*bytes.Buffer{a, b} {
+
+		client := &PgisClient{
+			Logger:    log.SimpleWOFLogger("test"),
+			SQLWriter: wr,
+		}
+
+		indexers = append(indexers, client)
+	}
+
+	m := NewMultiIndexer(indexers...)
+
+	err = m.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature failed because %s", err)
+	}
+
+	for i, wr := range []*bytes.Buffer{a, b} {
+
+		str_sql := wr.String()
+
+		if !strings.HasPrefix(str_sql, "INSERT INTO whosonfirst ") || !strings.Contains(str_sql, "101736545") {
+			t.Errorf("expected client %d to write an INSERT for 101736545, got %q", i, str_sql)
+		}
+	}
+}
+
+// an Indexer that keeps track of how many Execs it is running at once
+
+type inflightIndexer struct {
+	mockIndexer
+	mu       sync.Mutex
+	inflight int
+	max      int
+}
+
+func (m *inflightIndexer) Exec(ctx context.Context, sql string, args ...interface{}) (int64, error) {
+
+	m.mu.Lock()
+	m.inflight += 1
+
+	if m.inflight > m.max {
+		m.max = m.inflight
+	}
+
+	m.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	m.mu.Lock()
+	m.inflight -= 1
+	m.mu.Unlock()
+
+	return 1, nil
+}
+
+func TestMultiIndexerWithLimit(t *testing.T) {
+
+	limit := 2
+
+	a := &inflightIndexer{}
+	b := &inflightIndexer{}
+
+	m := NewMultiIndexerWithLimit(limit, a, b)
+
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < 20; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			_, err := m.Exec(context.Background(), "VACUUM")
+
+			if err != nil {
+				t.Errorf("Exec failed because %s", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for i, idx := range []*inflightIndexer{a, b} {
+
+		if idx.max > limit {
+			t.Errorf("indexer %d had %d Execs in flight, expected no more than %d", i, idx.max, limit)
+		}
+
+		// the limit is per indexer, not for the MultiIndexer as a whole
+
+		if idx.max < 2 {
+			t.Errorf("indexer %d never had more than %d Execs in flight", i, idx.max)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	geom "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/geometry"
+	"strings"
+	"time"
+)
+
+// the columns (and the order) that QueryRowToPgisRow expects, assuming the
+// default geometry column names
+
+const PGIS_ROW_COLUMNS = "id, parent_id, placetype_id, is_superseded, is_deprecated, meta, ST_AsGeoJSON(geom), ST_AsGeoJSON(centroid)"
+
+// http://postgis.net/docs/ST_Intersects.html
+
+func (client *PgisClient) IntersectsFeature(ctx context.Context, body []byte, opts *PgisIntersectsOptions) ([]*PgisRow, error) {
+
+	f, err := feature.LoadFeature(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	str_geom, err := geom.ToString(f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return client.IntersectsGeometry(ctx, []byte(str_geom), opts)
+}
+
+// IntersectsGeometry is the same as IntersectsFeature but takes a bare GeoJSON
+// geometry rather than a whole feature. Results for both are ordered by id
+// unless opts.OrderBy says otherwise.
+
+func (client *PgisClient) IntersectsGeometry(ctx context.Context, body []byte, opts *PgisIntersectsOptions) ([]*PgisRow, error) {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return nil, errors.New("missing geometry")
+	}
+
+	str_geom := string(body)
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	where, err := intersectsConditions(client.columns(), opts, "$1")
+
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{
+		str_geom,
+	}
+
+	where, args = opts.filters(client.columns(), where, args)
+
+	order, err := opts.orderBy(client.columns(), "id", "ST_GeomFromGeoJSON($1)")
+
+	if err != nil {
+		return nil, err
+	}
+
+	page, args := opts.pagination(args)
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE %s %s %s", client.columns().rowColumns(), source, strings.Join(where, " AND "), order, page)
+
+	if client.Verbose {
+		client.Logger.Status("%s", strings.Replace(sql, "$1", "'...'", 1))
+	}
+
+	return client.queryRows(ctx, sql, args...)
+}
+
+// the spatial conditions for an intersects query where query_geom is the SQL
+// expression (a placeholder or a column) for the GeoJSON geometry to test
+
+func intersectsConditions(cols pgisColumns, opts *PgisIntersectsOptions, query_geom string) ([]string, error) {
+
+	// remember Point geometries are only stored in the centroid column so if
+	// you are querying against points you will want to use that
+
+	geom_col := opts.GeometryColumn
+
+	switch geom_col {
+	case "", "geom":
+		geom_col = cols.geom
+	case "centroid":
+		geom_col = cols.centroid
+	case "geom_simplified":
+		// pass
+	default:
+		msg := fmt.Sprintf("invalid geometry column '%s'", geom_col)
+		return nil, errors.New(msg)
+	}
+
+	where := []string{
+		opts.spatialTest("ST_Intersects", geom_col, fmt.Sprintf("ST_GeomFromGeoJSON(%s)::geography", query_geom)),
+	}
+
+	// the subdivided geometries are a lot cheaper to test against than
+	// very large polygons so we use them to find candidate IDs and then
+	// confirm the results against the full geometry - note that this means
+	// records indexed without PgisClient.SubdivideMaxVertices will not be
+	// returned
+
+	if opts.UseSubdivided && geom_col == cols.geom {
+		where = append([]string{fmt.Sprintf("id IN (SELECT id FROM whosonfirst_subdivided WHERE ST_Intersects(geom, ST_SetSRID(ST_GeomFromGeoJSON(%s), 4326)))", query_geom)}, where...)
+	}
+
+	return where, nil
+}
+
+// IntersectsFeatures is IntersectsFeature for lots of features at once, in a
+// single query, and returns the results for each one keyed by its position in
+// bodies. Features that don't intersect anything are left out. opts.Limit and
+// opts.Offset apply to the results for each feature, not to all of them.
+
+func (client *PgisClient) IntersectsFeatures(ctx context.Context, bodies [][]byte, opts *PgisIntersectsOptions) (map[int][]*PgisRow, error) {
+
+	t1 := time.Now()
+
+	results, err := client.intersectsFeatures(ctx, bodies, opts)
+
+	client.reportQueried(t1, err)
+	return results, err
+}
+
+func (client *PgisClient) intersectsFeatures(ctx context.Context, bodies [][]byte, opts *PgisIntersectsOptions) (map[int][]*PgisRow, error) {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	str_geoms := make([]string, len(bodies))
+
+	for i, body := range bodies {
+
+		f, err := feature.LoadFeature(body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		str_geom, err := geom.ToString(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		str_geoms[i] = str_geom
+	}
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	where, err := intersectsConditions(client.columns(), opts, "query_geom")
+
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{
+		pq.Array(str_geoms),
+	}
+
+	where, args = opts.filters(client.columns(), where, args)
+
+	// https://www.postgresql.org/docs/9.6/static/functions-array.html
+	// https://www.postgresql.org/docs/9.6/static/queries-table-expressions.html#QUERIES-TABLEFUNCTIONS
+
+	// note that "WITH ORDINALITY" counts from 1
+
+	// results are grouped by query_idx anyway so the order only matters
+	// within each one
+
+	order, err := opts.orderBy(client.columns(), "id", "ST_GeomFromGeoJSON(query_geom)")
+
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	from := fmt.Sprintf("unnest($1::text[]) WITH ORDINALITY AS q(query_geom, query_idx) JOIN %s ON %s", source, strings.Join(where, " AND "))
+
+	sql := fmt.Sprintf("SELECT query_idx, %s FROM %s %s", client.columns().rowColumns(), from, order)
+
+	// https://www.postgresql.org/docs/9.6/static/tutorial-window.html
+
+	// a plain LIMIT would apply to all the geometries together so a page is
+	// counted separately for each one instead
+
+	if opts.paginated() {
+
+		args = append(args, opts.Offset)
+		page := []string{fmt.Sprintf("query_rank > $%d", len(args))}
+
+		if opts.Limit > 0 {
+			args = append(args, opts.Offset+opts.Limit)
+			page = append(page, fmt.Sprintf("query_rank <= $%d", len(args)))
+		}
+
+		ranked := fmt.Sprintf("SELECT q.query_idx, whosonfirst.*, ROW_NUMBER() OVER (PARTITION BY q.query_idx %s) AS query_rank FROM %s", order, from)
+		sql = fmt.Sprintf("SELECT query_idx, %s FROM (%s) AS r WHERE %s ORDER BY query_idx, query_rank", client.columns().rowColumns(), ranked, strings.Join(page, " AND "))
+	}
+
+	if client.Verbose {
+		client.Logger.Status("%s (%d geometries)", sql, len(str_geoms))
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	if client.Explain {
+
+		err := client.explain(ctx, db, sql, args...)
+
+		if err != nil {
+			client.Logger.Warning("failed to explain query because %s", err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, sql, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	results := make(map[int][]*PgisRow)
+
+	for rows.Next() {
+
+		var idx int64
+
+		pg_row, err := QueryRowToPgisRow(&indexedResultSet{rows: rows, idx: &idx})
+
+		if err != nil {
+			return nil, err
+		}
+
+		i := int(idx) - 1
+		results[i] = append(results[i], pg_row)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// a PgisResultSet for rows where the first column is something other than
+// the ones QueryRowToPgisRow knows about
+
+type indexedResultSet struct {
+	rows PgisResultSet
+	idx  *int64
+}
+
+func (rs *indexedResultSet) Scan(dest ...interface{}) error {
+	return rs.rows.Scan(append([]interface{}{rs.idx}, dest...)...)
+}
+
+// http://postgis.net/docs/ST_Covers.html
+
+// PointInPolygon returns all the records whose geometry contains lon, lat (which
+// is to say reverse-geocoding) ordered, unless opts.OrderBy says otherwise, from
+// the smallest to the largest, which is usually the same as most to least
+// specific placetype
+
+func (client *PgisClient) PointInPolygon(ctx context.Context, lon float64, lat float64, opts *PgisIntersectsOptions) ([]*PgisRow, error) {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	cols := client.columns()
+
+	st_point := "ST_SetSRID(ST_MakePoint($1, $2), 4326)"
+
+	where := []string{
+		opts.spatialTest("ST_Covers", cols.geom, fmt.Sprintf("%s::geography", st_point)),
+	}
+
+	// see notes in IntersectsFeature
+
+	if opts.UseSubdivided {
+		where = append([]string{fmt.Sprintf("id IN (SELECT id FROM whosonfirst_subdivided WHERE ST_Intersects(geom, %s))", st_point)}, where...)
+	}
+
+	args := []interface{}{
+		lon, lat,
+	}
+
+	where, args = opts.filters(cols, where, args)
+
+	order, err := opts.orderBy(cols, "area", st_point)
+
+	if err != nil {
+		return nil, err
+	}
+
+	page, args := opts.pagination(args)
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE %s %s %s", cols.rowColumns(), source, strings.Join(where, " AND "), order, page)
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, args)
+	}
+
+	return client.queryRows(ctx, sql, args...)
+}
+
+// run sql and return all of the rows it matches, which must have been selected
+// using PGIS_ROW_COLUMNS (or pgisColumns.rowColumns)
+
+func (client *PgisClient) queryRows(ctx context.Context, sql string, args ...interface{}) ([]*PgisRow, error) {
+
+	t1 := time.Now()
+
+	results, err := client.selectRows(ctx, sql, args...)
+
+	client.reportQueried(t1, err)
+	return results, err
+}
+
+func (client *PgisClient) selectRows(ctx context.Context, sql string, args ...interface{}) ([]*PgisRow, error) {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	if client.Explain {
+
+		err := client.explain(ctx, db, sql, args...)
+
+		if err != nil {
+			client.Logger.Warning("failed to explain query because %s", err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, sql, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	results := make([]*PgisRow, 0)
+
+	for rows.Next() {
+
+		pg_row, err := QueryRowToPgisRow(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, pg_row)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,294 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIntersectsConditions(t *testing.T) {
+
+	cols := (&PgisClient{}).columns()
+
+	subdivided := "id IN (SELECT id FROM whosonfirst_subdivided WHERE ST_Intersects(geom, ST_SetSRID(ST_GeomFromGeoJSON($1), 4326)))"
+
+	tests := []struct {
+		cols  pgisColumns
+		opts  *PgisIntersectsOptions
+		where []string
+		err   bool
+	}{
+		{cols, &PgisIntersectsOptions{}, []string{"ST_Intersects(geom, ST_GeomFromGeoJSON($1)::geography)"}, false},
+		{cols, &PgisIntersectsOptions{GeometryColumn: "geom"}, []string{"ST_Intersects(geom, ST_GeomFromGeoJSON($1)::geography)"}, false},
+		{cols, &PgisIntersectsOptions{GeometryColumn: "centroid"}, []string{"ST_Intersects(centroid, ST_GeomFromGeoJSON($1)::geography)"}, false},
+		{cols, &PgisIntersectsOptions{GeometryColumn: "geom_simplified"}, []string{"ST_Intersects(geom_simplified, ST_GeomFromGeoJSON($1)::geography)"}, false},
+		{cols, &PgisIntersectsOptions{GeometryColumn: "bbox"}, nil, true},
+		{cols, &PgisIntersectsOptions{UseSubdivided: true}, []string{subdivided, "ST_Intersects(geom, ST_GeomFromGeoJSON($1)::geography)"}, false},
+		{cols, &PgisIntersectsOptions{GeometryColumn: "centroid", UseSubdivided: true}, []string{"ST_Intersects(centroid, ST_GeomFromGeoJSON($1)::geography)"}, false},
+		{cols, &PgisIntersectsOptions{GeometryColumn: "geom_simplified", UseSubdivided: true}, []string{"ST_Intersects(geom_simplified, ST_GeomFromGeoJSON($1)::geography)"}, false},
+		{cols, &PgisIntersectsOptions{MakeValid: true}, []string{"(geom && ST_GeomFromGeoJSON($1)::geography AND ST_Intersects(ST_MakeValid(geom::geometry)::geography, ST_GeomFromGeoJSON($1)::geography))"}, false},
+		{(&PgisClient{GeomColumn: "shape", CentroidColumn: "label"}).columns(), &PgisIntersectsOptions{GeometryColumn: "centroid"}, []string{`ST_Intersects("label", ST_GeomFromGeoJSON($1)::geography)`}, false},
+		{(&PgisClient{GeomColumn: "shape"}).columns(), &PgisIntersectsOptions{UseSubdivided: true}, []string{subdivided, `ST_Intersects("shape", ST_GeomFromGeoJSON($1)::geography)`}, false},
+	}
+
+	for i, test := range tests {
+
+		where, err := intersectsConditions(test.cols, test.opts, "$1")
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("test %d: expected an error for %s", i, test.opts.GeometryColumn)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("test %d: intersectsConditions failed because %s", i, err)
+			continue
+		}
+
+		if strings.Join(where, " AND ") != strings.Join(test.where, " AND ") {
+			t.Errorf("test %d: intersectsConditions returned %q, expected %q", i, where, test.where)
+		}
+	}
+}
+
+func TestPointInPolygon(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		rows := [][]driver.Value{
+			testRow(101736545, 102312317, `{"wof:name":"Montreal"}`, `{"type":"Point","coordinates":[-73.5,45.5]}`),
+			testRow(85633041, 102312307, `{"wof:name":"Canada"}`, `{"type":"Point","coordinates":[-106.3,56.1]}`),
+		}
+
+		return &testResult{columns: TEST_ROW_COLUMNS, rows: rows}, nil
+	}
+
+	covers := "ST_Covers(geom, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography)"
+	by_area := "ORDER BY ST_Area(geom) ASC NULLS LAST, id ASC"
+
+	tests := []struct {
+		opts     *PgisIntersectsOptions
+		expected string
+	}{
+		{nil, "SELECT " + PGIS_ROW_COLUMNS + " FROM whosonfirst WHERE " + covers + " " + by_area + " "},
+		{&PgisIntersectsOptions{PlacetypeId: 102312317, Limit: 1}, "SELECT " + PGIS_ROW_COLUMNS + " FROM whosonfirst WHERE " + covers + " AND placetype_id=$3 " + by_area + " LIMIT $4"},
+		{&PgisIntersectsOptions{OrderBy: "distance"}, "SELECT " + PGIS_ROW_COLUMNS + " FROM whosonfirst WHERE " + covers + " ORDER BY ST_Distance(centroid, ST_Centroid(ST_SetSRID(ST_MakePoint($1, $2), 4326))::geography) ASC, id ASC "},
+		{&PgisIntersectsOptions{UseSubdivided: true}, "SELECT " + PGIS_ROW_COLUMNS + " FROM whosonfirst WHERE id IN (SELECT id FROM whosonfirst_subdivided WHERE ST_Intersects(geom, ST_SetSRID(ST_MakePoint($1, $2), 4326))) AND " + covers + " " + by_area + " "},
+	}
+
+	for i, test := range tests {
+
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+
+			rows, err := client.PointInPolygon(context.Background(), -73.5, 45.5, test.opts)
+
+			if err != nil {
+				t.Fatalf("PointInPolygon failed because %s", err)
+			}
+
+			// the rows come back in the order the query put them in
+
+			if len(rows) != 2 || rows[0].Id != 101736545 || rows[1].Id != 85633041 {
+				t.Errorf("unexpected rows %v", rows)
+			}
+
+			if stmts := db.statements(); len(stmts) != 1 || stmts[0] != test.expected {
+				t.Errorf("expected %s, got %v", test.expected, stmts)
+			}
+		})
+	}
+}
+
+// IntersectsFeature is IntersectsGeometry for the feature's geometry
+
+func TestIntersectsFeatureGeometry(t *testing.T) {
+
+	var query_args [][]driver.Value
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		query_args = append(query_args, args)
+
+		rows := [][]driver.Value{
+			testRow(101736545, 102312317, `{"wof:name":"Montreal"}`, `{"type":"Point","coordinates":[-73.5,45.5]}`),
+		}
+
+		return &testResult{columns: TEST_ROW_COLUMNS, rows: rows}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	ctx := context.Background()
+
+	str_geom := `{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}`
+	str_feature := `{"type":"Feature","properties":{},"geometry":` + str_geom + `}`
+
+	opts := &PgisIntersectsOptions{PlacetypeId: 102312317}
+
+	by_feature, err := client.IntersectsFeature(ctx, []byte(str_feature), opts)
+
+	if err != nil {
+		t.Fatalf("IntersectsFeature failed because %s", err)
+	}
+
+	by_geometry, err := client.IntersectsGeometry(ctx, []byte(str_geom), opts)
+
+	if err != nil {
+		t.Fatalf("IntersectsGeometry failed because %s", err)
+	}
+
+	if len(by_feature) != 1 || len(by_geometry) != 1 || by_feature[0].Id != by_geometry[0].Id {
+		t.Errorf("expected the same results, got %v and %v", by_feature, by_geometry)
+	}
+
+	stmts := db.statements()
+
+	expected := "SELECT " + PGIS_ROW_COLUMNS + " FROM whosonfirst WHERE ST_Intersects(geom, ST_GeomFromGeoJSON($1)::geography) AND placetype_id=$2 ORDER BY id ASC "
+
+	if len(stmts) != 2 || stmts[0] != expected || stmts[1] != expected {
+		t.Errorf("expected %s twice, got %v", expected, stmts)
+	}
+
+	// the feature's geometry is re-encoded so it's compared as a geometry
+
+	for i, args := range query_args {
+
+		if len(args) != 2 || args[1] != int64(102312317) {
+			t.Errorf("unexpected args %v for query %d", args, i)
+			continue
+		}
+
+		var g map[string]interface{}
+
+		err := json.Unmarshal([]byte(args[0].(string)), &g)
+
+		if err != nil || g["type"] != "Polygon" {
+			t.Errorf("expected query %d to be for the polygon, got %v", i, args[0])
+		}
+	}
+
+	// and neither of them gets as far as the database without a geometry
+
+	_, err = client.IntersectsFeature(ctx, []byte("{"), opts)
+
+	if err == nil {
+		t.Errorf("expected IntersectsFeature with an invalid feature to fail")
+	}
+
+	_, err = client.IntersectsGeometry(ctx, []byte{}, opts)
+
+	if err == nil {
+		t.Errorf("expected IntersectsGeometry with no geometry to fail")
+	}
+
+	if len(db.statements()) != 2 {
+		t.Errorf("expected nothing else to be queried, got %v", db.statements())
+	}
+}
+
+func TestIntersectsFeatures(t *testing.T) {
+
+	var query_args []driver.Value
+
+	// the second feature doesn't intersect anything
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		query_args = args
+
+		rows := [][]driver.Value{
+			append([]driver.Value{int64(1)}, testRow(101736545, 102312317, `{"wof:name":"Montreal"}`, `{"type":"Point","coordinates":[-73.5,45.5]}`)...),
+			append([]driver.Value{int64(1)}, testRow(85633041, 102312307, `{"wof:name":"Canada"}`, `{"type":"Point","coordinates":[-106.3,56.1]}`)...),
+			append([]driver.Value{int64(3)}, testRow(85633041, 102312307, `{"wof:name":"Canada"}`, `{"type":"Point","coordinates":[-106.3,56.1]}`)...),
+		}
+
+		return &testResult{columns: append([]string{"query_idx"}, TEST_ROW_COLUMNS...), rows: rows}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	polygon := func(lon int) []byte {
+		return []byte(fmt.Sprintf(`{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":[[[%d,45],[%d,45],[%d,46],[%d,46],[%d,45]]]}}`, lon, lon+1, lon+1, lon, lon))
+	}
+
+	bodies := [][]byte{polygon(-74), polygon(0), polygon(-107)}
+
+	tests := []struct {
+		opts     *PgisIntersectsOptions
+		contains []string
+		args     string
+	}{
+		{
+			nil,
+			[]string{"FROM unnest($1::text[]) WITH ORDINALITY AS q(query_geom, query_idx) JOIN whosonfirst ON ST_Intersects(geom, ST_GeomFromGeoJSON(query_geom)::geography) ORDER BY id ASC"},
+			"[]",
+		},
+		{
+			&PgisIntersectsOptions{Limit: 10, Offset: 20},
+			[]string{
+				"ROW_NUMBER() OVER (PARTITION BY q.query_idx ORDER BY id ASC) AS query_rank FROM unnest($1::text[]) WITH ORDINALITY AS q(query_geom, query_idx)",
+				"WHERE query_rank > $2 AND query_rank <= $3 ORDER BY query_idx, query_rank",
+			},
+			"[20 30]",
+		},
+		{
+			&PgisIntersectsOptions{Offset: 20},
+			[]string{"WHERE query_rank > $2 ORDER BY query_idx, query_rank"},
+			"[20]",
+		},
+	}
+
+	for i, test := range tests {
+
+		results, err := client.IntersectsFeatures(context.Background(), bodies, test.opts)
+
+		if err != nil {
+			t.Fatalf("test %d: IntersectsFeatures failed because %s", i, err)
+		}
+
+		// the results are keyed by the position of each feature in
+		// bodies, counting from 0 rather than 1 like ORDINALITY
+
+		if len(results) != 2 || len(results[0]) != 2 || len(results[2]) != 1 {
+			t.Fatalf("test %d: unexpected results %v", i, results)
+		}
+
+		if results[0][0].Id != 101736545 || results[0][1].Id != 85633041 || results[2][0].Id != 85633041 {
+			t.Errorf("test %d: unexpected results %v", i, results)
+		}
+
+		if _, ok := results[1]; ok {
+			t.Errorf("test %d: expected nothing for a feature that doesn't intersect anything", i)
+		}
+
+		stmts := db.statements()
+		query := stmts[len(stmts)-1]
+
+		for _, expected := range test.contains {
+
+			if !strings.Contains(query, expected) {
+				t.Errorf("test %d: expected %s in %s", i, expected, query)
+			}
+		}
+
+		// one query, for all the features
+
+		if !strings.HasPrefix(fmt.Sprint(query_args[0]), "{") || fmt.Sprint(query_args[1:]) != test.args {
+			t.Errorf("test %d: unexpected args %v", i, query_args)
+		}
+	}
+
+	if len(db.statements()) != len(tests) {
+		t.Errorf("expected one query for each test, got %v", db.statements())
+	}
+}
@@ -0,0 +1,140 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	wof "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
+	"time"
+)
+
+// the table IndexLabel writes to; see the README for its schema
+
+const PGIS_LABELS_TABLE = "whosonfirst_labels"
+
+// IndexLabel indexes feature's centroid (which is to say the point you would
+// use to label it on a map) as the geometry of a record in the
+// whosonfirst_labels table, throwing its actual geometry away. This is for
+// tile and label pipelines that want a lightweight point layer and is not the
+// same as SkipGeometry, which still writes to the whosonfirst table. Records
+// get the same property columns they would in the whosonfirst table so the
+// labels table needs whatever optional columns (like repo or the hierarchy
+// columns) the client has been configured to store.
+
+func (client *PgisClient) IndexLabel(feature geojson.Feature) error {
+	return client.IndexLabelContext(context.Background(), feature)
+}
+
+// IndexLabelContext is IndexLabel with a ctx whose cancellation abandons a
+// record that is still being written
+
+func (client *PgisClient) IndexLabelContext(ctx context.Context, feature geojson.Feature) error {
+
+	wofid := wof.Id(feature)
+
+	t1 := time.Now()
+
+	unlock := client.lockId(wofid)
+
+	err := client.indexLabel(ctx, feature)
+
+	unlock()
+
+	client.reportProgress(wofid, err)
+	client.reportIndexed(t1, err)
+	return err
+}
+
+func (client *PgisClient) indexLabel(ctx context.Context, feature geojson.Feature) error {
+
+	wofid := wof.Id(feature)
+
+	if wofid == 0 {
+		client.Logger.Debug("skipping Earth because it confuses PostGIS")
+		return ErrSkippedEarth
+	}
+
+	centroid, err := wof.Centroid(feature)
+
+	if err != nil {
+		return newPgisError(ErrInvalidGeometry, err)
+	}
+
+	str_centroid, err := centroid.ToString()
+
+	if err != nil {
+		return newPgisError(ErrInvalidGeometry, err)
+	}
+
+	if !hasCoordinates(str_centroid) {
+		msg := fmt.Sprintf("feature %d has no usable centroid", wofid)
+		return newPgisError(ErrInvalidGeometry, errors.New(msg))
+	}
+
+	prop_cols, prop_args, err := client.propertyColumns(feature)
+
+	if err != nil {
+		return err
+	}
+
+	cols := append([]string{"id"}, prop_cols...)
+	args := append([]interface{}{wofid}, prop_args...)
+
+	if client.conflictRepo() {
+		cols = append(cols, "repo")
+		args = append(args, wof.Repo(feature))
+	}
+
+	cols = append(cols, "lastmod")
+	args = append(args, lastModified(feature))
+
+	vals := make([]string, len(cols))
+
+	for i := range cols {
+		vals[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	cols = append(cols, "geom")
+	args = append(args, str_centroid)
+	vals = append(vals, fmt.Sprintf("ST_SetSRID(ST_GeomFromGeoJSON($%d), 4326)", len(args)))
+
+	conflict, err := client.conflictColumns()
+
+	if err != nil {
+		return err
+	}
+
+	sql := upsertTableSQL(PGIS_LABELS_TABLE, cols, vals, conflict)
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, displayArgs(cols, args))
+	}
+
+	if client.SQLWriter != nil {
+		return client.writeSQL([]string{sql}, [][]interface{}{args})
+	}
+
+	if client.Debug {
+		return nil
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	_, err = db.ExecContext(ctx, sql, args...)
+
+	if err != nil {
+		client.Logger.Error("failed to execute query because %s", err)
+		return classifyError(err)
+	}
+
+	return nil
+}
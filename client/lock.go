@@ -0,0 +1,81 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// https://www.postgresql.org/docs/9.6/static/explicit-locking.html#ADVISORY-LOCKS
+
+// AcquireLoadLock returns this when some other session is already holding the
+// lock for the same key
+
+var ErrLocked = errors.New("load lock is held by another process")
+
+// AcquireLoadLock takes a PostgreSQL advisory lock on key so that two loads
+// (from cron, say) don't end up writing to the same table at the same time. It
+// doesn't wait: if another session already has the lock ErrLocked is returned
+// straight away. The lock belongs to a connection which is held until release
+// is called, or the process exits, whichever comes first. That connection
+// doesn't count against the client's maximum number of connections, since
+// nothing else would be able to use the last one, but it is still a connection
+// to PostgreSQL. Advisory locks belong to a session so this can't be used with
+// the "transaction" PoolerMode.
+
+func (client *PgisClient) AcquireLoadLock(ctx context.Context, key int64) (func(), error) {
+
+	err := client.requireSession("the load lock")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// this deliberately doesn't go through dbconn because the connection is
+	// held for as long as the load runs so with -pgis-maxconns 1 nothing
+	// would ever get indexed
+
+	conn, err := client.db.Conn(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sql := "SELECT pg_try_advisory_lock($1)"
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, []interface{}{key})
+	}
+
+	var ok bool
+
+	row := conn.QueryRowContext(ctx, sql, key)
+	err = row.Scan(&ok)
+
+	if err == nil && !ok {
+		err = ErrLocked
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var once sync.Once
+
+	release := func() {
+
+		once.Do(func() {
+
+			_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+
+			if err != nil {
+				client.Logger.Warning("failed to release load lock %d because %s", key, err)
+			}
+
+			conn.Close()
+		})
+	}
+
+	return release, nil
+}
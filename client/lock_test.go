@@ -0,0 +1,101 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// two clients (standing in for two loads) sharing a database whose advisory
+// locks behave the way PostgreSQL's do, which is to say that only one session
+// can hold a given key at a time
+
+func TestAcquireLoadLockContention(t *testing.T) {
+
+	var mu sync.Mutex
+	held := make(map[int64]bool)
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		key := args[0].(int64)
+
+		if strings.Contains(query, "pg_try_advisory_lock") {
+
+			ok := !held[key]
+			held[key] = true
+
+			return &testResult{columns: []string{"pg_try_advisory_lock"}, rows: [][]driver.Value{{ok}}}, nil
+		}
+
+		ok := held[key]
+		delete(held, key)
+
+		return &testResult{columns: []string{"pg_advisory_unlock"}, rows: [][]driver.Value{{ok}}}, nil
+	}
+
+	first, db := newTestClient(t, handler)
+
+	second, err := NewPgisClientWithDriver(TEST_DRIVER, t.Name(), 4)
+
+	if err != nil {
+		t.Fatalf("failed to create second client because %s", err)
+	}
+
+	defer second.Close()
+
+	ctx := context.Background()
+
+	release, err := first.AcquireLoadLock(ctx, 1234)
+
+	if err != nil {
+		t.Fatalf("failed to acquire the load lock because %s", err)
+	}
+
+	// the second load fails straight away, rather than waiting, for the
+	// same key but not for some other one
+
+	_, err = second.AcquireLoadLock(ctx, 1234)
+
+	if err != ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+
+	other, err := second.AcquireLoadLock(ctx, 5678)
+
+	if err != nil {
+		t.Errorf("failed to acquire another load lock because %s", err)
+	} else {
+		other()
+	}
+
+	// until the first one is done, which can happen more than once
+
+	release()
+	release()
+
+	release, err = second.AcquireLoadLock(ctx, 1234)
+
+	if err != nil {
+		t.Fatalf("failed to acquire the load lock once it was released because %s", err)
+	}
+
+	release()
+
+	unlocks := 0
+
+	for _, stmt := range db.statements() {
+
+		if strings.Contains(stmt, "pg_advisory_unlock") {
+			unlocks += 1
+		}
+	}
+
+	if unlocks != 3 {
+		t.Errorf("expected the lock to be released 3 times, got %d", unlocks)
+	}
+}
@@ -0,0 +1,48 @@
+package pgis
+
+import (
+	"errors"
+	"time"
+)
+
+// PgisMetrics is told about everything the client does that's worth counting
+// (or timing) so that it can be exported to something like Prometheus. The
+// methods are called synchronously, and concurrently, so they should be quick
+// and safe for concurrent use. Errors for features that were deliberately
+// skipped (see IsSkipped) are passed to Indexed as-is so it can tell the
+// difference. Looking up a record that doesn't exist isn't a failed query so
+// Queried is passed a nil error for ErrNotFound.
+
+type PgisMetrics interface {
+	Indexed(d time.Duration, err error)  // IndexFeature, IndexGeometry and IndexLabel
+	Executed(d time.Duration, err error) // Exec and the methods that update or delete existing records
+	Queried(d time.Duration, err error)  // the methods that read records (or counts) back
+}
+
+// there's nothing to gain from making a NoopMetrics for people to pass around
+// so a nil PgisClient.Metrics is the no-op and these take care of checking
+
+func (client *PgisClient) reportIndexed(t1 time.Time, err error) {
+
+	if client.Metrics != nil {
+		client.Metrics.Indexed(time.Since(t1), err)
+	}
+}
+
+func (client *PgisClient) reportExecuted(t1 time.Time, err error) {
+
+	if client.Metrics != nil {
+		client.Metrics.Executed(time.Since(t1), err)
+	}
+}
+
+func (client *PgisClient) reportQueried(t1 time.Time, err error) {
+
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+
+	if client.Metrics != nil {
+		client.Metrics.Queried(time.Since(t1), err)
+	}
+}
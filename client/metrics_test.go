@@ -0,0 +1,189 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	mu     sync.Mutex
+	calls  map[string]int
+	errors map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+
+	m := testMetrics{
+		calls:  make(map[string]int),
+		errors: make(map[string]int),
+	}
+
+	return &m
+}
+
+func (m *testMetrics) report(kind string, err error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls[kind] += 1
+
+	if err != nil {
+		m.errors[kind] += 1
+	}
+}
+
+func (m *testMetrics) Indexed(d time.Duration, err error) {
+	m.report("indexed", err)
+}
+
+func (m *testMetrics) Executed(d time.Duration, err error) {
+	m.report("executed", err)
+}
+
+func (m *testMetrics) Queried(d time.Duration, err error) {
+	m.report("queried", err)
+}
+
+var _ PgisMetrics = (*testMetrics)(nil)
+
+func TestMetrics(t *testing.T) {
+
+	// anything that mentions the broken table fails, everything else finds
+	// nothing (or one row, for EXISTS) and changes one row
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.Contains(query, "broken") {
+			return nil, errors.New("relation \"broken\" does not exist")
+		}
+
+		if strings.HasPrefix(query, "SELECT EXISTS") {
+			return &testResult{columns: []string{"exists"}, rows: [][]driver.Value{{true}}}, nil
+		}
+
+		if strings.HasPrefix(query, "SELECT COUNT") {
+			return &testResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}, nil
+		}
+
+		if strings.HasPrefix(query, "SELECT") {
+			return &testResult{columns: TEST_ROW_COLUMNS}, nil
+		}
+
+		return &testResult{affected: 1}, nil
+	}
+
+	client, _ := newTestClient(t, handler)
+
+	metrics := newTestMetrics()
+	client.Metrics = metrics
+
+	ctx := context.Background()
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	calls := []struct {
+		kind string
+		fail bool
+		call func() error
+	}{
+		{"indexed", false, func() error { return client.IndexFeature(f, "test") }},
+		{"queried", false, func() error {
+			_, err := client.GetById(101736545)
+			return ignoreNotFound(err)
+		}},
+		{"queried", false, func() error {
+			_, err := client.Exists(ctx, 101736545)
+			return err
+		}},
+		{"queried", false, func() error {
+			_, err := client.GetByIds(ctx, []int64{101736545, 85633041})
+			return err
+		}},
+		{"queried", false, func() error {
+
+			it, err := client.Query(ctx, nil)
+
+			if err != nil {
+				return err
+			}
+
+			return it.Close()
+		}},
+		{"queried", false, func() error {
+			_, err := client.RawFeature(ctx, 101736545)
+			return ignoreNotFound(err)
+		}},
+		{"queried", false, func() error {
+			_, err := client.Count(ctx, nil)
+			return err
+		}},
+		{"executed", false, func() error { return client.UpdateMeta(ctx, f) }},
+		{"executed", false, func() error {
+			_, err := client.DeleteIds(ctx, []int64{101736545})
+			return err
+		}},
+		{"executed", false, func() error {
+			_, err := client.Touch(ctx, []int64{101736545})
+			return err
+		}},
+		{"executed", false, func() error {
+			_, err := client.SweepStale(ctx, "whosonfirst-data", "run")
+			return err
+		}},
+		{"executed", false, func() error {
+			_, err := client.Exec(ctx, "DELETE FROM whosonfirst WHERE id=$1", 101736545)
+			return err
+		}},
+		{"executed", true, func() error {
+			_, err := client.Exec(ctx, "DELETE FROM broken WHERE id=$1", 101736545)
+			return err
+		}},
+	}
+
+	expected := newTestMetrics()
+
+	for i, c := range calls {
+
+		err := c.call()
+
+		if c.fail != (err != nil) {
+			t.Fatalf("call %d (%s) returned %v", i, c.kind, err)
+		}
+
+		expected.report(c.kind, err)
+	}
+
+	for _, kind := range []string{"indexed", "executed", "queried"} {
+
+		if metrics.calls[kind] != expected.calls[kind] {
+			t.Errorf("expected %d %s calls, got %d", expected.calls[kind], kind, metrics.calls[kind])
+		}
+
+		if metrics.errors[kind] != expected.errors[kind] {
+			t.Errorf("expected %d %s errors, got %d", expected.errors[kind], kind, metrics.errors[kind])
+		}
+	}
+}
+
+// a record that isn't there is still a successful query as far as metrics are
+// concerned
+
+func ignoreNotFound(err error) error {
+
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
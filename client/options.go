@@ -0,0 +1,343 @@
+package pgis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// these are used by IntersectsFeature but also by all the other methods that
+// need to filter records - the name is a historical accident
+
+type PgisIntersectsOptions struct {
+	PlacetypeId    int64     // 0 means any placetype
+	GeometryColumn string    // the column to test against; one of geom, geom_simplified or centroid
+	UseSubdivided  bool      // prune candidates using the whosonfirst_subdivided table (geom only)
+	MinArea        float64   // in square meters, requires the area_meters column; 0 means no minimum
+	MaxArea        float64   // in square meters, requires the area_meters column; 0 means no maximum
+	Repo           string    // "" means any repo
+	Country        string    // "" means any country
+	IsDeprecated   []int64   // match any of these is_deprecated flags; empty means any
+	IsSuperseded   []int64   // match any of these is_superseded flags; empty means any
+	BBox           []float64 // minx, miny, maxx, maxy; empty means anywhere
+	UseBBoxColumn  bool      // prune BBox candidates using the bbox column first
+	ContinentId    int64     // requires the continent_id column; 0 means any continent
+	CountryId      int64     // requires the country_id column; 0 means any country
+	RegionId       int64     // requires the region_id column; 0 means any region
+	LocalityId     int64     // requires the locality_id column; 0 means any locality
+	OrderBy        string    // one of id, placetype, area or distance; "" means the method's default
+	Limit          int64     // the maximum number of results; 0 means no limit (ignored by Count; per feature for IntersectsFeatures)
+	Offset         int64     // the number of results to skip; use with OrderBy for stable pages
+	MakeValid      bool      // test against ST_MakeValid of the stored geometry so invalid records don't fail the query
+	ModifiedSince  time.Time // records whose lastmod is at or after this; the zero time means any time
+	ModifiedBefore time.Time // records whose lastmod is before this; the zero time means any time
+}
+
+func NewDefaultPgisIntersectsOptions() *PgisIntersectsOptions {
+
+	opts := PgisIntersectsOptions{
+		PlacetypeId:    0,
+		GeometryColumn: "geom",
+		UseSubdivided:  false,
+		MinArea:        0.0,
+		MaxArea:        0.0,
+		Repo:           "",
+		Country:        "",
+		IsDeprecated:   []int64{},
+		IsSuperseded:   []int64{},
+		BBox:           []float64{},
+		UseBBoxColumn:  false,
+		ContinentId:    0,
+		CountryId:      0,
+		RegionId:       0,
+		LocalityId:     0,
+		OrderBy:        "",
+		Limit:          0,
+		Offset:         0,
+		MakeValid:      false,
+		ModifiedSince:  time.Time{},
+		ModifiedBefore: time.Time{},
+	}
+
+	return &opts
+}
+
+// SetFilter sets one of a fixed list of filters from a string value, which is
+// meant for things like command line flags; valid keys are: placetype, repo,
+// country, deprecated, superseded, bbox, continent_id, country_id, region_id
+// and locality_id. Flags (deprecated and superseded) may be a comma-separated
+// list of existential flags (-1, 0, 1) and bbox is a comma-separated string of
+// minx, miny, maxx and maxy.
+
+func (opts *PgisIntersectsOptions) SetFilter(key string, value string) error {
+
+	switch key {
+	case "placetype":
+
+		pt, err := placetypes.GetPlacetypeByName(value)
+
+		if err != nil {
+			return err
+		}
+
+		opts.PlacetypeId = pt.Id
+
+	case "repo":
+		opts.Repo = value
+	case "country":
+		opts.Country = value
+	case "deprecated", "superseded":
+
+		fl := make([]int64, 0)
+
+		for _, str_fl := range strings.Split(value, ",") {
+
+			i, err := strconv.ParseInt(strings.TrimSpace(str_fl), 10, 64)
+
+			if err != nil || i < -1 || i > 1 {
+				msg := fmt.Sprintf("invalid %s flag '%s'", key, str_fl)
+				return errors.New(msg)
+			}
+
+			fl = append(fl, i)
+		}
+
+		if key == "deprecated" {
+			opts.IsDeprecated = fl
+		} else {
+			opts.IsSuperseded = fl
+		}
+
+	case "bbox":
+
+		parts := strings.Split(value, ",")
+
+		if len(parts) != 4 {
+			msg := fmt.Sprintf("invalid bbox '%s'", value)
+			return errors.New(msg)
+		}
+
+		bbox := make([]float64, 4)
+
+		for i, str_c := range parts {
+
+			c, err := strconv.ParseFloat(strings.TrimSpace(str_c), 64)
+
+			if err != nil {
+				msg := fmt.Sprintf("invalid bbox '%s'", value)
+				return errors.New(msg)
+			}
+
+			bbox[i] = c
+		}
+
+		opts.BBox = bbox
+
+	case "continent_id", "country_id", "region_id", "locality_id":
+
+		id, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil {
+			msg := fmt.Sprintf("invalid %s '%s'", key, value)
+			return errors.New(msg)
+		}
+
+		switch key {
+		case "continent_id":
+			opts.ContinentId = id
+		case "country_id":
+			opts.CountryId = id
+		case "region_id":
+			opts.RegionId = id
+		default:
+			opts.LocalityId = id
+		}
+
+	default:
+		msg := fmt.Sprintf("invalid filter '%s'", key)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// append any non-spatial conditions defined by opts to where (and their values
+// to args) numbering placeholders after whatever is already in args
+
+func (opts *PgisIntersectsOptions) filters(cols pgisColumns, where []string, args []interface{}) ([]string, []interface{}) {
+
+	if opts.PlacetypeId != 0 {
+		args = append(args, opts.PlacetypeId)
+		where = append(where, fmt.Sprintf("placetype_id=$%d", len(args)))
+	}
+
+	if opts.MinArea > 0.0 {
+		args = append(args, opts.MinArea)
+		where = append(where, fmt.Sprintf("area_meters >= $%d", len(args)))
+	}
+
+	if opts.MaxArea > 0.0 {
+		args = append(args, opts.MaxArea)
+		where = append(where, fmt.Sprintf("area_meters <= $%d", len(args)))
+	}
+
+	if opts.Repo != "" {
+		args = append(args, opts.Repo)
+		where = append(where, fmt.Sprintf("meta->>'wof:repo'=$%d", len(args)))
+	}
+
+	if opts.Country != "" {
+		args = append(args, opts.Country)
+		where = append(where, fmt.Sprintf("meta->>'wof:country'=$%d", len(args)))
+	}
+
+	if len(opts.IsDeprecated) > 0 {
+		args = append(args, pq.Array(opts.IsDeprecated))
+		where = append(where, fmt.Sprintf("is_deprecated = ANY($%d)", len(args)))
+	}
+
+	if len(opts.IsSuperseded) > 0 {
+		args = append(args, pq.Array(opts.IsSuperseded))
+		where = append(where, fmt.Sprintf("is_superseded = ANY($%d)", len(args)))
+	}
+
+	// these can use the by_lastmod index; the times are sent the same way
+	// lastmod is written (see Touch) so that this works on databases where
+	// it's a CHAR(25) too
+
+	if !opts.ModifiedSince.IsZero() {
+		args = append(args, opts.ModifiedSince.Format(time.RFC3339))
+		where = append(where, fmt.Sprintf("lastmod >= $%d", len(args)))
+	}
+
+	if !opts.ModifiedBefore.IsZero() {
+		args = append(args, opts.ModifiedBefore.Format(time.RFC3339))
+		where = append(where, fmt.Sprintf("lastmod < $%d", len(args)))
+	}
+
+	ancestors := map[string]int64{
+		"continent_id": opts.ContinentId,
+		"country_id":   opts.CountryId,
+		"region_id":    opts.RegionId,
+		"locality_id":  opts.LocalityId,
+	}
+
+	for _, col := range HIERARCHY_COLUMNS {
+
+		id := ancestors[col]
+
+		if id != 0 {
+			args = append(args, id)
+			where = append(where, fmt.Sprintf("%s=$%d", col, len(args)))
+		}
+	}
+
+	// Point geometries are only stored in the centroid column - see notes
+	// in IndexFeature
+
+	if len(opts.BBox) == 4 {
+		args = append(args, opts.BBox[0], opts.BBox[1], opts.BBox[2], opts.BBox[3])
+		i := len(args)
+
+		// the bbox column is a plain geometry so the && (bounding box
+		// intersects) test is about as cheap as it gets; records without
+		// a geometry, which is to say points, don't have a bbox so they
+		// still need to be tested against their centroid
+
+		if opts.UseBBoxColumn {
+			where = append(where, fmt.Sprintf("(bbox IS NULL OR bbox && ST_MakeEnvelope($%d, $%d, $%d, $%d, 4326))", i-3, i-2, i-1, i))
+		}
+
+		st_bbox := fmt.Sprintf("ST_MakeEnvelope($%d, $%d, $%d, $%d, 4326)::geography", i-3, i-2, i-1, i)
+		where = append(where, opts.spatialTest("ST_Intersects", fmt.Sprintf("COALESCE(%s, %s)", cols.geom, cols.centroid), st_bbox))
+	}
+
+	return where, args
+}
+
+// http://postgis.net/docs/ST_MakeValid.html
+
+// the SQL to test the stored geometry col against other (a geography) using
+// predicate, which is ST_Intersects or ST_Covers. An invalid stored geometry
+// makes GEOS throw an error that fails the whole query so if opts.MakeValid is
+// set the test is run against ST_MakeValid(col) instead. That can't use the
+// spatial index so the (bounding box only, and therefore safe) && test is done
+// first to keep it to the records that might actually match.
+
+func (opts *PgisIntersectsOptions) spatialTest(predicate string, col string, other string) string {
+
+	if !opts.MakeValid {
+		return fmt.Sprintf("%s(%s, %s)", predicate, col, other)
+	}
+
+	return fmt.Sprintf("(%s && %s AND %s(ST_MakeValid(%s::geometry)::geography, %s))", col, other, predicate, col, other)
+}
+
+// the ORDER BY clause for opts.OrderBy, or default if it's empty, where
+// query_geom is the SQL expression for the geometry being queried (used to sort
+// by distance from its centroid) or "" if there isn't one. Everything is sorted
+// by id last so that the order of results is always the same.
+
+func (opts *PgisIntersectsOptions) orderBy(cols pgisColumns, default_order string, query_geom string) (string, error) {
+
+	order := opts.OrderBy
+
+	if order == "" {
+		order = default_order
+	}
+
+	switch order {
+	case "id":
+		return "ORDER BY id ASC", nil
+	case "placetype":
+		return "ORDER BY placetype_id ASC, id ASC", nil
+	case "area":
+
+		// Point geometries are only stored in the centroid column - see
+		// notes in IndexFeature
+
+		return fmt.Sprintf("ORDER BY ST_Area(%s) ASC NULLS LAST, id ASC", cols.geom), nil
+
+	case "distance":
+
+		if query_geom == "" {
+			return "", errors.New("can not order by distance without a geometry to measure from")
+		}
+
+		return fmt.Sprintf("ORDER BY ST_Distance(%s, ST_Centroid(%s)::geography) ASC, id ASC", cols.centroid, query_geom), nil
+
+	default:
+		msg := fmt.Sprintf("invalid order '%s'", order)
+		return "", errors.New(msg)
+	}
+}
+
+// returns true if opts asks for a page of results rather than all of them
+
+func (opts *PgisIntersectsOptions) paginated() bool {
+	return opts.Limit > 0 || opts.Offset > 0
+}
+
+// the LIMIT and OFFSET clauses (if any) for opts, with their values appended
+// to args
+
+func (opts *PgisIntersectsOptions) pagination(args []interface{}) (string, []interface{}) {
+
+	clauses := make([]string, 0)
+
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		clauses = append(clauses, fmt.Sprintf("LIMIT $%d", len(args)))
+	}
+
+	if opts.Offset > 0 {
+		args = append(args, opts.Offset)
+		clauses = append(clauses, fmt.Sprintf("OFFSET $%d", len(args)))
+	}
+
+	return strings.Join(clauses, " "), args
+}
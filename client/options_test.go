@@ -0,0 +1,228 @@
+package pgis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetFilter(t *testing.T) {
+
+	tests := []struct {
+		key   string
+		value string
+		want  string // the relevant fields of opts, see below
+		err   bool
+	}{
+		{"placetype", "locality", "placetype=102312317", false},
+		{"placetype", "not-a-placetype", "", true},
+		{"repo", "whosonfirst-data-admin-ca", "repo=whosonfirst-data-admin-ca", false},
+		{"country", "CA", "country=CA", false},
+		{"deprecated", "0", "deprecated=[0]", false},
+		{"superseded", "-1, 0", "superseded=[-1 0]", false},
+		{"deprecated", "2", "", true},
+		{"superseded", "yes", "", true},
+		{"bbox", "-74, 45, -73, 46", "bbox=[-74 45 -73 46]", false},
+		{"bbox", "-74,45,-73", "", true},
+		{"bbox", "-74,45,-73,north", "", true},
+		{"continent_id", "102191575", "continent_id=102191575", false},
+		{"country_id", "85633041", "country_id=85633041", false},
+		{"region_id", "85682057", "region_id=85682057", false},
+		{"locality_id", "101736545", "locality_id=101736545", false},
+		{"locality_id", "montreal", "", true},
+		{"alt_label", "quattroshapes", "", true},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultPgisIntersectsOptions()
+
+		err := opts.SetFilter(test.key, test.value)
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("SetFilter(%q, %q) succeeded, expected an error", test.key, test.value)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("SetFilter(%q, %q) failed because %s", test.key, test.value, err)
+			continue
+		}
+
+		var got string
+
+		switch test.key {
+		case "placetype":
+			got = fmt.Sprintf("placetype=%d", opts.PlacetypeId)
+		case "repo":
+			got = fmt.Sprintf("repo=%s", opts.Repo)
+		case "country":
+			got = fmt.Sprintf("country=%s", opts.Country)
+		case "deprecated":
+			got = fmt.Sprintf("deprecated=%v", opts.IsDeprecated)
+		case "superseded":
+			got = fmt.Sprintf("superseded=%v", opts.IsSuperseded)
+		case "bbox":
+			got = fmt.Sprintf("bbox=%v", opts.BBox)
+		case "continent_id":
+			got = fmt.Sprintf("continent_id=%d", opts.ContinentId)
+		case "country_id":
+			got = fmt.Sprintf("country_id=%d", opts.CountryId)
+		case "region_id":
+			got = fmt.Sprintf("region_id=%d", opts.RegionId)
+		case "locality_id":
+			got = fmt.Sprintf("locality_id=%d", opts.LocalityId)
+		}
+
+		if got != test.want {
+			t.Errorf("SetFilter(%q, %q) set %s, expected %s", test.key, test.value, got, test.want)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+
+	cols := (&PgisClient{}).columns()
+
+	tests := []struct {
+		opts  *PgisIntersectsOptions
+		where string
+		args  int
+	}{
+		{
+			opts:  NewDefaultPgisIntersectsOptions(),
+			where: "",
+			args:  0,
+		},
+		{
+			opts:  &PgisIntersectsOptions{PlacetypeId: 102312317, Repo: "whosonfirst-data", IsDeprecated: []int64{0}},
+			where: "placetype_id=$2 AND meta->>'wof:repo'=$3 AND is_deprecated = ANY($4)",
+			args:  3,
+		},
+		{
+			opts:  &PgisIntersectsOptions{MinArea: 10.0, MaxArea: 20.0, Country: "CA", IsSuperseded: []int64{0, 1}},
+			where: "area_meters >= $2 AND area_meters <= $3 AND meta->>'wof:country'=$4 AND is_superseded = ANY($5)",
+			args:  4,
+		},
+		{
+			opts:  &PgisIntersectsOptions{PlacetypeId: 102312317, ModifiedSince: time.Unix(1500000000, 0), ModifiedBefore: time.Unix(1600000000, 0)},
+			where: "placetype_id=$2 AND lastmod >= $3 AND lastmod < $4",
+			args:  3,
+		},
+		{
+			opts:  &PgisIntersectsOptions{CountryId: 85633041, LocalityId: 101736545},
+			where: "country_id=$2 AND locality_id=$3",
+			args:  2,
+		},
+		{
+			opts:  &PgisIntersectsOptions{BBox: []float64{-74, 45, -73, 46}, UseBBoxColumn: true},
+			where: "(bbox IS NULL OR bbox && ST_MakeEnvelope($2, $3, $4, $5, 4326)) AND ST_Intersects(COALESCE(geom, centroid), ST_MakeEnvelope($2, $3, $4, $5, 4326)::geography)",
+			args:  4,
+		},
+	}
+
+	for i, test := range tests {
+
+		// the placeholders are numbered after anything that's already
+		// in args
+
+		where, args := test.opts.filters(cols, []string{}, []interface{}{"query"})
+
+		if strings.Join(where, " AND ") != test.where {
+			t.Errorf("test %d: filters returned %q, expected %q", i, strings.Join(where, " AND "), test.where)
+		}
+
+		if len(args) != test.args+1 {
+			t.Errorf("test %d: filters returned %d args, expected %d", i, len(args)-1, test.args)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+
+	cols := (&PgisClient{}).columns()
+
+	tests := []struct {
+		order      string
+		fallback   string
+		query_geom string
+		expected   string
+		err        bool
+	}{
+		{"id", "placetype", "", "ORDER BY id ASC", false},
+		{"placetype", "id", "", "ORDER BY placetype_id ASC, id ASC", false},
+		{"area", "id", "", "ORDER BY ST_Area(geom) ASC NULLS LAST, id ASC", false},
+		{"distance", "id", "ST_GeomFromGeoJSON($1)", "ORDER BY ST_Distance(centroid, ST_Centroid(ST_GeomFromGeoJSON($1))::geography) ASC, id ASC", false},
+		{"distance", "id", "", "", true},
+		{"", "id", "", "ORDER BY id ASC", false},
+		{"", "area", "", "ORDER BY ST_Area(geom) ASC NULLS LAST, id ASC", false},
+		{"name", "id", "", "", true},
+	}
+
+	for _, test := range tests {
+
+		opts := &PgisIntersectsOptions{OrderBy: test.order}
+
+		order, err := opts.orderBy(cols, test.fallback, test.query_geom)
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("expected ordering by '%s' with no geometry '%s' to fail", test.order, test.query_geom)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("failed to order by '%s' because %s", test.order, err)
+			continue
+		}
+
+		if order != test.expected {
+			t.Errorf("orderBy('%s') returned %q, expected %q", test.order, order, test.expected)
+		}
+	}
+}
+
+func TestPagination(t *testing.T) {
+
+	tests := []struct {
+		limit    int64
+		offset   int64
+		expected string
+		args     []interface{}
+	}{
+		{0, 0, "", []interface{}{"query"}},
+		{10, 0, "LIMIT $2", []interface{}{"query", 10}},
+		{0, 20, "OFFSET $2", []interface{}{"query", 20}},
+		{10, 20, "LIMIT $2 OFFSET $3", []interface{}{"query", 10, 20}},
+	}
+
+	for _, test := range tests {
+
+		opts := &PgisIntersectsOptions{Limit: test.limit, Offset: test.offset}
+
+		if opts.paginated() != (test.limit > 0 || test.offset > 0) {
+			t.Errorf("expected limit %d and offset %d to be paginated", test.limit, test.offset)
+		}
+
+		// the placeholders are numbered after anything that's already
+		// in args
+
+		page, args := opts.pagination([]interface{}{"query"})
+
+		if page != test.expected {
+			t.Errorf("pagination for limit %d and offset %d returned %q, expected %q", test.limit, test.offset, page, test.expected)
+		}
+
+		if fmt.Sprint(args) != fmt.Sprint(test.args) {
+			t.Errorf("pagination for limit %d and offset %d returned args %v, expected %v", test.limit, test.offset, args, test.args)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package pgis
+
+import (
+	"errors"
+	"fmt"
+)
+
+// http://postgis.net/docs/ST_ForcePolygonCCW.html
+// http://postgis.net/docs/ST_ForcePolygonCW.html
+
+// these are the valid values for PgisClient.RingOrientation. RFC 7946 says
+// exterior rings should be counter-clockwise (and holes clockwise) but plenty
+// of GeoJSON, and the older spec, doesn't care while some consumers, like
+// ST_AsMVT, expect the opposite. The default is to store rings the way they
+// were found.
+
+const PGIS_RING_ORIENTATION_CCW = "ccw"
+const PGIS_RING_ORIENTATION_CW = "cw"
+
+// st_geom with its rings forced in to the client's RingOrientation (which is
+// harmless for anything that isn't a polygon)
+
+func (client *PgisClient) orientRings(st_geom string) (string, error) {
+
+	switch client.RingOrientation {
+	case "":
+		return st_geom, nil
+	case PGIS_RING_ORIENTATION_CCW:
+		return fmt.Sprintf("ST_ForcePolygonCCW(%s)", st_geom), nil
+	case PGIS_RING_ORIENTATION_CW:
+		return fmt.Sprintf("ST_ForcePolygonCW(%s)", st_geom), nil
+	default:
+		msg := fmt.Sprintf("invalid ring orientation '%s'", client.RingOrientation)
+		return "", errors.New(msg)
+	}
+}
@@ -0,0 +1,47 @@
+package pgis
+
+import (
+	"testing"
+)
+
+func TestOrientRings(t *testing.T) {
+
+	tests := []struct {
+		orientation string
+		expected    string
+		err         bool
+	}{
+		{"", "ST_GeomFromGeoJSON($1)", false},
+		{PGIS_RING_ORIENTATION_CCW, "ST_ForcePolygonCCW(ST_GeomFromGeoJSON($1))", false},
+		{PGIS_RING_ORIENTATION_CW, "ST_ForcePolygonCW(ST_GeomFromGeoJSON($1))", false},
+		{"CCW", "", true},
+		{"clockwise", "", true},
+	}
+
+	for _, test := range tests {
+
+		client := &PgisClient{
+			RingOrientation: test.orientation,
+		}
+
+		st_geom, err := client.orientRings("ST_GeomFromGeoJSON($1)")
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("expected ring orientation %q to be refused, got %s", test.orientation, st_geom)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ring orientation %q failed because %s", test.orientation, err)
+			continue
+		}
+
+		if st_geom != test.expected {
+			t.Errorf("expected ring orientation %q to give %s, got %s", test.orientation, test.expected, st_geom)
+		}
+	}
+}
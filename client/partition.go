@@ -0,0 +1,105 @@
+package pgis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// https://www.postgresql.org/docs/11/static/ddl-partitioning.html
+
+// when PgisClient.CreatePartitions is true the whosonfirst table is assumed to
+// be partitioned with PARTITION BY LIST (placetype_id) and a partition called
+// whosonfirst_{PLACETYPE} is created, if necessary, the first time a record
+// with that placetype is indexed; records are always written to the parent
+// table and PostgreSQL routes them to the right partition. If a record's
+// placetype changes, its old row (in the old partition) is deleted in the same
+// transaction as the new one is written, see staleSQL.
+
+func partitionSQL(placetype_id int64, placetype string) (string, error) {
+
+	name := fmt.Sprintf("whosonfirst_%s", placetype)
+
+	err := validateIdent(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF whosonfirst FOR VALUES IN (%d)", quoteIdent(name), placetype_id), nil
+}
+
+// the state of a single partition; mu is held until the partition has actually
+// been created (or written out, see SQLWriter) so that nothing else tries to
+// insert in to it first
+
+type pgisPartition struct {
+	mu   sync.Mutex
+	done bool
+}
+
+// create the partition for placetype_id if this client hasn't already; the
+// statement is idempotent so it doesn't matter if another process got there
+// first
+
+func (client *PgisClient) ensurePartition(ctx context.Context, placetype_id int64, placetype string) error {
+
+	if !client.CreatePartitions {
+		return nil
+	}
+
+	v, _ := client.partitions.LoadOrStore(placetype_id, &pgisPartition{})
+	p := v.(*pgisPartition)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return nil
+	}
+
+	sql, err := partitionSQL(placetype_id, placetype)
+
+	if err != nil {
+		return err
+	}
+
+	if client.Verbose {
+		client.Logger.Status("%s", sql)
+	}
+
+	err = client.createPartition(ctx, sql)
+
+	// if it failed p.done stays false and the next caller tries again
+
+	if err != nil {
+		return err
+	}
+
+	p.done = true
+	return nil
+}
+
+func (client *PgisClient) createPartition(ctx context.Context, sql string) error {
+
+	if client.SQLWriter != nil {
+		return client.writeSQL([]string{sql}, [][]interface{}{nil})
+	}
+
+	if client.Debug {
+		return nil
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	_, err = db.ExecContext(ctx, sql)
+	return err
+}
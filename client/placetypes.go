@@ -0,0 +1,81 @@
+package pgis
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"sync"
+)
+
+// placetypes.GetPlacetypeByName (and ById) loop over the entire placetypes
+// specification every time they're called, which adds up when indexing
+// millions of records, so lookups (including failed ones) are memoized here
+
+type placetypeLookup struct {
+	placetype *placetypes.WOFPlacetype
+	err       error
+}
+
+var placetypes_by_name = new(sync.Map)
+var placetypes_by_id = new(sync.Map)
+
+func placetypeByName(name string) (*placetypes.WOFPlacetype, error) {
+
+	v, ok := placetypes_by_name.Load(name)
+
+	if !ok {
+		pt, err := placetypes.GetPlacetypeByName(name)
+		v, _ = placetypes_by_name.LoadOrStore(name, placetypeLookup{pt, err})
+	}
+
+	lookup := v.(placetypeLookup)
+	return lookup.placetype, lookup.err
+}
+
+func placetypeById(id int64) (*placetypes.WOFPlacetype, error) {
+
+	v, ok := placetypes_by_id.Load(id)
+
+	if !ok {
+		pt, err := placetypes.GetPlacetypeById(id)
+		v, _ = placetypes_by_id.LoadOrStore(id, placetypeLookup{pt, err})
+	}
+
+	lookup := v.(placetypeLookup)
+	return lookup.placetype, lookup.err
+}
+
+// PgisPlacetypeResolver returns the placetype for a name that the placetypes
+// package doesn't know about, for example one that was added to Who's On First
+// after the version vendored here
+
+type PgisPlacetypeResolver func(name string) (*placetypes.WOFPlacetype, error)
+
+// ResolvePlacetype returns the placetype for name the same way the client does
+// when it indexes a feature, which is to say including any that are only known
+// to its PlacetypeResolver. It returns an ErrUnknownPlacetype error otherwise.
+
+func (client *PgisClient) ResolvePlacetype(name string) (*placetypes.WOFPlacetype, error) {
+	return client.resolvePlacetype(name)
+}
+
+// the placetype for name according to the placetypes package or, failing that,
+// the client's PlacetypeResolver if it has one; results from the resolver are
+// not memoized so if it's expensive it should do that itself
+
+func (client *PgisClient) resolvePlacetype(name string) (*placetypes.WOFPlacetype, error) {
+
+	pt, err := placetypeByName(name)
+
+	if err == nil {
+		return pt, nil
+	}
+
+	if client.PlacetypeResolver != nil {
+		pt, err = client.PlacetypeResolver(name)
+	}
+
+	if err != nil {
+		return nil, newPgisError(ErrUnknownPlacetype, err)
+	}
+
+	return pt, nil
+}
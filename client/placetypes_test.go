@@ -0,0 +1,160 @@
+package pgis
+
+import (
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"testing"
+)
+
+func TestPlacetypeCache(t *testing.T) {
+
+	tests := []struct {
+		name string
+		id   int64
+		err  bool
+	}{
+		{"locality", 102312317, false},
+		{"country", 102312307, false},
+		{"planetoid", 0, true},
+	}
+
+	for _, test := range tests {
+
+		// the second lookup comes from the cache and should be
+		// indistinguishable from the first
+
+		for i := 0; i < 2; i++ {
+
+			pt, err := placetypeByName(test.name)
+
+			if test.err {
+
+				if err == nil {
+					t.Errorf("expected looking up %s to fail", test.name)
+				}
+
+				_, ok := placetypes_by_name.Load(test.name)
+
+				if !ok {
+					t.Errorf("expected the failed lookup for %s to be remembered", test.name)
+				}
+
+				continue
+			}
+
+			if err != nil {
+				t.Fatalf("failed to look up %s because %s", test.name, err)
+			}
+
+			if pt.Id != test.id {
+				t.Errorf("expected %s to be %d, got %d", test.name, test.id, pt.Id)
+			}
+
+			by_id, err := placetypeById(test.id)
+
+			if err != nil {
+				t.Fatalf("failed to look up %d because %s", test.id, err)
+			}
+
+			if by_id.Name != test.name {
+				t.Errorf("expected %d to be %s, got %s", test.id, test.name, by_id.Name)
+			}
+		}
+	}
+
+	cached, _ := placetypeByName("locality")
+	again, _ := placetypeByName("locality")
+
+	if cached != again {
+		t.Errorf("expected the same placetype to be returned for every lookup")
+	}
+}
+
+func TestResolvePlacetype(t *testing.T) {
+
+	calls := 0
+
+	resolver := func(name string) (*placetypes.WOFPlacetype, error) {
+
+		calls += 1
+
+		if name == "planetoid" {
+			return &placetypes.WOFPlacetype{Id: 1, Name: name, Role: "common", Parent: []int64{}}, nil
+		}
+
+		return nil, errors.New("unknown")
+	}
+
+	tests := []struct {
+		resolver PgisPlacetypeResolver
+		name     string
+		id       int64
+		calls    int
+		err      bool
+	}{
+		{nil, "locality", 102312317, 0, false},
+		{nil, "planetoid", 0, 0, true},
+		{resolver, "locality", 102312317, 0, false},
+		{resolver, "planetoid", 1, 1, false},
+		{resolver, "planetoid", 1, 1, false}, // not memoized
+		{resolver, "asteroid", 0, 1, true},
+	}
+
+	for _, test := range tests {
+
+		calls = 0
+
+		client := &PgisClient{
+			PlacetypeResolver: test.resolver,
+		}
+
+		pt, err := client.ResolvePlacetype(test.name)
+
+		if calls != test.calls {
+			t.Errorf("expected the resolver to be called %d times for %s, got %d", test.calls, test.name, calls)
+		}
+
+		if test.err {
+
+			if !errors.Is(err, ErrUnknownPlacetype) {
+				t.Errorf("expected %s to be an unknown placetype, got %v", test.name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("failed to resolve %s because %s", test.name, err)
+			continue
+		}
+
+		if pt.Id != test.id {
+			t.Errorf("expected %s to be %d, got %d", test.name, test.id, pt.Id)
+		}
+	}
+}
+
+// the memoized lookups against the ones in the placetypes package, for a known
+// placetype and an unknown one
+
+func BenchmarkResolvePlacetype(b *testing.B) {
+
+	client := &PgisClient{}
+
+	for _, name := range []string{"locality", "planetoid"} {
+
+		b.Run(name, func(b *testing.B) {
+
+			for i := 0; i < b.N; i++ {
+				client.resolvePlacetype(name)
+			}
+		})
+
+		b.Run(name+"-uncached", func(b *testing.B) {
+
+			for i := 0; i < b.N; i++ {
+				placetypes.GetPlacetypeByName(name)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package pgis
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// when PgisClient.PointsTable is set features with a Point geometry (which is
+// to say most venues) are written to that table rather than the whosonfirst
+// table, which is left with the polygons (and lines). The points table needs
+// the same columns as the whosonfirst table, in the same order, which is what
+// CreateSchema does. The methods that read records back look in both tables, as
+// do the ones that update or delete records by ID, and if a record's geometry
+// changes from a point to a polygon, or back, the old copy is deleted when the
+// new one is written. Prune only looks in the whosonfirst table.
+
+// returns the (quoted) name of the table to write a record with a geom_type
+// geometry to, or "" for the whosonfirst table
+
+func (client *PgisClient) routeTable(geom_type string) (string, error) {
+
+	if client.PointsTable == "" || geom_type != "Point" {
+		return "", nil
+	}
+
+	err := validateIdent(client.PointsTable)
+
+	if err != nil {
+		msg := fmt.Sprintf("invalid points table name because %s", err)
+		return "", errors.New(msg)
+	}
+
+	return quoteIdent(client.PointsTable), nil
+}
+
+// all the tables a record might have been written to
+
+func (client *PgisClient) recordTables() ([]string, error) {
+
+	tables := []string{"whosonfirst"}
+
+	table, err := client.routeTable("Point")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if table != "" {
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+// the FROM clause for reading records back: the whosonfirst table or, if there
+// is a points table, both of them together under the whosonfirst name so that
+// nothing else in the query needs to know
+
+func (client *PgisClient) recordSource() (string, error) {
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(tables) == 1 {
+		return tables[0], nil
+	}
+
+	selects := make([]string, len(tables))
+
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf("SELECT * FROM %s", table)
+	}
+
+	return fmt.Sprintf("(%s) AS whosonfirst", strings.Join(selects, " UNION ALL ")), nil
+}
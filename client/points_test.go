@@ -0,0 +1,246 @@
+package pgis
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"strings"
+	"testing"
+)
+
+func TestPointsTable(t *testing.T) {
+
+	tests := []struct {
+		points string
+		geom   string
+		insert string
+		delete string
+	}{
+		{"", `{"type":"Point","coordinates":[-73.5,45.5]}`, "INSERT INTO whosonfirst ", ""},
+		{"whosonfirst_points", `{"type":"Point","coordinates":[-73.5,45.5]}`, `INSERT INTO "whosonfirst_points" `, "DELETE FROM whosonfirst WHERE id=101736545;"},
+		{"whosonfirst_points", `{"type":"Polygon","coordinates":[[[-74,45],[-73,45],[-73,46],[-74,46],[-74,45]]]}`, "INSERT INTO whosonfirst ", `DELETE FROM "whosonfirst_points" WHERE id=101736545;`},
+	}
+
+	for _, test := range tests {
+
+		body := `{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-74,45,-73,46"},"geometry":` + test.geom + `}`
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatalf("failed to load feature because %s", err)
+		}
+
+		wr := &bytes.Buffer{}
+
+		client := &PgisClient{
+			PointsTable: test.points,
+			Logger:      log.SimpleWOFLogger("test"),
+			SQLWriter:   wr,
+		}
+
+		err = client.IndexFeature(f, "test")
+
+		if err != nil {
+			t.Fatalf("IndexFeature failed because %s", err)
+		}
+
+		if !strings.Contains(wr.String(), test.insert) {
+			t.Errorf("expected %q with points table %q to contain %q, got %q", test.geom, test.points, test.insert, wr.String())
+		}
+
+		// the old copy of the record, from whichever table it isn't being
+		// written to, is deleted in the same transaction
+
+		if test.delete == "" {
+
+			if strings.Contains(wr.String(), "DELETE") {
+				t.Errorf("expected %q without a points table not to delete anything, got %q", test.geom, wr.String())
+			}
+
+			continue
+		}
+
+		expected := "BEGIN;\n" + test.delete + "\n" + test.insert
+
+		if !strings.HasPrefix(wr.String(), expected) {
+			t.Errorf("expected %q with points table %q to start with %q, got %q", test.geom, test.points, expected, wr.String())
+		}
+	}
+}
+
+func TestRecordTables(t *testing.T) {
+
+	client := &PgisClient{}
+
+	tables, err := client.recordTables()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(tables, ",") != "whosonfirst" {
+		t.Errorf("expected only the whosonfirst table, got %v", tables)
+	}
+
+	client.PointsTable = "whosonfirst_points"
+
+	tables, err = client.recordTables()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(tables, ",") != `whosonfirst,"whosonfirst_points"` {
+		t.Errorf("expected the whosonfirst and points tables, got %v", tables)
+	}
+}
+
+// a point that has been indexed can be read back and updated even though it
+// isn't in the whosonfirst table
+
+func TestPointsTableRoundTrip(t *testing.T) {
+
+	body := `{"type":"Feature","id":1108955149,"properties":{"wof:id":1108955149,"wof:name":"Olympic Stadium","wof:placetype":"venue","wof:parent_id":-1,"wof:repo":"whosonfirst-data-venue-ca","geom:latitude":45.558,"geom:longitude":-73.552,"geom:bbox":"-73.552,45.558,-73.552,45.558"},"geometry":{"type":"Point","coordinates":[-73.552,45.558]}}`
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	points := `"whosonfirst_points"`
+
+	// the only record is in the points table so anything that doesn't
+	// look there finds nothing
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if !strings.Contains(query, points) {
+			return &testResult{columns: TEST_ROW_COLUMNS}, nil
+		}
+
+		if strings.HasPrefix(query, "SELECT") {
+			row := testRow(1108955149, 102312325, `{"wof:name":"Olympic Stadium"}`, `{"type":"Point","coordinates":[-73.552,45.558]}`)
+			return &testResult{columns: TEST_ROW_COLUMNS, rows: [][]driver.Value{row}}, nil
+		}
+
+		return &testResult{affected: 1}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+	client.PointsTable = "whosonfirst_points"
+
+	err = client.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature failed because %s", err)
+	}
+
+	row, err := client.GetById(1108955149)
+
+	if err != nil {
+		t.Fatalf("GetById failed because %s", err)
+	}
+
+	if row.Id != 1108955149 {
+		t.Errorf("GetById returned %d, expected 1108955149", row.Id)
+	}
+
+	rows, err := client.GetByIds(context.Background(), []int64{1108955149})
+
+	if err != nil {
+		t.Fatalf("GetByIds failed because %s", err)
+	}
+
+	if len(rows) != 1 {
+		t.Errorf("GetByIds returned %d records, expected 1", len(rows))
+	}
+
+	err = client.UpdateMeta(context.Background(), f)
+
+	if err != nil {
+		t.Errorf("UpdateMeta failed because %s", err)
+	}
+
+	touched, err := client.Touch(context.Background(), []int64{1108955149})
+
+	if err != nil {
+		t.Errorf("Touch failed because %s", err)
+	}
+
+	if touched != 1 {
+		t.Errorf("Touch updated %d records, expected 1", touched)
+	}
+
+	stmts := db.statements()
+
+	expected := []string{
+		"BEGIN",
+		"DELETE FROM whosonfirst WHERE id=$1",
+		`INSERT INTO "whosonfirst_points" `,
+		"COMMIT",
+	}
+
+	if len(stmts) < len(expected) {
+		t.Fatalf("expected at least %d statements, got %v", len(expected), stmts)
+	}
+
+	for i, prefix := range expected {
+
+		if !strings.HasPrefix(stmts[i], prefix) {
+			t.Errorf("expected statement %d to start with %q, got %q", i, prefix, stmts[i])
+		}
+	}
+}
+
+func TestPointsTableIndexGeometry(t *testing.T) {
+
+	tests := []struct {
+		format   string
+		geometry []byte
+		table    string
+	}{
+		{GEOMETRY_FORMAT_GEOJSON, []byte(`{"type":"Point","coordinates":[-73.5,45.5]}`), `INSERT INTO "whosonfirst_points" `},
+		{GEOMETRY_FORMAT_GEOJSON, []byte(`{"type":"MultiPoint","coordinates":[[-73.5,45.5]]}`), "INSERT INTO whosonfirst "},
+		{GEOMETRY_FORMAT_WKT, []byte("POINT(-73.5 45.5)"), `INSERT INTO "whosonfirst_points" `},
+		{GEOMETRY_FORMAT_WKT, []byte(" point z (-73.5 45.5 0)"), `INSERT INTO "whosonfirst_points" `},
+		{GEOMETRY_FORMAT_WKT, []byte("POLYGON((-74 45,-73 45,-73 46,-74 45))"), "INSERT INTO whosonfirst "},
+		{GEOMETRY_FORMAT_WKB, []byte{0x01, 0x01, 0x00, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, `INSERT INTO "whosonfirst_points" `},
+		{GEOMETRY_FORMAT_WKB, []byte{0x00, 0x00, 0x00, 0x03, 0xe9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, `INSERT INTO "whosonfirst_points" `},
+		{GEOMETRY_FORMAT_WKB, []byte{0x01, 0x01, 0x00, 0x00, 0x20, 0xe6, 0x10, 0x00, 0x00}, `INSERT INTO "whosonfirst_points" `},
+		{GEOMETRY_FORMAT_WKB, []byte{0x01, 0x03, 0x00, 0x00, 0x00}, "INSERT INTO whosonfirst "},
+	}
+
+	for _, test := range tests {
+
+		wr := &bytes.Buffer{}
+
+		client := &PgisClient{
+			PointsTable: "whosonfirst_points",
+			Logger:      log.SimpleWOFLogger("test"),
+			SQLWriter:   wr,
+		}
+
+		rec := &PgisGeometryRecord{
+			Id:        1108955149,
+			ParentId:  -1,
+			Placetype: "venue",
+			Meta:      Meta{Repo: "whosonfirst-data-venue-ca"},
+			Geometry:  test.geometry,
+			Format:    test.format,
+		}
+
+		err := client.IndexGeometry(context.Background(), rec)
+
+		if err != nil {
+			t.Fatalf("IndexGeometry failed because %s", err)
+		}
+
+		if !strings.Contains(wr.String(), test.table) {
+			t.Errorf("expected %s geometry %q to be written with %q, got %q", test.format, test.geometry, test.table, wr.String())
+		}
+	}
+}
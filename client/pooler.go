@@ -0,0 +1,59 @@
+package pgis
+
+import (
+	"errors"
+	"fmt"
+)
+
+// https://www.pgbouncer.org/features.html
+
+// these are the valid values for PgisClient.PoolerMode, which says whether
+// there is a connection pooler (like pgbouncer) between the client and
+// PostgreSQL and if so how it hands out server connections. The default is to
+// assume there isn't one, which is the same as "session" as far as the client
+// is concerned. In "transaction" mode consecutive statements can end up on
+// different server connections so anything that depends on the state of a
+// session, like session-level SETs, advisory locks or named prepared
+// statements, doesn't work.
+//
+// The client only ever uses unnamed prepared statements (which is all lib/pq
+// does for statements with arguments) and SET LOCAL, inside a transaction, so
+// the only thing that changes in transaction mode is that AcquireLoadLock
+// refuses to take a lock it won't be able to keep. If you are using the pgx
+// driver (see NewPgisClientWithDriver) you will need to tell it not to cache
+// prepared statements yourself.
+
+const PGIS_POOLER_SESSION = "session"
+const PGIS_POOLER_TRANSACTION = "transaction"
+
+// returns an error if PoolerMode isn't one of the above
+
+func (client *PgisClient) checkPoolerMode() error {
+
+	switch client.PoolerMode {
+	case "", PGIS_POOLER_SESSION, PGIS_POOLER_TRANSACTION:
+		return nil
+	default:
+		msg := fmt.Sprintf("invalid pooler mode '%s'", client.PoolerMode)
+		return errors.New(msg)
+	}
+}
+
+// returns an error describing why what can't be done if the client is behind
+// a transaction-pooling connection pooler
+
+func (client *PgisClient) requireSession(what string) error {
+
+	err := client.checkPoolerMode()
+
+	if err != nil {
+		return err
+	}
+
+	if client.PoolerMode == PGIS_POOLER_TRANSACTION {
+		msg := fmt.Sprintf("%s needs a session of its own so it can not be used with the %s pooler mode", what, client.PoolerMode)
+		return errors.New(msg)
+	}
+
+	return nil
+}
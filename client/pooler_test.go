@@ -0,0 +1,90 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRequireSession(t *testing.T) {
+
+	tests := []struct {
+		mode string
+		err  string
+	}{
+		{"", ""},
+		{PGIS_POOLER_SESSION, ""},
+		{PGIS_POOLER_TRANSACTION, "can not be used with the transaction pooler mode"},
+		{"statement", "invalid pooler mode"},
+	}
+
+	for _, test := range tests {
+
+		client := &PgisClient{
+			PoolerMode: test.mode,
+		}
+
+		err := client.requireSession("the load lock")
+
+		if test.err == "" {
+
+			if err != nil {
+				t.Errorf("pooler mode %q failed because %s", test.mode, err)
+			}
+
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected pooler mode %q to fail with %q, got %v", test.mode, test.err, err)
+		}
+	}
+}
+
+// the load lock is refused in transaction mode before anything is sent to the
+// database, and taken otherwise
+
+func TestAcquireLoadLockPoolerMode(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.Contains(query, "pg_try_advisory_lock") {
+			return &testResult{columns: []string{"pg_try_advisory_lock"}, rows: [][]driver.Value{{true}}}, nil
+		}
+
+		return &testResult{columns: []string{"pg_advisory_unlock"}, rows: [][]driver.Value{{true}}}, nil
+	}
+
+	for _, mode := range []string{PGIS_POOLER_SESSION, PGIS_POOLER_TRANSACTION} {
+
+		t.Run(fmt.Sprintf("mode=%s", mode), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+			client.PoolerMode = mode
+
+			release, err := client.AcquireLoadLock(context.Background(), 1234)
+
+			if mode == PGIS_POOLER_TRANSACTION {
+
+				if err == nil {
+					release()
+					t.Fatalf("expected the load lock to be refused in transaction mode")
+				}
+
+				if len(db.statements()) != 0 {
+					t.Errorf("expected nothing to be sent to the database, got %v", db.statements())
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to acquire the load lock because %s", err)
+			}
+
+			release()
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package pgis
+
+import (
+	"sync/atomic"
+)
+
+type PgisIndexProgress struct {
+	Indexed int64 // the number of features processed so far, including errors
+	Errors  int64 // the number of features that failed to index
+	Total   int64 // the total number of features expected or 0 if unknown
+	LastId  int64 // the WOF ID of the most recently processed feature
+}
+
+// this is called by IndexFeature for every feature it processes; sends are
+// non-blocking so a slow (or absent) reader will simply miss updates rather
+// than slowing down indexing
+
+func (client *PgisClient) reportProgress(wofid int64, err error) {
+
+	indexed := atomic.AddInt64(&client.progress_indexed, 1)
+	errors := atomic.LoadInt64(&client.progress_errors)
+
+	if err != nil && !IsSkipped(err) {
+		errors = atomic.AddInt64(&client.progress_errors, 1)
+	}
+
+	if client.Progress == nil {
+		return
+	}
+
+	p := PgisIndexProgress{
+		Indexed: indexed,
+		Errors:  errors,
+		Total:   client.ProgressTotal,
+		LastId:  wofid,
+	}
+
+	select {
+	case client.Progress <- p:
+		// pass
+	default:
+		// pass
+	}
+}
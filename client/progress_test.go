@@ -0,0 +1,62 @@
+package pgis
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReportProgress(t *testing.T) {
+
+	failed := errors.New("failed")
+
+	tests := []struct {
+		id       int64
+		err      error
+		expected PgisIndexProgress
+	}{
+		{1, nil, PgisIndexProgress{Indexed: 1, Errors: 0, Total: 4, LastId: 1}},
+		{2, failed, PgisIndexProgress{Indexed: 2, Errors: 1, Total: 4, LastId: 2}},
+		{3, ErrSkippedDeprecated, PgisIndexProgress{Indexed: 3, Errors: 1, Total: 4, LastId: 3}}, // skipping isn't failing
+		{4, newPgisError(ErrInvalidGeometry, failed), PgisIndexProgress{Indexed: 4, Errors: 2, Total: 4, LastId: 4}},
+	}
+
+	progress := make(chan PgisIndexProgress, len(tests))
+
+	client := &PgisClient{
+		Progress:      progress,
+		ProgressTotal: int64(len(tests)),
+	}
+
+	for _, test := range tests {
+
+		client.reportProgress(test.id, test.err)
+
+		p := <-progress
+
+		if p != test.expected {
+			t.Errorf("expected %+v after %d, got %+v", test.expected, test.id, p)
+		}
+	}
+}
+
+// nobody reading the progress channel, or there not being one, doesn't hold
+// anything up and the counts are kept all the same
+
+func TestReportProgressUnread(t *testing.T) {
+
+	for _, progress := range []chan PgisIndexProgress{nil, make(chan PgisIndexProgress)} {
+
+		client := &PgisClient{}
+
+		if progress != nil {
+			client.Progress = progress
+		}
+
+		client.reportProgress(1, nil)
+		client.reportProgress(2, errors.New("failed"))
+
+		if client.progress_indexed != 2 || client.progress_errors != 1 {
+			t.Errorf("expected 2 indexed and 1 error, got %d and %d", client.progress_indexed, client.progress_errors)
+		}
+	}
+}
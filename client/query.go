@@ -0,0 +1,371 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PgisRowIterator wraps a sql.Rows so that very large result sets can be
+// processed one row at a time without being buffered in memory. Rows are only
+// decoded in to a PgisRow when Scan is called. The iterator holds on to one of
+// the client's connections so Close must always be called when you're done.
+
+type PgisRowIterator struct {
+	client *PgisClient
+	rows   *sql.Rows
+	once   sync.Once
+}
+
+func (it *PgisRowIterator) Next() bool {
+	return it.rows.Next()
+}
+
+func (it *PgisRowIterator) Scan() (*PgisRow, error) {
+	return QueryRowToPgisRow(it.rows)
+}
+
+func (it *PgisRowIterator) Err() error {
+	return it.rows.Err()
+}
+
+func (it *PgisRowIterator) Close() error {
+
+	var err error
+
+	it.once.Do(func() {
+		err = it.rows.Close()
+		it.client.conns <- true
+	})
+
+	return err
+}
+
+func (client *PgisClient) Query(ctx context.Context, opts *PgisIntersectsOptions) (*PgisRowIterator, error) {
+
+	t1 := time.Now()
+
+	it, err := client.query(ctx, opts)
+
+	client.reportQueried(t1, err)
+	return it, err
+}
+
+func (client *PgisClient) query(ctx context.Context, opts *PgisIntersectsOptions) (*PgisRowIterator, error) {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sql, args, err := client.selectSQL(opts, client.columns().rowColumns())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return client.queryIterator(ctx, sql, args)
+}
+
+// the SELECT statement, and its arguments, for str_cols of all the records
+// matching opts; this is shared by Query and ExportCSV so that they always
+// agree about what matches and in what order
+
+func (client *PgisClient) selectSQL(opts *PgisIntersectsOptions, str_cols string) (string, []interface{}, error) {
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	where, args := opts.filters(client.columns(), []string{}, []interface{}{})
+
+	sql := fmt.Sprintf("SELECT %s FROM %s", str_cols, source)
+
+	if len(where) > 0 {
+		sql = fmt.Sprintf("%s WHERE %s", sql, strings.Join(where, " AND "))
+	}
+
+	// results are only sorted if asked for, or if they're being paged
+	// through, since sorting the whole table isn't free
+
+	if opts.OrderBy != "" || opts.paginated() {
+
+		order, err := opts.orderBy(client.columns(), "id", "")
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		var page string
+		page, args = opts.pagination(args)
+
+		sql = fmt.Sprintf("%s %s %s", sql, order, page)
+	}
+
+	return sql, args, nil
+}
+
+// run sql and return an iterator for its results, which holds on to one of the
+// client's connections until it is closed
+
+func (client *PgisClient) queryIterator(ctx context.Context, sql string, args []interface{}) (*PgisRowIterator, error) {
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, args)
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Explain {
+
+		err := client.explain(ctx, db, sql, args...)
+
+		if err != nil {
+			client.Logger.Warning("failed to explain query because %s", err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, sql, args...)
+
+	if err != nil {
+		client.conns <- true
+		return nil, err
+	}
+
+	it := PgisRowIterator{
+		client: client,
+		rows:   rows,
+	}
+
+	return &it, nil
+}
+
+func (client *PgisClient) Count(ctx context.Context, opts *PgisIntersectsOptions) (int64, error) {
+
+	t1 := time.Now()
+
+	count, err := client.count(ctx, opts)
+
+	client.reportQueried(t1, err)
+	return count, err
+}
+
+func (client *PgisClient) count(ctx context.Context, opts *PgisIntersectsOptions) (int64, error) {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return 0, err
+	}
+
+	where, args := opts.filters(client.columns(), []string{}, []interface{}{})
+
+	sql := fmt.Sprintf("SELECT COUNT(id) FROM %s", source)
+
+	if len(where) > 0 {
+		sql = fmt.Sprintf("%s WHERE %s", sql, strings.Join(where, " AND "))
+	}
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, args)
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	var count int64
+
+	if client.Explain {
+
+		err := client.explain(ctx, db, sql, args...)
+
+		if err != nil {
+			client.Logger.Warning("failed to explain query because %s", err)
+		}
+	}
+
+	row := db.QueryRowContext(ctx, sql, args...)
+	err = row.Scan(&count)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CountByPlacetype returns the number of records (matching opts) for each
+// placetype ID; use placetypes.GetPlacetypeById if you need the names
+
+func (client *PgisClient) CountByPlacetype(ctx context.Context, opts *PgisIntersectsOptions) (map[int64]int64, error) {
+
+	t1 := time.Now()
+
+	counts, err := client.countByPlacetype(ctx, opts)
+
+	client.reportQueried(t1, err)
+	return counts, err
+}
+
+func (client *PgisClient) countByPlacetype(ctx context.Context, opts *PgisIntersectsOptions) (map[int64]int64, error) {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := opts.filters(client.columns(), []string{}, []interface{}{})
+
+	sql := fmt.Sprintf("SELECT placetype_id, COUNT(id) FROM %s", source)
+
+	if len(where) > 0 {
+		sql = fmt.Sprintf("%s WHERE %s", sql, strings.Join(where, " AND "))
+	}
+
+	sql = fmt.Sprintf("%s GROUP BY placetype_id", sql)
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", sql, args)
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	if client.Explain {
+
+		err := client.explain(ctx, db, sql, args...)
+
+		if err != nil {
+			client.Logger.Warning("failed to explain query because %s", err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, sql, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	counts := make(map[int64]int64)
+
+	for rows.Next() {
+
+		var placetype_id int64
+		var count int64
+
+		err := rows.Scan(&placetype_id, &count)
+
+		if err != nil {
+			return nil, err
+		}
+
+		counts[placetype_id] = count
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// RawFeature returns the original GeoJSON feature for id exactly as it was
+// indexed, which requires that it was indexed with StoreRaw set; records that
+// weren't are treated as not found
+
+func (client *PgisClient) RawFeature(ctx context.Context, id int64) ([]byte, error) {
+
+	t1 := time.Now()
+
+	raw, err := client.rawFeature(ctx, id)
+
+	client.reportQueried(t1, err)
+	return raw, err
+}
+
+func (client *PgisClient) rawFeature(ctx context.Context, id int64) ([]byte, error) {
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	var raw sql.NullString
+
+	row := db.QueryRowContext(ctx, fmt.Sprintf("SELECT raw FROM %s WHERE id=$1", source), id)
+	err = row.Scan(&raw)
+
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	if !raw.Valid {
+		return nil, ErrNotFound
+	}
+
+	return []byte(raw.String), nil
+}
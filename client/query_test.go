@@ -0,0 +1,180 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a handler for queries that only count things
+
+func testCountHandler(query string, args []driver.Value) (*testResult, error) {
+	return &testResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(2)}}}, nil
+}
+
+func TestCountIgnoresPagination(t *testing.T) {
+
+	client, db := newTestClient(t, testCountHandler)
+
+	opts := &PgisIntersectsOptions{PlacetypeId: 102312317, Limit: 10, Offset: 20}
+
+	count, err := client.Count(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("Count failed because %s", err)
+	}
+
+	if count != 2 {
+		t.Errorf("expected a count of 2, got %d", count)
+	}
+
+	expected := "SELECT COUNT(id) FROM whosonfirst WHERE placetype_id=$1"
+
+	if stmts := db.statements(); len(stmts) != 1 || stmts[0] != expected {
+		t.Errorf("expected %s, got %v", expected, stmts)
+	}
+
+	// and the same options page the query itself
+
+	query, _, err := client.selectSQL(opts, "id")
+
+	if err != nil {
+		t.Fatalf("selectSQL failed because %s", err)
+	}
+
+	if !strings.HasSuffix(query, "LIMIT $2 OFFSET $3") {
+		t.Errorf("expected the query to be paginated, got %s", query)
+	}
+}
+
+func TestCountByPlacetype(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		rows := [][]driver.Value{{int64(102312317), int64(3)}, {int64(102312307), int64(1)}}
+		return &testResult{columns: []string{"placetype_id", "count"}, rows: rows}, nil
+	}
+
+	tests := []struct {
+		opts     *PgisIntersectsOptions
+		points   string
+		expected string
+	}{
+		{nil, "", "SELECT placetype_id, COUNT(id) FROM whosonfirst GROUP BY placetype_id"},
+		{&PgisIntersectsOptions{Repo: "whosonfirst-data", Limit: 10}, "", "SELECT placetype_id, COUNT(id) FROM whosonfirst WHERE meta->>'wof:repo'=$1 GROUP BY placetype_id"},
+		{nil, "whosonfirst_points", `SELECT placetype_id, COUNT(id) FROM (SELECT * FROM whosonfirst UNION ALL SELECT * FROM "whosonfirst_points") AS whosonfirst GROUP BY placetype_id`},
+	}
+
+	for i, test := range tests {
+
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+			client.PointsTable = test.points
+
+			counts, err := client.CountByPlacetype(context.Background(), test.opts)
+
+			if err != nil {
+				t.Fatalf("CountByPlacetype failed because %s", err)
+			}
+
+			if len(counts) != 2 || counts[102312317] != 3 || counts[102312307] != 1 {
+				t.Errorf("unexpected counts %v", counts)
+			}
+
+			if stmts := db.statements(); len(stmts) != 1 || stmts[0] != test.expected {
+				t.Errorf("expected %s, got %v", test.expected, stmts)
+			}
+		})
+	}
+}
+
+// the iterator holds on to one of the client's connection slots from the time
+// the query is run until it is closed, however many times that is
+
+func TestPgisRowIterator(t *testing.T) {
+
+	count := 300
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		rsp := &testResult{columns: TEST_ROW_COLUMNS}
+
+		for i := 0; i < count; i++ {
+			rsp.rows = append(rsp.rows, testRow(int64(i+1), 102312317, `{"wof:name":"test"}`, `{"type":"Point","coordinates":[-73.5,45.5]}`))
+		}
+
+		return rsp, nil
+	}
+
+	client, _ := newTestClient(t, handler)
+
+	free := len(client.conns)
+
+	it, err := client.Query(context.Background(), nil)
+
+	if err != nil {
+		t.Fatalf("Query failed because %s", err)
+	}
+
+	seen := 0
+
+	for it.Next() {
+
+		if len(client.conns) != free-1 {
+			t.Fatalf("expected the iterator to hold 1 connection slot, %d of %d are free", len(client.conns), free)
+		}
+
+		row, err := it.Scan()
+
+		if err != nil {
+			t.Fatalf("failed to scan row %d because %s", seen, err)
+		}
+
+		seen += 1
+
+		if row.Id != int64(seen) {
+			t.Fatalf("expected row %d to be %d, got %d", seen, seen, row.Id)
+		}
+	}
+
+	err = it.Err()
+
+	if err != nil {
+		t.Errorf("expected no error after the last row, got %s", err)
+	}
+
+	if seen != count {
+		t.Errorf("expected %d rows, got %d", count, seen)
+	}
+
+	// a second Close would block, with every slot already returned, if it
+	// tried to return the slot again
+
+	for i := 0; i < 2; i++ {
+
+		done := make(chan error, 1)
+
+		go func() {
+			done <- it.Close()
+		}()
+
+		select {
+		case err := <-done:
+
+			if err != nil {
+				t.Errorf("Close %d failed because %s", i+1, err)
+			}
+
+		case <-time.After(time.Second):
+			t.Fatalf("Close %d blocked returning the connection slot", i+1)
+		}
+
+		if len(client.conns) != free {
+			t.Errorf("expected %d free connection slots after Close %d, got %d", free, i+1, len(client.conns))
+		}
+	}
+}
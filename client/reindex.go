@@ -0,0 +1,102 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// https://www.postgresql.org/docs/12/static/sql-reindex.html
+
+// the first version of PostgreSQL that supports REINDEX ... CONCURRENTLY, in
+// the same format as server_version_num
+
+const POSTGRES_REINDEX_CONCURRENTLY = 120000
+
+// ReindexSpatial rebuilds all of the GiST (spatial) indexes on the whosonfirst
+// and whosonfirst_subdivided tables, which can be worthwhile after a very large
+// load. If concurrently is true each index is rebuilt without locking out reads
+// or writes, which is slower and requires PostgreSQL 12 or higher.
+
+func (client *PgisClient) ReindexSpatial(ctx context.Context, concurrently bool) error {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	if concurrently {
+
+		v, err := postgresVersion(ctx, db)
+
+		if err != nil {
+			return err
+		}
+
+		if v < POSTGRES_REINDEX_CONCURRENTLY {
+			msg := fmt.Sprintf("REINDEX CONCURRENTLY requires PostgreSQL >= 12 (found %d)", v)
+			return errors.New(msg)
+		}
+	}
+
+	sql := "SELECT indexname FROM pg_indexes WHERE tablename IN ('whosonfirst', 'whosonfirst_subdivided') AND indexdef ILIKE '% USING gist %'"
+
+	rows, err := db.QueryContext(ctx, sql)
+
+	if err != nil {
+		return err
+	}
+
+	indexes := make([]string, 0)
+
+	for rows.Next() {
+
+		var name string
+
+		err := rows.Scan(&name)
+
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		indexes = append(indexes, name)
+	}
+
+	err = rows.Err()
+	rows.Close()
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range indexes {
+
+		sql := fmt.Sprintf("REINDEX INDEX %s", quoteIdent(name))
+
+		if concurrently {
+			sql = fmt.Sprintf("REINDEX INDEX CONCURRENTLY %s", quoteIdent(name))
+		}
+
+		if client.Verbose {
+			client.Logger.Status("%s", sql)
+		}
+
+		if client.Debug {
+			continue
+		}
+
+		_, err := db.ExecContext(ctx, sql)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestReindexSpatial(t *testing.T) {
+
+	version := "160002"
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if query == "SHOW server_version_num" {
+			return &testResult{columns: []string{"server_version_num"}, rows: [][]driver.Value{{version}}}, nil
+		}
+
+		if strings.HasPrefix(query, "SELECT indexname") {
+			return &testResult{columns: []string{"indexname"}, rows: [][]driver.Value{{"by_geom"}, {"by_subdivided_geom"}}}, nil
+		}
+
+		return &testResult{}, nil
+	}
+
+	find := "SELECT indexname FROM pg_indexes WHERE tablename IN ('whosonfirst', 'whosonfirst_subdivided') AND indexdef ILIKE '% USING gist %'"
+
+	tests := []struct {
+		what         string
+		version      string
+		concurrently bool
+		debug        bool
+		expected     []string
+		err          bool
+	}{
+		{"plain", "110000", false, false, []string{find, `REINDEX INDEX "by_geom"`, `REINDEX INDEX "by_subdivided_geom"`}, false},
+		{"concurrently", "160002", true, false, []string{"SHOW server_version_num", find, `REINDEX INDEX CONCURRENTLY "by_geom"`, `REINDEX INDEX CONCURRENTLY "by_subdivided_geom"`}, false},
+		{"too old", "110000", true, false, []string{"SHOW server_version_num"}, true},
+		{"debug", "160002", false, true, []string{find}, false},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.what, func(t *testing.T) {
+
+			version = test.version
+
+			client, db := newTestClient(t, handler)
+			client.Debug = test.debug
+
+			err := client.ReindexSpatial(context.Background(), test.concurrently)
+
+			if test.err != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if stmts := db.statements(); strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected %v, got %v", test.expected, stmts)
+			}
+		})
+	}
+}
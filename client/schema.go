@@ -0,0 +1,261 @@
+package pgis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the columns IndexFeature expects to find in the whosonfirst table, given the
+// way the client is configured, mapped to the (information_schema udt_name)
+// types it will accept for each
+
+func (client *PgisClient) expectedColumns() map[string][]string {
+
+	columns := map[string][]string{
+		"id":            []string{"int8"},
+		"parent_id":     []string{"int8"},
+		"placetype_id":  []string{"int8"},
+		"is_superseded": []string{"int2", "int4", "int8"},
+		"is_deprecated": []string{"int2", "int4", "int8"},
+		"meta":          []string{"json", "jsonb"},
+		"geom_hash":     []string{"bpchar", "varchar", "text"},
+		"lastmod":       []string{"timestamptz", "bpchar", "varchar", "text"},
+	}
+
+	columns[client.geomColumn()] = []string{"geography"}
+	columns[client.centroidColumn()] = []string{"geography"}
+
+	if client.SimplifiedTolerance > 0.0 {
+		columns["geom_simplified"] = []string{"geography"}
+	}
+
+	if client.ProjectedSRID > 0 {
+		columns["geom_projected"] = []string{"geometry"}
+	}
+
+	if client.StoreBBox {
+		columns["bbox"] = []string{"geometry"}
+	}
+
+	if client.RunId != "" {
+		columns["run_id"] = []string{"text", "varchar", "bpchar"}
+	}
+
+	if client.StoreArea {
+		columns["area_meters"] = []string{"float8", "float4", "numeric"}
+	}
+
+	if client.StoreLength {
+		columns["length_meters"] = []string{"float8", "float4", "numeric"}
+	}
+
+	if client.conflictRepo() {
+		columns["repo"] = []string{"text", "varchar", "bpchar"}
+	}
+
+	if client.StoreRaw {
+		columns["raw"] = []string{"json", "text"}
+	}
+
+	if client.StoreLatLon {
+		columns["latitude"] = []string{"float8", "float4", "numeric"}
+		columns["longitude"] = []string{"float8", "float4", "numeric"}
+	}
+
+	if client.StoreValidity {
+		columns["valid_range"] = []string{"daterange"}
+	}
+
+	if client.StoreFeatureHash {
+		columns["feature_hash"] = []string{"bpchar", "varchar", "text"}
+	}
+
+	if client.GeohashPrecision > 0 {
+		columns["geohash"] = []string{"text", "varchar", "bpchar"}
+	}
+
+	if client.StoreHierarchy {
+
+		for _, col := range HIERARCHY_COLUMNS {
+			columns[col] = []string{"int8"}
+		}
+	}
+
+	return columns
+}
+
+// ValidateSchema compares the columns of the whosonfirst table with the ones
+// IndexFeature is going to try and write to and returns an error describing
+// any differences. It is meant to be called before indexing anything so that
+// a mismatched database fails early and obviously rather than halfway through
+// a load with an opaque PostgreSQL error. It also checks that the installed
+// version of PostGIS has the functions any optional features need.
+
+func (client *PgisClient) ValidateSchema(ctx context.Context) error {
+
+	err := client.checkPoolerMode()
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{client.geomColumn(), client.centroidColumn()} {
+
+		err = validateIdent(name)
+
+		if err != nil {
+			msg := fmt.Sprintf("invalid geometry column name because %s", err)
+			return errors.New(msg)
+		}
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	sql := "SELECT column_name, udt_name FROM information_schema.columns WHERE table_name=$1 AND table_schema=ANY(current_schemas(false))"
+
+	rows, err := db.QueryContext(ctx, sql, "whosonfirst")
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	actual := make(map[string]string)
+
+	for rows.Next() {
+
+		var name string
+		var udt string
+
+		err := rows.Scan(&name, &udt)
+
+		if err != nil {
+			return err
+		}
+
+		actual[name] = udt
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return err
+	}
+
+	if len(actual) == 0 {
+		return errors.New("the whosonfirst table does not exist")
+	}
+
+	problems := make([]string, 0)
+
+	for name, types := range client.expectedColumns() {
+
+		udt, ok := actual[name]
+
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing column %s (%s)", name, strings.Join(types, "|")))
+			continue
+		}
+
+		valid := false
+
+		for _, t := range types {
+
+			if udt == t {
+				valid = true
+				break
+			}
+		}
+
+		if !valid {
+			problems = append(problems, fmt.Sprintf("column %s is %s but should be %s", name, udt, strings.Join(types, "|")))
+		}
+	}
+
+	if client.PointsTable != "" {
+
+		var exists bool
+
+		row := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_name=$1 AND table_schema=ANY(current_schemas(false)))", client.PointsTable)
+		err := row.Scan(&exists)
+
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			problems = append(problems, fmt.Sprintf("missing table %s", client.PointsTable))
+		}
+	}
+
+	if client.SubdivideMaxVertices > 0 {
+
+		var exists bool
+
+		row := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_name=$1 AND table_schema=ANY(current_schemas(false)))", "whosonfirst_subdivided")
+		err := row.Scan(&exists)
+
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			problems = append(problems, "missing table whosonfirst_subdivided")
+		}
+	}
+
+	for what, required := range client.postGISRequirements() {
+
+		err := client.requirePostGIS(ctx, db, map[string][]int{what: required})
+
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	// http://postgis.net/docs/Find_SRID.html
+
+	if client.ProjectedSRID > 0 && actual["geom_projected"] == "geometry" {
+
+		var srid int
+
+		row := db.QueryRowContext(ctx, "SELECT Find_SRID(current_schema(), 'whosonfirst', 'geom_projected')")
+		err := row.Scan(&srid)
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("unable to determine SRID of geom_projected column because %s", err))
+		} else if srid != client.ProjectedSRID {
+			problems = append(problems, fmt.Sprintf("column geom_projected has SRID %d but should be %d", srid, client.ProjectedSRID))
+		}
+	}
+
+	problem, err := client.checkConflictIndex(ctx, db)
+
+	if err != nil {
+		return err
+	}
+
+	if problem != "" {
+		problems = append(problems, problem)
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		msg := fmt.Sprintf("invalid schema for whosonfirst table: %s", strings.Join(problems, "; "))
+		return errors.New(msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-flags"
+	"github.com/whosonfirst/go-whosonfirst-flags/existential"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"strings"
+	"time"
+)
+
+// this is a go-whosonfirst-spr StandardPlacesResult reconstructed from the
+// columns in the whosonfirst table - the database doesn't store everything
+// an SPR wants to know about (is_current, is_ceased, supersedes and so on) so
+// those properties are always "unknown" or empty
+
+type PgisStandardPlacesResult struct {
+	WOFId           int64   `json:"wof:id"`
+	WOFParentId     int64   `json:"wof:parent_id"`
+	WOFName         string  `json:"wof:name"`
+	WOFPlacetype    string  `json:"wof:placetype"`
+	WOFCountry      string  `json:"wof:country"`
+	WOFRepo         string  `json:"wof:repo"`
+	WOFPath         string  `json:"wof:path"`
+	WOFSupersededBy []int64 `json:"wof:superseded_by"`
+	WOFSupersedes   []int64 `json:"wof:supersedes"`
+	MZURI           string  `json:"mz:uri"`
+	MZLatitude      float64 `json:"mz:latitude"`
+	MZLongitude     float64 `json:"mz:longitude"`
+	MZMinLatitude   float64 `json:"mz:min_latitude"`
+	MZMinLongitude  float64 `json:"mz:min_longitude"`
+	MZMaxLatitude   float64 `json:"mz:max_latitude"`
+	MZMaxLongitude  float64 `json:"mz:max_longitude"`
+	MZIsCurrent     int64   `json:"mz:is_current"`
+	MZIsCeased      int64   `json:"mz:is_ceased"`
+	MZIsDeprecated  int64   `json:"mz:is_deprecated"`
+	MZIsSuperseded  int64   `json:"mz:is_superseded"`
+	MZIsSuperseding int64   `json:"mz:is_superseding"`
+	WOFLastModified int64   `json:"wof:lastmodified"`
+}
+
+func (client *PgisClient) StandardPlacesResponse(ctx context.Context, id int64) (spr.StandardPlacesResult, error) {
+
+	t1 := time.Now()
+
+	result, err := client.standardPlacesResponse(ctx, id)
+
+	client.reportQueried(t1, err)
+	return result, err
+}
+
+func (client *PgisClient) standardPlacesResponse(ctx context.Context, id int64) (spr.StandardPlacesResult, error) {
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	var wofid int64
+	var parentid int64
+	var placetypeid int64
+	var superseded int64
+	var deprecated int64
+	var str_meta string
+	var lastmod sql.NullString
+	var lat, lon sql.NullFloat64
+	var minlat, minlon, maxlat, maxlon sql.NullFloat64
+
+	// Point geometries are only stored in the centroid column - see notes
+	// in IndexFeature
+
+	cols := client.columns()
+
+	st_bounds := fmt.Sprintf("COALESCE(%s, %s)::geometry", cols.geom, cols.centroid)
+
+	sql := fmt.Sprintf(`SELECT id, parent_id, placetype_id, is_superseded, is_deprecated, meta, lastmod,
+	       ST_Y(%s::geometry), ST_X(%s::geometry),
+	       ST_YMin(%s), ST_XMin(%s),
+	       ST_YMax(%s), ST_XMax(%s)
+	       FROM %s WHERE id=$1`, cols.centroid, cols.centroid, st_bounds, st_bounds, st_bounds, st_bounds, source)
+
+	row := db.QueryRowContext(ctx, sql, id)
+	err = row.Scan(&wofid, &parentid, &placetypeid, &superseded, &deprecated, &str_meta, &lastmod, &lat, &lon, &minlat, &minlon, &maxlat, &maxlon)
+
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	var meta Meta
+
+	err = json.Unmarshal([]byte(str_meta), &meta)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pt, err := placetypeById(placetypeid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := uri.Id2RelPath(wofid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	abs_uri, err := uri.Id2AbsPath("https://whosonfirst.mapzen.com/data", wofid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var lastmod_ts int64
+
+	if lastmod.Valid {
+
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(lastmod.String))
+
+		if err == nil {
+			lastmod_ts = t.Unix()
+		}
+	}
+
+	s := PgisStandardPlacesResult{
+		WOFId:           wofid,
+		WOFParentId:     parentid,
+		WOFName:         meta.Name,
+		WOFPlacetype:    pt.Name,
+		WOFCountry:      meta.Country,
+		WOFRepo:         meta.Repo,
+		WOFPath:         path,
+		WOFSupersededBy: []int64{},
+		WOFSupersedes:   []int64{},
+		MZURI:           abs_uri,
+		MZLatitude:      lat.Float64,
+		MZLongitude:     lon.Float64,
+		MZMinLatitude:   minlat.Float64,
+		MZMinLongitude:  minlon.Float64,
+		MZMaxLatitude:   maxlat.Float64,
+		MZMaxLongitude:  maxlon.Float64,
+		MZIsCurrent:     -1,
+		MZIsCeased:      -1,
+		MZIsDeprecated:  deprecated,
+		MZIsSuperseded:  superseded,
+		MZIsSuperseding: -1,
+		WOFLastModified: lastmod_ts,
+	}
+
+	return &s, nil
+}
+
+func (s *PgisStandardPlacesResult) Id() int64 {
+	return s.WOFId
+}
+
+func (s *PgisStandardPlacesResult) ParentId() int64 {
+	return s.WOFParentId
+}
+
+func (s *PgisStandardPlacesResult) Name() string {
+	return s.WOFName
+}
+
+func (s *PgisStandardPlacesResult) Placetype() string {
+	return s.WOFPlacetype
+}
+
+func (s *PgisStandardPlacesResult) Country() string {
+	return s.WOFCountry
+}
+
+func (s *PgisStandardPlacesResult) Repo() string {
+	return s.WOFRepo
+}
+
+func (s *PgisStandardPlacesResult) Path() string {
+	return s.WOFPath
+}
+
+func (s *PgisStandardPlacesResult) URI() string {
+	return s.MZURI
+}
+
+func (s *PgisStandardPlacesResult) Latitude() float64 {
+	return s.MZLatitude
+}
+
+func (s *PgisStandardPlacesResult) Longitude() float64 {
+	return s.MZLongitude
+}
+
+func (s *PgisStandardPlacesResult) MinLatitude() float64 {
+	return s.MZMinLatitude
+}
+
+func (s *PgisStandardPlacesResult) MinLongitude() float64 {
+	return s.MZMinLongitude
+}
+
+func (s *PgisStandardPlacesResult) MaxLatitude() float64 {
+	return s.MZMaxLatitude
+}
+
+func (s *PgisStandardPlacesResult) MaxLongitude() float64 {
+	return s.MZMaxLongitude
+}
+
+func (s *PgisStandardPlacesResult) IsCurrent() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsCurrent)
+}
+
+func (s *PgisStandardPlacesResult) IsCeased() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsCeased)
+}
+
+func (s *PgisStandardPlacesResult) IsDeprecated() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsDeprecated)
+}
+
+func (s *PgisStandardPlacesResult) IsSuperseded() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsSuperseded)
+}
+
+func (s *PgisStandardPlacesResult) IsSuperseding() flags.ExistentialFlag {
+	return existentialFlag(s.MZIsSuperseding)
+}
+
+func (s *PgisStandardPlacesResult) SupersededBy() []int64 {
+	return s.WOFSupersededBy
+}
+
+func (s *PgisStandardPlacesResult) Supersedes() []int64 {
+	return s.WOFSupersedes
+}
+
+func (s *PgisStandardPlacesResult) LastModified() int64 {
+	return s.WOFLastModified
+}
+
+// the values stored in the database were produced by ExistentialFlag.StringFlag
+// in IndexFeature so they are always -1, 0 or 1 and this won't fail
+
+func existentialFlag(i int64) flags.ExistentialFlag {
+	fl, _ := existential.NewKnownUnknownFlag(i)
+	return fl
+}
@@ -0,0 +1,89 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStandardPlacesResponse(t *testing.T) {
+
+	pt, err := placetypeByName("locality")
+
+	if err != nil {
+		t.Fatalf("failed to load placetype because %s", err)
+	}
+
+	columns := strings.Split("id,parent_id,placetype_id,is_superseded,is_deprecated,meta,lastmod,st_y,st_x,st_ymin,st_xmin,st_ymax,st_xmax", ",")
+
+	meta := `{"wof:name":"Montreal","wof:country":"CA","wof:repo":"whosonfirst-data"}`
+	row := []driver.Value{int64(101736545), int64(85633041), pt.Id, int64(1), int64(0), meta, "2017-06-23T19:22:51Z", 45.5, -73.5, 45.25, -74.0, 45.75, -73.25}
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if fmt.Sprint(args) != "[101736545]" {
+			return &testResult{columns: columns}, nil
+		}
+
+		return &testResult{columns: columns, rows: [][]driver.Value{row}}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	s, err := client.StandardPlacesResponse(context.Background(), 101736545)
+
+	if err != nil {
+		t.Fatalf("failed to build SPR because %s", err)
+	}
+
+	stmts := db.statements()
+
+	if len(stmts) != 1 || !strings.Contains(stmts[0], "FROM whosonfirst WHERE id=$1") {
+		t.Fatalf("unexpected statements %v", stmts)
+	}
+
+	tests := []struct {
+		what     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"Id", s.Id(), int64(101736545)},
+		{"ParentId", s.ParentId(), int64(85633041)},
+		{"Name", s.Name(), "Montreal"},
+		{"Placetype", s.Placetype(), "locality"},
+		{"Country", s.Country(), "CA"},
+		{"Repo", s.Repo(), "whosonfirst-data"},
+		{"Path", s.Path(), "101/736/545/101736545.geojson"},
+		{"URI", s.URI(), "https://whosonfirst.mapzen.com/data/101/736/545/101736545.geojson"},
+		{"Latitude", s.Latitude(), 45.5},
+		{"Longitude", s.Longitude(), -73.5},
+		{"MinLatitude", s.MinLatitude(), 45.25},
+		{"MinLongitude", s.MinLongitude(), -74.0},
+		{"MaxLatitude", s.MaxLatitude(), 45.75},
+		{"MaxLongitude", s.MaxLongitude(), -73.25},
+		{"IsCurrent", s.IsCurrent().StringFlag(), "-1"},
+		{"IsCeased", s.IsCeased().StringFlag(), "-1"},
+		{"IsDeprecated", s.IsDeprecated().StringFlag(), "0"},
+		{"IsSuperseded", s.IsSuperseded().StringFlag(), "1"},
+		{"IsSuperseding", s.IsSuperseding().StringFlag(), "-1"},
+		{"SupersededBy", fmt.Sprint(s.SupersededBy()), "[]"},
+		{"Supersedes", fmt.Sprint(s.Supersedes()), "[]"},
+		{"LastModified", s.LastModified(), int64(1498245771)},
+	}
+
+	for _, test := range tests {
+
+		if test.value != test.expected {
+			t.Errorf("expected %s to be %v (%T), got %v (%T)", test.what, test.expected, test.expected, test.value, test.value)
+		}
+	}
+
+	_, err = client.StandardPlacesResponse(context.Background(), 1)
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing record, got %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PgisRepoStats is a summary of the records indexed from a single repo, as
+// returned by RepoStats
+
+type PgisRepoStats struct {
+	Repo        string          `json:"repo"`
+	Count       int64           `json:"count"`
+	Deprecated  int64           `json:"deprecated"`
+	Superseded  int64           `json:"superseded"`
+	MinLastMod  string          `json:"min_lastmod"`
+	MaxLastMod  string          `json:"max_lastmod"`
+	ByPlacetype map[int64]int64 `json:"placetypes"`
+}
+
+// RepoStats returns a PgisRepoStats for each repo (matching opts) ordered by
+// repo name. Records without a repo (which IndexFeature doesn't allow but other
+// tools might) are grouped under an empty repo name.
+
+func (client *PgisClient) RepoStats(ctx context.Context, opts *PgisIntersectsOptions) ([]*PgisRepoStats, error) {
+
+	t1 := time.Now()
+
+	stats, err := client.repoStats(ctx, opts)
+
+	client.reportQueried(t1, err)
+	return stats, err
+}
+
+func (client *PgisClient) repoStats(ctx context.Context, opts *PgisIntersectsOptions) ([]*PgisRepoStats, error) {
+
+	err := client.requireQueryPostGIS(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = NewDefaultPgisIntersectsOptions()
+	}
+
+	where, args := opts.filters(client.columns(), []string{}, []interface{}{})
+
+	str_where := ""
+
+	if len(where) > 0 {
+		str_where = fmt.Sprintf(" WHERE %s", strings.Join(where, " AND "))
+	}
+
+	// is_deprecated and is_superseded are existential flags so only 1
+	// counts as yes
+
+	repo_col := client.repoColumn()
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`SELECT COALESCE(%s, ''), COUNT(id),
+	      COUNT(CASE WHEN is_deprecated=1 THEN 1 END), COUNT(CASE WHEN is_superseded=1 THEN 1 END),
+	      MIN(lastmod), MAX(lastmod)
+	      FROM %s%s GROUP BY 1 ORDER BY 1`, repo_col, source, str_where)
+
+	placetypes_query := fmt.Sprintf("SELECT COALESCE(%s, ''), placetype_id, COUNT(id) FROM %s%s GROUP BY 1, 2", repo_col, source, str_where)
+
+	if client.Verbose {
+		client.Logger.Status("%s %v", query, args)
+		client.Logger.Status("%s %v", placetypes_query, args)
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	if client.Explain {
+
+		err := client.explain(ctx, db, query, args...)
+
+		if err != nil {
+			client.Logger.Warning("failed to explain query because %s", err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]*PgisRepoStats, 0)
+	lookup := make(map[string]*PgisRepoStats)
+
+	for rows.Next() {
+
+		s := PgisRepoStats{
+			ByPlacetype: make(map[int64]int64),
+		}
+
+		// lastmod may be a string or a timestamp (see README) which
+		// database/sql will happily turn in to an RFC 3339 string
+
+		var min_lastmod sql.NullString
+		var max_lastmod sql.NullString
+
+		err := rows.Scan(&s.Repo, &s.Count, &s.Deprecated, &s.Superseded, &min_lastmod, &max_lastmod)
+
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		s.MinLastMod = strings.TrimSpace(min_lastmod.String)
+		s.MaxLastMod = strings.TrimSpace(max_lastmod.String)
+
+		stats = append(stats, &s)
+		lookup[s.Repo] = &s
+	}
+
+	err = rows.Err()
+	rows.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = repoPlacetypes(ctx, db, lookup, placetypes_query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func repoPlacetypes(ctx context.Context, db *sql.DB, lookup map[string]*PgisRepoStats, query string, args ...interface{}) error {
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var repo string
+		var placetype_id int64
+		var count int64
+
+		err := rows.Scan(&repo, &placetype_id, &count)
+
+		if err != nil {
+			return err
+		}
+
+		s, ok := lookup[repo]
+
+		if ok {
+			s.ByPlacetype[placetype_id] = count
+		}
+	}
+
+	return rows.Err()
+}
+
+// DistinctRepos returns the names of the repos that records have been indexed
+// from, in order. This uses the repo column if the client is using the id+repo
+// conflict key and the wof:repo property in meta if it isn't.
+
+func (client *PgisClient) DistinctRepos(ctx context.Context) ([]string, error) {
+
+	repos := make([]string, 0)
+
+	err := client.selectDistinct(ctx, client.repoColumn(), func(rows *sql.Rows) error {
+
+		var repo string
+
+		err := rows.Scan(&repo)
+
+		if err != nil {
+			return err
+		}
+
+		repos = append(repos, repo)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return repos, nil
+}
+
+// DistinctPlacetypes returns the IDs of the placetypes of the records that have
+// been indexed, in order
+
+func (client *PgisClient) DistinctPlacetypes(ctx context.Context) ([]int64, error) {
+
+	placetypes := make([]int64, 0)
+
+	err := client.selectDistinct(ctx, "placetype_id", func(rows *sql.Rows) error {
+
+		var placetype_id int64
+
+		err := rows.Scan(&placetype_id)
+
+		if err != nil {
+			return err
+		}
+
+		placetypes = append(placetypes, placetype_id)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return placetypes, nil
+}
+
+// calls scan for each (non-NULL) distinct value of col, which may be any SQL
+// expression, in the whosonfirst (and points) table, in order
+
+func (client *PgisClient) selectDistinct(ctx context.Context, col string, scan func(*sql.Rows) error) error {
+
+	t1 := time.Now()
+
+	err := client.distinct(ctx, col, scan)
+
+	client.reportQueried(t1, err)
+	return err
+}
+
+func (client *PgisClient) distinct(ctx context.Context, col string, scan func(*sql.Rows) error) error {
+
+	source, err := client.recordSource()
+
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL ORDER BY 1", col, source, col)
+
+	if client.Verbose {
+		client.Logger.Status("%s", query)
+	}
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	if client.Explain {
+
+		err := client.explain(ctx, db, query)
+
+		if err != nil {
+			client.Logger.Warning("failed to explain query because %s", err)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		err := scan(rows)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
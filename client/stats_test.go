@@ -0,0 +1,155 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRepoStats(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.Contains(query, "placetype_id") {
+
+			rows := [][]driver.Value{
+				{"whosonfirst-data-admin-ca", int64(102312317), int64(2)},
+				{"whosonfirst-data-admin-ca", int64(102312307), int64(1)},
+				{"whosonfirst-data-admin-us", int64(102312317), int64(4)},
+				{"whosonfirst-data-admin-xx", int64(102312317), int64(1)}, // not in the first query
+			}
+
+			return &testResult{columns: []string{"repo", "placetype_id", "count"}, rows: rows}, nil
+		}
+
+		rows := [][]driver.Value{
+			{"whosonfirst-data-admin-ca", int64(3), int64(1), int64(0), "2017-08-23T00:00:00Z", " 2019-01-01T00:00:00Z "},
+			{"whosonfirst-data-admin-us", int64(4), int64(0), int64(2), nil, nil},
+		}
+
+		return &testResult{columns: []string{"repo", "count", "deprecated", "superseded", "min", "max"}, rows: rows}, nil
+	}
+
+	tests := []struct {
+		client   *PgisClient
+		opts     *PgisIntersectsOptions
+		expected []string
+	}{
+		{&PgisClient{}, nil, []string{
+			"SELECT COALESCE(meta->>'wof:repo', ''), COUNT(id), COUNT(CASE WHEN is_deprecated=1 THEN 1 END), COUNT(CASE WHEN is_superseded=1 THEN 1 END), MIN(lastmod), MAX(lastmod) FROM whosonfirst GROUP BY 1 ORDER BY 1",
+			"SELECT COALESCE(meta->>'wof:repo', ''), placetype_id, COUNT(id) FROM whosonfirst GROUP BY 1, 2",
+		}},
+		{&PgisClient{ConflictKey: PGIS_CONFLICT_ID_REPO, PointsTable: "whosonfirst_points"}, &PgisIntersectsOptions{Country: "CA"}, []string{
+			`SELECT COALESCE(repo, ''), COUNT(id), COUNT(CASE WHEN is_deprecated=1 THEN 1 END), COUNT(CASE WHEN is_superseded=1 THEN 1 END), MIN(lastmod), MAX(lastmod) FROM (SELECT * FROM whosonfirst UNION ALL SELECT * FROM "whosonfirst_points") AS whosonfirst WHERE meta->>'wof:country'=$1 GROUP BY 1 ORDER BY 1`,
+			`SELECT COALESCE(repo, ''), placetype_id, COUNT(id) FROM (SELECT * FROM whosonfirst UNION ALL SELECT * FROM "whosonfirst_points") AS whosonfirst WHERE meta->>'wof:country'=$1 GROUP BY 1, 2`,
+		}},
+	}
+
+	for i, test := range tests {
+
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+			client.ConflictKey = test.client.ConflictKey
+			client.PointsTable = test.client.PointsTable
+
+			stats, err := client.RepoStats(context.Background(), test.opts)
+
+			if err != nil {
+				t.Fatalf("RepoStats failed because %s", err)
+			}
+
+			expected := []*PgisRepoStats{
+				{"whosonfirst-data-admin-ca", 3, 1, 0, "2017-08-23T00:00:00Z", "2019-01-01T00:00:00Z", map[int64]int64{102312317: 2, 102312307: 1}},
+				{"whosonfirst-data-admin-us", 4, 0, 2, "", "", map[int64]int64{102312317: 4}},
+			}
+
+			if !reflect.DeepEqual(stats, expected) {
+				t.Errorf("expected %v, got %v", expected, stats)
+			}
+
+			// the aggregate query is spread over a few lines
+
+			stmts := db.statements()
+
+			for i := range stmts {
+				stmts[i] = strings.Join(strings.Fields(stmts[i]), " ")
+			}
+
+			if strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected %v, got %v", test.expected, stmts)
+			}
+		})
+	}
+}
+
+func TestDistinct(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.HasPrefix(query, "SELECT DISTINCT placetype_id") {
+			return &testResult{columns: []string{"placetype_id"}, rows: [][]driver.Value{{int64(102312307)}, {int64(102312317)}}}, nil
+		}
+
+		return &testResult{columns: []string{"repo"}, rows: [][]driver.Value{{"whosonfirst-data-admin-ca"}, {"whosonfirst-data-admin-us"}}}, nil
+	}
+
+	tests := []struct {
+		conflict string
+		points   string
+		expected []string
+	}{
+		{"", "", []string{
+			"SELECT DISTINCT meta->>'wof:repo' FROM whosonfirst WHERE meta->>'wof:repo' IS NOT NULL ORDER BY 1",
+			"SELECT DISTINCT placetype_id FROM whosonfirst WHERE placetype_id IS NOT NULL ORDER BY 1",
+		}},
+		{PGIS_CONFLICT_ID_REPO, "whosonfirst_points", []string{
+			`SELECT DISTINCT repo FROM (SELECT * FROM whosonfirst UNION ALL SELECT * FROM "whosonfirst_points") AS whosonfirst WHERE repo IS NOT NULL ORDER BY 1`,
+			`SELECT DISTINCT placetype_id FROM (SELECT * FROM whosonfirst UNION ALL SELECT * FROM "whosonfirst_points") AS whosonfirst WHERE placetype_id IS NOT NULL ORDER BY 1`,
+		}},
+	}
+
+	for i, test := range tests {
+
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+			client.ConflictKey = test.conflict
+			client.PointsTable = test.points
+
+			metrics := newTestMetrics()
+			client.Metrics = metrics
+
+			repos, err := client.DistinctRepos(context.Background())
+
+			if err != nil {
+				t.Fatalf("DistinctRepos failed because %s", err)
+			}
+
+			if !reflect.DeepEqual(repos, []string{"whosonfirst-data-admin-ca", "whosonfirst-data-admin-us"}) {
+				t.Errorf("unexpected repos %v", repos)
+			}
+
+			placetypes, err := client.DistinctPlacetypes(context.Background())
+
+			if err != nil {
+				t.Fatalf("DistinctPlacetypes failed because %s", err)
+			}
+
+			if !reflect.DeepEqual(placetypes, []int64{102312307, 102312317}) {
+				t.Errorf("unexpected placetypes %v", placetypes)
+			}
+
+			if stmts := db.statements(); strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected %v, got %v", test.expected, stmts)
+			}
+
+			if metrics.calls["queried"] != 2 {
+				t.Errorf("expected 2 queries to be reported, got %d", metrics.calls["queried"])
+			}
+		})
+	}
+}
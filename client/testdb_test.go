@@ -0,0 +1,261 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a database/sql driver that doesn't talk to a database at all but records the
+// statements it is sent and answers them with whatever testDB.handler says, so
+// that the client can be tested all the way down to the connection
+
+const TEST_DRIVER = "pgis-test"
+
+type testResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+}
+
+type testDB struct {
+	mu      sync.Mutex
+	log     []string // every statement, including BEGIN, COMMIT and ROLLBACK
+	handler func(query string, args []driver.Value) (*testResult, error)
+}
+
+func (db *testDB) record(query string) {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.log = append(db.log, query)
+}
+
+func (db *testDB) statements() []string {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return append([]string{}, db.log...)
+}
+
+func (db *testDB) run(query string, named []driver.NamedValue) (*testResult, error) {
+
+	db.record(query)
+
+	if db.handler == nil {
+		return &testResult{}, nil
+	}
+
+	args := make([]driver.Value, len(named))
+
+	for i, v := range named {
+		args[i] = v.Value
+	}
+
+	return db.handler(query, args)
+}
+
+// the DSN is the name the testDB was registered under
+
+var testDBs sync.Map
+
+type testDriver struct{}
+
+func (d testDriver) Open(dsn string) (driver.Conn, error) {
+
+	v, ok := testDBs.Load(dsn)
+
+	if !ok {
+		return nil, errors.New("unknown test database")
+	}
+
+	return &testConn{db: v.(*testDB)}, nil
+}
+
+type testConn struct {
+	db *testDB
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *testConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return &testTx{db: c.db}, nil
+}
+
+func (c *testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+
+	rsp, err := c.db.run(query, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(rsp.affected), nil
+}
+
+func (c *testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+
+	rsp, err := c.db.run(query, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &testRows{result: rsp}, nil
+}
+
+type testTx struct {
+	db *testDB
+}
+
+func (tx *testTx) Commit() error {
+	tx.db.record("COMMIT")
+	return nil
+}
+
+func (tx *testTx) Rollback() error {
+	tx.db.record("ROLLBACK")
+	return nil
+}
+
+type testRows struct {
+	result *testResult
+	next   int
+}
+
+func (r *testRows) Columns() []string {
+	return r.result.columns
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+
+	copy(dest, r.result.rows[r.next])
+	r.next += 1
+
+	return nil
+}
+
+func init() {
+	sql.Register(TEST_DRIVER, testDriver{})
+}
+
+// a client connected to a testDB of its own that answers every statement with
+// handler (or nothing, if handler is nil)
+
+func newTestClient(t *testing.T, handler func(query string, args []driver.Value) (*testResult, error)) (*PgisClient, *testDB) {
+
+	db := &testDB{
+		handler: handler,
+	}
+
+	testDBs.Store(t.Name(), db)
+
+	client, err := NewPgisClientWithDriver(TEST_DRIVER, t.Name(), 4)
+
+	if err != nil {
+		t.Fatalf("failed to create test client because %s", err)
+	}
+
+	client.Logger = log.SimpleWOFLogger("test")
+
+	t.Cleanup(func() {
+		client.Close()
+		testDBs.Delete(t.Name())
+	})
+
+	return client, db
+}
+
+// the PgisRow columns (see QueryRowToPgisRow) for a record with no geometry
+
+func testRow(id int64, placetype_id int64, meta string, centroid string) []driver.Value {
+	return []driver.Value{id, int64(-1), placetype_id, int64(0), int64(0), meta, nil, centroid}
+}
+
+var TEST_ROW_COLUMNS = strings.Split("id,parent_id,placetype_id,is_superseded,is_deprecated,meta,geom,centroid", ",")
+
+// the value expression for each column in an INSERT ... VALUES statement, like
+// the ones upsertTableSQL makes
+
+func testInsertValues(t *testing.T, query string) map[string]string {
+
+	start := strings.Index(query, " (")
+	middle := strings.Index(query, ") VALUES (")
+	end := strings.Index(query, ") ON CONFLICT")
+
+	if start == -1 || middle == -1 || end == -1 {
+		t.Fatalf("not an upsert: %s", query)
+	}
+
+	cols := strings.Split(query[start+2:middle], ", ")
+	vals := make([]string, 0)
+
+	// split the values on the commas that aren't inside parentheses or
+	// string literals
+
+	depth := 0
+	quoted := false
+	last := middle + len(") VALUES (")
+
+	for i := last; i < end; i++ {
+
+		switch query[i] {
+		case '\'':
+			quoted = !quoted
+		case '(':
+			if !quoted {
+				depth += 1
+			}
+		case ')':
+			if !quoted {
+				depth -= 1
+			}
+		case ',':
+			if !quoted && depth == 0 {
+				vals = append(vals, strings.TrimSpace(query[last:i]))
+				last = i + 1
+			}
+		}
+	}
+
+	vals = append(vals, strings.TrimSpace(query[last:end]))
+
+	if len(vals) != len(cols) {
+		t.Fatalf("%d values for %d columns in %s", len(vals), len(cols), query)
+	}
+
+	values := make(map[string]string)
+
+	for i, col := range cols {
+		values[col] = vals[i]
+	}
+
+	return values
+}
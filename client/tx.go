@@ -0,0 +1,35 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+)
+
+// http://go-database-sql.org/modifying.html#working-with-transactions
+
+// run fn in a transaction which is committed if fn succeeds and rolled back if
+// it fails or ctx is cancelled (or times out) before it's done. Either way the
+// transaction is finished by the time this returns so it can never be left
+// open, holding locks, on a connection that's gone back in to the pool.
+
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
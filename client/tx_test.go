@@ -0,0 +1,180 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithTx(t *testing.T) {
+
+	db := &testDB{}
+
+	testDBs.Store(t.Name(), db)
+	defer testDBs.Delete(t.Name())
+
+	conn, err := sql.Open(TEST_DRIVER, t.Name())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, cancel context.CancelFunc, tx *sql.Tx) error
+		err  error
+		end  string
+	}{
+		{"commit", func(ctx context.Context, cancel context.CancelFunc, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM whosonfirst WHERE id=$1", 1)
+			return err
+		}, nil, "COMMIT"},
+		{"error", func(ctx context.Context, cancel context.CancelFunc, tx *sql.Tx) error {
+			return failed
+		}, failed, "ROLLBACK"},
+		{"cancelled", func(ctx context.Context, cancel context.CancelFunc, tx *sql.Tx) error {
+
+			_, err := tx.ExecContext(ctx, "DELETE FROM whosonfirst WHERE id=$1", 1)
+
+			// fn doesn't notice but withTx does
+
+			cancel()
+			return err
+		}, context.Canceled, "ROLLBACK"},
+	}
+
+	for _, test := range tests {
+
+		db.mu.Lock()
+		db.log = nil
+		db.mu.Unlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		err := withTx(ctx, conn, func(tx *sql.Tx) error {
+			return test.fn(ctx, cancel, tx)
+		})
+
+		cancel()
+
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+
+		// database/sql also rolls back a transaction whose context has been
+		// cancelled, in the background, so that might not have happened yet
+
+		var stmts []string
+
+		for i := 0; i < 100; i++ {
+
+			stmts = db.statements()
+
+			if len(stmts) > 0 && (stmts[len(stmts)-1] == "COMMIT" || stmts[len(stmts)-1] == "ROLLBACK") {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if len(stmts) == 0 || stmts[0] != "BEGIN" || stmts[len(stmts)-1] != test.end {
+			t.Errorf("%s: expected the transaction to end with %s, got %v", test.name, test.end, stmts)
+		}
+
+		if strings.Count(strings.Join(stmts, "\n"), "COMMIT")+strings.Count(strings.Join(stmts, "\n"), "ROLLBACK") != 1 {
+			t.Errorf("%s: expected the transaction to be finished exactly once, got %v", test.name, stmts)
+		}
+	}
+}
+
+// a chunked write that fails part way through is rolled back as a whole
+
+func TestChunkedWritesRollBack(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.Contains(query, `"pts"`) {
+			return nil, errors.New("relation \"pts\" does not exist")
+		}
+
+		return &testResult{affected: 1}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+	client.PointsTable = "pts"
+
+	ctx := context.Background()
+
+	calls := []struct {
+		name string
+		call func() (int64, error)
+	}{
+		{"DeleteIds", func() (int64, error) { return client.DeleteIds(ctx, []int64{101736545}) }},
+		{"Touch", func() (int64, error) { return client.Touch(ctx, []int64{101736545}) }},
+		{"SweepStale", func() (int64, error) { return client.SweepStale(ctx, "whosonfirst-data", "run") }},
+	}
+
+	for _, c := range calls {
+
+		db.mu.Lock()
+		db.log = nil
+		db.mu.Unlock()
+
+		count, err := c.call()
+
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+
+		if count != 0 {
+			t.Errorf("%s: expected nothing to be reported as changed, got %d", c.name, count)
+		}
+
+		stmts := db.statements()
+
+		// BEGIN, the whosonfirst table, the points table and ROLLBACK
+
+		if len(stmts) != 4 || stmts[0] != "BEGIN" || stmts[3] != "ROLLBACK" {
+			t.Errorf("%s: expected the writes to be rolled back, got %v", c.name, stmts)
+		}
+	}
+
+	// and one that doesn't is committed, once
+
+	client.PointsTable = ""
+
+	db.mu.Lock()
+	db.log = nil
+	db.mu.Unlock()
+
+	ids := make([]int64, IDS_CHUNK_SIZE+1)
+
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+
+	deleted, err := client.DeleteIds(ctx, ids)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 2 {
+		t.Errorf("expected 2 deleted (one per chunk), got %d", deleted)
+	}
+
+	expected := []string{"BEGIN", "DELETE FROM whosonfirst WHERE id = ANY($1)", "DELETE FROM whosonfirst WHERE id = ANY($1)", "COMMIT"}
+
+	if !reflect.DeepEqual(db.statements(), expected) {
+		t.Errorf("expected %v, got %v", expected, db.statements())
+	}
+}
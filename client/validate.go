@@ -0,0 +1,310 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// http://postgis.net/docs/ST_IsValid.html
+
+// InvalidGeometries returns the IDs of all the records whose geom column is not
+// a valid geometry, for example because it has self-intersecting rings. This is
+// a full table scan.
+
+func (client *PgisClient) InvalidGeometries(ctx context.Context) ([]int64, error) {
+
+	t1 := time.Now()
+
+	ids, err := client.invalidGeometries(ctx)
+
+	client.reportQueried(t1, err)
+	return ids, err
+}
+
+func (client *PgisClient) invalidGeometries(ctx context.Context) ([]int64, error) {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	geom_col := client.columns().geom
+
+	sql := fmt.Sprintf("SELECT id FROM whosonfirst WHERE %s IS NOT NULL AND NOT ST_IsValid(%s::geometry)", geom_col, geom_col)
+
+	if client.Verbose {
+		client.Logger.Status("%s", sql)
+	}
+
+	rows, err := db.QueryContext(ctx, sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+
+	for rows.Next() {
+
+		var id int64
+
+		err := rows.Scan(&id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// http://postgis.net/docs/ST_Covers.html
+// http://postgis.net/docs/ST_DWithin.html
+
+// centroids this close (in meters) to their geometry are considered to be on its
+// boundary, to allow for rounding
+
+const CENTROID_CONTAINMENT_TOLERANCE = 1.0
+
+// CheckCentroidContainment returns the IDs of all the records whose centroid is
+// not inside (or on the boundary of) their geom column, which is usually a sign
+// of a bad centroid or of a geometry that belongs to some other record. Records
+// without a geometry, like Point features, are not checked. This is a full table
+// scan.
+
+func (client *PgisClient) CheckCentroidContainment(ctx context.Context) ([]int64, error) {
+
+	t1 := time.Now()
+
+	ids, err := client.checkCentroidContainment(ctx)
+
+	client.reportQueried(t1, err)
+	return ids, err
+}
+
+func (client *PgisClient) checkCentroidContainment(ctx context.Context) ([]int64, error) {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	cols := client.columns()
+
+	// ST_Covers is (a lot) cheaper so ST_DWithin only has to deal with the
+	// centroids that are outside their geometry
+
+	sql := fmt.Sprintf("SELECT id FROM whosonfirst WHERE %s IS NOT NULL AND %s IS NOT NULL AND NOT ST_Covers(%s, %s) AND NOT ST_DWithin(%s, %s, $1)", cols.geom, cols.centroid, cols.geom, cols.centroid, cols.geom, cols.centroid)
+
+	if client.Verbose {
+		client.Logger.Status("%s %f", sql, CENTROID_CONTAINMENT_TOLERANCE)
+	}
+
+	rows, err := db.QueryContext(ctx, sql, CENTROID_CONTAINMENT_TOLERANCE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+
+	for rows.Next() {
+
+		var id int64
+
+		err := rows.Scan(&id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// http://postgis.net/docs/ST_MakeValid.html
+
+// FixGeometries replaces the geom column of each of ids that isn't valid with
+// the output of ST_MakeValid, keeping only the polygons if it was a polygon to
+// start with, and updates everything derived from it the same way UnionGeometry
+// does. It returns the number of records updated. Like DeleteIds the updates are
+// done in chunks, in a single transaction.
+
+func (client *PgisClient) FixGeometries(ctx context.Context, ids []int64) (int64, error) {
+
+	t1 := time.Now()
+
+	fixed, err := client.fixGeometries(ctx, ids)
+
+	client.reportExecuted(t1, err)
+	return fixed, err
+}
+
+func (client *PgisClient) fixGeometries(ctx context.Context, ids []int64) (int64, error) {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	sql_fix, derived, err := client.fixGeometriesSQL()
+
+	if err != nil {
+		return 0, err
+	}
+
+	fixed := int64(0)
+
+	fix := func(tx *sql.Tx) error {
+
+		for _, chunk := range chunkIds(ids, IDS_CHUNK_SIZE) {
+
+			if client.Verbose {
+
+				for _, stmt := range append([]string{sql_fix}, derived...) {
+					client.Logger.Status("%s (%d ids)", stmt, len(chunk))
+				}
+			}
+
+			if client.Debug {
+				continue
+			}
+
+			// only the records that were actually fixed need
+			// anything else updating
+
+			rows, err := tx.QueryContext(ctx, sql_fix, pq.Array(chunk))
+
+			if err != nil {
+				client.Logger.Warning("Failed to fix geometries because %s (%s)", err, sql_fix)
+				return err
+			}
+
+			fixed_ids := make([]int64, 0)
+
+			for rows.Next() {
+
+				var id int64
+
+				err := rows.Scan(&id)
+
+				if err != nil {
+					rows.Close()
+					return err
+				}
+
+				fixed_ids = append(fixed_ids, id)
+			}
+
+			rows.Close()
+
+			err = rows.Err()
+
+			if err != nil {
+				return err
+			}
+
+			if len(fixed_ids) == 0 {
+				continue
+			}
+
+			for _, stmt := range derived {
+
+				_, err := tx.ExecContext(ctx, stmt, pq.Array(fixed_ids))
+
+				if err != nil {
+					client.Logger.Warning("Failed to fix geometries because %s (%s)", err, stmt)
+					return err
+				}
+			}
+
+			fixed += int64(len(fixed_ids))
+		}
+
+		return nil
+	}
+
+	if client.Debug {
+		return 0, fix(nil)
+	}
+
+	err = withTx(ctx, db, fix)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return fixed, nil
+}
+
+// the statement that fixes the invalid geometries in the chunk of IDs passed as
+// $1, returning the IDs it fixed, and the ones in derivedGeometrySQL to run for
+// those IDs. ST_MakeValid can turn a polygon in to a collection with lines or
+// points in it too so only the polygons are kept from a polygon, but lines are
+// left as they are
+
+func (client *PgisClient) fixGeometriesSQL() (string, []string, error) {
+
+	table, err := client.routeTable("MultiPolygon")
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if table == "" {
+		table = "whosonfirst"
+	}
+
+	geom_col := client.columns().geom
+
+	st_geom := fmt.Sprintf("%s::geometry", geom_col)
+	st_valid := fmt.Sprintf("CASE WHEN ST_Dimension(%s) = 2 THEN ST_CollectionExtract(ST_MakeValid(%s), 3) ELSE ST_MakeValid(%s) END", st_geom, st_geom, st_geom)
+
+	sql_fix := fmt.Sprintf("UPDATE %s SET %s=ST_Multi(%s)::geography WHERE id = ANY($1) AND NOT ST_IsValid(%s) RETURNING id", table, geom_col, st_valid, st_geom)
+
+	derived, err := client.derivedGeometrySQL(table, "id = ANY($1)")
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return sql_fix, derived, nil
+}
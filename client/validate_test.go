@@ -0,0 +1,172 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestInvalidGeometries(t *testing.T) {
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		rows := [][]driver.Value{{int64(101736545)}, {int64(85633041)}}
+		return &testResult{columns: []string{"id"}, rows: rows}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	ids, err := client.InvalidGeometries(context.Background())
+
+	if err != nil {
+		t.Fatalf("InvalidGeometries failed because %s", err)
+	}
+
+	if !reflect.DeepEqual(ids, []int64{101736545, 85633041}) {
+		t.Errorf("unexpected ids %v", ids)
+	}
+
+	expected := "SELECT id FROM whosonfirst WHERE geom IS NOT NULL AND NOT ST_IsValid(geom::geometry)"
+
+	if stmts := db.statements(); len(stmts) != 1 || stmts[0] != expected {
+		t.Errorf("expected %s, got %v", expected, stmts)
+	}
+}
+
+func TestFixGeometriesSQL(t *testing.T) {
+
+	st_valid := "CASE WHEN ST_Dimension(geom::geometry) = 2 THEN ST_CollectionExtract(ST_MakeValid(geom::geometry), 3) ELSE ST_MakeValid(geom::geometry) END"
+	sql_fix := "UPDATE whosonfirst SET geom=ST_Multi(" + st_valid + ")::geography WHERE id = ANY($1) AND NOT ST_IsValid(geom::geometry) RETURNING id"
+
+	tests := []struct {
+		client   *PgisClient
+		expected []string
+	}{
+		{&PgisClient{}, []string{sql_fix}},
+		{&PgisClient{SimplifiedTolerance: 0.5, StoreBBox: true, StoreArea: true}, []string{
+			sql_fix,
+			"UPDATE whosonfirst SET geom_simplified=ST_Multi(ST_SimplifyPreserveTopology(geom::geometry, 0.5)), bbox=(SELECT ST_MakeEnvelope(ST_XMin(b), ST_YMin(b), ST_XMax(b), ST_YMax(b), 4326) FROM Box2D(geom::geometry) AS b), area_meters=ST_Area(geom::geometry::geography) WHERE id = ANY($1)",
+		}},
+		{&PgisClient{SubdivideMaxVertices: 256, PointsTable: "whosonfirst_points"}, []string{
+			sql_fix,
+			"DELETE FROM whosonfirst_subdivided WHERE id = ANY($1)",
+			"INSERT INTO whosonfirst_subdivided (id, geom) SELECT id, ST_Subdivide(geom::geometry, 256) FROM whosonfirst WHERE id = ANY($1)",
+		}},
+	}
+
+	for i, test := range tests {
+
+		fix, derived, err := test.client.fixGeometriesSQL()
+
+		if err != nil {
+			t.Fatalf("test %d: fixGeometriesSQL failed because %s", i, err)
+		}
+
+		stmts := append([]string{fix}, derived...)
+
+		if strings.Join(stmts, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, stmts)
+		}
+	}
+}
+
+func TestFixGeometries(t *testing.T) {
+
+	// only 85633041 needs fixing
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if strings.HasSuffix(query, "RETURNING id") {
+			return &testResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(85633041)}}}, nil
+		}
+
+		return &testResult{affected: 1}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+	client.StoreArea = true
+
+	fixed, err := client.FixGeometries(context.Background(), []int64{101736545, 85633041})
+
+	if err != nil {
+		t.Fatalf("FixGeometries failed because %s", err)
+	}
+
+	if fixed != 1 {
+		t.Errorf("expected 1 geometry to be fixed, got %d", fixed)
+	}
+
+	stmts := db.statements()
+
+	if len(stmts) != 4 || stmts[0] != "BEGIN" || !strings.HasSuffix(stmts[1], "RETURNING id") || !strings.Contains(stmts[2], "area_meters=") || stmts[3] != "COMMIT" {
+		t.Errorf("expected the fix and the area to be updated in a transaction, got %v", stmts)
+	}
+
+	// and nothing else is updated if nothing was fixed
+
+	handler = func(query string, args []driver.Value) (*testResult, error) {
+		return &testResult{columns: []string{"id"}}, nil
+	}
+
+	client, db = newTestClient(t, handler)
+	client.StoreArea = true
+
+	fixed, err = client.FixGeometries(context.Background(), []int64{101736545})
+
+	if err != nil {
+		t.Fatalf("FixGeometries failed because %s", err)
+	}
+
+	if stmts := db.statements(); fixed != 0 || len(stmts) != 3 {
+		t.Errorf("expected nothing to be fixed, got %d and %v", fixed, stmts)
+	}
+}
+
+func TestCheckCentroidContainment(t *testing.T) {
+
+	var query_args []driver.Value
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+		query_args = args
+		return &testResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(85633041)}}}, nil
+	}
+
+	tests := []struct {
+		client   *PgisClient
+		expected string
+	}{
+		{&PgisClient{}, "SELECT id FROM whosonfirst WHERE geom IS NOT NULL AND centroid IS NOT NULL AND NOT ST_Covers(geom, centroid) AND NOT ST_DWithin(geom, centroid, $1)"},
+		{&PgisClient{GeomColumn: "shape", CentroidColumn: "label"}, `SELECT id FROM whosonfirst WHERE "shape" IS NOT NULL AND "label" IS NOT NULL AND NOT ST_Covers("shape", "label") AND NOT ST_DWithin("shape", "label", $1)`},
+	}
+
+	for i, test := range tests {
+
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+
+			client, db := newTestClient(t, handler)
+			client.GeomColumn = test.client.GeomColumn
+			client.CentroidColumn = test.client.CentroidColumn
+
+			ids, err := client.CheckCentroidContainment(context.Background())
+
+			if err != nil {
+				t.Fatalf("CheckCentroidContainment failed because %s", err)
+			}
+
+			if !reflect.DeepEqual(ids, []int64{85633041}) {
+				t.Errorf("unexpected ids %v", ids)
+			}
+
+			if stmts := db.statements(); len(stmts) != 1 || stmts[0] != test.expected {
+				t.Errorf("expected %s, got %v", test.expected, stmts)
+			}
+
+			if len(query_args) != 1 || query_args[0] != CENTROID_CONTAINMENT_TOLERANCE {
+				t.Errorf("expected the tolerance to be %f, got %v", CENTROID_CONTAINMENT_TOLERANCE, query_args)
+			}
+		})
+	}
+}
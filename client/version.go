@@ -0,0 +1,241 @@
+package pgis
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// http://postgis.net/docs/PostGIS_Lib_Version.html
+
+// PostGISVersion returns the major and minor version of the PostGIS extension
+// (not PostgreSQL itself) installed in the database. The version is looked up
+// the first time it's needed and remembered for the life of the client.
+
+func (client *PgisClient) PostGISVersion(ctx context.Context) (int, int, error) {
+
+	db, err := client.dbconn()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer func() {
+		client.conns <- true
+	}()
+
+	return client.cachedPostGISVersion(ctx, db)
+}
+
+// failed lookups aren't remembered so a database that was briefly unreachable
+// doesn't leave the client without a version forever
+
+func (client *PgisClient) cachedPostGISVersion(ctx context.Context, db *sql.DB) (int, int, error) {
+
+	client.postgis_mu.Lock()
+	defer client.postgis_mu.Unlock()
+
+	if client.postgis_version != nil {
+		return client.postgis_version[0], client.postgis_version[1], nil
+	}
+
+	major, minor, err := postGISVersion(ctx, db)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	client.postgis_version = []int{major, minor}
+	return major, minor, nil
+}
+
+// this takes a *sql.DB rather than acquiring a connection itself so that it
+// can be called by methods that are already holding one
+
+func postGISVersion(ctx context.Context, db *sql.DB) (int, int, error) {
+
+	var str_version string
+
+	row := db.QueryRowContext(ctx, "SELECT postgis_lib_version()")
+	err := row.Scan(&str_version)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return parsePostGISVersion(str_version)
+}
+
+// the version string looks like "2.4.1" or sometimes "3.0.0alpha4" or
+// "3.1rc1" so only the leading digits of the major and minor parts are
+// considered
+
+func parsePostGISVersion(str_version string) (int, int, error) {
+
+	parts := strings.Split(str_version, ".")
+
+	if len(parts) < 2 {
+		msg := fmt.Sprintf("invalid PostGIS version '%s'", str_version)
+		return 0, 0, errors.New(msg)
+	}
+
+	version := make([]int, 2)
+
+	for i, p := range parts[:2] {
+
+		digits := p
+
+		idx := strings.IndexFunc(p, func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+
+		if idx != -1 {
+			digits = p[:idx]
+		}
+
+		v, err := strconv.Atoi(digits)
+
+		if err != nil {
+			msg := fmt.Sprintf("invalid PostGIS version '%s'", str_version)
+			return 0, 0, errors.New(msg)
+		}
+
+		version[i] = v
+	}
+
+	return version[0], version[1], nil
+}
+
+// the oldest versions of PostGIS that have the functions used by the optional
+// parts of the client, as a major, minor pair. ST_PointOnSurface and
+// ST_Centroid are as old as ST_GeomFromGeoJSON, which everything needs, but
+// ServerCentroid is checked all the same so that every option that depends on
+// PostGIS fails the same way.
+
+var POSTGIS_ST_SUBDIVIDE = []int{2, 2}
+var POSTGIS_ST_MAKEVALID = []int{2, 0}
+var POSTGIS_ST_FORCEPOLYGONCCW = []int{2, 4}
+var POSTGIS_ST_POINTONSURFACE = []int{2, 0}
+
+// the versions of PostGIS needed by the client's indexing options, by the name
+// of the function that needs them
+
+func (client *PgisClient) postGISRequirements() map[string][]int {
+
+	requirements := make(map[string][]int)
+
+	if client.SubdivideMaxVertices > 0 {
+		requirements["ST_Subdivide"] = POSTGIS_ST_SUBDIVIDE
+	}
+
+	if client.CoordinatePrecision > 0 {
+		requirements["ST_MakeValid"] = POSTGIS_ST_MAKEVALID
+	}
+
+	if client.RingOrientation != "" {
+		requirements["ST_ForcePolygonCCW"] = POSTGIS_ST_FORCEPOLYGONCCW
+	}
+
+	if client.ServerCentroid != "" {
+		requirements["ST_PointOnSurface"] = POSTGIS_ST_POINTONSURFACE
+	}
+
+	return requirements
+}
+
+// returns an ErrUnsupportedPostGIS error for the first (by name) of
+// requirements that the database's version of PostGIS is too old for
+
+func (client *PgisClient) requirePostGIS(ctx context.Context, db *sql.DB, requirements map[string][]int) error {
+
+	if len(requirements) == 0 {
+		return nil
+	}
+
+	major, minor, err := client.cachedPostGISVersion(ctx, db)
+
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(requirements))
+
+	for what := range requirements {
+		names = append(names, what)
+	}
+
+	sort.Strings(names)
+
+	for _, what := range names {
+
+		err := checkPostGISVersion(major, minor, what, requirements[what])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the version checks for the methods that take PgisIntersectsOptions; opts may
+// be nil. This acquires a connection if the version hasn't been looked up yet
+// so it must be called before the caller gets one of its own.
+
+func (client *PgisClient) requireQueryPostGIS(ctx context.Context, opts *PgisIntersectsOptions) error {
+
+	if opts == nil || !opts.MakeValid {
+		return nil
+	}
+
+	major, minor, err := client.PostGISVersion(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return checkPostGISVersion(major, minor, "ST_MakeValid", POSTGIS_ST_MAKEVALID)
+}
+
+// returns an error like "ST_Subdivide requires PostGIS >= 2.2 (found 2.1)"
+// if major.minor is older than required
+
+func checkPostGISVersion(major int, minor int, what string, required []int) error {
+
+	if major > required[0] || (major == required[0] && minor >= required[1]) {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s requires PostGIS >= %d.%d (found %d.%d)", what, required[0], required[1], major, minor)
+	return newPgisError(ErrUnsupportedPostGIS, errors.New(msg))
+}
+
+// https://www.postgresql.org/docs/current/static/runtime-config-preset.html
+
+// the version of PostgreSQL itself as an integer like 120004 (12.4) or 90613
+// (9.6.13) which is easier to compare than the version string
+
+func postgresVersion(ctx context.Context, db *sql.DB) (int, error) {
+
+	var str_version string
+
+	row := db.QueryRowContext(ctx, "SHOW server_version_num")
+	err := row.Scan(&str_version)
+
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(str_version))
+
+	if err != nil {
+		msg := fmt.Sprintf("invalid PostgreSQL version '%s'", str_version)
+		return 0, errors.New(msg)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,216 @@
+package pgis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"strings"
+	"testing"
+)
+
+func TestParsePostGISVersion(t *testing.T) {
+
+	tests := []struct {
+		version string
+		major   int
+		minor   int
+		err     bool
+	}{
+		{"2.4.1", 2, 4, false},
+		{"2.2", 2, 2, false},
+		{"3.0.0alpha4", 3, 0, false},
+		{"3.1rc1", 3, 1, false},
+		{"3.4.2 r1234", 3, 4, false},
+		{"10.12.1", 10, 12, false},
+		{"3", 0, 0, true},
+		{"", 0, 0, true},
+		{"three.one", 0, 0, true},
+		{"3.beta", 0, 0, true},
+	}
+
+	for _, test := range tests {
+
+		major, minor, err := parsePostGISVersion(test.version)
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("parsePostGISVersion(%q) returned %d.%d, expected an error", test.version, major, minor)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parsePostGISVersion(%q) failed because %s", test.version, err)
+			continue
+		}
+
+		if major != test.major || minor != test.minor {
+			t.Errorf("parsePostGISVersion(%q) returned %d.%d, expected %d.%d", test.version, major, minor, test.major, test.minor)
+		}
+	}
+}
+
+func TestCheckPostGISVersion(t *testing.T) {
+
+	tests := []struct {
+		major    int
+		minor    int
+		required []int
+		ok       bool
+	}{
+		{2, 2, POSTGIS_ST_SUBDIVIDE, true},
+		{2, 1, POSTGIS_ST_SUBDIVIDE, false},
+		{3, 0, POSTGIS_ST_SUBDIVIDE, true},
+		{2, 3, POSTGIS_ST_FORCEPOLYGONCCW, false},
+		{2, 4, POSTGIS_ST_FORCEPOLYGONCCW, true},
+		{1, 5, POSTGIS_ST_MAKEVALID, false},
+		{2, 0, POSTGIS_ST_POINTONSURFACE, true},
+	}
+
+	for _, test := range tests {
+
+		err := checkPostGISVersion(test.major, test.minor, "test", test.required)
+
+		if test.ok && err != nil {
+			t.Errorf("%d.%d against %v failed because %s", test.major, test.minor, test.required, err)
+		}
+
+		if !test.ok && !errors.Is(err, ErrUnsupportedPostGIS) {
+			t.Errorf("%d.%d against %v returned %v, expected ErrUnsupportedPostGIS", test.major, test.minor, test.required, err)
+		}
+	}
+}
+
+func TestRequirePostGIS(t *testing.T) {
+
+	version := "2.1.8"
+
+	handler := func(query string, args []driver.Value) (*testResult, error) {
+
+		if query == "SELECT postgis_lib_version()" {
+			return &testResult{columns: []string{"postgis_lib_version"}, rows: [][]driver.Value{{version}}}, nil
+		}
+
+		if strings.HasPrefix(query, "SELECT COUNT") {
+			return &testResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}, nil
+		}
+
+		return &testResult{affected: 1}, nil
+	}
+
+	client, db := newTestClient(t, handler)
+
+	f, err := feature.LoadFeature([]byte(`{"type":"Feature","id":101736545,"properties":{"wof:id":101736545,"wof:name":"Montreal","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`))
+
+	if err != nil {
+		t.Fatalf("failed to load feature because %s", err)
+	}
+
+	ctx := context.Background()
+
+	opts := NewDefaultPgisIntersectsOptions()
+	opts.MakeValid = true
+
+	// nothing that needs a newer PostGIS is used so the version isn't even
+	// looked up
+
+	err = client.IndexFeature(f, "test")
+
+	if err != nil {
+		t.Fatalf("IndexFeature without any options failed because %s", err)
+	}
+
+	options := []struct {
+		name  string
+		set   func(bool)
+		valid bool // whether 2.1 is new enough
+	}{
+		{"SubdivideMaxVertices", func(on bool) {
+			client.SubdivideMaxVertices = 0
+
+			if on {
+				client.SubdivideMaxVertices = 256
+			}
+		}, false},
+		{"RingOrientation", func(on bool) {
+			client.RingOrientation = ""
+
+			if on {
+				client.RingOrientation = PGIS_RING_ORIENTATION_CCW
+			}
+		}, false},
+		{"ServerCentroid", func(on bool) {
+			client.ServerCentroid = ""
+
+			if on {
+				client.ServerCentroid = PGIS_SERVER_CENTROID_CENTROID
+			}
+		}, true},
+		{"CoordinatePrecision", func(on bool) {
+			client.CoordinatePrecision = 0
+
+			if on {
+				client.CoordinatePrecision = 6
+			}
+		}, true},
+	}
+
+	for _, option := range options {
+
+		option.set(true)
+
+		err = client.IndexFeature(f, "test")
+
+		option.set(false)
+
+		if option.valid && err != nil {
+			t.Errorf("IndexFeature with %s set failed because %s", option.name, err)
+		}
+
+		if !option.valid && !errors.Is(err, ErrUnsupportedPostGIS) {
+			t.Errorf("expected IndexFeature with %s set to return ErrUnsupportedPostGIS, got %v", option.name, err)
+		}
+	}
+
+	_, err = client.Count(ctx, opts)
+
+	if err != nil {
+		t.Errorf("Count with MakeValid failed because %s", err)
+	}
+
+	// the version is only ever looked up once
+
+	lookups := 0
+
+	for _, stmt := range db.statements() {
+
+		if stmt == "SELECT postgis_lib_version()" {
+			lookups += 1
+		}
+	}
+
+	if lookups != 1 {
+		t.Errorf("expected the PostGIS version to be looked up once, not %d times", lookups)
+	}
+
+	// and that a version that's too old is refused for queries too; the
+	// client has to forget the version it's already seen for that
+
+	version = "1.5.8"
+	client.postgis_version = nil
+
+	_, err = client.Count(ctx, opts)
+
+	if !errors.Is(err, ErrUnsupportedPostGIS) {
+		t.Errorf("expected Count with MakeValid to return ErrUnsupportedPostGIS for PostGIS %s, got %v", version, err)
+	}
+
+	_, err = client.Count(ctx, nil)
+
+	if err != nil {
+		t.Errorf("Count without MakeValid failed because %s", err)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/tidwall/pretty"
-	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"github.com/whosonfirst/go-whosonfirst-pgis/flags"
 	"log"
 	"os"
 	"strconv"
@@ -16,19 +16,25 @@ type DBrow struct {
 
 func main() {
 
-	pgis_host := flag.String("pgis-host", "localhost", "The host of your PostgreSQL server.")
-	pgis_port := flag.Int("pgis-port", 5432, "The port of your PostgreSQL server.")
-	pgis_user := flag.String("pgis-user", "whosonfirst", "The name of your PostgreSQL user.")
-	pgis_pswd := flag.String("pgis-password", "", "The password of your PostgreSQL user.")
-	pgis_dbname := flag.String("pgis-database", "whosonfirst", "The name of your PostgreSQL database.")
-	pgis_maxconns := flag.Int("pgis-maxconns", 10, "The maximum number of connections to use with your PostgreSQL database.")
+	config := flag.String("config", "", "The path to a JSON config file whose keys are the names of any of the flags below. Flags passed on the command line take precedence.")
+
+	pgis_flags := flags.NewPgisFlags(flag.CommandLine)
 
 	flag.Parse()
 
-	client, err := pgis.NewPgisClient(*pgis_host, *pgis_port, *pgis_user, *pgis_pswd, *pgis_dbname, *pgis_maxconns)
+	if *config != "" {
+
+		err := flags.ApplyConfigFile(flag.CommandLine, *config)
+
+		if err != nil {
+			log.Fatalf("failed to read config file %s because %v", *config, err)
+		}
+	}
+
+	client, err := pgis_flags.NewClient()
 
 	if err != nil {
-		log.Fatalf("failed to create PgisClient (%s:%d) because %v", *pgis_host, *pgis_port, err)
+		log.Fatal(err)
 	}
 
 	for _, str_id := range flag.Args() {
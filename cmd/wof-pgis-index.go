@@ -1,85 +1,724 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	wof "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
 	"github.com/whosonfirst/go-whosonfirst-index"
 	"github.com/whosonfirst/go-whosonfirst-index/utils"
 	"github.com/whosonfirst/go-whosonfirst-log"
 	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"github.com/whosonfirst/go-whosonfirst-pgis/flags"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
 	"github.com/whosonfirst/go-whosonfirst-timer"
+	"github.com/whosonfirst/go-whosonfirst-uri"
 	"io"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// the conventional exit status for a process terminated by SIGINT
+
+const EXIT_INTERRUPTED = 130
+
+// https://tools.ietf.org/html/rfc1952#page-6
+
+var gzip_magic = []byte{0x1f, 0x8b}
+
+// fh decompressed if it starts with the gzip magic bytes and fh unchanged
+// (give or take some buffering) if it doesn't
+
+func maybeGunzip(fh io.Reader) (io.Reader, error) {
+
+	br := bufio.NewReader(fh)
+
+	magic, err := br.Peek(len(gzip_magic))
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, gzip_magic) {
+		return br, nil
+	}
+
+	return gzip.NewReader(br)
+}
+
+// a feature that has been read (and parsed) by indexPipeline and is waiting
+// to be indexed
+
+type loadedFeature struct {
+	path    string
+	feature geojson.Feature
+}
+
+// send the paths to index (either the files themselves or the contents of one
+// or more filelist files) to path_ch until there aren't any more or ctx is
+// cancelled
+
+func producePaths(ctx context.Context, mode string, args []string, path_ch chan<- string) error {
+
+	defer close(path_ch)
+
+	send := func(path string) bool {
+
+		select {
+		case path_ch <- path:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for _, arg := range args {
+
+		if mode == "files" {
+
+			if !send(arg) {
+				return nil
+			}
+
+			continue
+		}
+
+		fh, err := os.Open(arg)
+
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(fh)
+
+		for scanner.Scan() {
+
+			if !send(scanner.Text()) {
+				fh.Close()
+				return nil
+			}
+		}
+
+		err = scanner.Err()
+		fh.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// this is an alternative to the go-whosonfirst-index indexer, which reads (and
+// parses) each file and then indexes it before moving on to the next one, for
+// lots of small files where disk IO and JSON parsing are the bottleneck: a pool
+// of readers parse files and hand them off to a pool of workers that do the
+// inserts. The first error from any of them stops everything and is returned.
+
+func indexPipeline(ctx context.Context, mode string, args []string, readers int, workers int, load func(string) (geojson.Feature, error), store func(geojson.Feature, string) error) error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	path_ch := make(chan string)
+	feature_ch := make(chan loadedFeature, readers)
+	err_ch := make(chan error, readers+workers+1)
+
+	fail := func(err error) {
+		err_ch <- err
+		cancel()
+	}
+
+	go func() {
+
+		err := producePaths(ctx, mode, args, path_ch)
+
+		if err != nil {
+			fail(err)
+		}
+	}()
+
+	read_wg := new(sync.WaitGroup)
+
+	for i := 0; i < readers; i++ {
+
+		read_wg.Add(1)
+
+		go func() {
+
+			defer read_wg.Done()
+
+			for path := range path_ch {
+
+				f, err := load(path)
+
+				if err != nil {
+					fail(err)
+					return
+				}
+
+				if f == nil {
+					continue
+				}
+
+				select {
+				case feature_ch <- loadedFeature{path: path, feature: f}:
+					// pass
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		read_wg.Wait()
+		close(feature_ch)
+	}()
+
+	index_wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+
+		index_wg.Add(1)
+
+		go func() {
+
+			defer index_wg.Done()
+
+			for lf := range feature_ch {
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				err := store(lf.feature, lf.path)
+
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+
+	index_wg.Wait()
+
+	select {
+	case err := <-err_ch:
+		return err
+	default:
+		return nil
+	}
+}
+
+// the part of a pgis.PgisClient that wof-pgis-index needs, so that loading can
+// be tested without a database
+
+type featureIndexer interface {
+	IndexFeatureContext(ctx context.Context, feature geojson.Feature, collection string) error
+	IndexLabelContext(ctx context.Context, feature geojson.Feature) error
+	ResolvePlacetype(name string) (*placetypes.WOFPlacetype, error)
+	Close() error
+}
+
+// the set of placetypes in names, each of which has to be one that client knows
+// about, which includes any custom placetypes (see PgisClient.PlacetypeResolver)
+
+func wantedPlacetypes(client featureIndexer, names []string) (map[string]bool, error) {
+
+	wanted := make(map[string]bool)
+
+	for _, name := range names {
+
+		_, err := client.ResolvePlacetype(name)
+
+		if err != nil {
+			msg := fmt.Sprintf("invalid placetype '%s' because %s", name, err)
+			return nil, errors.New(msg)
+		}
+
+		wanted[name] = true
+	}
+
+	return wanted, nil
+}
+
+// a loader reads (and checks) each file it is given and hands the features
+// that are wanted to client, counting the ones that are skipped along the way
+
+type loader struct {
+	client             featureIndexer
+	table              string
+	labels             bool
+	placetypes         map[string]bool // empty means every placetype is wanted
+	check_filename     string          // "", "warn" or "strict"
+	budget             *pgis.PgisErrorBudget
+	logger             *log.WOFLogger
+	skipped            int64 // not one of placetypes
+	skipped_earth      int64
+	skipped_empty      int64
+	skipped_deprecated int64
+	skipped_superseded int64
+}
+
+// load returns a nil feature (and no error) for files that aren't principal
+// WOF records, which are skipped
+
+func (l *loader) load(fh io.Reader, ctx context.Context) (geojson.Feature, error) {
+
+	path, _ := index.PathForContext(ctx)
+
+	// gzipped files (123.geojson.gz) are treated as though they had the
+	// name of the file they contain
+
+	wof_path := path
+
+	if path != index.STDIN && strings.HasSuffix(path, ".gz") {
+
+		wof_path = strings.TrimSuffix(path, ".gz")
+
+		wof_ctx, err := index.ContextForPath(wof_path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ctx = wof_ctx
+	}
+
+	ok, err := utils.IsPrincipalWOFRecord(fh, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		// we know we've just invoked this above so...
+		// path, _ := index.PathForContext(ctx)
+		// logger.Debug("SKIP %s", path)
+		return nil, nil
+	}
+
+	fh, err = maybeGunzip(fh)
+
+	if err != nil {
+		l.logger.Warning("failed to load %s because %s", path, err)
+		return nil, l.budget.Record(-1, path, err)
+	}
+
+	f, err := feature.LoadWOFFeatureFromReader(fh)
+
+	if err != nil {
+		l.logger.Warning("failed to load %s because %s", path, err)
+		return nil, l.budget.Record(-1, path, err)
+	}
+
+	// a file whose contents don't match its name is usually the result of
+	// a bad copy (or a bad tool) somewhere upstream
+
+	if l.check_filename != "" {
+
+		ok, _ := uri.IsWOFFile(wof_path)
+
+		if ok {
+
+			path_id, err := uri.IdFromPath(wof_path)
+
+			if err == nil && path_id != wof.Id(f) {
+
+				if l.check_filename == "warn" {
+					l.logger.Warning("%s has a wof:id of %d", path, wof.Id(f))
+				} else {
+					msg := fmt.Sprintf("%s has a wof:id of %d", path, wof.Id(f))
+					l.logger.Warning("failed to load %s because %s", path, msg)
+					return nil, l.budget.Record(wof.Id(f), path, errors.New(msg))
+				}
+			}
+		}
+	}
+
+	return f, nil
+}
+
+func (l *loader) loadPath(path string) (geojson.Feature, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		l.logger.Warning("failed to open %s because %s", path, err)
+		return nil, l.budget.Record(-1, path, err)
+	}
+
+	defer fh.Close()
+
+	ctx, err := index.ContextForPath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l.load(fh, ctx)
+}
+
+// a record that is still being written when ctx is cancelled is rolled back,
+// which isn't the feature's fault so it isn't counted as a failure
+
+func (l *loader) store(ctx context.Context, f geojson.Feature, path string) error {
+
+	if len(l.placetypes) > 0 && !l.placetypes[wof.Placetype(f)] {
+		atomic.AddInt64(&l.skipped, 1)
+		return nil
+	}
+
+	var err error
+
+	if l.labels {
+		err = l.client.IndexLabelContext(ctx, f)
+	} else {
+		err = l.client.IndexFeatureContext(ctx, f, l.table)
+	}
+
+	switch err {
+	case pgis.ErrSkippedEarth:
+		atomic.AddInt64(&l.skipped_earth, 1)
+		return nil
+	case pgis.ErrSkippedEmptyGeometry:
+		atomic.AddInt64(&l.skipped_empty, 1)
+		return nil
+	case pgis.ErrSkippedDeprecated:
+		atomic.AddInt64(&l.skipped_deprecated, 1)
+		return nil
+	case pgis.ErrSkippedSuperseded:
+		atomic.AddInt64(&l.skipped_superseded, 1)
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err != nil {
+		l.logger.Warning("failed to index %s because %s", path, err)
+		return l.budget.RecordFeature(f, path, err)
+	}
+
+	return nil
+}
+
+// index everything in paths, with the go-whosonfirst-index indexer or, if
+// readers is greater than zero, indexPipeline. If ctx is cancelled no more
+// features are handed to the client, anything it is still writing is rolled
+// back and, once that has happened, it is closed and the exit status is
+// EXIT_INTERRUPTED; otherwise it is 0.
+
+func (l *loader) index(ctx context.Context, mode string, paths []string, readers int, workers int) (int, error) {
+
+	store := func(f geojson.Feature, path string) error {
+		return l.store(ctx, f, path)
+	}
+
+	var err error
+
+	if readers > 0 {
+		err = indexPipeline(ctx, mode, paths, readers, workers, l.loadPath, store)
+	} else {
+
+		cb := func(fh io.Reader, fh_ctx context.Context, args ...interface{}) error {
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				// pass
+			}
+
+			f, err := l.load(fh, fh_ctx)
+
+			if err != nil || f == nil {
+				return err
+			}
+
+			path, _ := index.PathForContext(fh_ctx)
+
+			return store(f, path)
+		}
+
+		indexer, idx_err := index.NewIndexer(mode, cb)
+
+		if idx_err != nil {
+			msg := fmt.Sprintf("failed to create new indexer because %s", idx_err)
+			return 0, errors.New(msg)
+		}
+
+		err = indexer.IndexPaths(paths)
+	}
+
+	if ctx.Err() != nil {
+		l.client.Close()
+		return EXIT_INTERRUPTED, nil
+	}
+
+	return 0, err
+}
+
 func main() {
 
+	config := flag.String("config", "", "The path to a JSON config file whose keys are the names of any of the flags below. Flags passed on the command line take precedence.")
+
 	mode := flag.String("mode", "files", "The mode to use importing data. Valid options are: directory, meta, repo, filelist and files.")
+	geohash := flag.Int("geohash-precision", 0, "If greater than zero also store a geohash, with this many characters, of each feature's centroid in the geohash column.")
 	geom := flag.String("geometry", "", "Which geometry to index. Valid options are: centroid, bbox or whatever is in the default GeoJSON geometry (default).")
+	geom_column := flag.String("geom-column", "geom", "The name of the column to store each feature's geometry in.")
+	centroid_column := flag.String("centroid-column", "centroid", "The name of the column to store each feature's centroid in.")
+	simplified := flag.Float64("simplified-tolerance", 0.0, "If greater than zero also store a copy of each geometry, simplified with this tolerance (in decimal degrees), in the geom_simplified column.")
 
+	readers := flag.Int("readers", 0, "If greater than zero read and parse files using this many concurrent readers, separately from the database inserts. Only valid for the files and filelist modes.")
+	projected_srid := flag.Int("projected-srid", 0, "If greater than zero also store a copy of each geometry, transformed to this SRID (for example 3857), in the geom_projected column.")
 	procs := flag.Int("procs", 200, "The number of concurrent processes to use importing data.")
 
-	pgis_host := flag.String("pgis-host", "localhost", "The host of your PostgreSQL server.")
-	pgis_port := flag.Int("pgis-port", 5432, "The port of your PostgreSQL server.")
-	pgis_user := flag.String("pgis-user", "whosonfirst", "The name of your PostgreSQL user.")
-	pgis_pswd := flag.String("pgis-password", "", "The password of your PostgreSQL user.")
-	pgis_dbname := flag.String("pgis-database", "whosonfirst", "The name of your PostgreSQL database.")
+	pgis_flags := flags.NewPgisFlags(flag.CommandLine)
 	pgis_table := flag.String("pgis-table", "whosonfirst", "The name of your PostgreSQL database table.")
-	pgis_maxconns := flag.Int("pgis-maxconns", 10, "The maximum number of connections to use with your PostgreSQL database.")
+	pgis_lifetime := flag.Duration("pgis-conn-max-lifetime", pgis.PGIS_DEFAULT_CONN_MAX_LIFETIME, "The maximum amount of time a connection to your PostgreSQL database may be reused. 0 means forever.")
+	pgis_idle := flag.Duration("pgis-conn-max-idle-time", pgis.PGIS_DEFAULT_CONN_MAX_IDLE_TIME, "The maximum amount of time a connection to your PostgreSQL database may be idle. 0 means forever.")
+	pgis_pooler := flag.String("pgis-pooler-mode", "", "If there is a connection pooler (like pgbouncer) between you and your PostgreSQL server, the way it pools connections. Valid options are: session and transaction.")
+
+	precision := flag.Int("coordinate-precision", 0, "If greater than zero round the coordinates of each geometry to this many decimal places before storing them. 7 is roughly a centimeter.")
+	async_commit := flag.Bool("async-commit", false, "Turn off synchronous_commit for the writes made while indexing. This is a lot faster but if the database crashes the most recently indexed features may be lost, so only use it for loads that can be started over.")
+	ring_orientation := flag.String("ring-orientation", "", "Force the rings of each polygon in to this orientation before storing it. Valid options are: ccw (exterior rings counter-clockwise, as RFC 7946 says they should be) and cw. The default is to leave them as they are.")
+	server_centroid := flag.String("server-centroid", "", "Have PostGIS derive the centroid column from each (non-Point) geometry rather than using the centroid from the feature's properties. Valid options are: point-on-surface and centroid.")
+	strict_crs := flag.Bool("strict-crs", false, "Fail to index features whose (GeoJSON 2008) crs member, or src:geom_srid property, declares something other than EPSG:4326 rather than transforming them.")
+	subdivide := flag.Int("subdivide-max-vertices", 0, "If greater than zero also store each geometry subdivided in to pieces with no more than this many vertices in the whosonfirst_subdivided table.")
+	skip_geom := flag.Bool("skip-geometry", false, "Only index the centroid and properties for each feature, leaving the geom column empty.")
+	skip_empty := flag.Bool("skip-empty-geometry", false, "Skip (and count) features that have neither a usable geometry nor a centroid rather than treating them as errors.")
+	skip_deprecated := flag.Bool("skip-deprecated", false, "Skip (and count) features that are deprecated.")
+	skip_superseded := flag.Bool("skip-superseded", false, "Skip (and count) features that are superseded.")
+	store_area := flag.Bool("store-area", false, "Store the area (in square meters) of each geometry in the area_meters column.")
+	store_bbox := flag.Bool("store-bbox", false, "Store the bounding box of each geometry in the bbox column.")
+	store_hier := flag.Bool("store-hierarchy", false, "Store the continent, country, region and locality IDs from each feature's (first) hierarchy in the continent_id, country_id, region_id and locality_id columns.")
+	store_length := flag.Bool("store-length", false, "Store the length (in meters) of each linear geometry in the length_meters column.")
+	store_latlon := flag.Bool("store-lat-lon", false, "Store the latitude and longitude of each feature's centroid in the latitude and longitude columns.")
+	store_raw := flag.Bool("store-raw", false, "Store the original GeoJSON for each feature in the raw column.")
+	store_validity := flag.Bool("store-validity", false, "Store each feature's edtf:inception and edtf:cessation dates as a date range in the valid_range column.")
+	store_feature_hash := flag.Bool("store-feature-hash", false, "Store a hash of each feature's geometry and (indexed) properties in the feature_hash column.")
+	max_meta := flag.Int("max-meta-bytes", 0, "If greater than zero the maximum size of the meta column for a record. Records with more than one hierarchy are trimmed to the first one and if that's still too big they fail to index. 0 means no limit.")
+	omit_empty := flag.Bool("omit-empty-meta", false, "Leave empty properties (like a missing wof:name or wof:country) out of the meta column rather than storing them as empty strings.")
+	progress := flag.Bool("progress", false, "Periodically log how many features have been indexed.")
+	run_id := flag.String("run-id", "", "Stamp every record indexed with this identifier (in the run_id column).")
+	sweep_repo := flag.String("sweep-repo", "", "Once indexing is complete delete all the records for this repo that were not stamped with -run-id.")
+	conflict_key := flag.String("conflict-key", "id", "What makes a record unique, and so updated rather than inserted. Valid options are: id, id+repo and id+placetype.")
+	points_table := flag.String("points-table", "", "Write features with a Point geometry to this table, rather than the whosonfirst table. The table must have the same columns as the whosonfirst table.")
+	create_partitions := flag.Bool("create-partitions", false, "Create a whosonfirst_{PLACETYPE} partition of the whosonfirst table, if it doesn't already exist, for each placetype indexed. The table must be partitioned by list on placetype_id.")
+	continue_on_error := flag.Bool("continue-on-error", false, "Keep going when individual features fail to index, reporting all the failures at the end.")
+	max_errors := flag.Int("max-errors", 0, "If -continue-on-error is set give up after this many features have failed. 0 means no limit.")
+	rejects := flag.String("rejects", "", "Write each feature that fails to index, along with the reason why, to this file as a line of JSON.")
+	output_sql := flag.String("output-sql", "", "Write the SQL statements needed to index each feature to this file rather than executing them. A database connection is still required.")
+	optimize := flag.Bool("optimize", false, "Run VACUUM ANALYZE on the whosonfirst table (and the points, subdivided and labels tables, if there are any) once indexing is complete.")
+	labels := flag.Bool("labels", false, "Index the centroid of each feature, as its geometry, in the whosonfirst_labels table rather than indexing the feature itself in the whosonfirst table.")
+	load_lock := flag.Int64("load-lock", 0, "Take a PostgreSQL advisory lock with this key before indexing anything, and fail straight away if another process already has it. 0 means don't take a lock.")
+	check_filename := flag.String("check-filename", "", "Compare the wof:id of each feature with the ID in its filename. Valid options are: warn (log mismatches) and strict (fail to index them). Files whose names aren't WOF filenames are not checked.")
+
+	var only_placetypes flags.MultiString
+	flag.Var(&only_placetypes, "placetype", "Only index features with this placetype. This flag may be passed multiple times.")
 
 	verbose := flag.Bool("verbose", false, "Be chatty about what's happening. This is automatically enabled if the -debug flag is set.")
 	debug := flag.Bool("debug", false, "Go through all the motions but don't actually index anything.")
 
 	flag.Parse()
 
+	logger := log.SimpleWOFLogger()
+
+	if *config != "" {
+
+		err := flags.ApplyConfigFile(flag.CommandLine, *config)
+
+		if err != nil {
+			logger.Fatal("failed to read config file %s because %v", *config, err)
+		}
+	}
+
 	if *debug {
 		*verbose = true
 	}
 
 	runtime.GOMAXPROCS(*procs)
 
-	logger := log.SimpleWOFLogger()
+	switch *check_filename {
+	case "", "warn", "strict":
+		// pass
+	default:
+		logger.Fatal("invalid -check-filename '%s'", *check_filename)
+	}
 
-	client, err := pgis.NewPgisClient(*pgis_host, *pgis_port, *pgis_user, *pgis_pswd, *pgis_dbname, *pgis_maxconns)
+	if *readers > 0 && *mode != "files" && *mode != "filelist" {
+		logger.Fatal("-readers can only be used with the files and filelist modes")
+	}
+
+	if *sweep_repo != "" && *run_id == "" {
+		logger.Fatal("-sweep-repo requires that you also pass -run-id")
+	}
+
+	if *output_sql != "" && (*sweep_repo != "" || *optimize) {
+		logger.Fatal("-output-sql can not be combined with -sweep-repo or -optimize")
+	}
+
+	client, err := pgis_flags.NewClient()
 
 	if err != nil {
-		logger.Fatal("failed to create PgisClient (%s:%d) because %v", *pgis_host, *pgis_port, err)
+		logger.Fatal("%s", err)
 	}
 
+	client.SetConnMaxLifetime(*pgis_lifetime)
+	client.SetConnMaxIdleTime(*pgis_idle)
+
 	client.Verbose = *verbose
 	client.Debug = *debug
 	client.Geometry = *geom
+	client.AsyncCommit = *async_commit
+	client.PoolerMode = *pgis_pooler
+	client.RingOrientation = *ring_orientation
+	client.ServerCentroid = *server_centroid
+	client.GeomColumn = *geom_column
+	client.CentroidColumn = *centroid_column
+	client.SimplifiedTolerance = *simplified
+	client.OmitEmptyMeta = *omit_empty
+	client.MaxMetaBytes = *max_meta
+	client.SubdivideMaxVertices = *subdivide
+	client.CoordinatePrecision = *precision
+	client.ProjectedSRID = *projected_srid
+	client.GeohashPrecision = *geohash
+	client.RunId = *run_id
+	client.ConflictKey = *conflict_key
+	client.CreatePartitions = *create_partitions
+	client.PointsTable = *points_table
+	client.StoreArea = *store_area
+	client.StoreLength = *store_length
+	client.StoreHierarchy = *store_hier
+	client.StoreBBox = *store_bbox
+	client.StoreRaw = *store_raw
+	client.StoreFeatureHash = *store_feature_hash
+	client.StoreValidity = *store_validity
+	client.StoreLatLon = *store_latlon
+	client.StrictCRS = *strict_crs
+	client.SkipGeometry = *skip_geom
+	client.SkipEmptyGeometry = *skip_empty
+	client.SkipDeprecated = *skip_deprecated
+	client.SkipSuperseded = *skip_superseded
+
+	wanted_placetypes, err := wantedPlacetypes(client, only_placetypes)
+
+	if err != nil {
+		logger.Fatal("%s", err)
+	}
+
+	var sql_fh *os.File
 
-	cb := func(fh io.Reader, ctx context.Context, args ...interface{}) error {
+	if *output_sql != "" {
 
-		ok, err := utils.IsPrincipalWOFRecord(fh, ctx)
+		sql_fh, err = os.Create(*output_sql)
 
 		if err != nil {
-			return err
+			logger.Fatal("failed to create %s because %v", *output_sql, err)
 		}
 
-		if !ok {
-			// we know we've just invoked this above so...
-			// path, _ := index.PathForContext(ctx)
-			// logger.Debug("SKIP %s", path)
-			return nil
+		client.SQLWriter = sql_fh
+	}
+
+	// ValidateSchema only knows about the whosonfirst table
+
+	if !*debug && !*labels {
+
+		err = client.ValidateSchema(context.Background())
+
+		if err != nil {
+			logger.Fatal("%s", err)
 		}
+	}
+
+	// there's no need to release the lock explicitly since that happens
+	// when the process exits, one way or another
 
-		feature, err := feature.LoadWOFFeatureFromReader(fh)
+	if *load_lock != 0 {
+
+		_, err := client.AcquireLoadLock(context.Background(), *load_lock)
 
 		if err != nil {
-			return err
+			logger.Fatal("failed to acquire load lock %d because %s", *load_lock, err)
 		}
+	}
+
+	if *progress {
+
+		progress_ch := make(chan pgis.PgisIndexProgress, 1)
+		client.Progress = progress_ch
+
+		go func() {
 
-		return client.IndexFeature(feature, *pgis_table)
+			last := time.Now()
+
+			for p := range progress_ch {
+
+				if time.Since(last) < 10*time.Second {
+					continue
+				}
+
+				logger.Status("indexed %d features (%d errors), last ID was %d", p.Indexed, p.Errors, p.LastId)
+				last = time.Now()
+			}
+		}()
 	}
 
-	indexer, err := index.NewIndexer(*mode, cb)
+	// on SIGINT we stop handing new features to the client, roll back any
+	// in-flight inserts and then exit (see loader.index)
 
-	if err != nil {
-		logger.Fatal("Failed to create new indexer because %s", err)
+	root_ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signal_ch := make(chan os.Signal, 1)
+	signal.Notify(signal_ch, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signal_ch
+		logger.Status("received interrupt, rolling back in-flight inserts")
+		cancel()
+	}()
+
+	budget := pgis.NewPgisErrorBudget(*continue_on_error, *max_errors)
+
+	var rejects_fh *os.File
+
+	if *rejects != "" {
+
+		rejects_fh, err = os.Create(*rejects)
+
+		if err != nil {
+			logger.Fatal("failed to create %s because %v", *rejects, err)
+		}
+
+		budget.Rejects = rejects_fh
+	}
+
+	l := &loader{
+		client:         client,
+		table:          *pgis_table,
+		labels:         *labels,
+		placetypes:     wanted_placetypes,
+		check_filename: *check_filename,
+		budget:         budget,
+		logger:         logger,
 	}
 
 	tm, err := timer.NewDefaultTimer()
@@ -90,11 +729,78 @@ func main() {
 
 	defer tm.Stop()
 
-	err = indexer.IndexPaths(flag.Args())
+	status, err := l.index(root_ctx, *mode, flag.Args(), *readers, *pgis_flags.MaxConns)
+
+	if sql_fh != nil {
+		sql_fh.Close()
+	}
+
+	if rejects_fh != nil {
+		rejects_fh.Close()
+	}
+
+	if status == EXIT_INTERRUPTED {
+		logger.Status("indexing interrupted")
+		os.Exit(status)
+	}
 
 	if err != nil {
 		logger.Fatal("Failed to index paths in %s mode because %s", *mode, err)
 	}
 
+	if len(wanted_placetypes) > 0 {
+		logger.Status("skipped %d features that weren't one of the placetypes to index", atomic.LoadInt64(&l.skipped))
+	}
+
+	// Earth is always skipped so this is only worth mentioning if it
+	// happened
+
+	if atomic.LoadInt64(&l.skipped_earth) > 0 {
+		logger.Status("skipped %d Earth features because they confuse PostGIS", atomic.LoadInt64(&l.skipped_earth))
+	}
+
+	if *skip_empty {
+		logger.Status("skipped %d features with no usable geometry", atomic.LoadInt64(&l.skipped_empty))
+	}
+
+	if *skip_deprecated {
+		logger.Status("skipped %d deprecated features", atomic.LoadInt64(&l.skipped_deprecated))
+	}
+
+	if *skip_superseded {
+		logger.Status("skipped %d superseded features", atomic.LoadInt64(&l.skipped_superseded))
+	}
+
+	failures := budget.Failures()
+
+	if len(failures) > 0 {
+
+		for _, f := range failures {
+			logger.Warning("FAILED %s (%d) because %s", f.Path, f.Id, f.Err)
+		}
+
+		logger.Fatal("%s", budget.Err())
+	}
+
+	if *sweep_repo != "" {
+
+		count, err := client.SweepStale(root_ctx, *sweep_repo, *run_id)
+
+		if err != nil {
+			logger.Fatal("Failed to sweep stale records for %s because %s", *sweep_repo, err)
+		}
+
+		logger.Status("swept %d stale records for %s", count, *sweep_repo)
+	}
+
+	if *optimize {
+
+		err = client.Optimize(root_ctx)
+
+		if err != nil {
+			logger.Fatal("Failed to optimize tables because %s", err)
+		}
+	}
+
 	os.Exit(0)
 }
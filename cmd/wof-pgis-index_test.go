@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	wof "github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
+	"github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMaybeGunzip(t *testing.T) {
+
+	plain := []byte(`{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`)
+
+	var compressed bytes.Buffer
+
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(plain)
+	zw.Close()
+
+	tests := []struct {
+		what  string
+		input []byte
+		want  []byte
+		err   bool
+	}{
+		{"plain", plain, plain, false},
+		{"gzipped", compressed.Bytes(), plain, false},
+		{"empty", []byte{}, []byte{}, false},
+		{"one byte", []byte{0x1f}, []byte{0x1f}, false},
+		{"truncated gzip", compressed.Bytes()[:4], nil, true},
+	}
+
+	for _, test := range tests {
+
+		r, err := maybeGunzip(bytes.NewReader(test.input))
+
+		if err == nil {
+			var body []byte
+			body, err = ioutil.ReadAll(r)
+
+			if err == nil && !bytes.Equal(body, test.want) {
+				t.Errorf("%s: maybeGunzip returned %q, expected %q", test.what, body, test.want)
+			}
+		}
+
+		if test.err && err == nil {
+			t.Errorf("%s: expected an error", test.what)
+		}
+
+		if !test.err && err != nil {
+			t.Errorf("%s: unexpected error %s", test.what, err)
+		}
+	}
+}
+
+// a directory of (small) features to index, one for each of placetypes, and
+// the paths to them
+
+func testFixtures(t testing.TB, placetypes []string) (string, []string) {
+
+	root, err := ioutil.TempDir("", "wof-pgis-index")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(root)
+	})
+
+	paths := make([]string, len(placetypes))
+
+	for i, pt := range placetypes {
+
+		id := int64(1000000 + i)
+		paths[i] = filepath.Join(root, fmt.Sprintf("%d.geojson", id))
+
+		writeFixture(t, paths[i], id, pt)
+	}
+
+	return root, paths
+}
+
+func writeFixture(t testing.TB, path string, id int64, placetype string) {
+
+	body := fmt.Sprintf(`{"type":"Feature","id":%d,"properties":{"wof:id":%d,"wof:name":"fixture %d","wof:placetype":"%s","wof:parent_id":-1,"wof:repo":"whosonfirst-data","geom:latitude":45.5,"geom:longitude":-73.5,"geom:bbox":"-73.5,45.5,-73.5,45.5"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}`, id, id, id, placetype)
+
+	err := ioutil.WriteFile(path, []byte(body), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the reader/indexer pipeline against the go-whosonfirst-index indexer it is an
+// alternative to, over the same fixtures and with a fake indexer that only
+// counts the features it is given, so that it's the reading and parsing that
+// is being measured
+
+func BenchmarkIndexPipeline(b *testing.B) {
+
+	venues := make([]string, 500)
+
+	for i := range venues {
+		venues[i] = "venue"
+	}
+
+	root, paths := testFixtures(b, venues)
+
+	load := func(path string) (geojson.Feature, error) {
+
+		fh, err := os.Open(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer fh.Close()
+
+		return feature.LoadWOFFeatureFromReader(fh)
+	}
+
+	indexed := int64(0)
+
+	store := func(f geojson.Feature, path string) error {
+		atomic.AddInt64(&indexed, 1)
+		return nil
+	}
+
+	check := func(b *testing.B) {
+
+		if indexed != int64(b.N*len(paths)) {
+			b.Fatalf("expected %d features to be indexed, got %d", b.N*len(paths), indexed)
+		}
+	}
+
+	b.Run("indexer", func(b *testing.B) {
+
+		indexed = 0
+
+		cb := func(fh io.Reader, ctx context.Context, args ...interface{}) error {
+
+			f, err := feature.LoadWOFFeatureFromReader(fh)
+
+			if err != nil {
+				return err
+			}
+
+			return store(f, "")
+		}
+
+		indexer, err := index.NewIndexer("directory", cb)
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for i := 0; i < b.N; i++ {
+
+			err := indexer.IndexPaths([]string{root})
+
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		check(b)
+	})
+
+	for _, readers := range []int{1, 4, 16} {
+
+		b.Run(fmt.Sprintf("pipeline-%d", readers), func(b *testing.B) {
+
+			indexed = 0
+
+			for i := 0; i < b.N; i++ {
+
+				err := indexPipeline(context.Background(), "files", paths, readers, 4, load, store)
+
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			check(b)
+		})
+	}
+}
+
+// a featureIndexer that records the IDs it is given and, if block is set,
+// doesn't return until ctx is cancelled, like a statement that is still running
+// when the process is interrupted
+
+type mockIndexer struct {
+	mu              sync.Mutex
+	ids             []int64
+	block           bool
+	started         chan bool
+	inflight        int64
+	closed          int64
+	closed_inflight int64 // how many calls were still in flight when Close was called
+	resolver        pgis.PgisPlacetypeResolver
+}
+
+func (m *mockIndexer) IndexFeatureContext(ctx context.Context, f geojson.Feature, collection string) error {
+
+	atomic.AddInt64(&m.inflight, 1)
+	defer atomic.AddInt64(&m.inflight, -1)
+
+	m.mu.Lock()
+	m.ids = append(m.ids, wof.Id(f))
+	m.mu.Unlock()
+
+	if !m.block {
+		return nil
+	}
+
+	select {
+	case m.started <- true:
+		// pass
+	default:
+		// pass
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m *mockIndexer) IndexLabelContext(ctx context.Context, f geojson.Feature) error {
+	return m.IndexFeatureContext(ctx, f, "whosonfirst_labels")
+}
+
+func (m *mockIndexer) ResolvePlacetype(name string) (*placetypes.WOFPlacetype, error) {
+
+	client := &pgis.PgisClient{
+		PlacetypeResolver: m.resolver,
+	}
+
+	return client.ResolvePlacetype(name)
+}
+
+func (m *mockIndexer) Close() error {
+	atomic.AddInt64(&m.closed_inflight, atomic.LoadInt64(&m.inflight))
+	atomic.AddInt64(&m.closed, 1)
+	return nil
+}
+
+func testLoader(client featureIndexer) *loader {
+
+	return &loader{
+		client: client,
+		table:  "whosonfirst",
+		budget: pgis.NewPgisErrorBudget(false, 0),
+		logger: log.SimpleWOFLogger("test"),
+	}
+}
+
+// what happens on SIGINT: the signal handler cancels the root context while
+// a feature is being indexed, which has to be abandoned before the client is
+// closed and the exit status is EXIT_INTERRUPTED
+
+func TestIndexInterrupted(t *testing.T) {
+
+	root, paths := testFixtures(t, []string{"locality", "locality", "locality", "locality"})
+
+	// the most features that can have been started when the interrupt
+	// arrives is one per worker: the files mode has one, the directory mode
+	// one per CPU (see go-whosonfirst-crawl) and the pipeline the number it
+	// is given
+
+	tests := []struct {
+		what    string
+		mode    string
+		paths   []string
+		readers int
+		started int
+	}{
+		{"files", "files", paths, 0, 1},
+		{"directory", "directory", []string{root}, 0, len(paths)},
+		{"pipeline", "files", paths, 2, 2},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.what, func(t *testing.T) {
+
+			client := &mockIndexer{
+				block:   true,
+				started: make(chan bool, 1),
+			}
+
+			l := testLoader(client)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() {
+				<-client.started
+				cancel()
+			}()
+
+			status, err := l.index(ctx, test.mode, test.paths, test.readers, 2)
+
+			if err != nil {
+				t.Fatalf("expected an interrupted run not to fail, got %s", err)
+			}
+
+			if status != EXIT_INTERRUPTED {
+				t.Errorf("expected exit status %d, got %d", EXIT_INTERRUPTED, status)
+			}
+
+			if atomic.LoadInt64(&client.closed) != 1 {
+				t.Errorf("expected the client to be closed once, got %d", client.closed)
+			}
+
+			if atomic.LoadInt64(&client.closed_inflight) != 0 {
+				t.Errorf("expected nothing to be in flight when the client was closed, got %d", client.closed_inflight)
+			}
+
+			if len(client.ids) == 0 || len(client.ids) > test.started {
+				t.Errorf("expected between 1 and %d features to have been started, got %v", test.started, client.ids)
+			}
+
+			if len(l.budget.Failures()) != 0 {
+				t.Errorf("expected the interrupted features not to be counted as failures, got %v", l.budget.Failures())
+			}
+		})
+	}
+
+	// and nothing is closed (by the loader) when nothing is interrupted
+
+	client := &mockIndexer{}
+	l := testLoader(client)
+
+	status, err := l.index(context.Background(), "files", paths, 0, 2)
+
+	if err != nil || status != 0 {
+		t.Fatalf("expected an uninterrupted run to succeed, got %d and %v", status, err)
+	}
+
+	if len(client.ids) != len(paths) || client.closed != 0 {
+		t.Errorf("expected %d features to be indexed and the client left open, got %v and %d", len(paths), client.ids, client.closed)
+	}
+}
+
+// -placetype limits what is indexed to the placetypes it names, which can be
+// custom ones known only to the client's PlacetypeResolver, and counts the rest
+// as skipped
+
+func TestIndexPlacetypes(t *testing.T) {
+
+	client := &mockIndexer{
+		resolver: func(name string) (*placetypes.WOFPlacetype, error) {
+
+			if name == "planetoid" {
+				return &placetypes.WOFPlacetype{Id: 1, Name: name, Role: "common", Parent: []int64{}}, nil
+			}
+
+			msg := fmt.Sprintf("unknown placetype %s", name)
+			return nil, errors.New(msg)
+		},
+	}
+
+	_, err := wantedPlacetypes(client, []string{"locality", "asteroid"})
+
+	if err == nil {
+		t.Errorf("expected an unknown placetype to be rejected")
+	}
+
+	wanted, err := wantedPlacetypes(client, []string{"locality", "planetoid"})
+
+	if err != nil {
+		t.Fatalf("expected a custom placetype to be accepted, got %s", err)
+	}
+
+	// the WOF feature loader only knows the standard placetypes so a
+	// custom one can be asked for but there are never any features for it
+
+	_, paths := testFixtures(t, []string{"locality", "venue", "region", "locality"})
+
+	l := testLoader(client)
+	l.placetypes = wanted
+
+	status, err := l.index(context.Background(), "files", paths, 0, 2)
+
+	if err != nil || status != 0 {
+		t.Fatalf("expected the run to succeed, got %d and %v", status, err)
+	}
+
+	indexed := fmt.Sprint(client.ids)
+	expected := "[1000000 1000003]"
+
+	if indexed != expected {
+		t.Errorf("expected %s to be indexed, got %s", expected, indexed)
+	}
+
+	if atomic.LoadInt64(&l.skipped) != 2 {
+		t.Errorf("expected 2 features to be skipped, got %d", l.skipped)
+	}
+}
+
+// a file whose wof:id doesn't match its name is indexed anyway without
+// -check-filename, with a warning with -check-filename=warn and is recorded as
+// a failure, without being indexed, with -check-filename=strict
+
+func TestCheckFilename(t *testing.T) {
+
+	root, paths := testFixtures(t, []string{"locality"})
+
+	mismatched := filepath.Join(root, "1000001.geojson")
+	writeFixture(t, mismatched, 2000001, "locality")
+
+	paths = append(paths, mismatched)
+
+	tests := []struct {
+		check             string
+		continue_on_error bool
+		indexed           string
+		failed            bool
+	}{
+		{"", false, "[1000000 2000001]", false},
+		{"warn", false, "[1000000 2000001]", false},
+		{"strict", true, "[1000000]", false},
+		{"strict", false, "[1000000]", true},
+	}
+
+	for _, test := range tests {
+
+		client := &mockIndexer{}
+
+		l := testLoader(client)
+		l.check_filename = test.check
+		l.budget = pgis.NewPgisErrorBudget(test.continue_on_error, 0)
+
+		status, err := l.index(context.Background(), "files", paths, 0, 2)
+
+		if test.failed != (err != nil) {
+			t.Errorf("-check-filename=%s (continue %t): expected the run to fail: %t, got %v", test.check, test.continue_on_error, test.failed, err)
+		}
+
+		if status != 0 {
+			t.Errorf("-check-filename=%s: expected exit status 0, got %d", test.check, status)
+		}
+
+		if fmt.Sprint(client.ids) != test.indexed {
+			t.Errorf("-check-filename=%s: expected %s to be indexed, got %v", test.check, test.indexed, client.ids)
+		}
+
+		failures := l.budget.Failures()
+
+		if test.check != "strict" {
+
+			if len(failures) != 0 {
+				t.Errorf("-check-filename=%s: expected no failures, got %v", test.check, failures)
+			}
+
+			continue
+		}
+
+		if len(failures) != 1 || failures[0].Id != 2000001 || failures[0].Path != mismatched {
+			t.Errorf("-check-filename=%s: expected %s to be recorded as a failure, got %v", test.check, mismatched, failures)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"github.com/whosonfirst/go-whosonfirst-pgis/flags"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// log.Fatal exits with status 1 so anything that goes wrong is 1 and
+// a query that simply doesn't match anything is 2
+
+const EXIT_NOT_FOUND = 2
+
+// the conventional exit status for a process terminated by SIGINT
+
+const EXIT_INTERRUPTED = 130
+
+type IntersectsRow struct {
+	Id           int64           `json:"id"`
+	ParentId     int64           `json:"parent_id"`
+	PlacetypeId  int64           `json:"placetype_id"`
+	IsSuperseded int             `json:"is_superseded"`
+	IsDeprecated int             `json:"is_deprecated"`
+	Meta         json.RawMessage `json:"meta"`
+}
+
+func main() {
+
+	config := flag.String("config", "", "The path to a JSON config file whose keys are the names of any of the flags below. Flags passed on the command line take precedence.")
+
+	format := flag.String("format", "text", "The format to output results in. Valid options are: text, json and geojson.")
+	placetype := flag.String("placetype", "", "Only return features of this placetype.")
+	subdivided := flag.Bool("subdivided", false, "Use the whosonfirst_subdivided table to find candidate features before testing them against the geom column.")
+	min_area := flag.Float64("min-area", 0.0, "Only return features whose area (in square meters) is at least this large. Requires features to have been indexed with -store-area.")
+	max_area := flag.Float64("max-area", 0.0, "Only return features whose area (in square meters) is no larger than this. Requires features to have been indexed with -store-area.")
+	var filters flags.MultiString
+	flag.Var(&filters, "filter", "Only return features matching this key=value filter. Valid keys are: placetype, repo, country, deprecated, superseded, bbox, continent_id, country_id, region_id and locality_id. This flag may be passed multiple times.")
+
+	limit := flag.Int64("limit", 0, "The maximum number of features to return for each geometry. 0 means no limit.")
+	offset := flag.Int64("offset", 0, "The number of features to skip for each geometry. Use with -order-by to page through results.")
+	order_by := flag.String("order-by", "id", "The order to return results in. Valid options are: id, placetype, area (smallest first) and distance (from the centroid of the query geometry).")
+
+	geom_col := flag.String("geometry-column", "geom", "The column to test for intersections against. Valid options are: geom, geom_simplified and centroid.")
+	make_valid := flag.Bool("make-valid", false, "Test against a valid version (using ST_MakeValid) of each stored geometry so that invalid geometries don't cause the whole query to fail. This is slower.")
+
+	pgis_flags := flags.NewPgisFlags(flag.CommandLine)
+
+	explain := flag.Bool("explain", false, "Log the query plan (using EXPLAIN ANALYZE) for each query. Note that this means each query is run twice.")
+	verbose := flag.Bool("verbose", false, "Be chatty about what's happening.")
+
+	flag.Parse()
+
+	if *config != "" {
+
+		err := flags.ApplyConfigFile(flag.CommandLine, *config)
+
+		if err != nil {
+			log.Fatalf("failed to read config file %s because %v", *config, err)
+		}
+	}
+
+	switch *format {
+	case "text", "json", "geojson":
+		// pass
+	default:
+		log.Fatalf("invalid format '%s'", *format)
+	}
+
+	opts := pgis.NewDefaultPgisIntersectsOptions()
+	opts.GeometryColumn = *geom_col
+	opts.UseSubdivided = *subdivided
+	opts.MinArea = *min_area
+	opts.MaxArea = *max_area
+	opts.OrderBy = *order_by
+	opts.Limit = *limit
+	opts.Offset = *offset
+	opts.MakeValid = *make_valid
+
+	for _, f := range filters {
+
+		kv := strings.SplitN(f, "=", 2)
+
+		if len(kv) != 2 {
+			log.Fatalf("invalid filter '%s'", f)
+		}
+
+		err := opts.SetFilter(kv[0], kv[1])
+
+		if err != nil {
+			log.Fatalf("invalid filter '%s' because %v", f, err)
+		}
+	}
+
+	if *placetype != "" {
+
+		pt, err := placetypes.GetPlacetypeByName(*placetype)
+
+		if err != nil {
+			log.Fatalf("invalid placetype '%s' because %v", *placetype, err)
+		}
+
+		opts.PlacetypeId = pt.Id
+	}
+
+	client, err := pgis_flags.NewClient()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client.Verbose = *verbose
+	client.Explain = *explain
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signal_ch := make(chan os.Signal, 1)
+	signal.Notify(signal_ch, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signal_ch
+		cancel()
+	}()
+
+	results := make([]*pgis.PgisRow, 0)
+
+	for _, path := range flag.Args() {
+
+		body, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rows, err := client.IntersectsFeature(ctx, body, opts)
+
+		if ctx.Err() != nil {
+			client.Close()
+			log.Println("interrupted")
+			os.Exit(EXIT_INTERRUPTED)
+		}
+
+		if err != nil {
+			log.Fatalf("failed to intersect %s because %v", path, err)
+		}
+
+		results = append(results, rows...)
+	}
+
+	err = writeResults(os.Stdout, *format, results)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(results) == 0 {
+		os.Exit(EXIT_NOT_FOUND)
+	}
+
+	os.Exit(0)
+}
+
+// text is one tab-separated line (ID, placetype ID and name) for each row, json
+// is a list of IntersectsRow and geojson is a FeatureCollection
+
+func writeResults(wr io.Writer, format string, results []*pgis.PgisRow) error {
+
+	switch format {
+
+	case "json":
+
+		out := make([]*IntersectsRow, 0)
+
+		for _, row := range results {
+
+			r := IntersectsRow{
+				Id:           row.Id,
+				ParentId:     row.ParentId,
+				PlacetypeId:  row.PlacetypeId,
+				IsSuperseded: row.IsSuperseded,
+				IsDeprecated: row.IsDeprecated,
+				Meta:         json.RawMessage(row.Meta),
+			}
+
+			out = append(out, &r)
+		}
+
+		enc := json.NewEncoder(wr)
+		return enc.Encode(out)
+
+	case "geojson":
+
+		fc, err := pgis.NewPgisFeatureCollection(results)
+
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(wr)
+		return enc.Encode(fc)
+
+	default:
+
+		for _, row := range results {
+
+			// the row is still printed, without a name, since its ID
+			// and placetype are good
+
+			var meta pgis.Meta
+			err := json.Unmarshal([]byte(row.Meta), &meta)
+
+			if err != nil {
+				log.Printf("failed to parse meta for %d because %v", row.Id, err)
+			}
+
+			fmt.Fprintf(wr, "%d\t%d\t%s\n", row.Id, row.PlacetypeId, meta.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"testing"
+)
+
+func TestWriteResults(t *testing.T) {
+
+	results := []*pgis.PgisRow{
+		{Id: 101736545, ParentId: 85633041, PlacetypeId: 102312317, Meta: `{"wof:name":"Montreal","wof:repo":"whosonfirst-data"}`, Centroid: `{"type":"Point","coordinates":[-73.5,45.5]}`},
+		{Id: 85633041, ParentId: -1, PlacetypeId: 102312307, IsDeprecated: 1, Meta: `{"wof:name":"Canada"}`},
+	}
+
+	tests := []struct {
+		format   string
+		results  []*pgis.PgisRow
+		expected string
+	}{
+		{"text", results, "101736545\t102312317\tMontreal\n85633041\t102312307\tCanada\n"},
+		{"text", []*pgis.PgisRow{}, ""},
+		{"text", []*pgis.PgisRow{{Id: 1, PlacetypeId: 102312317, Meta: "{"}}, "1\t102312317\t\n"}, // bad meta still prints the row
+		{"json", results, `[{"id":101736545,"parent_id":85633041,"placetype_id":102312317,"is_superseded":0,"is_deprecated":0,"meta":{"wof:name":"Montreal","wof:repo":"whosonfirst-data"}},{"id":85633041,"parent_id":-1,"placetype_id":102312307,"is_superseded":0,"is_deprecated":1,"meta":{"wof:name":"Canada"}}]` + "\n"},
+		{"json", []*pgis.PgisRow{}, "[]\n"},
+		{"geojson", results[:1], `{"type":"FeatureCollection","features":[{"type":"Feature","id":101736545,"properties":{"mz:is_deprecated":0,"mz:is_superseded":0,"wof:country":"","wof:hierarchy":null,"wof:id":101736545,"wof:name":"Montreal","wof:parent_id":85633041,"wof:placetype":"locality","wof:placetype_id":102312317,"wof:repo":"whosonfirst-data"},"geometry":{"type":"Point","coordinates":[-73.5,45.5]}}]}` + "\n"},
+		{"geojson", []*pgis.PgisRow{}, `{"type":"FeatureCollection","features":[]}` + "\n"},
+	}
+
+	for _, test := range tests {
+
+		var buf bytes.Buffer
+
+		err := writeResults(&buf, test.format, test.results)
+
+		if err != nil {
+			t.Errorf("writeResults(%s) failed because %s", test.format, err)
+			continue
+		}
+
+		if buf.String() != test.expected {
+			t.Errorf("writeResults(%s) returned %q, expected %q", test.format, buf.String(), test.expected)
+		}
+	}
+
+	// unlike the text format a FeatureCollection can't be written without
+	// the meta since that's where the properties come from
+
+	var buf bytes.Buffer
+
+	err := writeResults(&buf, "geojson", []*pgis.PgisRow{{Id: 1, Meta: "{"}})
+
+	if err == nil {
+		t.Errorf("expected invalid meta to fail the geojson format")
+	}
+}
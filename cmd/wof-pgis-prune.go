@@ -2,21 +2,18 @@ package main
 
 import (
 	"flag"
-	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"github.com/whosonfirst/go-whosonfirst-pgis/flags"
 	"log"
 	"runtime"
 )
 
 func main() {
 
+	config := flag.String("config", "", "The path to a JSON config file whose keys are the names of any of the flags below. Flags passed on the command line take precedence.")
+
 	procs := flag.Int("procs", 200, "The number of concurrent processes to use importing data.")
 
-	pgis_host := flag.String("pgis-host", "localhost", "The host of your PostgreSQL server.")
-	pgis_port := flag.Int("pgis-port", 5432, "The port of your PostgreSQL server.")
-	pgis_user := flag.String("pgis-user", "whosonfirst", "The name of your PostgreSQL user.")
-	pgis_pswd := flag.String("pgis-password", "", "The password of your PostgreSQL user.")
-	pgis_dbname := flag.String("pgis-database", "whosonfirst", "The name of your PostgreSQL database.")
-	pgis_maxconns := flag.Int("pgis-maxconns", 10, "The maximum number of connections to use with your PostgreSQL database.")
+	pgis_flags := flags.NewPgisFlags(flag.CommandLine)
 
 	data_root := flag.String("data-root", "/usr/local/data", "The root folder where Who's On First data repositories are stored.")
 
@@ -26,16 +23,25 @@ func main() {
 
 	flag.Parse()
 
+	if *config != "" {
+
+		err := flags.ApplyConfigFile(flag.CommandLine, *config)
+
+		if err != nil {
+			log.Fatalf("failed to read config file %s because %v", *config, err)
+		}
+	}
+
 	if *debug {
 		*verbose = true
 	}
 
 	runtime.GOMAXPROCS(*procs)
 
-	client, err := pgis.NewPgisClient(*pgis_host, *pgis_port, *pgis_user, *pgis_pswd, *pgis_dbname, *pgis_maxconns)
+	client, err := pgis_flags.NewClient()
 
 	if err != nil {
-		log.Fatalf("failed to create PgisClient (%s:%d) because %v", *pgis_host, *pgis_port, err)
+		log.Fatal(err)
 	}
 
 	client.Verbose = *verbose
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"github.com/whosonfirst/go-whosonfirst-pgis/flags"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+func main() {
+
+	config := flag.String("config", "", "The path to a JSON config file whose keys are the names of any of the flags below. Flags passed on the command line take precedence.")
+
+	pgis_flags := flags.NewPgisFlags(flag.CommandLine)
+
+	format := flag.String("format", "table", "The output format. Valid options are: table, json.")
+
+	var filters flags.MultiString
+	flag.Var(&filters, "filter", "Only count features matching this key=value filter. Valid keys are: placetype, repo, country, deprecated, superseded, bbox, continent_id, country_id, region_id and locality_id. This flag may be passed multiple times.")
+
+	verbose := flag.Bool("verbose", false, "Be chatty about what's happening.")
+
+	flag.Parse()
+
+	if *config != "" {
+
+		err := flags.ApplyConfigFile(flag.CommandLine, *config)
+
+		if err != nil {
+			log.Fatalf("failed to read config file %s because %v", *config, err)
+		}
+	}
+
+	switch *format {
+	case "table", "json":
+		// pass
+	default:
+		log.Fatalf("invalid format '%s'", *format)
+	}
+
+	opts := pgis.NewDefaultPgisIntersectsOptions()
+
+	for _, f := range filters {
+
+		kv := strings.SplitN(f, "=", 2)
+
+		if len(kv) != 2 {
+			log.Fatalf("invalid filter '%s'", f)
+		}
+
+		err := opts.SetFilter(kv[0], kv[1])
+
+		if err != nil {
+			log.Fatalf("invalid filter '%s' because %v", f, err)
+		}
+	}
+
+	client, err := pgis_flags.NewClient()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client.Verbose = *verbose
+
+	stats, err := client.RepoStats(context.Background(), opts)
+
+	if err != nil {
+		log.Fatalf("failed to generate repo stats because %v", err)
+	}
+
+	if *format == "json" {
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		err = enc.Encode(stats)
+
+		if err != nil {
+			log.Fatalf("failed to encode repo stats because %v", err)
+		}
+
+		os.Exit(0)
+	}
+
+	wr := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(wr, "repo\tcount\tdeprecated\tsuperseded\tmin lastmod\tmax lastmod\tplacetypes")
+
+	for _, s := range stats {
+
+		repo := s.Repo
+
+		if repo == "" {
+			repo = "-"
+		}
+
+		fmt.Fprintf(wr, "%s\t%d\t%d\t%d\t%s\t%s\t%s\n", repo, s.Count, s.Deprecated, s.Superseded, s.MinLastMod, s.MaxLastMod, placetypeCounts(s.ByPlacetype))
+	}
+
+	err = wr.Flush()
+
+	if err != nil {
+		log.Fatalf("failed to write repo stats because %v", err)
+	}
+
+	os.Exit(0)
+}
+
+// a compact name=count list, largest first
+
+func placetypeCounts(counts map[int64]int64) string {
+
+	ids := make([]int64, 0, len(counts))
+
+	for id := range counts {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+
+		if counts[ids[i]] == counts[ids[j]] {
+			return ids[i] < ids[j]
+		}
+
+		return counts[ids[i]] > counts[ids[j]]
+	})
+
+	parts := make([]string, len(ids))
+
+	for i, id := range ids {
+
+		name := fmt.Sprintf("%d", id)
+
+		pt, err := placetypes.GetPlacetypeById(id)
+
+		if err == nil {
+			name = pt.Name
+		}
+
+		parts[i] = fmt.Sprintf("%s=%d", name, counts[id])
+	}
+
+	return strings.Join(parts, ",")
+}
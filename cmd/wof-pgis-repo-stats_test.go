@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPlacetypeCounts(t *testing.T) {
+
+	tests := []struct {
+		counts   map[int64]int64
+		expected string
+	}{
+		{map[int64]int64{}, ""},
+		{map[int64]int64{102312317: 2}, "locality=2"},
+		{map[int64]int64{102312317: 2, 102312307: 5}, "country=5,locality=2"},
+		{map[int64]int64{102312317: 2, 102312307: 2}, "country=2,locality=2"}, // ties are ordered by ID
+		{map[int64]int64{102312317: 1, 1: 3}, "1=3,locality=1"},               // unknown placetypes are left as IDs
+	}
+
+	for _, test := range tests {
+
+		str_counts := placetypeCounts(test.counts)
+
+		if str_counts != test.expected {
+			t.Errorf("placetypeCounts(%v) returned %q, expected %q", test.counts, str_counts, test.expected)
+		}
+	}
+}
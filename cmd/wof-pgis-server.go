@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"github.com/whosonfirst/go-whosonfirst-pgis/flags"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the largest geometry (in bytes) that /intersects will accept
+
+const MAX_REQUEST_BODY = 32 * 1024 * 1024
+
+// serverMetrics counts requests (by endpoint and status) and the queries they
+// make, and reports them in the Prometheus text format at /metrics
+
+type serverMetrics struct {
+	mu            sync.Mutex
+	requests      map[string]int64
+	queries       int64
+	query_errors  int64
+	query_seconds float64
+}
+
+func newServerMetrics() *serverMetrics {
+
+	m := serverMetrics{
+		requests: make(map[string]int64),
+	}
+
+	return &m
+}
+
+func (m *serverMetrics) Indexed(d time.Duration, err error) {
+	// the server doesn't index anything
+}
+
+func (m *serverMetrics) Executed(d time.Duration, err error) {
+	// or run arbitrary statements
+}
+
+func (m *serverMetrics) Queried(d time.Duration, err error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queries += 1
+	m.query_seconds += d.Seconds()
+
+	if err != nil {
+		m.query_errors += 1
+	}
+}
+
+func (m *serverMetrics) request(endpoint string, status int) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("endpoint=\"%s\",code=\"%d\"", endpoint, status)
+	m.requests[key] += 1
+}
+
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+func (m *serverMetrics) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.requests))
+
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	rsp.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(rsp, "# TYPE wof_pgis_requests_total counter")
+
+	for _, k := range keys {
+		fmt.Fprintf(rsp, "wof_pgis_requests_total{%s} %d\n", k, m.requests[k])
+	}
+
+	fmt.Fprintln(rsp, "# TYPE wof_pgis_queries_total counter")
+	fmt.Fprintf(rsp, "wof_pgis_queries_total %d\n", m.queries)
+	fmt.Fprintln(rsp, "# TYPE wof_pgis_query_errors_total counter")
+	fmt.Fprintf(rsp, "wof_pgis_query_errors_total %d\n", m.query_errors)
+	fmt.Fprintln(rsp, "# TYPE wof_pgis_query_seconds_total counter")
+	fmt.Fprintf(rsp, "wof_pgis_query_seconds_total %f\n", m.query_seconds)
+}
+
+// a http.ResponseWriter that remembers the status code so it can be counted
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func countRequests(m *serverMetrics, endpoint string, h http.Handler) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		w := &statusWriter{ResponseWriter: rsp, status: http.StatusOK}
+		h.ServeHTTP(w, req)
+
+		m.request(endpoint, w.status)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func main() {
+
+	config := flag.String("config", "", "The path to a JSON config file whose keys are the names of any of the flags below. Flags passed on the command line take precedence.")
+
+	host := flag.String("host", "localhost", "The hostname to listen for requests on.")
+	port := flag.Int("port", 8080, "The port number to listen for requests on.")
+
+	pgis_flags := flags.NewPgisFlags(flag.CommandLine)
+
+	subdivided := flag.Bool("subdivided", false, "Use the whosonfirst_subdivided table to find candidate features before testing them against the geom column.")
+	verbose := flag.Bool("verbose", false, "Be chatty about what's happening.")
+
+	flag.Parse()
+
+	if *config != "" {
+
+		err := flags.ApplyConfigFile(flag.CommandLine, *config)
+
+		if err != nil {
+			log.Fatalf("failed to read config file %s because %v", *config, err)
+		}
+	}
+
+	client, err := pgis_flags.NewClient()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	metrics := newServerMetrics()
+
+	client.Verbose = *verbose
+	client.Metrics = metrics
+
+	mux := http.NewServeMux()
+	mux.Handle("/point", countRequests(metrics, "point", PointHandler(client, *subdivided)))
+	mux.Handle("/intersects", countRequests(metrics, "intersects", IntersectsHandler(client, *subdivided)))
+	mux.Handle("/feature/", countRequests(metrics, "feature", FeatureHandler(client)))
+	mux.Handle("/metrics", metrics)
+
+	address := fmt.Sprintf("%s:%d", *host, *port)
+
+	server := &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+
+	signal_ch := make(chan os.Signal, 1)
+	signal.Notify(signal_ch, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+
+		<-signal_ch
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		server.Shutdown(ctx)
+	}()
+
+	log.Printf("listening on %s\n", address)
+
+	err = server.ListenAndServe()
+
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("failed to start server because %v", err)
+	}
+
+	client.Close()
+	os.Exit(0)
+}
+
+// GET /point?lat={LATITUDE}&lon={LONGITUDE} returns a GeoJSON FeatureCollection
+// of everything that contains the point, smallest first
+
+func PointHandler(client *pgis.PgisClient, subdivided bool) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != "GET" {
+			http.Error(rsp, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := req.URL.Query()
+
+		lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+
+		if err != nil || lat < -90.0 || lat > 90.0 {
+			http.Error(rsp, "invalid latitude", http.StatusBadRequest)
+			return
+		}
+
+		lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+
+		if err != nil || lon < -180.0 || lon > 180.0 {
+			http.Error(rsp, "invalid longitude", http.StatusBadRequest)
+			return
+		}
+
+		opts, err := requestOptions(req, subdivided)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows, err := client.PointInPolygon(req.Context(), lon, lat, opts)
+
+		if err != nil {
+			client.Logger.Warning("failed to query %f, %f because %s", lat, lon, err)
+			http.Error(rsp, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		writeFeatureCollection(rsp, rows)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// POST /intersects with a GeoJSON geometry (or feature) as the request body
+// returns a GeoJSON FeatureCollection of everything that intersects it
+
+func IntersectsHandler(client *pgis.PgisClient, subdivided bool) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != "POST" {
+			http.Error(rsp, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rsp, req.Body, MAX_REQUEST_BODY))
+
+		if err != nil {
+			http.Error(rsp, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		opts, err := requestOptions(req, subdivided)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var probe struct {
+			Type string `json:"type"`
+		}
+
+		err = json.Unmarshal(body, &probe)
+
+		if err != nil {
+			http.Error(rsp, "invalid GeoJSON", http.StatusBadRequest)
+			return
+		}
+
+		var rows []*pgis.PgisRow
+
+		if probe.Type == "Feature" {
+			rows, err = client.IntersectsFeature(req.Context(), body, opts)
+		} else {
+			rows, err = client.IntersectsGeometry(req.Context(), body, opts)
+		}
+
+		if err != nil {
+			client.Logger.Warning("failed to query intersecting features because %s", err)
+			http.Error(rsp, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		writeFeatureCollection(rsp, rows)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// GET /feature/{ID} returns the GeoJSON Feature for a single record
+
+func FeatureHandler(client *pgis.PgisClient) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != "GET" {
+			http.Error(rsp, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		str_id := strings.TrimPrefix(req.URL.Path, "/feature/")
+
+		id, err := strconv.ParseInt(str_id, 10, 64)
+
+		if err != nil || id < 0 {
+			http.Error(rsp, "invalid ID", http.StatusBadRequest)
+			return
+		}
+
+		row, err := client.GetByIdContext(req.Context(), id)
+
+		if errors.Is(err, pgis.ErrNotFound) {
+			http.Error(rsp, "not found", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			client.Logger.Warning("failed to get %d because %s", id, err)
+			http.Error(rsp, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		f, err := row.ToFeature()
+
+		if err != nil {
+			http.Error(rsp, "failed to encode feature", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(rsp, f)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// the same key=value filters as wof-pgis-intersects -filter, passed as query
+// parameters; lat and lon are left for the handler and anything else that isn't
+// a filter is an error, which the handlers report as a 400
+
+func requestOptions(req *http.Request, subdivided bool) (*pgis.PgisIntersectsOptions, error) {
+
+	opts := pgis.NewDefaultPgisIntersectsOptions()
+	opts.UseSubdivided = subdivided
+
+	for k, v := range req.URL.Query() {
+
+		switch k {
+		case "lat", "lon":
+			continue
+		}
+
+		for _, value := range v {
+
+			err := opts.SetFilter(k, value)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+func writeFeatureCollection(rsp http.ResponseWriter, rows []*pgis.PgisRow) {
+
+	fc, err := pgis.NewPgisFeatureCollection(rows)
+
+	if err != nil {
+		http.Error(rsp, "failed to encode features", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(rsp, fc)
+}
+
+func writeJSON(rsp http.ResponseWriter, v interface{}) {
+
+	enc, err := json.Marshal(v)
+
+	if err != nil {
+		http.Error(rsp, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	rsp.Header().Set("Content-Type", "application/json")
+	rsp.Write(enc)
+}
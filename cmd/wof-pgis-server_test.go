@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// these only exercise the paths that return before the database is queried
+
+func TestHandlers(t *testing.T) {
+
+	client := &pgis.PgisClient{}
+
+	tests := []struct {
+		what    string
+		handler http.Handler
+		method  string
+		target  string
+		body    string
+		status  int
+	}{
+		{"point wrong method", PointHandler(client, false), "POST", "/point?lat=0&lon=0", "", http.StatusMethodNotAllowed},
+		{"point missing latitude", PointHandler(client, false), "GET", "/point?lon=0", "", http.StatusBadRequest},
+		{"point invalid latitude", PointHandler(client, false), "GET", "/point?lat=91&lon=0", "", http.StatusBadRequest},
+		{"point invalid longitude", PointHandler(client, false), "GET", "/point?lat=0&lon=-181", "", http.StatusBadRequest},
+		{"point invalid placetype", PointHandler(client, false), "GET", "/point?lat=0&lon=0&placetype=planetoid", "", http.StatusBadRequest},
+		{"point invalid deprecated", PointHandler(client, false), "GET", "/point?lat=0&lon=0&deprecated=2", "", http.StatusBadRequest},
+		{"point unknown parameter", PointHandler(client, false), "GET", "/point?lat=0&lon=0&radius=10", "", http.StatusBadRequest},
+		{"intersects wrong method", IntersectsHandler(client, false), "GET", "/intersects", "", http.StatusMethodNotAllowed},
+		{"intersects invalid GeoJSON", IntersectsHandler(client, false), "POST", "/intersects", "{", http.StatusBadRequest},
+		{"intersects invalid superseded", IntersectsHandler(client, false), "POST", "/intersects?superseded=x", `{"type":"Point","coordinates":[0,0]}`, http.StatusBadRequest},
+		{"intersects too large", IntersectsHandler(client, false), "POST", "/intersects", strings.Repeat(" ", MAX_REQUEST_BODY+1), http.StatusBadRequest},
+		{"feature wrong method", FeatureHandler(client), "DELETE", "/feature/101736545", "", http.StatusMethodNotAllowed},
+		{"feature missing ID", FeatureHandler(client), "GET", "/feature/", "", http.StatusBadRequest},
+		{"feature invalid ID", FeatureHandler(client), "GET", "/feature/montreal", "", http.StatusBadRequest},
+		{"feature negative ID", FeatureHandler(client), "GET", "/feature/-1", "", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		rsp := httptest.NewRecorder()
+
+		test.handler.ServeHTTP(rsp, req)
+
+		if rsp.Code != test.status {
+			t.Errorf("%s: %s %s returned %d, expected %d", test.what, test.method, test.target, rsp.Code, test.status)
+		}
+	}
+}
+
+func TestRequestOptions(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/point?lat=45.5&lon=-73.5&placetype=locality&repo=whosonfirst-data-admin-ca", nil)
+
+	opts, err := requestOptions(req, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !opts.UseSubdivided {
+		t.Errorf("requestOptions did not set UseSubdivided")
+	}
+
+	if opts.PlacetypeId != 102312317 {
+		t.Errorf("requestOptions set placetype %d, expected 102312317", opts.PlacetypeId)
+	}
+
+	if opts.Repo != "whosonfirst-data-admin-ca" {
+		t.Errorf("requestOptions set repo %q, expected %q", opts.Repo, "whosonfirst-data-admin-ca")
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+
+	metrics := newServerMetrics()
+
+	ok := http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {})
+	h := countRequests(metrics, "feature", FeatureHandler(&pgis.PgisClient{}))
+
+	for _, target := range []string{"/feature/x", "/feature/y"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	countRequests(metrics, "point", ok).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/point", nil))
+
+	metrics.Queried(500*time.Millisecond, nil)
+	metrics.Queried(250*time.Millisecond, http.ErrHandlerTimeout)
+
+	rsp := httptest.NewRecorder()
+	metrics.ServeHTTP(rsp, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rsp.Body.String()
+
+	expected := []string{
+		`wof_pgis_requests_total{endpoint="feature",code="400"} 2`,
+		`wof_pgis_requests_total{endpoint="point",code="200"} 1`,
+		"wof_pgis_queries_total 2",
+		"wof_pgis_query_errors_total 1",
+		"wof_pgis_query_seconds_total 0.750000",
+	}
+
+	for _, line := range expected {
+
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %q but got %s", line, body)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-pgis/flags"
+	"log"
+	"os"
+)
+
+// the exit status when there are invalid geometries (and -fix wasn't passed)
+
+const EXIT_INVALID = 2
+
+func main() {
+
+	config := flag.String("config", "", "The path to a JSON config file whose keys are the names of any of the flags below. Flags passed on the command line take precedence.")
+
+	pgis_flags := flags.NewPgisFlags(flag.CommandLine)
+
+	centroids := flag.Bool("centroids", false, "Report records whose centroid is not inside their geometry rather than invalid geometries. These can't be fixed automatically.")
+	fix := flag.Bool("fix", false, "Repair invalid geometries (using ST_MakeValid) rather than just reporting them.")
+
+	verbose := flag.Bool("verbose", false, "Be chatty about what's happening. This is automatically enabled if the -debug flag is set.")
+	debug := flag.Bool("debug", false, "Go through all the motions but don't actually fix anything.")
+
+	flag.Parse()
+
+	if *config != "" {
+
+		err := flags.ApplyConfigFile(flag.CommandLine, *config)
+
+		if err != nil {
+			log.Fatalf("failed to read config file %s because %v", *config, err)
+		}
+	}
+
+	if *debug {
+		*verbose = true
+	}
+
+	client, err := pgis_flags.NewClient()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client.Verbose = *verbose
+	client.Debug = *debug
+
+	ctx := context.Background()
+
+	if *centroids {
+
+		ids, err := client.CheckCentroidContainment(ctx)
+
+		if err != nil {
+			log.Fatalf("failed to check centroids because %v", err)
+		}
+
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+
+		if len(ids) > 0 {
+			log.Printf("found %d centroids outside their geometry", len(ids))
+			os.Exit(EXIT_INVALID)
+		}
+
+		os.Exit(0)
+	}
+
+	ids, err := client.InvalidGeometries(ctx)
+
+	if err != nil {
+		log.Fatalf("failed to find invalid geometries because %v", err)
+	}
+
+	for _, id := range ids {
+		fmt.Println(id)
+	}
+
+	if len(ids) == 0 {
+		os.Exit(0)
+	}
+
+	if !*fix {
+		log.Printf("found %d invalid geometries", len(ids))
+		os.Exit(EXIT_INVALID)
+	}
+
+	count, err := client.FixGeometries(ctx, ids)
+
+	if err != nil {
+		log.Fatalf("failed to fix invalid geometries because %v", err)
+	}
+
+	log.Printf("fixed %d of %d invalid geometries", count, len(ids))
+	os.Exit(0)
+}
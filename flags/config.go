@@ -0,0 +1,104 @@
+package flags
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// ApplyConfigFile reads a JSON config file whose keys are the names of flags in
+// fs (without the leading "-") and sets each flag that was not explicitly passed
+// on the command line, which is to say command line flags always win. For
+// example:
+//
+//	{
+//		"pgis-host": "/var/run/postgresql",
+//		"pgis-maxconns": 20,
+//		"simplified-tolerance": 0.001,
+//		"verbose": true
+//	}
+//
+// Keys that don't match any flag in fs are ignored so that the same file can be
+// shared by tools with different flags. Lists are applied one item at a time
+// which is how repeatable flags expect to be set. This must be called after fs
+// has been parsed.
+
+func ApplyConfigFile(fs *flag.FlagSet, path string) error {
+
+	body, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	// numbers are kept as they were written rather than being turned in
+	// to float64s, which can't hold every int64 (like a large -run-id or
+	// wof:id) exactly
+
+	var config map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	err = dec.Decode(&config)
+
+	if err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range config {
+
+		if fs.Lookup(name) == nil || explicit[name] {
+			continue
+		}
+
+		values, ok := value.([]interface{})
+
+		if !ok {
+			values = []interface{}{value}
+		}
+
+		for _, v := range values {
+
+			str_v, err := configValue(v)
+
+			if err != nil {
+				msg := fmt.Sprintf("invalid value for '%s' in %s, %s", name, path, err)
+				return errors.New(msg)
+			}
+
+			err = fs.Set(name, str_v)
+
+			if err != nil {
+				msg := fmt.Sprintf("invalid value for '%s' in %s, %s", name, path, err)
+				return errors.New(msg)
+			}
+		}
+	}
+
+	return nil
+}
+
+func configValue(v interface{}) (string, error) {
+
+	switch v.(type) {
+	case string:
+		return v.(string), nil
+	case bool:
+		return strconv.FormatBool(v.(bool)), nil
+	case json.Number:
+		return v.(json.Number).String(), nil
+	default:
+		return "", errors.New("unsupported type")
+	}
+}
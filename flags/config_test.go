@@ -0,0 +1,118 @@
+package flags
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+
+	config := `{
+	"pgis-host": "/var/run/postgresql",
+	"pgis-port": 5433,
+	"max-id": 9007199254740993,
+	"tolerance": 0.001,
+	"verbose": true,
+	"placetype": ["region", "locality"],
+	"unknown-flag": "ignored"
+}`
+
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	err := ioutil.WriteFile(path, []byte(config), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	host := fs.String("pgis-host", "localhost", "")
+	port := fs.Int("pgis-port", 5432, "")
+	max_id := fs.Int64("max-id", 0, "")
+	tolerance := fs.Float64("tolerance", 0.0, "")
+	verbose := fs.Bool("verbose", false, "")
+
+	var placetypes MultiString
+	fs.Var(&placetypes, "placetype", "")
+
+	// flags on the command line take precedence over the config file
+
+	err = fs.Parse([]string{"-pgis-port", "6543"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ApplyConfigFile(fs, path)
+
+	if err != nil {
+		t.Fatalf("ApplyConfigFile failed because %s", err)
+	}
+
+	if *host != "/var/run/postgresql" {
+		t.Errorf("expected pgis-host to be /var/run/postgresql, got %s", *host)
+	}
+
+	if *port != 6543 {
+		t.Errorf("expected the command line pgis-port (6543) to win, got %d", *port)
+	}
+
+	if *max_id != 9007199254740993 {
+		t.Errorf("expected max-id to be 9007199254740993, got %d", *max_id)
+	}
+
+	if *tolerance != 0.001 {
+		t.Errorf("expected tolerance to be 0.001, got %f", *tolerance)
+	}
+
+	if !*verbose {
+		t.Errorf("expected verbose to be true")
+	}
+
+	if placetypes.String() != "region,locality" {
+		t.Errorf("expected placetype to be region,locality, got %s", placetypes.String())
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{`{"pgis-port": "not a number"}`, "invalid value for 'pgis-port'"},
+		{`{"pgis-port": 5432.5}`, "invalid value for 'pgis-port'"},
+		{`{"pgis-port": {"nested": true}}`, "unsupported type"},
+		{`{"pgis-port": `, "unexpected EOF"},
+	}
+
+	for _, test := range tests {
+
+		path := filepath.Join(t.TempDir(), "config.json")
+
+		err := ioutil.WriteFile(path, []byte(test.config), 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("pgis-port", 5432, "")
+
+		err = fs.Parse([]string{})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ApplyConfigFile(fs, path)
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("ApplyConfigFile(%s) returned %v, expected an error containing %q", test.config, err, test.err)
+		}
+	}
+}
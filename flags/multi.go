@@ -0,0 +1,18 @@
+package flags
+
+import (
+	"strings"
+)
+
+// MultiString is a flag.Value for flags that may be passed more than once
+
+type MultiString []string
+
+func (m *MultiString) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *MultiString) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
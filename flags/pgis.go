@@ -0,0 +1,77 @@
+package flags
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+)
+
+// PgisFlags are the -pgis-* flags that every tool uses to connect to its
+// PostgreSQL database
+
+type PgisFlags struct {
+	Host     *string
+	Port     *int
+	User     *string
+	Password *string
+	Database *string
+	MaxConns *int
+	Service  *string
+	Driver   *string
+}
+
+// NewPgisFlags defines the -pgis-* flags in fs
+
+func NewPgisFlags(fs *flag.FlagSet) *PgisFlags {
+
+	f := PgisFlags{
+		Host:     fs.String("pgis-host", "localhost", "The host of your PostgreSQL server. If the value starts with a \"/\" it is treated as the directory containing your PostgreSQL server's Unix domain socket."),
+		Port:     fs.Int("pgis-port", 5432, "The port of your PostgreSQL server."),
+		User:     fs.String("pgis-user", "whosonfirst", "The name of your PostgreSQL user."),
+		Password: fs.String("pgis-password", "", "The password of your PostgreSQL user."),
+		Database: fs.String("pgis-database", "whosonfirst", "The name of your PostgreSQL database."),
+		MaxConns: fs.Int("pgis-maxconns", 10, "The maximum number of connections to use with your PostgreSQL database."),
+		Service:  fs.String("pgis-service", "", "The name of a PostgreSQL connection service (defined in a pg_service.conf file) to use instead of the other -pgis-* connection flags."),
+		Driver:   fs.String("pgis-driver", pgis.PGIS_DEFAULT_DRIVER, "The name of the database/sql driver to connect with. Drivers other than \"postgres\" (lib/pq) need to have been imported by the tool."),
+	}
+
+	return &f
+}
+
+// NewClient returns a PgisClient for the -pgis-service flag if it was set and
+// for the other -pgis-* flags if it wasn't. This must be called after the flags
+// have been parsed (and any config file applied).
+
+func (f *PgisFlags) NewClient() (*pgis.PgisClient, error) {
+
+	if *f.Service != "" {
+
+		dsn, err := pgis.NewPgisServiceDSN(*f.Service)
+
+		if err != nil {
+			msg := fmt.Sprintf("failed to load PostgreSQL service %s because %v", *f.Service, err)
+			return nil, errors.New(msg)
+		}
+
+		client, err := pgis.NewPgisClientWithDriver(*f.Driver, dsn, *f.MaxConns)
+
+		if err != nil {
+			msg := fmt.Sprintf("failed to create PgisClient (%s) because %v", *f.Service, err)
+			return nil, errors.New(msg)
+		}
+
+		return client, nil
+	}
+
+	dsn := pgis.NewPgisDSN(*f.Host, *f.Port, *f.User, *f.Password, *f.Database)
+
+	client, err := pgis.NewPgisClientWithDriver(*f.Driver, dsn, *f.MaxConns)
+
+	if err != nil {
+		msg := fmt.Sprintf("failed to create PgisClient (%s:%d) because %v", *f.Host, *f.Port, err)
+		return nil, errors.New(msg)
+	}
+
+	return client, nil
+}
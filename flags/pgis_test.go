@@ -0,0 +1,57 @@
+package flags
+
+import (
+	"flag"
+	"github.com/whosonfirst/go-whosonfirst-pgis/client"
+	"strings"
+	"testing"
+)
+
+func TestPgisFlagsDriver(t *testing.T) {
+
+	tests := []struct {
+		args   []string
+		driver string
+	}{
+		{[]string{}, pgis.PGIS_DEFAULT_DRIVER},
+		{[]string{"-pgis-driver", "pgx"}, "pgx"},
+		{[]string{"-pgis-service", "whosonfirst", "-pgis-driver", "pgx"}, "pgx"},
+	}
+
+	for _, test := range tests {
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := NewPgisFlags(fs)
+
+		err := fs.Parse(test.args)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if *f.Driver != test.driver {
+			t.Errorf("%v set the driver to %q, expected %q", test.args, *f.Driver, test.driver)
+		}
+	}
+}
+
+// pgx isn't imported here so asking for it fails, and says why, before
+// anything tries to connect
+
+func TestPgisFlagsNewClientUnknownDriver(t *testing.T) {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := NewPgisFlags(fs)
+
+	err := fs.Parse([]string{"-pgis-driver", "pgx"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.NewClient()
+
+	if err == nil || !strings.Contains(err.Error(), "unknown driver") {
+		t.Errorf("NewClient with the pgx driver returned %v, expected an unknown driver error", err)
+	}
+}